@@ -0,0 +1,73 @@
+package GdalView
+
+import (
+	"net/http"
+
+	"github.com/GrainArc/SouceMap/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 空间插值 ====================
+
+// InterpolateIDW 反距离权重插值
+func (h *UserController) InterpolateIDW(c *gin.Context) {
+	var req services.IDWRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.rasterService.StartIDWTask(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "任务已提交",
+		"data":    resp,
+	})
+}
+
+// InterpolateKriging 普通克里金插值
+func (h *UserController) InterpolateKriging(c *gin.Context) {
+	var req services.KrigingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.rasterService.StartKrigingTask(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "任务已提交",
+		"data":    resp,
+	})
+}
+
+// InterpolateNaturalNeighbor 自然邻域插值
+func (h *UserController) InterpolateNaturalNeighbor(c *gin.Context) {
+	var req services.NaturalNeighborRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.rasterService.StartNaturalNeighborTask(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "任务已提交",
+		"data":    resp,
+	})
+}
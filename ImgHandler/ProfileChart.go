@@ -0,0 +1,156 @@
+package ImgHandler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+)
+
+// niceStep 取1、2、5乘10的幂作为刻度间隔
+func niceStep(span float64, count int) float64 {
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(count)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// drawThickLine 绘制指定宽度的线段
+func drawThickLine(img *image.RGBA, x0, y0, x1, y1 float64, width int, c color.Color) {
+	steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+	half := width / 2
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := int(math.Round(x0 + (x1-x0)*t))
+		y := int(math.Round(y0 + (y1-y0)*t))
+		for dy := -half; dy <= half; dy++ {
+			for dx := -half; dx <= half; dx++ {
+				img.Set(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+// formatTick 刻度文字，整数刻度不显示小数
+func formatTick(v, step float64) string {
+	if step >= 1 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	decimals := int(math.Ceil(-math.Log10(step)))
+	return fmt.Sprintf("%.*f", decimals, v)
+}
+
+// ProfileChart 绘制高程剖面图，distances单位为米，elevations中的NaN视为无数据断开
+func ProfileChart(distances, elevations []float64, title string) ([]byte, error) {
+	if len(distances) < 2 || len(distances) != len(elevations) {
+		return nil, fmt.Errorf("剖面点不足")
+	}
+	ttfFont, err := loadFont()
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := 900, 420
+	left, right, top, bottom := 80, 30, 50, 60
+	plotW, plotH := float64(width-left-right), float64(height-top-bottom)
+
+	minZ, maxZ := math.Inf(1), math.Inf(-1)
+	for _, z := range elevations {
+		if !math.IsNaN(z) {
+			minZ, maxZ = math.Min(minZ, z), math.Max(maxZ, z)
+		}
+	}
+	if math.IsInf(minZ, 1) {
+		return nil, fmt.Errorf("剖面没有有效高程")
+	}
+	// 高程轴上下留白，平坦剖面至少显示10米范围
+	pad := math.Max((maxZ-minZ)*0.1, 5)
+	zStep := niceStep(maxZ-minZ+2*pad, 6)
+	zLow := math.Floor((minZ-pad)/zStep) * zStep
+	zHigh := math.Ceil((maxZ+pad)/zStep) * zStep
+
+	total := distances[len(distances)-1]
+	unit, scale := "m", 1.0
+	if total >= 5000 {
+		unit, scale = "km", 1000
+	}
+	dStep := niceStep(total/scale, 8)
+
+	px := func(d float64) float64 { return float64(left) + d/total*plotW }
+	py := func(z float64) float64 { return float64(top) + (zHigh-z)/(zHigh-zLow)*plotH }
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	gridColor := color.RGBA{225, 225, 225, 255}
+	axisColor := color.RGBA{90, 90, 90, 255}
+	textColor := color.RGBA{60, 60, 60, 255}
+	fillColor := color.RGBA{198, 226, 196, 255}
+	lineColor := color.RGBA{46, 125, 50, 255}
+
+	// 网格和刻度
+	for z := zLow; z <= zHigh+zStep/2; z += zStep {
+		y := py(z)
+		drawThickLine(img, float64(left), y, float64(width-right), y, 1, gridColor)
+		label := formatTick(z, zStep)
+		drawChineseText(img, left-8-calculateTextWidth(label, 12, ttfFont), int(y)+4, label, 12, textColor, ttfFont)
+	}
+	for d := 0.0; d <= total/scale+dStep/1000; d += dStep {
+		x := px(d * scale)
+		drawThickLine(img, x, float64(top), x, float64(height-bottom), 1, gridColor)
+		label := formatTick(d, dStep)
+		drawChineseText(img, int(x)-calculateTextWidth(label, 12, ttfFont)/2, height-bottom+18, label, 12, textColor, ttfFont)
+	}
+
+	// 剖面线以下填充
+	for i := 1; i < len(distances); i++ {
+		z0, z1 := elevations[i-1], elevations[i]
+		if math.IsNaN(z0) || math.IsNaN(z1) {
+			continue
+		}
+		x0, x1 := int(math.Round(px(distances[i-1]))), int(math.Round(px(distances[i])))
+		for x := x0; x <= x1; x++ {
+			t := 0.0
+			if x1 > x0 {
+				t = float64(x-x0) / float64(x1-x0)
+			}
+			for y := int(py(z0 + (z1-z0)*t)); y < height-bottom; y++ {
+				img.Set(x, y, fillColor)
+			}
+		}
+	}
+	for i := 1; i < len(distances); i++ {
+		z0, z1 := elevations[i-1], elevations[i]
+		if math.IsNaN(z0) || math.IsNaN(z1) {
+			continue
+		}
+		drawThickLine(img, px(distances[i-1]), py(z0), px(distances[i]), py(z1), 2, lineColor)
+	}
+
+	// 坐标轴
+	drawThickLine(img, float64(left), float64(top), float64(left), float64(height-bottom), 1, axisColor)
+	drawThickLine(img, float64(left), float64(height-bottom), float64(width-right), float64(height-bottom), 1, axisColor)
+
+	xLabel := fmt.Sprintf("距离(%s)", unit)
+	drawChineseText(img, left+int(plotW)/2-calculateTextWidth(xLabel, 13, ttfFont)/2, height-16, xLabel, 13, textColor, ttfFont)
+	drawChineseText(img, 10, top-14, "高程(m)", 13, textColor, ttfFont)
+	if title != "" {
+		drawChineseText(img, width/2-calculateTextWidth(title, 16, ttfFont)/2, 28, title, 16, color.Black, ttfFont)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -101,6 +101,11 @@ func GeoJsonTransformTo4326(original *geojson.FeatureCollection, EPSG string) (*
 	return original, nil // 转换成功，返回转换后的FeatureCollection
 }
 
+// CGCS2000ZoneEPSG 经度所在的CGCS2000 3度带投影(带号前缀)EPSG，带号 = round(经度/3)，4513对应25带
+func CGCS2000ZoneEPSG(lon float64) int {
+	return 4488 + int(math.Round(lon/3))
+}
+
 // 35带转换
 func GeoJsonTransformToCGCS(original *geojson.FeatureCollection) (*geojson.FeatureCollection, error) {
 	db := models.DB
@@ -128,7 +133,7 @@ func GeoJsonTransformToCGCS(original *geojson.FeatureCollection) (*geojson.Featu
 	case orb.MultiPoint:
 		cx = geom[0][0]
 	}
-	EPSG := int(4488 + math.Round((cx / 3)))
+	EPSG := CGCS2000ZoneEPSG(cx)
 
 	for i, item := range original.Features {
 		//获取目标坐标系
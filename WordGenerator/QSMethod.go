@@ -8,6 +8,7 @@ import (
 	"gitee.com/gooffice/gooffice/measurement"
 	"gitee.com/gooffice/gooffice/schema/soo/wml"
 	"github.com/GrainArc/SouceMap/Transformer"
+	"github.com/GrainArc/SouceMap/methods"
 	"github.com/GrainArc/SouceMap/models"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
@@ -35,66 +36,44 @@ func BoundaryPointsTable(word *document.Document, geo *geojson.FeatureCollection
 	Ptable := Tables[1]
 
 	for _, feature := range geo.Features {
-
+		var ring orb.Ring
 		switch geom := feature.Geometry.(type) {
-
 		case orb.Polygon:
-			// 将多边形的边界添加到DXF中
-			ring := geom[0]
-			for j, pt := range ring {
-				row := Ptable.AddRow()
-				cell0 := row.AddCell()
-				cell0.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell0.AddParagraph().AddRun().AddText(fmt.Sprintf("%d", j+1))
-				cell1 := row.AddCell()
-				cell1.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell1.AddParagraph().AddRun().AddText(fmt.Sprintf("J%d", j+1))
-				cell2 := row.AddCell()
-				cell2.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell3 := row.AddCell()
-				cell3.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				if pt[0] <= 2000 {
-					newx, newy := Transformer.CoordTransform4326To4523(pt[0], pt[1])
-					cell2.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", newx))
-					cell3.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", newy))
-				} else {
-					cell2.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", pt[0]))
-					cell3.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", pt[1]))
-				}
-			}
-			borders := Ptable.Properties().Borders()
-			borders.SetAll(wml.ST_BorderSingle, color.Auto, 1*measurement.Point)
+			ring = geom[0]
 		case orb.MultiPolygon:
-			// 将多边形的边界添加到DXF中
-			ring := geom[0][0]
-			for j, pt := range ring {
-				row := Ptable.AddRow()
-				cell0 := row.AddCell()
-				cell0.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell0.AddParagraph().AddRun().AddText(fmt.Sprintf("%d", j+1))
-				cell1 := row.AddCell()
-				cell1.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell1.AddParagraph().AddRun().AddText(fmt.Sprintf("J%d", j+1))
-				cell2 := row.AddCell()
-				cell2.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				cell3 := row.AddCell()
-				cell3.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
-				if pt[0] <= 2000 {
-					newx, newy := Transformer.CoordTransform4326To4523(pt[0], pt[1])
-					cell2.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", newx))
-					cell3.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", newy))
-				} else {
-					cell2.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", pt[0]))
-					cell3.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", pt[1]))
-				}
-			}
-			borders := Ptable.Properties().Borders()
-			borders.SetAll(wml.ST_BorderSingle, color.Auto, 1*measurement.Point)
-
+			ring = geom[0][0]
 		default:
 			// 如果遇到非Polygon几何类型，打印一条消息
 			fmt.Printf("Unsupported geometry type: %T", geom)
+			continue
+		}
+		// 经纬度按要素中心所在3度带投影，同一要素的界址点使用同一带，界址点自西北角起顺时针编号
+		projected := ring
+		if center := ring.Bound().Center(); center[0] <= 2000 {
+			zone := fmt.Sprintf("%d", Transformer.CGCS2000ZoneEPSG(center[0]))
+			projected = make(orb.Ring, len(ring))
+			for j, pt := range ring {
+				newx, newy := Transformer.CoordTransformAToB(pt[0], pt[1], "4326", zone)
+				projected[j] = orb.Point{newx, newy}
+			}
+		}
+		for _, point := range methods.OrderBoundaryPoints(projected) {
+			row := Ptable.AddRow()
+			cell0 := row.AddCell()
+			cell0.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
+			cell0.AddParagraph().AddRun().AddText(fmt.Sprintf("%d", point.No))
+			cell1 := row.AddCell()
+			cell1.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
+			cell1.AddParagraph().AddRun().AddText(point.Name)
+			cell2 := row.AddCell()
+			cell2.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
+			cell2.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", point.X))
+			cell3 := row.AddCell()
+			cell3.Properties().SetVerticalAlignment(wml.ST_VerticalJcCenter)
+			cell3.AddParagraph().AddRun().AddText(fmt.Sprintf("%4f", point.Y))
 		}
+		borders := Ptable.Properties().Borders()
+		borders.SetAll(wml.ST_BorderSingle, color.Auto, 1*measurement.Point)
 	}
 	//word.AddParagraph().AddRun().AddPageBreak()
 }
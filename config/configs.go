@@ -38,6 +38,7 @@ type Config struct {
 	Tiles3d       string   `xml:"tiles3d"`
 	DeviceName    string   `xml:"DeviceName"`
 	Download      string   `xml:"download"`
+	LockAdmins    string   `xml:"LockAdmins"` // 锁管理员用户名，逗号分隔，启动时授予admin等级
 }
 
 func InitConfig() {
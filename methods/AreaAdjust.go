@@ -0,0 +1,94 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 面积平差：将控制面积与子要素面积之和的差值按面积比例或权重分配到各子要素，
+// 按小数位取整后用最大余数法保证平差后面积之和等于控制面积
+
+// AreaAdjustItem 参与平差的要素
+type AreaAdjustItem struct {
+	ID       int32   `json:"id"`
+	Area     float64 `json:"area"`     // 平差前面积
+	Weight   float64 `json:"weight"`   // 权重，按比例平差时等于面积
+	Adjusted float64 `json:"adjusted"` // 平差后面积
+	Diff     float64 `json:"diff"`     // 改正数
+}
+
+// AreaAdjustResult 平差结果
+type AreaAdjustResult struct {
+	ControlArea  float64          `json:"control_area"`
+	OriginalSum  float64          `json:"original_sum"`
+	AdjustedSum  float64          `json:"adjusted_sum"`
+	Discrepancy  float64          `json:"discrepancy"`   // 控制面积-平差前面积之和
+	RelativeDiff float64          `json:"relative_diff"` // 相对差值
+	Items        []AreaAdjustItem `json:"items"`
+}
+
+// AdjustAreas 执行面积平差，useWeight为false时按面积比例分配
+func AdjustAreas(items []AreaAdjustItem, controlArea float64, useWeight bool, decimals int) (*AreaAdjustResult, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("没有参与平差的要素")
+	}
+	if controlArea <= 0 {
+		return nil, fmt.Errorf("控制面积必须大于0")
+	}
+	if decimals < 0 {
+		decimals = 0
+	}
+	result := &AreaAdjustResult{ControlArea: controlArea}
+	sumWeight := 0.0
+	for i := range items {
+		if !useWeight {
+			items[i].Weight = items[i].Area
+		}
+		if items[i].Weight < 0 {
+			return nil, fmt.Errorf("要素 %d 的权重不能为负", items[i].ID)
+		}
+		result.OriginalSum += items[i].Area
+		sumWeight += items[i].Weight
+	}
+	if sumWeight <= 0 {
+		return nil, fmt.Errorf("权重之和必须大于0")
+	}
+	result.Discrepancy = controlArea - result.OriginalSum
+	result.RelativeDiff = result.Discrepancy / controlArea
+
+	scale := math.Pow(10, float64(decimals))
+	units := make([]float64, len(items))
+	remainders := make([]float64, len(items))
+	var sumUnits float64
+	for i := range items {
+		exact := (items[i].Area + result.Discrepancy*items[i].Weight/sumWeight) * scale
+		units[i] = math.Floor(exact)
+		remainders[i] = exact - units[i]
+		sumUnits += units[i]
+	}
+	// 最大余数法：剩余的最小单位依次分给余数最大的要素
+	missing := int(math.Round(controlArea*scale - sumUnits))
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for k := 0; k < missing && k < len(order); k++ {
+		units[order[k]]++
+	}
+
+	for i := range items {
+		items[i].Adjusted = units[i] / scale
+		items[i].Diff = items[i].Adjusted - items[i].Area
+		if items[i].Adjusted < 0 {
+			return nil, fmt.Errorf("要素 %d 平差后面积为负，请检查控制面积或权重", items[i].ID)
+		}
+		result.AdjustedSum += items[i].Adjusted
+	}
+	result.AdjustedSum = math.Round(result.AdjustedSum*scale) / scale
+	result.Items = items
+	return result, nil
+}
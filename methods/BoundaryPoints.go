@@ -0,0 +1,169 @@
+package methods
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/Transformer"
+	"github.com/paulmach/orb"
+)
+
+// 界址点与四至：界址点自西北角起顺时针编号J1、J2…，坐标为投影坐标 [东坐标, 北坐标]
+
+// BoundaryPoint 界址点
+type BoundaryPoint struct {
+	No      int     `json:"no"`
+	Name    string  `json:"name"`    // 点号 J1…
+	X       float64 `json:"x"`       // 东坐标
+	Y       float64 `json:"y"`       // 北坐标
+	Lon     float64 `json:"lon"`     // 经度，由调用方填写
+	Lat     float64 `json:"lat"`     // 纬度，由调用方填写
+	Length  float64 `json:"length"`  // 至下一点的边长(米)
+	Bearing float64 `json:"bearing"` // 至下一点的方位角(度)
+	Side    string  `json:"side"`    // 该边所在方向 北/东/南/西
+}
+
+// BoundaryNeighbour 某一方向上的相邻要素
+type BoundaryNeighbour struct {
+	Name   string  `json:"name"`
+	Layer  string  `json:"layer"`
+	Length float64 `json:"length"` // 相邻边界长度
+}
+
+// FourNeighbours 四至
+type FourNeighbours struct {
+	B     string                         `json:"B"` // 北至
+	D     string                         `json:"D"` // 东至
+	N     string                         `json:"N"` // 南至
+	X     string                         `json:"X"` // 西至
+	Sides map[string][]BoundaryNeighbour `json:"sides"`
+}
+
+// SideOfBearing 按方位角划分方向，北为315-45度，东为45-135度，以此类推
+func SideOfBearing(bearing float64) string {
+	bearing = math.Mod(bearing+360, 360)
+	switch {
+	case bearing >= 315 || bearing < 45:
+		return "北"
+	case bearing < 135:
+		return "东"
+	case bearing < 225:
+		return "南"
+	}
+	return "西"
+}
+
+func pointBearing(from, to orb.Point) float64 {
+	b := math.Atan2(to[0]-from[0], to[1]-from[1]) * 180 / math.Pi
+	return math.Mod(b+360, 360)
+}
+
+// OrderBoundaryPoints 界址点排序：去掉重复的闭合点，统一为顺时针，自西北角起编号；
+// 每条边按外法线方位确定所在方向
+func OrderBoundaryPoints(ring orb.Ring) []BoundaryPoint {
+	points := make([]orb.Point, 0, len(ring))
+	for i, p := range ring {
+		if i > 0 && p.Equal(points[len(points)-1]) {
+			continue
+		}
+		points = append(points, p)
+	}
+	if len(points) > 1 && points[0].Equal(points[len(points)-1]) {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return nil
+	}
+	// 鞋带公式面积为正时为逆时针
+	area := 0.0
+	for i := range points {
+		j := (i + 1) % len(points)
+		area += points[i][0]*points[j][1] - points[j][0]*points[i][1]
+	}
+	if area > 0 {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	// 西北角：距外接矩形左上角最近的点
+	bound := orb.MultiPoint(points).Bound()
+	corner := orb.Point{bound.Min[0], bound.Max[1]}
+	start := 0
+	best := math.Inf(1)
+	for i, p := range points {
+		d := math.Hypot(p[0]-corner[0], p[1]-corner[1])
+		if d < best {
+			best, start = d, i
+		}
+	}
+
+	result := make([]BoundaryPoint, len(points))
+	for k := range points {
+		p := points[(start+k)%len(points)]
+		next := points[(start+k+1)%len(points)]
+		bearing := pointBearing(p, next)
+		result[k] = BoundaryPoint{
+			No:      k + 1,
+			Name:    "J" + strconv.Itoa(k+1),
+			X:       p[0],
+			Y:       p[1],
+			Length:  math.Hypot(next[0]-p[0], next[1]-p[1]),
+			Bearing: bearing,
+			// 顺时针时外侧在前进方向左边
+			Side: SideOfBearing(bearing - 90),
+		}
+	}
+	return result
+}
+
+// CGCS2000ZoneEPSG 经度所在的CGCS2000 3度带投影(带号前缀)EPSG，与Transformer.CGCS2000ZoneEPSG一致
+func CGCS2000ZoneEPSG(lon float64) int {
+	return Transformer.CGCS2000ZoneEPSG(lon)
+}
+
+// SummarizeNeighbours 汇总各方向的相邻要素，按相邻长度排序后以顿号连接，没有相邻要素的方向填写默认值
+func SummarizeNeighbours(sides map[string][]BoundaryNeighbour, defaultName string) FourNeighbours {
+	result := FourNeighbours{Sides: map[string][]BoundaryNeighbour{}}
+	for side, list := range sides {
+		merged := map[string]*BoundaryNeighbour{}
+		var order []string
+		for _, n := range list {
+			key := n.Layer + "\x00" + n.Name
+			if m, ok := merged[key]; ok {
+				m.Length += n.Length
+				continue
+			}
+			item := n
+			merged[key] = &item
+			order = append(order, key)
+		}
+		items := make([]BoundaryNeighbour, 0, len(order))
+		for _, key := range order {
+			items = append(items, *merged[key])
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Length > items[j].Length })
+		result.Sides[side] = items
+	}
+	describe := func(side string) string {
+		var names []string
+		seen := map[string]bool{}
+		for _, n := range result.Sides[side] {
+			if n.Name == "" || seen[n.Name] {
+				continue
+			}
+			seen[n.Name] = true
+			names = append(names, n.Name)
+		}
+		if len(names) == 0 {
+			return defaultName
+		}
+		return strings.Join(names, "、")
+	}
+	result.B = describe("北")
+	result.D = describe("东")
+	result.N = describe("南")
+	result.X = describe("西")
+	return result
+}
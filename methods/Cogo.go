@@ -0,0 +1,336 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// 坐标几何(COGO)导线计算，坐标均为投影坐标 [东坐标, 北坐标]，方位角为自坐标北方向顺时针量取
+
+// CogoLeg 导线边，Type 为 line(直线) 或 arc(圆弧)
+// 圆弧边可给出弦方位角+弦长(Bearing/Distance)，或起点切线方位角+弧长/圆心角(Bearing/ArcLength/Delta)
+type CogoLeg struct {
+	Type      string  `json:"type"`
+	Bearing   string  `json:"bearing"`    // 方位角：123.5、123°45'30"、123-45-30、123度45分30秒、N45°30'E
+	Distance  float64 `json:"distance"`   // 直线边长或圆弧弦长(米)
+	Radius    float64 `json:"radius"`     // 圆弧半径(米)
+	ArcLength float64 `json:"arc_length"` // 圆弧弧长(米)
+	Delta     string  `json:"delta"`      // 圆心角
+	Direction string  `json:"direction"`  // R 顺时针(右转) / L 逆时针(左转)
+	Major     bool    `json:"major"`      // 弦长方式给出时是否为大于180度的优弧
+}
+
+// CogoLegReport 单边计算结果
+type CogoLegReport struct {
+	Index       int     `json:"index"`
+	Type        string  `json:"type"`
+	Bearing     float64 `json:"bearing"`      // 弦方位角(十进制度)
+	Distance    float64 `json:"distance"`     // 弦长
+	ArcLength   float64 `json:"arc_length"`   // 弧长，直线边为0
+	Delta       float64 `json:"delta"`        // 圆心角(十进制度)，直线边为0
+	DX          float64 `json:"dx"`           // 东坐标增量
+	DY          float64 `json:"dy"`           // 北坐标增量
+	CorrX       float64 `json:"corr_x"`       // 终点东坐标改正数
+	CorrY       float64 `json:"corr_y"`       // 终点北坐标改正数
+	AdjBearing  float64 `json:"adj_bearing"`  // 平差后弦方位角
+	AdjDistance float64 `json:"adj_distance"` // 平差后弦长
+	EndX        float64 `json:"end_x"`        // 平差后终点东坐标
+	EndY        float64 `json:"end_y"`        // 平差后终点北坐标
+}
+
+// CogoMisclosure 闭合差
+type CogoMisclosure struct {
+	DX        float64 `json:"dx"`        // 东坐标闭合差
+	DY        float64 `json:"dy"`        // 北坐标闭合差
+	Linear    float64 `json:"linear"`    // 全长闭合差
+	Perimeter float64 `json:"perimeter"` // 导线全长(弦长之和)
+	Ratio     float64 `json:"ratio"`     // 全长相对闭合差分母，闭合差为0时为0
+	Precision string  `json:"precision"` // 1/N 形式
+}
+
+// CogoResult 导线计算结果
+type CogoResult struct {
+	Closed     bool            `json:"closed"`     // 是否闭合(回到起点或附合到已知点)
+	Adjusted   bool            `json:"adjusted"`   // 是否已做罗盘仪法则平差
+	Misclosure *CogoMisclosure `json:"misclosure"` // 非闭合导线为空
+	Legs       []CogoLegReport `json:"legs"`
+	Stations   []orb.Point     `json:"stations"` // 平差后各边终点(含起点)
+	Path       orb.LineString  `json:"path"`     // 加密圆弧后的完整路径
+	Area       float64         `json:"area"`     // 闭合为面时的平面面积(平方米)
+}
+
+var cogoNumberRe = regexp.MustCompile(`[0-9]+(?:\.[0-9]+)?`)
+
+// ParseAngle 解析角度，支持十进制度和度分秒，返回十进制度
+func ParseAngle(s string) (float64, error) {
+	nums := cogoNumberRe.FindAllString(s, -1)
+	if len(nums) == 0 || len(nums) > 3 {
+		return 0, fmt.Errorf("无法解析角度: %s", s)
+	}
+	value := 0.0
+	for i, n := range nums {
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("无法解析角度: %s", s)
+		}
+		if i > 0 && v >= 60 {
+			return 0, fmt.Errorf("角度分秒超出范围: %s", s)
+		}
+		value += v / math.Pow(60, float64(i))
+	}
+	if strings.HasPrefix(strings.TrimSpace(s), "-") {
+		value = -value
+	}
+	return value, nil
+}
+
+// ParseBearing 解析方位角，象限角(N45°30'E)换算为自北顺时针的方位角
+func ParseBearing(s string) (float64, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	if text == "" {
+		return 0, fmt.Errorf("方位角为空")
+	}
+	first, last := text[0], text[len(text)-1]
+	if (first == 'N' || first == 'S') && (last == 'E' || last == 'W') {
+		a, err := ParseAngle(text[1 : len(text)-1])
+		if err != nil {
+			return 0, err
+		}
+		if a < 0 || a > 90 {
+			return 0, fmt.Errorf("象限角超出范围: %s", s)
+		}
+		switch {
+		case first == 'N' && last == 'E':
+			return a, nil
+		case first == 'S' && last == 'E':
+			return 180 - a, nil
+		case first == 'S' && last == 'W':
+			return 180 + a, nil
+		default:
+			return math.Mod(360-a, 360), nil
+		}
+	}
+	// 十进制度数中的"-"按负号处理，度分秒中的"-"为分隔符
+	if strings.Count(text, "-") > 1 || (strings.Contains(text, "-") && !strings.HasPrefix(text, "-")) {
+		text = strings.ReplaceAll(text, "-", " ")
+	}
+	a, err := ParseAngle(text)
+	if err != nil {
+		return 0, err
+	}
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a, nil
+}
+
+func cogoOffset(p orb.Point, bearing, distance float64) orb.Point {
+	r := bearing * math.Pi / 180
+	return orb.Point{p[0] + distance*math.Sin(r), p[1] + distance*math.Cos(r)}
+}
+
+func cogoBearing(a, b orb.Point) float64 {
+	deg := math.Atan2(b[0]-a[0], b[1]-a[1]) * 180 / math.Pi
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+type cogoLegGeom struct {
+	bearing, chord, arcLength, delta float64
+	arc                              []orb.Point // 不含起点的圆弧加密点(含终点)
+}
+
+// computeLeg 计算单边的弦方位角、弦长及圆弧加密点
+func computeLeg(start orb.Point, leg CogoLeg, arcSegments int) (cogoLegGeom, error) {
+	var g cogoLegGeom
+	bearing, err := ParseBearing(leg.Bearing)
+	if err != nil {
+		return g, err
+	}
+	if !strings.EqualFold(leg.Type, "arc") {
+		if leg.Distance <= 0 {
+			return g, fmt.Errorf("边长必须大于0")
+		}
+		g.bearing, g.chord = bearing, leg.Distance
+		g.arc = []orb.Point{cogoOffset(start, bearing, leg.Distance)}
+		return g, nil
+	}
+
+	if leg.Radius <= 0 {
+		return g, fmt.Errorf("圆弧半径必须大于0")
+	}
+	right := !strings.EqualFold(leg.Direction, "L")
+	sign := 1.0
+	if !right {
+		sign = -1.0
+	}
+	var delta float64 // 弧度
+	switch {
+	case leg.Distance > 0:
+		// 弦长方式
+		if leg.Distance > 2*leg.Radius {
+			return g, fmt.Errorf("弦长%.3f大于圆弧直径", leg.Distance)
+		}
+		delta = 2 * math.Asin(leg.Distance/(2*leg.Radius))
+		if leg.Major {
+			delta = 2*math.Pi - delta
+		}
+		g.bearing, g.chord = bearing, leg.Distance
+	case leg.ArcLength > 0 || leg.Delta != "":
+		// 切线方式：Bearing为起点切线方位角
+		if leg.ArcLength > 0 {
+			delta = leg.ArcLength / leg.Radius
+		} else {
+			d, err := ParseAngle(leg.Delta)
+			if err != nil {
+				return g, err
+			}
+			delta = math.Abs(d) * math.Pi / 180
+		}
+		if delta <= 0 || delta >= 2*math.Pi {
+			return g, fmt.Errorf("圆心角超出范围")
+		}
+		g.bearing = math.Mod(bearing+sign*delta*90/math.Pi+360, 360)
+		g.chord = 2 * leg.Radius * math.Sin(delta/2)
+	default:
+		return g, fmt.Errorf("圆弧边需要弦长、弧长或圆心角")
+	}
+	g.delta = delta * 180 / math.Pi
+	g.arcLength = leg.Radius * delta
+
+	// 圆心：自起点沿切线方向右转(或左转)90度
+	tangent := math.Mod(g.bearing-sign*g.delta/2+360, 360)
+	center := cogoOffset(start, tangent+sign*90, leg.Radius)
+	startAngle := cogoBearing(center, start)
+	if arcSegments <= 0 {
+		arcSegments = 8
+	}
+	n := int(math.Ceil(g.delta / 90 * float64(arcSegments)))
+	if n < 2 {
+		n = 2
+	}
+	for i := 1; i <= n; i++ {
+		angle := startAngle + sign*g.delta*float64(i)/float64(n)
+		g.arc = append(g.arc, cogoOffset(center, angle, leg.Radius))
+	}
+	// 终点按弦计算，避免累积误差
+	g.arc[len(g.arc)-1] = cogoOffset(start, g.bearing, g.chord)
+	return g, nil
+}
+
+// ComputeTraverse 计算导线
+// closeTo 为附合的已知点，为空且 closePolygon 时闭合回起点；adjust 时按罗盘仪法则(Bowditch)分配闭合差
+// arcSegments 为圆弧每90度的加密段数
+func ComputeTraverse(start orb.Point, legs []CogoLeg, closeTo *orb.Point, closePolygon bool, adjust bool, arcSegments int) (*CogoResult, error) {
+	if len(legs) == 0 {
+		return nil, fmt.Errorf("导线边为空")
+	}
+	geoms := make([]cogoLegGeom, len(legs))
+	raw := []orb.Point{start}
+	current := start
+	perimeter := 0.0
+	for i, leg := range legs {
+		g, err := computeLeg(current, leg, arcSegments)
+		if err != nil {
+			return nil, fmt.Errorf("第%d条边: %v", i+1, err)
+		}
+		geoms[i] = g
+		current = g.arc[len(g.arc)-1]
+		raw = append(raw, current)
+		perimeter += g.chord
+	}
+
+	result := &CogoResult{}
+	var target *orb.Point
+	if closeTo != nil {
+		target = closeTo
+	} else if closePolygon {
+		target = &start
+	}
+	var misX, misY float64
+	if target != nil {
+		result.Closed = true
+		misX, misY = current[0]-target[0], current[1]-target[1]
+		m := &CogoMisclosure{DX: misX, DY: misY, Linear: math.Hypot(misX, misY), Perimeter: perimeter}
+		if m.Linear > 1e-9 {
+			m.Ratio = perimeter / m.Linear
+			m.Precision = fmt.Sprintf("1/%d", int64(math.Floor(m.Ratio)))
+		} else {
+			m.Precision = "1/∞"
+		}
+		result.Misclosure = m
+		result.Adjusted = adjust && perimeter > 0
+	}
+
+	// 各点改正数与累计弦长成正比，圆弧加密点按其在弦上的比例插值
+	correction := func(cum float64) (float64, float64) {
+		if !result.Adjusted {
+			return 0, 0
+		}
+		return -misX * cum / perimeter, -misY * cum / perimeter
+	}
+	result.Stations = []orb.Point{start}
+	result.Path = orb.LineString{start}
+	cum := 0.0
+	for i, g := range geoms {
+		cx0, cy0 := correction(cum)
+		cum += g.chord
+		cx1, cy1 := correction(cum)
+		n := len(g.arc)
+		for j, p := range g.arc {
+			t := float64(j+1) / float64(n)
+			result.Path = append(result.Path, orb.Point{p[0] + cx0 + (cx1-cx0)*t, p[1] + cy0 + (cy1-cy0)*t})
+		}
+		end := orb.Point{raw[i+1][0] + cx1, raw[i+1][1] + cy1}
+		result.Path[len(result.Path)-1] = end
+		prev := result.Stations[len(result.Stations)-1]
+		result.Stations = append(result.Stations, end)
+		result.Legs = append(result.Legs, CogoLegReport{
+			Index:       i + 1,
+			Type:        strings.ToLower(legs[i].Type),
+			Bearing:     g.bearing,
+			Distance:    g.chord,
+			ArcLength:   g.arcLength,
+			Delta:       g.delta,
+			DX:          raw[i+1][0] - raw[i][0],
+			DY:          raw[i+1][1] - raw[i][1],
+			CorrX:       cx1,
+			CorrY:       cy1,
+			AdjBearing:  cogoBearing(prev, end),
+			AdjDistance: math.Hypot(end[0]-prev[0], end[1]-prev[1]),
+			EndX:        end[0],
+			EndY:        end[1],
+		})
+		if result.Legs[i].Type == "" {
+			result.Legs[i].Type = "line"
+		}
+	}
+	if closePolygon && closeTo == nil {
+		// 闭合为面：平差后末点与起点重合，未平差时补一条闭合边
+		if result.Adjusted {
+			result.Path[len(result.Path)-1] = start
+			result.Stations[len(result.Stations)-1] = start
+		} else {
+			result.Path = append(result.Path, start)
+		}
+		result.Area = math.Abs(cogoRingArea(result.Path))
+	}
+	return result, nil
+}
+
+// cogoRingArea 平面面积(鞋带公式)
+func cogoRingArea(ring orb.LineString) float64 {
+	area := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
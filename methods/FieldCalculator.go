@@ -18,6 +18,11 @@ func NewFieldCalculatorService() *FieldCalculatorService {
 
 // CalculateField 执行字段计算
 func (s *FieldCalculatorService) CalculateField(req models.FieldCalculatorRequest) (*models.FieldCalculatorResponse, error) {
+	return s.CalculateFieldTx(models.DB, req)
+}
+
+// CalculateFieldTx 在指定连接（事务）中执行字段计算
+func (s *FieldCalculatorService) CalculateFieldTx(db *gorm.DB, req models.FieldCalculatorRequest) (*models.FieldCalculatorResponse, error) {
 	// 1. 验证表和字段是否存在
 	if err := s.validateTableAndField(req.TableName, req.TargetField); err != nil {
 		return nil, err
@@ -49,7 +54,7 @@ func (s *FieldCalculatorService) CalculateField(req models.FieldCalculatorReques
 	}
 
 	// 3. 执行SQL
-	result := models.DB.Exec(sqlStatement)
+	result := db.Exec(sqlStatement)
 	if result.Error != nil {
 		return nil, fmt.Errorf("执行SQL失败: %v", result.Error)
 	}
@@ -644,14 +649,13 @@ func (s *GeometryService) UpdateGeometryField(DB *gorm.DB, ctx context.Context,
 		return nil, err
 	}
 
-	// 执行更新
-	db, _ := DB.DB()
-	result, err := db.ExecContext(ctx, updateSQL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update geometry field: %w", err)
+	// 执行更新（DB可以是事务）
+	result := DB.WithContext(ctx).Exec(updateSQL)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update geometry field: %w", result.Error)
 	}
 
-	rowsAffected, _ := result.RowsAffected()
+	rowsAffected := result.RowsAffected
 
 	return &models.GeometryUpdateResponse{
 		TableName:    req.TableName,
@@ -0,0 +1,1061 @@
+package methods
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// 字段表达式：解析类似QGIS的表达式并编译为安全的SQL片段
+// 字段以双引号或标识符引用，字符串用单引号；只允许白名单中的函数，字段和关联图层在编译时校验
+// 编译结果中当前图层的别名为 t
+
+// ExprType 表达式值类型
+type ExprType string
+
+const (
+	ExprNumber ExprType = "number"
+	ExprString ExprType = "string"
+	ExprBool   ExprType = "bool"
+	ExprDate   ExprType = "date"
+	ExprNull   ExprType = "null"
+	ExprAny    ExprType = "any"
+)
+
+// CompiledExpression 编译后的表达式
+type CompiledExpression struct {
+	SQL    string   `json:"sql"`
+	Type   ExprType `json:"type"`
+	Fields []string `json:"fields"` // 引用的本图层字段
+	Layers []string `json:"layers"` // 引用的关联图层
+}
+
+// ExpressionFunction 可用函数说明
+type ExpressionFunction struct {
+	Name        string `json:"name"`
+	Group       string `json:"group"`
+	Syntax      string `json:"syntax"`
+	Description string `json:"description"`
+}
+
+// ==================== 词法分析 ====================
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokQuoted
+	tokVar
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+func tokenizeExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(src)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					i = j
+					for i < len(runes) && unicode.IsDigit(runes[i]) {
+						i++
+					}
+				}
+			}
+			text := string(runes[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("位置%d: 无效的数字 %s", start+1, text)
+			}
+			tokens = append(tokens, exprToken{tokNumber, text, start})
+		case r == '\'' || r == '"':
+			// 字符串和带引号的字段名，连续两个引号表示引号本身
+			start := i
+			quote := r
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(runes) {
+				if runes[i] == quote {
+					if i+1 < len(runes) && runes[i+1] == quote {
+						sb.WriteRune(quote)
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("位置%d: 引号未闭合", start+1)
+			}
+			kind := tokString
+			if quote == '"' {
+				kind = tokQuoted
+			}
+			tokens = append(tokens, exprToken{kind, sb.String(), start})
+		case r == '$' || r == '_' || unicode.IsLetter(r):
+			start := i
+			i++
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			text := string(runes[start:i])
+			kind := tokIdent
+			if r == '$' {
+				kind = tokVar
+			}
+			tokens = append(tokens, exprToken{kind, text, start})
+		case r == '(':
+			tokens = append(tokens, exprToken{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, exprToken{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, exprToken{tokComma, ",", i})
+			i++
+		default:
+			start := i
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "<=", ">=", "<>", "!=", "||":
+				tokens = append(tokens, exprToken{tokOp, two, start})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("+-*/%^=<>", r) {
+				tokens = append(tokens, exprToken{tokOp, string(r), start})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("位置%d: 无法识别的字符 %q", start+1, r)
+		}
+	}
+	tokens = append(tokens, exprToken{tokEOF, "", len(runes)})
+	return tokens, nil
+}
+
+// ==================== 语法分析与编译 ====================
+
+type exprValue struct {
+	sql string
+	typ ExprType
+}
+
+type exprCompiler struct {
+	db        *gorm.DB
+	tableName string
+	columns   map[string]string // 字段名 -> data_type
+	tokens    []exprToken
+	pos       int
+	fields    map[string]bool
+	layers    map[string]bool
+	layerCols map[string]map[string]string
+	aliasSeq  int
+}
+
+// TableColumnTypes 读取表字段及类型，表不存在时返回空
+func TableColumnTypes(db *gorm.DB, tableName string) map[string]string {
+	var rows []struct {
+		ColumnName string
+		DataType   string
+	}
+	db.Raw(`SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = 'public' AND table_name = ?`,
+		tableName).Scan(&rows)
+	result := make(map[string]string, len(rows))
+	for _, row := range rows {
+		result[row.ColumnName] = row.DataType
+	}
+	return result
+}
+
+func columnExprType(dataType string) ExprType {
+	switch dataType {
+	case "smallint", "integer", "bigint", "numeric", "real", "double precision":
+		return ExprNumber
+	case "character varying", "character", "text":
+		return ExprString
+	case "boolean":
+		return ExprBool
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		return ExprDate
+	}
+	return ExprAny
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func toNumberSQL(v exprValue) string {
+	switch v.typ {
+	case ExprNumber:
+		return v.sql
+	case ExprNull:
+		return "NULL::numeric"
+	case ExprBool:
+		return fmt.Sprintf("(%s)::int", v.sql)
+	}
+	// 文本按数字格式解析，不能解析时为NULL，避免整条语句失败
+	return fmt.Sprintf(`(CASE WHEN trim((%s)::text) ~ '^[-+]?[0-9]*\.?[0-9]+([eE][-+]?[0-9]+)?$' THEN trim((%s)::text)::numeric END)`, v.sql, v.sql)
+}
+
+func toTextSQL(v exprValue) string {
+	if v.typ == ExprString {
+		return v.sql
+	}
+	return fmt.Sprintf("(%s)::text", v.sql)
+}
+
+func toDateSQL(v exprValue) string {
+	if v.typ == ExprDate {
+		return fmt.Sprintf("(%s)::timestamp", v.sql)
+	}
+	return fmt.Sprintf("(%s)::text::timestamp", v.sql)
+}
+
+func toBoolSQL(v exprValue) string {
+	if v.typ == ExprBool || v.typ == ExprNull {
+		return v.sql
+	}
+	if v.typ == ExprNumber {
+		return fmt.Sprintf("((%s) <> 0)", v.sql)
+	}
+	return fmt.Sprintf("(%s)::text::boolean", v.sql)
+}
+
+func (p *exprCompiler) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprCompiler) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprCompiler) errorf(t exprToken, format string, args ...interface{}) error {
+	return fmt.Errorf("位置%d: %s", t.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprCompiler) isKeyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprCompiler) expectKeyword(word string) error {
+	if !p.isKeyword(word) {
+		return p.errorf(p.peek(), "缺少 %s", word)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprCompiler) expect(kind exprTokenKind, text string) error {
+	t := p.peek()
+	if t.kind != kind {
+		return p.errorf(t, "缺少 %s", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprCompiler) parseOr() (exprValue, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		left = exprValue{fmt.Sprintf("(%s OR %s)", toBoolSQL(left), toBoolSQL(right)), ExprBool}
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseAnd() (exprValue, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		left = exprValue{fmt.Sprintf("(%s AND %s)", toBoolSQL(left), toBoolSQL(right)), ExprBool}
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseNot() (exprValue, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		v, err := p.parseNot()
+		if err != nil {
+			return v, err
+		}
+		return exprValue{fmt.Sprintf("(NOT %s)", toBoolSQL(v)), ExprBool}, nil
+	}
+	return p.parseComparison()
+}
+
+// compareOperands 统一比较两侧的类型
+func compareOperands(a, b exprValue) (string, string) {
+	switch {
+	case a.typ == b.typ || a.typ == ExprNull || b.typ == ExprNull:
+		return a.sql, b.sql
+	case a.typ == ExprNumber || b.typ == ExprNumber:
+		return toNumberSQL(a), toNumberSQL(b)
+	case a.typ == ExprDate || b.typ == ExprDate:
+		return toDateSQL(a), toDateSQL(b)
+	}
+	return toTextSQL(a), toTextSQL(b)
+}
+
+var comparisonOps = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *exprCompiler) parseComparison() (exprValue, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return left, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && comparisonOps[t.text]:
+		p.next()
+		right, err := p.parseConcat()
+		if err != nil {
+			return right, err
+		}
+		op := t.text
+		if op == "!=" {
+			op = "<>"
+		}
+		l, r := compareOperands(left, right)
+		return exprValue{fmt.Sprintf("(%s %s %s)", l, op, r), ExprBool}, nil
+	case p.isKeyword("IS"):
+		p.next()
+		not := ""
+		if p.isKeyword("NOT") {
+			p.next()
+			not = " NOT"
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return left, err
+		}
+		return exprValue{fmt.Sprintf("(%s IS%s NULL)", left.sql, not), ExprBool}, nil
+	case p.isKeyword("NOT", "LIKE", "ILIKE", "IN"):
+		not := ""
+		if p.isKeyword("NOT") {
+			p.next()
+			not = "NOT "
+		}
+		switch {
+		case p.isKeyword("LIKE", "ILIKE"):
+			op := strings.ToUpper(p.next().text)
+			right, err := p.parseConcat()
+			if err != nil {
+				return right, err
+			}
+			return exprValue{fmt.Sprintf("(%s %s%s %s)", toTextSQL(left), not, op, toTextSQL(right)), ExprBool}, nil
+		case p.isKeyword("IN"):
+			p.next()
+			if err := p.expect(tokLParen, "("); err != nil {
+				return left, err
+			}
+			var items []exprValue
+			for {
+				item, err := p.parseConcat()
+				if err != nil {
+					return item, err
+				}
+				items = append(items, item)
+				if p.peek().kind != tokComma {
+					break
+				}
+				p.next()
+			}
+			if err := p.expect(tokRParen, ")"); err != nil {
+				return left, err
+			}
+			// 左侧或全部列表项为数字时按数字比较，否则按文本比较
+			numeric := left.typ == ExprNumber
+			if !numeric && left.typ != ExprString {
+				numeric = true
+				for _, item := range items {
+					if item.typ != ExprNumber {
+						numeric = false
+						break
+					}
+				}
+			}
+			l := toTextSQL(left)
+			if numeric {
+				l = toNumberSQL(left)
+			}
+			list := make([]string, len(items))
+			for i, item := range items {
+				if numeric {
+					list[i] = toNumberSQL(item)
+				} else {
+					list[i] = toTextSQL(item)
+				}
+			}
+			return exprValue{fmt.Sprintf("(%s %sIN (%s))", l, not, strings.Join(list, ", ")), ExprBool}, nil
+		}
+		return left, p.errorf(p.peek(), "NOT 之后应为 LIKE、ILIKE 或 IN")
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseConcat() (exprValue, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return right, err
+		}
+		// 与 concat() 一致，NULL 按空字符串处理
+		left = exprValue{fmt.Sprintf("(COALESCE(%s, '') || COALESCE(%s, ''))", toTextSQL(left), toTextSQL(right)), ExprString}
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseAdditive() (exprValue, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().kind == tokOp && (p.peek().text == "+" || p.peek().text == "-") {
+		op := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return right, err
+		}
+		// 日期加减天数
+		if left.typ == ExprDate && right.typ == ExprNumber {
+			left = exprValue{fmt.Sprintf("((%s)::date %s (%s)::int)", left.sql, op, right.sql), ExprDate}
+			continue
+		}
+		left = exprValue{fmt.Sprintf("(%s %s %s)", toNumberSQL(left), op, toNumberSQL(right)), ExprNumber}
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseMultiplicative() (exprValue, error) {
+	left, err := p.parsePower()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().kind == tokOp && strings.Contains("*/%", p.peek().text) {
+		op := p.next().text
+		right, err := p.parsePower()
+		if err != nil {
+			return right, err
+		}
+		switch op {
+		case "*":
+			left = exprValue{fmt.Sprintf("(%s * %s)", toNumberSQL(left), toNumberSQL(right)), ExprNumber}
+		default:
+			// 除数为0时结果为NULL
+			left = exprValue{fmt.Sprintf("(%s %s NULLIF(%s, 0))", toNumberSQL(left), op, toNumberSQL(right)), ExprNumber}
+		}
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parsePower() (exprValue, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+	if p.peek().kind == tokOp && p.peek().text == "^" {
+		p.next()
+		right, err := p.parsePower()
+		if err != nil {
+			return right, err
+		}
+		return exprValue{fmt.Sprintf("power(%s, %s)", toNumberSQL(left), toNumberSQL(right)), ExprNumber}, nil
+	}
+	return left, nil
+}
+
+func (p *exprCompiler) parseUnary() (exprValue, error) {
+	if p.peek().kind == tokOp && (p.peek().text == "-" || p.peek().text == "+") {
+		op := p.next().text
+		v, err := p.parseUnary()
+		if err != nil {
+			return v, err
+		}
+		if op == "+" {
+			return exprValue{toNumberSQL(v), ExprNumber}, nil
+		}
+		return exprValue{fmt.Sprintf("(-%s)", toNumberSQL(v)), ExprNumber}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprCompiler) field(t exprToken, name string) (exprValue, error) {
+	dataType, ok := p.columns[name]
+	if !ok {
+		// 字段名不区分大小写
+		for col, typ := range p.columns {
+			if strings.EqualFold(col, name) {
+				name, dataType, ok = col, typ, true
+				break
+			}
+		}
+	}
+	if !ok || name == "geom" {
+		return exprValue{}, p.errorf(t, "字段 %s 不存在", name)
+	}
+	p.fields[name] = true
+	return exprValue{"t." + quoteIdent(name), columnExprType(dataType)}, nil
+}
+
+func (p *exprCompiler) parsePrimary() (exprValue, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return exprValue{t.text, ExprNumber}, nil
+	case tokString:
+		return exprValue{quoteLiteral(t.text), ExprString}, nil
+	case tokQuoted:
+		return p.field(t, t.text)
+	case tokVar:
+		return p.variable(t)
+	case tokLParen:
+		v, err := p.parseOr()
+		if err != nil {
+			return v, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return v, err
+		}
+		return exprValue{"(" + v.sql + ")", v.typ}, nil
+	case tokIdent:
+		upper := strings.ToUpper(t.text)
+		switch upper {
+		case "NULL":
+			return exprValue{"NULL", ExprNull}, nil
+		case "TRUE", "FALSE":
+			return exprValue{upper, ExprBool}, nil
+		case "CASE":
+			return p.parseCase()
+		}
+		if p.peek().kind == tokLParen {
+			return p.parseFunction(t)
+		}
+		return p.field(t, t.text)
+	}
+	if t.kind == tokEOF {
+		return exprValue{}, p.errorf(t, "表达式不完整")
+	}
+	return exprValue{}, p.errorf(t, "意外的 %s", t.text)
+}
+
+func (p *exprCompiler) parseCase() (exprValue, error) {
+	var conds []string
+	var results []exprValue
+	for p.isKeyword("WHEN") {
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return cond, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return cond, err
+		}
+		result, err := p.parseOr()
+		if err != nil {
+			return result, err
+		}
+		conds = append(conds, toBoolSQL(cond))
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return exprValue{}, p.errorf(p.peek(), "CASE 至少需要一个 WHEN")
+	}
+	if p.isKeyword("ELSE") {
+		p.next()
+		result, err := p.parseOr()
+		if err != nil {
+			return result, err
+		}
+		results = append(results, result)
+	}
+	if err := p.expectKeyword("END"); err != nil {
+		return exprValue{}, err
+	}
+	typ, values := unifyResults(results)
+	var sb strings.Builder
+	sb.WriteString("(CASE")
+	for i, cond := range conds {
+		sb.WriteString(" WHEN " + cond + " THEN " + values[i])
+	}
+	if len(values) > len(conds) {
+		sb.WriteString(" ELSE " + values[len(conds)])
+	}
+	sb.WriteString(" END)")
+	return exprValue{sb.String(), typ}, nil
+}
+
+// unifyResults 分支结果类型不一致时统一为文本或数字
+func unifyResults(results []exprValue) (ExprType, []string) {
+	typ := ExprNull
+	for _, r := range results {
+		if r.typ == ExprNull {
+			continue
+		}
+		if typ == ExprNull {
+			typ = r.typ
+		} else if typ != r.typ {
+			typ = ExprString
+		}
+	}
+	args := make([]string, len(results))
+	for i, r := range results {
+		switch {
+		case r.typ == typ || r.typ == ExprNull:
+			args[i] = r.sql
+		case typ == ExprString:
+			args[i] = toTextSQL(r)
+		default:
+			args[i] = r.sql
+		}
+	}
+	return typ, args
+}
+
+func (p *exprCompiler) variable(t exprToken) (exprValue, error) {
+	switch strings.ToLower(t.text) {
+	case "$area":
+		return exprValue{"ST_Area(t.geom::geography)", ExprNumber}, nil
+	case "$length":
+		return exprValue{"ST_Length(t.geom::geography)", ExprNumber}, nil
+	case "$perimeter":
+		return exprValue{"ST_Perimeter(t.geom::geography)", ExprNumber}, nil
+	case "$x":
+		return exprValue{"ST_X(ST_Centroid(t.geom))", ExprNumber}, nil
+	case "$y":
+		return exprValue{"ST_Y(ST_Centroid(t.geom))", ExprNumber}, nil
+	case "$id":
+		return exprValue{"t.id", ExprNumber}, nil
+	case "$geometry_type":
+		return exprValue{"GeometryType(t.geom)", ExprString}, nil
+	case "$num_points":
+		return exprValue{"ST_NPoints(t.geom)", ExprNumber}, nil
+	}
+	return exprValue{}, p.errorf(t, "未知变量 %s", t.text)
+}
+
+// literalArg 读取必须为字符串常量的参数（图层名、字段名、格式串）
+func (p *exprCompiler) literalArg() (string, error) {
+	t := p.next()
+	if t.kind != tokString {
+		return "", p.errorf(t, "此处参数必须是字符串常量")
+	}
+	return t.text, nil
+}
+
+func (p *exprCompiler) layerColumn(t exprToken, layer, field string) (string, ExprType, error) {
+	cols, ok := p.layerCols[layer]
+	if !ok {
+		cols = TableColumnTypes(p.db, layer)
+		p.layerCols[layer] = cols
+	}
+	if len(cols) == 0 {
+		return "", ExprAny, p.errorf(t, "图层 %s 不存在", layer)
+	}
+	if _, ok := cols["geom"]; !ok {
+		return "", ExprAny, p.errorf(t, "图层 %s 没有geom字段", layer)
+	}
+	p.layers[layer] = true
+	if field == "" {
+		return "", ExprAny, nil
+	}
+	dataType, ok := cols[field]
+	if !ok || field == "geom" {
+		return "", ExprAny, p.errorf(t, "图层 %s 中字段 %s 不存在", layer, field)
+	}
+	return field, columnExprType(dataType), nil
+}
+
+func (p *exprCompiler) nextAlias() string {
+	p.aliasSeq++
+	return fmt.Sprintf("r%d", p.aliasSeq)
+}
+
+// parseFunction 编译函数调用；关联图层函数的图层名和字段名必须是字符串常量
+func (p *exprCompiler) parseFunction(nameTok exprToken) (exprValue, error) {
+	name := strings.ToLower(nameTok.text)
+	p.next() // (
+	switch name {
+	case "lookup":
+		// lookup('图层', '返回字段', '关联字段', 关联值)
+		layer, err := p.literalArg()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.expect(tokComma, ","); err != nil {
+			return exprValue{}, err
+		}
+		ret, err := p.literalArg()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.expect(tokComma, ","); err != nil {
+			return exprValue{}, err
+		}
+		key, err := p.literalArg()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.expect(tokComma, ","); err != nil {
+			return exprValue{}, err
+		}
+		value, err := p.parseOr()
+		if err != nil {
+			return value, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return exprValue{}, err
+		}
+		retCol, retType, err := p.layerColumn(nameTok, layer, ret)
+		if err != nil {
+			return exprValue{}, err
+		}
+		keyCol, _, err := p.layerColumn(nameTok, layer, key)
+		if err != nil {
+			return exprValue{}, err
+		}
+		a := p.nextAlias()
+		return exprValue{fmt.Sprintf("(SELECT %s.%s FROM %s %s WHERE %s.%s::text = %s ORDER BY %s.id LIMIT 1)",
+			a, quoteIdent(retCol), quoteIdent(layer), a, a, quoteIdent(keyCol), toTextSQL(value), a), retType}, nil
+	case "intersects_value", "intersects_count", "intersects_area":
+		// intersects_value('图层', '字段')：取重叠面积最大的相交要素的字段值
+		layer, err := p.literalArg()
+		if err != nil {
+			return exprValue{}, err
+		}
+		field := ""
+		if name == "intersects_value" {
+			if err := p.expect(tokComma, ","); err != nil {
+				return exprValue{}, err
+			}
+			if field, err = p.literalArg(); err != nil {
+				return exprValue{}, err
+			}
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return exprValue{}, err
+		}
+		col, colType, err := p.layerColumn(nameTok, layer, field)
+		if err != nil {
+			return exprValue{}, err
+		}
+		a := p.nextAlias()
+		switch name {
+		case "intersects_count":
+			return exprValue{fmt.Sprintf("(SELECT count(*) FROM %s %s WHERE ST_Intersects(%s.geom, t.geom))",
+				quoteIdent(layer), a, a), ExprNumber}, nil
+		case "intersects_area":
+			return exprValue{fmt.Sprintf("(SELECT COALESCE(SUM(ST_Area(ST_Intersection(ST_MakeValid(%s.geom), ST_MakeValid(t.geom))::geography)), 0) FROM %s %s WHERE ST_Intersects(%s.geom, t.geom))",
+				a, quoteIdent(layer), a, a), ExprNumber}, nil
+		}
+		return exprValue{fmt.Sprintf("(SELECT %s.%s FROM %s %s WHERE ST_Intersects(%s.geom, t.geom) ORDER BY ST_Area(ST_Intersection(ST_MakeValid(%s.geom), ST_MakeValid(t.geom))) DESC, %s.id LIMIT 1)",
+			a, quoteIdent(col), quoteIdent(layer), a, a, a, a), colType}, nil
+	}
+
+	spec, ok := expressionFunctions[name]
+	if !ok {
+		return exprValue{}, p.errorf(nameTok, "不支持的函数 %s", nameTok.text)
+	}
+	var args []exprValue
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return arg, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return exprValue{}, err
+	}
+	if len(args) < spec.min || (spec.max >= 0 && len(args) > spec.max) {
+		return exprValue{}, p.errorf(nameTok, "函数 %s 的参数个数不正确，用法: %s", name, spec.syntax)
+	}
+	return spec.build(args), nil
+}
+
+type exprFunctionSpec struct {
+	group, syntax, description string
+	min, max                   int // max为-1表示不限
+	build                      func(args []exprValue) exprValue
+}
+
+func numArgs(args []exprValue) []string {
+	result := make([]string, len(args))
+	for i, a := range args {
+		result[i] = toNumberSQL(a)
+	}
+	return result
+}
+
+func textArgs(args []exprValue) []string {
+	result := make([]string, len(args))
+	for i, a := range args {
+		result[i] = toTextSQL(a)
+	}
+	return result
+}
+
+func numFunc(sqlName string) func(args []exprValue) exprValue {
+	return func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("%s(%s)", sqlName, strings.Join(numArgs(args), ", ")), ExprNumber}
+	}
+}
+
+func textFunc(sqlName string, typ ExprType) func(args []exprValue) exprValue {
+	return func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("%s(%s)", sqlName, strings.Join(textArgs(args), ", ")), typ}
+	}
+}
+
+func dateField(part string) func(args []exprValue) exprValue {
+	return func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("EXTRACT(%s FROM %s)::int", part, toDateSQL(args[0])), ExprNumber}
+	}
+}
+
+var expressionFunctions = map[string]exprFunctionSpec{
+	// 条件
+	"if": {"条件", "if(条件, 值1, 值2)", "条件成立返回值1，否则返回值2", 3, 3, func(args []exprValue) exprValue {
+		typ, vals := unifyResults(args[1:])
+		return exprValue{fmt.Sprintf("(CASE WHEN %s THEN %s ELSE %s END)", toBoolSQL(args[0]), vals[0], vals[1]), typ}
+	}},
+	"coalesce": {"条件", "coalesce(值1, 值2, ...)", "返回第一个非空值", 1, -1, func(args []exprValue) exprValue {
+		typ, vals := unifyResults(args)
+		return exprValue{fmt.Sprintf("COALESCE(%s)", strings.Join(vals, ", ")), typ}
+	}},
+	"nullif": {"条件", "nullif(值1, 值2)", "两值相等时返回空", 2, 2, func(args []exprValue) exprValue {
+		l, r := compareOperands(args[0], args[1])
+		return exprValue{fmt.Sprintf("NULLIF(%s, %s)", l, r), args[0].typ}
+	}},
+	// 数学
+	"abs":   {"数学", "abs(x)", "绝对值", 1, 1, numFunc("abs")},
+	"floor": {"数学", "floor(x)", "向下取整", 1, 1, numFunc("floor")},
+	"ceil":  {"数学", "ceil(x)", "向上取整", 1, 1, numFunc("ceil")},
+	"sqrt":  {"数学", "sqrt(x)", "平方根", 1, 1, numFunc("sqrt")},
+	"exp":   {"数学", "exp(x)", "e的x次方", 1, 1, numFunc("exp")},
+	"ln":    {"数学", "ln(x)", "自然对数", 1, 1, numFunc("ln")},
+	"log10": {"数学", "log10(x)", "常用对数", 1, 1, numFunc("log")},
+	"power": {"数学", "power(x, y)", "x的y次方", 2, 2, numFunc("power")},
+	"pi":    {"数学", "pi()", "圆周率", 0, 0, numFunc("pi")},
+	"min":   {"数学", "min(x, y, ...)", "最小值", 1, -1, numFunc("LEAST")},
+	"max":   {"数学", "max(x, y, ...)", "最大值", 1, -1, numFunc("GREATEST")},
+	"round": {"数学", "round(x[, 小数位])", "四舍五入", 1, 2, func(args []exprValue) exprValue {
+		if len(args) == 1 {
+			return exprValue{fmt.Sprintf("round((%s)::numeric)", toNumberSQL(args[0])), ExprNumber}
+		}
+		return exprValue{fmt.Sprintf("round((%s)::numeric, (%s)::int)", toNumberSQL(args[0]), toNumberSQL(args[1])), ExprNumber}
+	}},
+	"to_int": {"转换", "to_int(x)", "转为整数", 1, 1, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("round((%s)::numeric)::bigint", toNumberSQL(args[0])), ExprNumber}
+	}},
+	"to_real": {"转换", "to_real(x)", "转为数字", 1, 1, func(args []exprValue) exprValue {
+		return exprValue{toNumberSQL(args[0]), ExprNumber}
+	}},
+	"to_string": {"转换", "to_string(x)", "转为文本", 1, 1, func(args []exprValue) exprValue {
+		return exprValue{toTextSQL(args[0]), ExprString}
+	}},
+	// 字符串
+	"upper":   {"字符串", "upper(s)", "转大写", 1, 1, textFunc("upper", ExprString)},
+	"lower":   {"字符串", "lower(s)", "转小写", 1, 1, textFunc("lower", ExprString)},
+	"trim":    {"字符串", "trim(s)", "去除首尾空白", 1, 1, textFunc("trim", ExprString)},
+	"length":  {"字符串", "length(s)", "字符数", 1, 1, textFunc("char_length", ExprNumber)},
+	"concat":  {"字符串", "concat(s1, s2, ...)", "拼接，空值按空字符串处理", 1, -1, textFunc("concat", ExprString)},
+	"replace": {"字符串", "replace(s, 查找, 替换)", "替换全部匹配的子串", 3, 3, textFunc("replace", ExprString)},
+	"regexp_replace": {"字符串", "regexp_replace(s, 正则, 替换)", "正则替换全部匹配", 3, 3, func(args []exprValue) exprValue {
+		t := textArgs(args)
+		return exprValue{fmt.Sprintf("regexp_replace(%s, %s, %s, 'g')", t[0], t[1], t[2]), ExprString}
+	}},
+	"strpos": {"字符串", "strpos(s, 子串)", "子串位置，从1开始，未找到为0", 2, 2, textFunc("strpos", ExprNumber)},
+	"substr": {"字符串", "substr(s, 起始[, 长度])", "截取子串，起始位置从1开始", 2, 3, func(args []exprValue) exprValue {
+		parts := []string{toTextSQL(args[0])}
+		for _, a := range args[1:] {
+			parts = append(parts, fmt.Sprintf("(%s)::int", toNumberSQL(a)))
+		}
+		return exprValue{fmt.Sprintf("substr(%s)", strings.Join(parts, ", ")), ExprString}
+	}},
+	"left": {"字符串", "left(s, n)", "左侧n个字符", 2, 2, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("left(%s, (%s)::int)", toTextSQL(args[0]), toNumberSQL(args[1])), ExprString}
+	}},
+	"right": {"字符串", "right(s, n)", "右侧n个字符", 2, 2, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("right(%s, (%s)::int)", toTextSQL(args[0]), toNumberSQL(args[1])), ExprString}
+	}},
+	"lpad": {"字符串", "lpad(s, 长度, 填充)", "左侧填充到指定长度", 3, 3, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("lpad(%s, (%s)::int, %s)", toTextSQL(args[0]), toNumberSQL(args[1]), toTextSQL(args[2])), ExprString}
+	}},
+	"rpad": {"字符串", "rpad(s, 长度, 填充)", "右侧填充到指定长度", 3, 3, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("rpad(%s, (%s)::int, %s)", toTextSQL(args[0]), toNumberSQL(args[1]), toTextSQL(args[2])), ExprString}
+	}},
+	// 日期
+	"now":   {"日期", "now()", "当前时间", 0, 0, func(args []exprValue) exprValue { return exprValue{"now()::timestamp", ExprDate} }},
+	"today": {"日期", "today()", "当前日期", 0, 0, func(args []exprValue) exprValue { return exprValue{"current_date", ExprDate} }},
+	"to_date": {"日期", "to_date(s[, 格式])", "文本转日期，格式如 'YYYY-MM-DD'", 1, 2, func(args []exprValue) exprValue {
+		if len(args) == 1 {
+			return exprValue{fmt.Sprintf("(%s)::date", toTextSQL(args[0])), ExprDate}
+		}
+		return exprValue{fmt.Sprintf("to_date(%s, %s)", toTextSQL(args[0]), toTextSQL(args[1])), ExprDate}
+	}},
+	"format_date": {"日期", "format_date(d, 格式)", "日期格式化，格式如 'YYYY年MM月DD日'", 2, 2, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("to_char(%s, %s)", toDateSQL(args[0]), toTextSQL(args[1])), ExprString}
+	}},
+	"year":  {"日期", "year(d)", "年", 1, 1, dateField("YEAR")},
+	"month": {"日期", "month(d)", "月", 1, 1, dateField("MONTH")},
+	"day":   {"日期", "day(d)", "日", 1, 1, dateField("DAY")},
+	"day_diff": {"日期", "day_diff(d1, d2)", "两个日期相差的天数 d1-d2", 2, 2, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("(%s::date - %s::date)", toDateSQL(args[0]), toDateSQL(args[1])), ExprNumber}
+	}},
+	"add_days": {"日期", "add_days(d, n)", "日期加n天", 2, 2, func(args []exprValue) exprValue {
+		return exprValue{fmt.Sprintf("(%s::date + (%s)::int)", toDateSQL(args[0]), toNumberSQL(args[1])), ExprDate}
+	}},
+}
+
+// ExpressionFunctions 返回可用的函数、变量和关联图层函数说明
+func ExpressionFunctions() []ExpressionFunction {
+	result := make([]ExpressionFunction, 0, len(expressionFunctions)+12)
+	for name, spec := range expressionFunctions {
+		result = append(result, ExpressionFunction{Name: name, Group: spec.group, Syntax: spec.syntax, Description: spec.description})
+	}
+	result = append(result,
+		ExpressionFunction{"$area", "几何", "$area", "椭球面积(平方米)"},
+		ExpressionFunction{"$length", "几何", "$length", "椭球长度(米)"},
+		ExpressionFunction{"$perimeter", "几何", "$perimeter", "椭球周长(米)"},
+		ExpressionFunction{"$x", "几何", "$x", "质心经度"},
+		ExpressionFunction{"$y", "几何", "$y", "质心纬度"},
+		ExpressionFunction{"$id", "几何", "$id", "要素ID"},
+		ExpressionFunction{"$geometry_type", "几何", "$geometry_type", "几何类型"},
+		ExpressionFunction{"$num_points", "几何", "$num_points", "节点数"},
+		ExpressionFunction{"lookup", "关联图层", "lookup('图层', '返回字段', '关联字段', 值)", "按属性关联取值"},
+		ExpressionFunction{"intersects_value", "关联图层", "intersects_value('图层', '字段')", "取重叠面积最大的相交要素的字段值"},
+		ExpressionFunction{"intersects_count", "关联图层", "intersects_count('图层')", "相交要素个数"},
+		ExpressionFunction{"intersects_area", "关联图层", "intersects_area('图层')", "与图层相交部分的椭球面积(平方米)"},
+		ExpressionFunction{"CASE", "条件", "CASE WHEN 条件 THEN 值 [ELSE 值] END", "多分支条件"},
+	)
+	return result
+}
+
+// CompileFieldExpression 编译字段表达式
+func CompileFieldExpression(db *gorm.DB, tableName string, expression string) (*CompiledExpression, error) {
+	columns := TableColumnTypes(db, tableName)
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("图层 %s 不存在", tableName)
+	}
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprCompiler{
+		db:        db,
+		tableName: tableName,
+		columns:   columns,
+		tokens:    tokens,
+		fields:    map[string]bool{},
+		layers:    map[string]bool{},
+		layerCols: map[string]map[string]string{},
+	}
+	if p.peek().kind == tokEOF {
+		return nil, fmt.Errorf("表达式为空")
+	}
+	value, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "意外的 %s", t.text)
+	}
+	compiled := &CompiledExpression{SQL: value.sql, Type: value.typ}
+	for f := range p.fields {
+		compiled.Fields = append(compiled.Fields, f)
+	}
+	for l := range p.layers {
+		compiled.Layers = append(compiled.Layers, l)
+	}
+	return compiled, nil
+}
+
+// CompileFilterExpression 编译筛选条件，结果作为WHERE子句使用
+func CompileFilterExpression(db *gorm.DB, tableName string, expression string) (*CompiledExpression, error) {
+	compiled, err := CompileFieldExpression(db, tableName, expression)
+	if err != nil {
+		return nil, err
+	}
+	compiled.SQL = toBoolSQL(exprValue{compiled.SQL, compiled.Type})
+	compiled.Type = ExprBool
+	return compiled, nil
+}
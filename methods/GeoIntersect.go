@@ -158,324 +158,25 @@ func getFeatureString(original geojson.FeatureCollection) string {
 	data := `[` + strings.Join(datas, ",") + `]`
 	return data
 }
-func mergeMapData(tempResults []map[string]interface{}) []map[string]interface{} {
-	if len(tempResults) == 0 {
-		return tempResults
-	}
-
-	// 首先收集所有可能的字段名
-	allFields := make(map[string]struct{})
-	for _, m := range tempResults {
-		for k := range m {
-			allFields[k] = struct{}{}
-		}
-	}
-
-	// 找出需要合并的字段组
-	fieldGroups := make(map[string][]string)
-	for field1 := range allFields {
-		for field2 := range allFields {
-			if field1 != field2 {
-				// 检查字段名是否有包含关系
-				if strings.Contains(field1, field2) || strings.Contains(field2, field1) {
-					// 找出更长的字段名作为主键
-					mainField := field1
-					if len(field2) > len(field1) {
-						mainField = field2
-					}
-					fieldGroups[mainField] = append(fieldGroups[mainField], field1, field2)
-				}
-			}
-		}
-	}
-
-	// 去重每个组中的字段
-	for mainField, fields := range fieldGroups {
-		uniqueFields := make(map[string]struct{})
-		for _, f := range fields {
-			uniqueFields[f] = struct{}{}
-		}
-		var uniqueList []string
-		for f := range uniqueFields {
-			if f != mainField {
-				uniqueList = append(uniqueList, f)
-			}
-		}
-		fieldGroups[mainField] = uniqueList
-	}
-
-	mergedResults := make([]map[string]interface{}, 0, len(tempResults))
-
-	for _, m := range tempResults {
-		merged := make(map[string]interface{})
-
-		// 复制所有字段到新map
-		for k, v := range m {
-			merged[k] = v
-		}
-
-		// 处理每个需要合并的字段组
-		for mainField, subFields := range fieldGroups {
-			if _, ok := merged[mainField]; !ok {
-				merged[mainField] = ""
-			}
-
-			var values []string
-			if mainValue, ok := merged[mainField].(string); ok && mainValue != "" {
-				values = append(values, mainValue)
-			}
-
-			for _, subField := range subFields {
-				if subValue, ok := merged[subField].(string); ok && subValue != "" {
-					values = append(values, subValue)
-					delete(merged, subField)
-				}
-			}
-
-			if len(values) > 0 {
-				merged[mainField] = strings.Join(values, "\n/")
-			}
-		}
-
-		mergedResults = append(mergedResults, merged)
-	}
-
-	return mergedResults
-}
-
 func GeoIntersect(jsonData geojson.FeatureCollection, tablename string, att string) []Result {
-	tablename = strings.ToLower(tablename)
-	att = strings.ToLower(att)
-	geo := jsonData.Features[0]
-	jsonString, _ := json.Marshal(geo)
-	var data map[string]interface{}
-	json.Unmarshal([]byte(jsonString), &data)
-	value := data["geometry"]
-	geodata, _ := json.Marshal(value)
-	jsonDataStr := string(geodata)
-	var sql string
-	DB := models.DB
-	var tempResults []map[string]interface{}
-	var Area float64
-	if strings.Contains(tablename, "dltb") {
-		Area = CalculateGeodesicArea(jsonData.Features[0])
-		// 将GeoJSON字符串转为几何类型，并设定SRID为4326，同时计算交集的面积，并将结果作为area返回
-		if strings.Contains(att, "dlbm") == false && strings.Contains(att, "dlmc") == false {
-			sql = fmt.Sprintf("SELECT \"numeric\"(ST_Area(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom))::geography)) AS area, %s AS \"%s\" FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", jsonDataStr, att, att, tablename, jsonDataStr) // 合并SQL查询
-			err := DB.Raw(sql).Scan(&tempResults)
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				fmt.Println(err.Error)
-				return make([]Result, 0)
-			}
-		} else {
-			if strings.Contains(att, "tkj") == true {
-				sql = fmt.Sprintf("SELECT \"numeric\"(ST_Area(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom))::geography)) AS area, kcxs ,%s AS \"%s\" FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", jsonDataStr, att, att, tablename, jsonDataStr)
-			} else {
-				sql = fmt.Sprintf("SELECT \"numeric\"(ST_Area(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom))::geography)) AS area, kcxs ,%s AS \"%s\" FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", jsonDataStr, att, att, tablename, jsonDataStr)
-			}
-
-			var KCdata []map[string]interface{}
-			err := DB.Raw(sql).Scan(&KCdata)
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				fmt.Println(err.Error)
-				return make([]Result, 0)
-			}
-			TKMJ := 0.00
-			for _, item := range KCdata {
-				tempResult := make(map[string]interface{})
-				var kcxsFloat float64
-				if strings.Contains(att, "tkj") == true {
-					switch v := item["kcxs"].(type) {
-					case float64: // 如果Kcxs是float64类型
-						kcxsFloat = v // 直接赋值
-					case string: // 如果Kcxs是string类型
-						if value, err := strconv.ParseFloat(v, 64); err == nil { // 尝试转换为float
-							kcxsFloat = value // 转换成功则赋值
-						} else {
-							continue // 转换失败，跳过当前item
-						}
-					default: // 如果Kcxs是其他类型
-						continue // 跳过当前item
-					}
-				} else {
-					switch v := item["kcxs"].(type) {
-					case float64: // 如果Kcxs是float64类型
-						kcxsFloat = v // 直接赋值
-					case string: // 如果Kcxs是string类型
-						if value, err := strconv.ParseFloat(v, 64); err == nil { // 尝试转换为float
-							kcxsFloat = value // 转换成功则赋值
-						} else {
-							continue // 转换失败，跳过当前item
-						}
-					default: // 如果Kcxs是其他类型
-						continue // 跳过当前item
-					}
-				}
-
-				if kcxsFloat > 0.00 { // 检查Kcxs是否大于0
-					Tarea := item["area"].(float64) * kcxsFloat          // 计算Tarea，等于面积与Kcxs的乘积
-					TKMJ = TKMJ + Tarea                                  // 将Tarea累加到TKMJ中
-					Realarea := item["area"].(float64) * (1 - kcxsFloat) // 计算Realarea，等于面积减去Tarea
-					tempResult["area"] = Realarea                        // 将Realarea存入tempResult中
-					tempResult[att] = item[att]                          // 将Dlmc存入tempResult中
-					tempResults = append(tempResults, tempResult)        // 将tempResult添加到tempResults中
-				} else {
-					if item[att].(string) == "田坎" || item[att].(string) == "1203" {
-						Tarea := item["area"].(float64)
-						TKMJ = TKMJ + Tarea
-					}
-					tempResult["area"] = item["area"]
-					tempResult[att] = item[att]
-					tempResults = append(tempResults, tempResult)
-				}
-
-			}
-			if TKMJ > 0.00 {
-				tempResult := make(map[string]interface{})
-				tempResult["area"] = TKMJ
-				if strings.Contains(att, "dlbm") == true {
-					tempResult[att] = "1203"
-				} else {
-					tempResult[att] = "田坎"
-				}
-
-				tempResults = append(tempResults, tempResult)
-			}
-
-		}
-
-	} else if strings.Contains(tablename, "yjjbnt") {
-
-		if att == "" {
-			Area = CalculateGeodesicArea(jsonData.Features[0])
-			att = "dlmc"
-			sql = fmt.Sprintf("SELECT \"numeric\"(ST_Area(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom))::geography)) AS area, kcxs  AS \"%s\" FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", jsonDataStr, "dlmc", tablename, jsonDataStr) // 合并SQL查询
-
-			var KCdata []map[string]interface{}
-			err := DB.Raw(sql).Scan(&KCdata)
-
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				fmt.Println(err.Error)
-				return make([]Result, 0)
-			}
-			TKMJ := 0.00
-			for _, item := range KCdata {
-				tempResult := make(map[string]interface{})
-				var kcxsFloat float64
-				switch v := item["dlmc"].(type) {
-				case float64: // 如果Kcxs是float64类型
-					kcxsFloat = v // 直接赋值
-				case string: // 如果Kcxs是string类型
-					if value, err := strconv.ParseFloat(v, 64); err == nil { // 尝试转换为float
-						kcxsFloat = value // 转换成功则赋值
-					} else {
-						continue // 转换失败，跳过当前item
-					}
-				default: // 如果Kcxs是其他类型
-					continue // 跳过当前item
-				}
-
-				if kcxsFloat > 0.00 {
-					Tarea := item["area"].(float64) * kcxsFloat
-					TKMJ = TKMJ + Tarea
-					Realarea := item["area"].(float64) * (1 - kcxsFloat)
-					tempResult["area"] = Realarea
-					tempResult["dlmc"] = tablename
-					tempResults = append(tempResults, tempResult)
-
-				} else {
-					tempResult["area"] = item["area"].(float64)
-					tempResult["dlmc"] = tablename
-					tempResults = append(tempResults, tempResult)
-				}
-
-			}
-			if TKMJ > 0.00 {
-				tempResult := make(map[string]interface{})
-				tempResult["area"] = TKMJ
-				tempResult["dlmc"] = "田坎"
-				tempResults = append(tempResults, tempResult)
-			}
-		} else {
-			Area = CalculateArea(jsonData.Features[0])
-			attrSelect := fmt.Sprintf("\"numeric\"(ST_Area(St_Transform(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom)),4523))) AS area, %s AS \""+att+"\"", jsonDataStr, att)
-			sql = fmt.Sprintf("SELECT %s FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", attrSelect, tablename, jsonDataStr)
-			// 将GeoJSON字符串转为几何类型，并设定SRID为4326，同时计算交集的面积，并将结果作为area返回
-			err := DB.Raw(sql).Scan(&tempResults)
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				fmt.Println(err.Error)
-				return make([]Result, 0)
-			}
-		}
-
-	} else {
-		//获取图形总面积
-		Area = CalculateArea(jsonData.Features[0])
-		// 动态构建查询字符串
-		if att != "" {
-			attrSelect := fmt.Sprintf("\"numeric\"(ST_Area(St_Transform(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom)),4523))) AS area, %s AS \""+att+"\"", jsonDataStr, att)
-			sql = fmt.Sprintf("SELECT %s FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", attrSelect, tablename, jsonDataStr)
-			// 将GeoJSON字符串转为几何类型，并设定SRID为4326，同时计算交集的面积，并将结果作为area返回
-			err := DB.Raw(sql).Scan(&tempResults)
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				fmt.Println(err.Error)
-				return make([]Result, 0)
-			}
-
-		} else {
-			att = "aa"
-			attrSelect := fmt.Sprintf("\"numeric\"(ST_Area(St_Transform(ST_Intersection(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326)), ST_MakeValid(geom)),4523))) AS area", jsonDataStr)
-			sql = fmt.Sprintf("SELECT %s FROM \"%s\" WHERE ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326), geom)", attrSelect, tablename, jsonDataStr)
-			err := DB.Raw(sql).Scan(&tempResults)
-			if err.Error != nil { // 检查是否发生错误
-				// 错误处理逻辑
-				return make([]Result, 0)
-			}
-			for i, _ := range tempResults {
-				tempResults[i]["aa"] = tablename
-			}
-
-		}
+	result, _ := GeoIntersectArea(jsonData, tablename, att)
+	return result
+}
 
+// GeoIntersectArea 按图层的用地分析规则统计压占面积，同时返回按同一规则计算的分析范围面积
+func GeoIntersectArea(jsonData geojson.FeatureCollection, tablename string, att string) ([]Result, float64) {
+	if len(jsonData.Features) == 0 {
+		return make([]Result, 0), 0
 	}
-	if strings.Contains(att, ",") == true {
-		tempResults = mergeMapData(tempResults)
-	}
-	//获取未压占部分
-	used_area := 0.00
-	for _, item := range tempResults {
-		used_area = used_area + item["area"].(float64)
-	}
-	used_area = math.Round(used_area*100) / 100
-	if Area-used_area >= 1 {
-		tempResults = append(tempResults, map[string]interface{}{
-			"area": Area - used_area,
-			att:    "未占用",
-		})
-	}
-
-	// 构造Result切片
-	var finalResults []Result
-	for _, tempResult := range tempResults {
-		if area, ok := tempResult["area"].(float64); ok {
-			if dlmc, ok := tempResult[att].(string); ok {
-				finalResults = append(finalResults, Result{
-					Area:          area,
-					Dlmc:          DLMCReplace(dlmc),
-					AttributeName: att, // 虽然我们不会使用这个字段，但它有助于调试和理解代码
-				})
-			}
-		}
+	geodata, _ := json.Marshal(geojson.NewGeometry(jsonData.Features[0].Geometry))
+	DB := models.DB
+	rule := LandRuleFor(DB, tablename)
+	result, area, err := LandOccupation(DB, string(geodata), tablename, att, rule)
+	if err != nil {
+		fmt.Println(err.Error())
+		return make([]Result, 0), 0
 	}
-
-	groupedResult := GroupAndSum(finalResults)
-
-	return groupedResult
+	return result, area
 }
 
 func GetIntersectGeo(jsonData geojson.FeatureCollection, tablename string, atts string) geojson.FeatureCollection {
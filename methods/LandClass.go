@@ -0,0 +1,259 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"gorm.io/gorm"
+)
+
+// 土地利用分类字典：按编码或名称查找地类，并将统计结果汇总到一级类、二级类或三大类
+
+// LandClassCategories 三大类
+var LandClassCategories = []string{"农用地", "建设用地", "未利用地"}
+
+// LandClassDict 某一分类版本的字典
+type LandClassDict struct {
+	Version string
+	Classes []models.LandClass
+	byCode  map[string]models.LandClass
+	byName  map[string]models.LandClass
+}
+
+// NewLandClassDict 由地类列表建立字典
+func NewLandClassDict(version string, classes []models.LandClass) *LandClassDict {
+	d := &LandClassDict{
+		Version: version,
+		Classes: classes,
+		byCode:  make(map[string]models.LandClass, len(classes)),
+		byName:  make(map[string]models.LandClass, len(classes)),
+	}
+	for _, c := range classes {
+		d.byCode[strings.ToUpper(c.Code)] = c
+		if _, ok := d.byName[c.Name]; !ok {
+			d.byName[c.Name] = c
+		}
+	}
+	return d
+}
+
+// LoadLandClassDict 读取分类字典，version为空时使用内置三调工作分类
+func LoadLandClassDict(db *gorm.DB, version string) (*LandClassDict, error) {
+	if version == "" {
+		version = models.DefaultLandClassVersion
+	}
+	var classes []models.LandClass
+	db.Where("version = ?", version).Order("sort, code").Find(&classes)
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("分类版本不存在: %s", version)
+	}
+	return NewLandClassDict(version, classes), nil
+}
+
+// Lookup 按编码或名称查找地类，编码带K(可调整地类)后缀时按去掉后缀的编码查找
+func (d *LandClassDict) Lookup(value string) (models.LandClass, bool) {
+	value = strings.TrimSpace(value)
+	if c, ok := d.byCode[strings.ToUpper(value)]; ok {
+		return c, true
+	}
+	if c, ok := d.byName[value]; ok {
+		return c, true
+	}
+	if upper := strings.ToUpper(value); strings.HasSuffix(upper, "K") {
+		if c, ok := d.byCode[strings.TrimSuffix(upper, "K")]; ok {
+			return c, true
+		}
+	}
+	return models.LandClass{}, false
+}
+
+// Ancestor 地类在指定级别上的上级地类，自身级别不高于level时返回自身
+func (d *LandClassDict) Ancestor(c models.LandClass, level int) models.LandClass {
+	for i := 0; i < 10 && c.Level > level && c.ParentCode != ""; i++ {
+		parent, ok := d.byCode[strings.ToUpper(c.ParentCode)]
+		if !ok {
+			break
+		}
+		c = parent
+	}
+	return c
+}
+
+// RollUp 将统计标签汇总到指定级别，level为1、2或category；
+// 标签为编码时返回编码，为名称时返回名称，多字段组合标签逐项汇总
+func (d *LandClassDict) RollUp(label, level string) (string, bool) {
+	if level == "category" {
+		for _, part := range strings.Split(label, "\n/") {
+			if c, ok := d.Lookup(part); ok && c.Category != "" {
+				return c.Category, true
+			}
+		}
+		return label, false
+	}
+	lv, err := strconv.Atoi(level)
+	if err != nil || lv < 1 {
+		return label, false
+	}
+	parts := strings.Split(label, "\n/")
+	matched := false
+	for i, part := range parts {
+		c, ok := d.Lookup(part)
+		if !ok {
+			continue
+		}
+		matched = true
+		a := d.Ancestor(c, lv)
+		if strings.TrimSpace(part) == c.Name {
+			parts[i] = a.Name
+		} else {
+			parts[i] = a.Code
+		}
+	}
+	return strings.Join(parts, "\n/"), matched
+}
+
+// RollUpResults 按分类字典汇总统计结果，未匹配到字典的标签保持不变
+func RollUpResults(results []Result, d *LandClassDict, level string) []Result {
+	sums := map[string]float64{}
+	var order []string
+	for _, r := range results {
+		label := r.Dlmc
+		if label != unoccupiedLabel {
+			label, _ = d.RollUp(label, level)
+		}
+		if _, ok := sums[label]; !ok {
+			order = append(order, label)
+		}
+		sums[label] += r.Area
+	}
+	rolled := make([]Result, 0, len(order))
+	for _, label := range order {
+		rolled = append(rolled, Result{Area: math.Round(sums[label]*100) / 100, Dlmc: label})
+	}
+	sort.SliceStable(rolled, func(i, j int) bool { return rolled[i].Area > rolled[j].Area })
+	moveUnoccupiedToEndWithSort(rolled)
+	return rolled
+}
+
+// 导入表头别名
+var landClassHeaders = map[string][]string{
+	"code":     {"编码", "地类编码", "代码", "code", "dlbm"},
+	"name":     {"名称", "地类名称", "name", "dlmc"},
+	"parent":   {"上级编码", "上级", "parent", "parent_code", "parentcode"},
+	"level":    {"级别", "层级", "level"},
+	"category": {"三大类", "类别", "category"},
+	"color":    {"颜色", "color"},
+	"sort":     {"排序", "序号", "sort"},
+}
+
+// ParseLandClassRows 解析CSV或Excel读取的表格，第一行为表头，至少包含编码和名称列
+func ParseLandClassRows(rows [][]string) ([]models.LandClass, error) {
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("表格没有数据")
+	}
+	index := map[string]int{}
+	for i, h := range rows[0] {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for key, aliases := range landClassHeaders {
+			for _, alias := range aliases {
+				if _, exists := index[key]; !exists && h == strings.ToLower(alias) {
+					index[key] = i
+				}
+			}
+		}
+	}
+	if _, ok := index["code"]; !ok {
+		return nil, fmt.Errorf("缺少编码列")
+	}
+	if _, ok := index["name"]; !ok {
+		return nil, fmt.Errorf("缺少名称列")
+	}
+	cell := func(row []string, key string) string {
+		i, ok := index[key]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var classes []models.LandClass
+	for n, row := range rows[1:] {
+		code := cell(row, "code")
+		if code == "" {
+			continue
+		}
+		c := models.LandClass{
+			Code:       code,
+			Name:       cell(row, "name"),
+			ParentCode: cell(row, "parent"),
+			Category:   cell(row, "category"),
+			Color:      cell(row, "color"),
+			Sort:       n + 1,
+		}
+		if v := cell(row, "level"); v != "" {
+			c.Level, _ = strconv.Atoi(v)
+		}
+		if v := cell(row, "sort"); v != "" {
+			if s, err := strconv.Atoi(v); err == nil {
+				c.Sort = s
+			}
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// ValidateLandClasses 检查编码重复、上级地类和三大类，未填写的上级和级别按编码前缀推断
+func ValidateLandClasses(classes []models.LandClass) error {
+	if len(classes) == 0 {
+		return fmt.Errorf("没有地类")
+	}
+	codes := make(map[string]int, len(classes))
+	for i, c := range classes {
+		if c.Code == "" || c.Name == "" {
+			return fmt.Errorf("第%d个地类的编码或名称为空", i+1)
+		}
+		key := strings.ToUpper(c.Code)
+		if _, dup := codes[key]; dup {
+			return fmt.Errorf("地类编码重复: %s", c.Code)
+		}
+		codes[key] = i
+	}
+	for i := range classes {
+		c := &classes[i]
+		if c.ParentCode == "" && len(c.Code) > 2 {
+			// 二级类编码前两位为一级类
+			if _, ok := codes[strings.ToUpper(c.Code[:2])]; ok {
+				c.ParentCode = c.Code[:2]
+			}
+		}
+		if c.ParentCode != "" {
+			if _, ok := codes[strings.ToUpper(c.ParentCode)]; !ok {
+				return fmt.Errorf("地类 %s 的上级地类 %s 不存在", c.Code, c.ParentCode)
+			}
+		}
+		if c.Category != "" && !IsStringInSlice(c.Category, LandClassCategories) {
+			return fmt.Errorf("地类 %s 的三大类只能为农用地、建设用地或未利用地", c.Code)
+		}
+	}
+	// 按上级关系计算级别，同时检查循环引用
+	for i := range classes {
+		level := 1
+		code := classes[i].ParentCode
+		for code != "" {
+			level++
+			if level > len(classes)+1 {
+				return fmt.Errorf("地类 %s 的上级关系存在循环", classes[i].Code)
+			}
+			code = classes[codes[strings.ToUpper(code)]].ParentCode
+		}
+		if classes[i].Level == 0 {
+			classes[i].Level = level
+		}
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package methods
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"gorm.io/gorm"
+)
+
+// 用地压占统计：按图层配置的地类字段、扣除系数、线状地物和面积计算方式统计分析范围内各地类面积
+
+const (
+	defaultDeductLabel = "田坎"
+	unoccupiedLabel    = "未占用"
+)
+
+// LandRuleFor 读取图层的用地分析规则，未配置时含kcxs字段的图层按三调地类图斑处理，其余按投影面积统计
+func LandRuleFor(db *gorm.DB, table string) models.LandOccupationRule {
+	table = strings.ToLower(table)
+	var rule models.LandOccupationRule
+	if err := db.Where("layer_name = ?", table).First(&rule).Error; err == nil {
+		return rule
+	}
+	rule = models.LandOccupationRule{LayerName: table, MinArea: 1}
+	if layerColumns(db, table)["kcxs"] {
+		rule.ClassFields = "dlbm,dlmc"
+		rule.DeductMode = "coefficient"
+		rule.DeductField = "kcxs"
+		rule.DeductLabels, _ = json.Marshal(map[string]string{"dlbm": "1203"})
+		rule.AreaMode = "geodesic"
+	} else {
+		rule.AreaMode = "planar"
+		rule.EPSG = 4523
+	}
+	return rule
+}
+
+func layerColumns(db *gorm.DB, table string) map[string]bool {
+	var columns []string
+	db.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = ?`, table).Scan(&columns)
+	result := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		result[strings.ToLower(col)] = true
+	}
+	return result
+}
+
+// ValidateLandRule 检查规则引用的图层和字段
+func ValidateLandRule(db *gorm.DB, rule *models.LandOccupationRule) error {
+	rule.LayerName = strings.ToLower(rule.LayerName)
+	cols := layerColumns(db, rule.LayerName)
+	if len(cols) == 0 {
+		return fmt.Errorf("图层不存在: %s", rule.LayerName)
+	}
+	var fields []string
+	for _, f := range strings.Split(rule.ClassFields, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			if !cols[f] {
+				return fmt.Errorf("地类字段不存在: %s", f)
+			}
+			fields = append(fields, f)
+		}
+	}
+	rule.ClassFields = strings.Join(fields, ",")
+	switch rule.DeductMode {
+	case "", "none":
+		rule.DeductMode = "none"
+	case "coefficient":
+		rule.DeductField = strings.ToLower(rule.DeductField)
+		if !cols[rule.DeductField] {
+			return fmt.Errorf("扣除系数字段不存在: %s", rule.DeductField)
+		}
+	default:
+		return fmt.Errorf("DeductMode只能为none或coefficient")
+	}
+	if len(rule.DeductLabels) > 0 {
+		var labels map[string]string
+		if err := json.Unmarshal(rule.DeductLabels, &labels); err != nil {
+			return fmt.Errorf("DeductLabels格式错误: %v", err)
+		}
+	}
+	if rule.LinearLayer != "" {
+		rule.LinearLayer = strings.ToLower(rule.LinearLayer)
+		rule.LinearWidthField = strings.ToLower(rule.LinearWidthField)
+		if !layerColumns(db, rule.LinearLayer)[rule.LinearWidthField] {
+			return fmt.Errorf("线状地物图层 %s 缺少宽度字段 %s", rule.LinearLayer, rule.LinearWidthField)
+		}
+	}
+	switch rule.AreaMode {
+	case "", "geodesic":
+		rule.AreaMode = "geodesic"
+	case "planar":
+		if rule.EPSG != 0 {
+			var count int64
+			db.Raw(`SELECT COUNT(*) FROM spatial_ref_sys WHERE srid = ?`, rule.EPSG).Scan(&count)
+			if count == 0 {
+				return fmt.Errorf("坐标系EPSG:%d不存在", rule.EPSG)
+			}
+		}
+	default:
+		return fmt.Errorf("AreaMode只能为geodesic或planar")
+	}
+	if rule.Decimals != nil && (*rule.Decimals < 0 || *rule.Decimals > 6) {
+		return fmt.Errorf("Decimals应在0到6之间")
+	}
+	if rule.MinArea < 0 {
+		return fmt.Errorf("MinArea不能为负")
+	}
+	return nil
+}
+
+// landAreaExpr 按规则的面积计算方式生成面积表达式
+func landAreaExpr(rule models.LandOccupationRule, epsg int, geom string) string {
+	if rule.AreaMode == "planar" {
+		return fmt.Sprintf("ST_Area(ST_Transform(%s, %d))", geom, epsg)
+	}
+	return fmt.Sprintf("ST_Area((%s)::geography)", geom)
+}
+
+// landLengthExpr 与面积计算方式一致的长度表达式
+func landLengthExpr(rule models.LandOccupationRule, epsg int, geom string) string {
+	if rule.AreaMode == "planar" {
+		return fmt.Sprintf("ST_Length(ST_Transform(%s, %d))", geom, epsg)
+	}
+	return fmt.Sprintf("ST_Length((%s)::geography)", geom)
+}
+
+// landLabelExpr 统计字段的分类表达式，多个字段时以最后一个字段在前、用"\n/"连接
+func landLabelExpr(alias string, fields []string, cols map[string]bool) string {
+	if len(fields) == 0 {
+		return "NULL"
+	}
+	ordered := append([]string{fields[len(fields)-1]}, fields[:len(fields)-1]...)
+	parts := make([]string, 0, len(ordered))
+	for _, f := range ordered {
+		if cols != nil && !cols[f] {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(`NULLIF(%s."%s"::text, '')`, alias, f))
+	}
+	if len(parts) == 0 {
+		return "NULL"
+	}
+	return fmt.Sprintf(`NULLIF(concat_ws(E'\n/', %s), '')`, strings.Join(parts, ", "))
+}
+
+// landFloat 数据库返回的数值或文本转为浮点数
+func landFloat(v interface{}) float64 {
+	switch c := v.(type) {
+	case float64:
+		return c
+	case float32:
+		return float64(c)
+	case int64:
+		return float64(c)
+	case int32:
+		return float64(c)
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(c), 64)
+		return f
+	case []byte:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(string(c)), 64)
+		return f
+	}
+	return 0
+}
+
+// LandOccupation 按规则统计几何范围内各地类面积，返回分组结果和分析范围总面积
+func LandOccupation(db *gorm.DB, geomJSON, table, att string, rule models.LandOccupationRule) ([]Result, float64, error) {
+	table = strings.ToLower(table)
+	att = strings.ToLower(att)
+	var fields []string
+	for _, f := range strings.Split(att, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	// 统计字段为空或属于地类字段时才扣除田坎和线状地物
+	classFields := map[string]bool{}
+	for _, f := range strings.Split(rule.ClassFields, ",") {
+		classFields[strings.TrimSpace(f)] = true
+	}
+	deductApplies := len(fields) == 0
+	for _, f := range fields {
+		if classFields[f] {
+			deductApplies = true
+		}
+	}
+	deductLabel := rule.DeductLabel
+	if deductLabel == "" {
+		deductLabel = defaultDeductLabel
+	}
+	if len(rule.DeductLabels) > 0 {
+		var labels map[string]string
+		json.Unmarshal(rule.DeductLabels, &labels)
+		for _, f := range fields {
+			if l, ok := labels[f]; ok && l != "" {
+				deductLabel = l
+				break
+			}
+		}
+	}
+
+	epsg := rule.EPSG
+	if rule.AreaMode == "planar" && epsg == 0 {
+		var lon float64
+		db.Raw(`SELECT ST_X(ST_Centroid(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326)))`, geomJSON).Scan(&lon)
+		epsg = CGCS2000ZoneEPSG(lon)
+	}
+	src := `WITH src AS (SELECT ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326)) AS g)`
+
+	var total float64
+	if err := db.Raw(fmt.Sprintf(`%s SELECT %s FROM src`, src, landAreaExpr(rule, epsg, "src.g")), geomJSON).
+		Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	labelExpr := landLabelExpr("t", fields, nil)
+	coefExpr := "0"
+	if deductApplies && rule.DeductMode == "coefficient" && rule.DeductField != "" {
+		coefExpr = fmt.Sprintf(`t."%s"`, rule.DeductField)
+	}
+	var rows []map[string]interface{}
+	sql := fmt.Sprintf(`%s
+		SELECT %s AS area, %s AS label, %s AS coef
+		FROM "%s" AS t, src
+		WHERE ST_Intersects(src.g, t.geom)`,
+		src, landAreaExpr(rule, epsg, "ST_Intersection(src.g, ST_MakeValid(t.geom))"), labelExpr, coefExpr, table)
+	if err := db.Raw(sql, geomJSON).Scan(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+
+	layerLabel := DLMCReplace(table)
+	areas := map[string]float64{}
+	used := 0.0
+	deducted := 0.0
+	for _, row := range rows {
+		area := landFloat(row["area"])
+		used += area
+		label, ok := row["label"].(string)
+		if len(fields) == 0 {
+			label, ok = layerLabel, true
+		}
+		if !ok {
+			continue
+		}
+		if coef := landFloat(row["coef"]); coef > 0 && coef < 1 {
+			deducted += area * coef
+			area *= 1 - coef
+		}
+		areas[label] += area
+	}
+	if deducted > 0 {
+		areas[deductLabel] += deducted
+	}
+
+	// 线状地物：按长度×宽度计算面积，计入自身地类并从所在图斑地类中扣除
+	if deductApplies && rule.LinearLayer != "" && rule.LinearWidthField != "" {
+		linearCols := layerColumns(db, rule.LinearLayer)
+		linearExpr := landLabelExpr("l", fields, linearCols)
+		hostExpr := landLabelExpr("h", fields, nil)
+		var linear []struct {
+			Label string
+			Host  string
+			Area  float64
+		}
+		sql := fmt.Sprintf(`%s
+			SELECT COALESCE(c.label, '') AS label, COALESCE(host.label, '') AS host, %s * COALESCE(c.width, 0) AS area
+			FROM (
+				SELECT %s AS label, l."%s"::float8 AS width, ST_Intersection(src.g, l.geom) AS clip
+				FROM "%s" AS l, src WHERE ST_Intersects(src.g, l.geom)
+			) c
+			LEFT JOIN LATERAL (
+				SELECT %s AS label FROM "%s" AS h
+				WHERE ST_Intersects(h.geom, ST_PointOnSurface(c.clip)) LIMIT 1
+			) host ON true`,
+			src, landLengthExpr(rule, epsg, "c.clip"), linearExpr, rule.LinearWidthField, rule.LinearLayer, hostExpr, table)
+		if err := db.Raw(sql, geomJSON).Scan(&linear).Error; err != nil {
+			return nil, 0, fmt.Errorf("线状地物扣除失败: %v", err)
+		}
+		for _, l := range linear {
+			if l.Area <= 0 {
+				continue
+			}
+			label, host := l.Label, l.Host
+			if len(fields) == 0 {
+				host = layerLabel
+			}
+			if label == "" {
+				label = rule.LinearLabel
+			}
+			if label == "" || host == "" {
+				continue
+			}
+			// 扣除量不超过所在地类面积
+			area := math.Min(l.Area, areas[host])
+			areas[host] -= area
+			areas[label] += area
+		}
+	}
+
+	if total-used >= math.Max(rule.MinArea, 0) && total-used > 0 {
+		areas[unoccupiedLabel] += total - used
+	}
+	return groupLandAreas(areas, total, rule), roundLandArea(total, rule), nil
+}
+
+func landDecimals(rule models.LandOccupationRule) int {
+	if rule.Decimals != nil {
+		return *rule.Decimals
+	}
+	return 2
+}
+
+func roundLandArea(v float64, rule models.LandOccupationRule) float64 {
+	scale := math.Pow(10, float64(landDecimals(rule)))
+	return math.Round(v*scale) / scale
+}
+
+// groupLandAreas 按替换后的地类名称合并面积，再剔除小面积地类并取整，按面积降序排列，未占用排在最后
+func groupLandAreas(areas map[string]float64, total float64, rule models.LandOccupationRule) []Result {
+	merged := make(map[string]float64, len(areas))
+	for label, area := range areas {
+		merged[DLMCReplace(label)] += area
+	}
+	var results []Result
+	for dlmc, area := range merged {
+		if area < rule.MinArea || area <= 0 {
+			continue
+		}
+		results = append(results, Result{Area: area, Dlmc: dlmc})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Area > results[j].Area })
+	moveUnoccupiedToEndWithSort(results)
+	if len(results) == 0 {
+		return results
+	}
+
+	if rule.BalanceRounding {
+		items := make([]AreaAdjustItem, len(results))
+		sum := 0.0
+		for i, r := range results {
+			items[i] = AreaAdjustItem{ID: int32(i), Area: r.Area}
+			sum += r.Area
+		}
+		// 各地类之和与总面积相差不超过最小统计面积时以总面积为准
+		control := sum
+		if math.Abs(total-sum) <= math.Max(rule.MinArea, 0) {
+			control = total
+		}
+		if adjusted, err := AdjustAreas(items, roundLandArea(control, rule), false, landDecimals(rule)); err == nil {
+			for i := range results {
+				results[i].Area = adjusted.Items[i].Adjusted
+			}
+			return results
+		}
+	}
+	for i := range results {
+		results[i].Area = roundLandArea(results[i].Area, rule)
+	}
+	return results
+}
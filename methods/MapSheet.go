@@ -0,0 +1,280 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 图幅编号：国家基本比例尺地形图分幅编号(GB/T 13989-2012)，以及大比例尺50×50cm正方形分幅
+
+// MapSheet 图幅，经纬度分幅的范围为经纬度，正方形分幅的范围为所在3度带投影坐标
+type MapSheet struct {
+	Number string  `json:"number"`
+	Scale  int     `json:"scale"`
+	Scheme string  `json:"scheme"` // gb 经纬度分幅 / rect 正方形分幅
+	EPSG   int     `json:"epsg"`   // 范围坐标系
+	West   float64 `json:"west"`
+	South  float64 `json:"south"`
+	East   float64 `json:"east"`
+	North  float64 `json:"north"`
+}
+
+type gbSheetScale struct {
+	Code   string
+	DLon   float64 // 经差(秒)
+	DLat   float64 // 纬差(秒)
+	Digits int     // 行列号位数
+}
+
+// 1:100万图幅经差6度、纬差4度，其余比例尺在1:100万图幅内按行列编号
+var gbSheetScales = map[int]gbSheetScale{
+	1000000: {"", 21600, 14400, 0},
+	500000:  {"B", 10800, 7200, 3},
+	250000:  {"C", 5400, 3600, 3},
+	100000:  {"D", 1800, 1200, 3},
+	50000:   {"E", 900, 600, 3},
+	25000:   {"F", 450, 300, 3},
+	10000:   {"G", 225, 150, 3},
+	5000:    {"H", 112.5, 75, 3},
+	2000:    {"I", 37.5, 25, 3},
+	1000:    {"J", 18.75, 12.5, 4},
+	500:     {"K", 9.375, 6.25, 4},
+}
+
+// 正方形分幅边长(米)和图号坐标小数位
+var rectSheetSizes = map[int]struct {
+	Size     float64
+	Decimals int
+}{
+	500:  {250, 2},
+	1000: {500, 1},
+	2000: {1000, 1},
+	5000: {2000, 0},
+}
+
+// sheetEpsilon 避免图廓线上的点因浮点误差落入相邻图幅
+const sheetEpsilon = 1e-9
+
+func gbScale(scale int) (gbSheetScale, error) {
+	s, ok := gbSheetScales[scale]
+	if !ok {
+		return s, fmt.Errorf("不支持的比例尺 1:%d", scale)
+	}
+	return s, nil
+}
+
+// GBSheetNumber 计算经纬度所在的图幅编号
+func GBSheetNumber(lon, lat float64, scale int) (*MapSheet, error) {
+	s, err := gbScale(scale)
+	if err != nil {
+		return nil, err
+	}
+	if lat < 0 || lat >= 88 || lon < -180 || lon >= 180 {
+		return nil, fmt.Errorf("坐标 (%f, %f) 超出分幅范围", lon, lat)
+	}
+	// 全球统一行列：经度自0度起、纬度自赤道起按经差纬差划分
+	col := math.Floor(lon*3600/s.DLon + sheetEpsilon)
+	row := math.Floor(lat*3600/s.DLat + sheetEpsilon)
+	return gbSheetFromCell(col, row, scale, s), nil
+}
+
+func gbSheetFromCell(col, row float64, scale int, s gbSheetScale) *MapSheet {
+	west := col * s.DLon / 3600
+	south := row * s.DLat / 3600
+	sheet := &MapSheet{
+		Scale:  scale,
+		Scheme: "gb",
+		EPSG:   4326,
+		West:   west,
+		South:  south,
+		East:   (col + 1) * s.DLon / 3600,
+		North:  (row + 1) * s.DLat / 3600,
+	}
+	// 所在1:100万图幅
+	centerLon := west + s.DLon/7200
+	centerLat := south + s.DLat/7200
+	row1M := int(math.Floor(centerLat / 4))
+	col1M := int(math.Floor(centerLon/6)) + 31
+	number := fmt.Sprintf("%c%02d", 'A'+row1M, col1M)
+	if s.Code != "" {
+		top := float64(row1M+1) * 4
+		left := float64(col1M-31) * 6
+		r := int(math.Floor((top-centerLat)*3600/s.DLat)) + 1
+		c := int(math.Floor((centerLon-left)*3600/s.DLon)) + 1
+		number += fmt.Sprintf("%s%0*d%0*d", s.Code, s.Digits, r, s.Digits, c)
+	}
+	sheet.Number = number
+	return sheet
+}
+
+var gbSheetPattern = regexp.MustCompile(`^([A-V])-?(\d{1,2})(?:([B-K])(\d+))?$`)
+
+// ParseGBSheetNumber 解析图幅编号得到图幅范围
+func ParseGBSheetNumber(number string) (*MapSheet, error) {
+	number = strings.ToUpper(strings.TrimSpace(number))
+	m := gbSheetPattern.FindStringSubmatch(number)
+	if m == nil {
+		return nil, fmt.Errorf("无法识别的图幅编号: %s", number)
+	}
+	row1M := int(m[1][0] - 'A')
+	col1M, _ := strconv.Atoi(m[2])
+	if col1M < 1 || col1M > 60 {
+		return nil, fmt.Errorf("无法识别的图幅编号: %s", number)
+	}
+	scale := 1000000
+	r, c := 1, 1
+	if m[3] != "" {
+		for sc, s := range gbSheetScales {
+			if s.Code == m[3] {
+				scale = sc
+			}
+		}
+		s := gbSheetScales[scale]
+		if len(m[4]) != s.Digits*2 {
+			return nil, fmt.Errorf("图幅编号 %s 的行列号位数不正确", number)
+		}
+		r, _ = strconv.Atoi(m[4][:s.Digits])
+		c, _ = strconv.Atoi(m[4][s.Digits:])
+		if r < 1 || c < 1 || float64(r) > 14400/s.DLat || float64(c) > 21600/s.DLon {
+			return nil, fmt.Errorf("图幅编号 %s 的行列号超出范围", number)
+		}
+	}
+	s := gbSheetScales[scale]
+	top := float64(row1M+1) * 4
+	left := float64(col1M-31) * 6
+	col := math.Round((left*3600)/s.DLon) + float64(c-1)
+	row := math.Round((top*3600)/s.DLat) - float64(r)
+	return gbSheetFromCell(col, row, scale, s), nil
+}
+
+// GBSheetsInBound 经纬度范围覆盖的图幅
+func GBSheetsInBound(west, south, east, north float64, scale, limit int) ([]*MapSheet, error) {
+	s, err := gbScale(scale)
+	if err != nil {
+		return nil, err
+	}
+	c0 := math.Floor(west*3600/s.DLon + sheetEpsilon)
+	c1 := math.Ceil(east*3600/s.DLon - sheetEpsilon)
+	r0 := math.Floor(math.Max(south, 0)*3600/s.DLat + sheetEpsilon)
+	r1 := math.Ceil(math.Min(north, 88)*3600/s.DLat - sheetEpsilon)
+	if c1 <= c0 {
+		c1 = c0 + 1
+	}
+	if r1 <= r0 {
+		r1 = r0 + 1
+	}
+	if limit > 0 && (c1-c0)*(r1-r0) > float64(limit) {
+		return nil, fmt.Errorf("范围内图幅超过%d幅，请缩小范围或选择更小比例尺", limit)
+	}
+	var sheets []*MapSheet
+	for row := r1 - 1; row >= r0; row-- {
+		for col := c0; col < c1; col++ {
+			sheets = append(sheets, gbSheetFromCell(col, row, scale, s))
+		}
+	}
+	return sheets, nil
+}
+
+// RectSheetSupported 是否支持正方形分幅
+func RectSheetSupported(scale int) bool {
+	_, ok := rectSheetSizes[scale]
+	return ok
+}
+
+// rectSheetFromCorner 由西南角投影坐标生成图幅，图号为西南角 北坐标-东坐标 公里数，东坐标不含带号
+func rectSheetFromCorner(south, west float64, scale, epsg int) *MapSheet {
+	size := rectSheetSizes[scale]
+	east := west
+	if epsg >= 4513 && epsg <= 4533 {
+		east -= float64(epsg-4488) * 1000000
+	}
+	return &MapSheet{
+		Number: fmt.Sprintf("%.*f-%.*f", size.Decimals, south/1000, size.Decimals, east/1000),
+		Scale:  scale,
+		Scheme: "rect",
+		EPSG:   epsg,
+		West:   west,
+		South:  south,
+		East:   west + size.Size,
+		North:  south + size.Size,
+	}
+}
+
+// RectSheetNumber 投影坐标(东, 北)所在的正方形图幅
+func RectSheetNumber(x, y float64, scale, epsg int) (*MapSheet, error) {
+	size, ok := rectSheetSizes[scale]
+	if !ok {
+		return nil, fmt.Errorf("正方形分幅只支持1:500、1:1000、1:2000、1:5000")
+	}
+	west := math.Floor(x/size.Size+sheetEpsilon) * size.Size
+	south := math.Floor(y/size.Size+sheetEpsilon) * size.Size
+	return rectSheetFromCorner(south, west, scale, epsg), nil
+}
+
+var rectSheetPattern = regexp.MustCompile(`^(\d+(?:\.(\d+))?)\s*-\s*(\d+(?:\.\d+)?)$`)
+
+// ParseRectSheetNumber 解析正方形分幅图号，scale为0时按小数位推断
+func ParseRectSheetNumber(number string, scale, epsg int) (*MapSheet, error) {
+	m := rectSheetPattern.FindStringSubmatch(strings.TrimSpace(number))
+	if m == nil {
+		return nil, fmt.Errorf("无法识别的图幅编号: %s", number)
+	}
+	if scale == 0 {
+		switch len(m[2]) {
+		case 2:
+			scale = 500
+		case 1:
+			scale = 1000
+		default:
+			scale = 5000
+		}
+	}
+	if !RectSheetSupported(scale) {
+		return nil, fmt.Errorf("正方形分幅只支持1:500、1:1000、1:2000、1:5000")
+	}
+	if epsg < 4513 || epsg > 4554 {
+		return nil, fmt.Errorf("正方形分幅需要指定CGCS2000 3度带投影EPSG")
+	}
+	north, _ := strconv.ParseFloat(m[1], 64)
+	east, _ := strconv.ParseFloat(m[3], 64)
+	west := east * 1000
+	if epsg <= 4533 {
+		west += float64(epsg-4488) * 1000000
+	}
+	size := rectSheetSizes[scale].Size
+	if math.Abs(math.Mod(north*1000, size)) > 1e-6 || math.Abs(math.Mod(west, size)) > 1e-6 {
+		return nil, fmt.Errorf("图号 %s 不是1:%d图幅的西南角坐标", number, scale)
+	}
+	return rectSheetFromCorner(north*1000, west, scale, epsg), nil
+}
+
+// RectSheetsInBound 投影坐标范围覆盖的正方形图幅
+func RectSheetsInBound(minX, minY, maxX, maxY float64, scale, epsg, limit int) ([]*MapSheet, error) {
+	size, ok := rectSheetSizes[scale]
+	if !ok {
+		return nil, fmt.Errorf("正方形分幅只支持1:500、1:1000、1:2000、1:5000")
+	}
+	c0 := math.Floor(minX/size.Size + sheetEpsilon)
+	c1 := math.Ceil(maxX/size.Size - sheetEpsilon)
+	r0 := math.Floor(minY/size.Size + sheetEpsilon)
+	r1 := math.Ceil(maxY/size.Size - sheetEpsilon)
+	if c1 <= c0 {
+		c1 = c0 + 1
+	}
+	if r1 <= r0 {
+		r1 = r0 + 1
+	}
+	if limit > 0 && (c1-c0)*(r1-r0) > float64(limit) {
+		return nil, fmt.Errorf("范围内图幅超过%d幅，请缩小范围或选择更小比例尺", limit)
+	}
+	var sheets []*MapSheet
+	for row := r1 - 1; row >= r0; row-- {
+		for col := c0; col < c1; col++ {
+			sheets = append(sheets, rectSheetFromCorner(row*size.Size, col*size.Size, scale, epsg))
+		}
+	}
+	return sheets, nil
+}
@@ -0,0 +1,240 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// 宗地按面积分割：切割线沿一个扫掠参数移动(平行线的法向偏移或绕点旋转的角度)，
+// 二分查找切割位置直到面积满足限差。坐标均为投影坐标，面积由调用方计算(椭球面积)
+
+// SubdivideRegionFunc 返回扫掠参数区间 [from, to] 对应的切割区域
+type SubdivideRegionFunc func(from, to float64) orb.Polygon
+
+// SubdivideAreaFunc 计算宗地与切割区域相交部分的面积，区域为空时应返回0
+type SubdivideAreaFunc func(region orb.Polygon) (float64, error)
+
+// SubdivideSweep 扫掠参数范围与区域构造
+type SubdivideSweep struct {
+	Lo     float64
+	Hi     float64
+	Region SubdivideRegionFunc
+}
+
+// SubdividePart 单块分割结果
+type SubdividePart struct {
+	Index      int         `json:"index"`
+	Target     float64     `json:"target"`     // 目标面积
+	Area       float64     `json:"area"`       // 实际面积
+	Diff       float64     `json:"diff"`       // 实际-目标
+	From       float64     `json:"from"`       // 起始切割位置
+	To         float64     `json:"to"`         // 终止切割位置
+	Iterations int         `json:"iterations"` // 迭代次数
+	Converged  bool        `json:"converged"`
+	Region     orb.Polygon `json:"-"`
+}
+
+// subdivideVertices 取出面要素全部顶点
+func subdivideVertices(geom orb.Geometry) ([]orb.Point, error) {
+	var points []orb.Point
+	switch g := geom.(type) {
+	case orb.Polygon:
+		for _, ring := range g {
+			points = append(points, ring...)
+		}
+	case orb.MultiPolygon:
+		for _, poly := range g {
+			for _, ring := range poly {
+				points = append(points, ring...)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("只有面要素才能分割")
+	}
+	if len(points) < 3 {
+		return nil, fmt.Errorf("面要素顶点不足")
+	}
+	return points, nil
+}
+
+func bearingVector(bearing float64) orb.Point {
+	rad := bearing * math.Pi / 180
+	return orb.Point{math.Sin(rad), math.Cos(rad)}
+}
+
+// ParallelSweep 平行线分割，切割线方位角为bearing，沿其右侧法向推进；
+// 需要从另一侧开始时将方位角加180度
+func ParallelSweep(geom orb.Geometry, bearing float64) (*SubdivideSweep, error) {
+	points, err := subdivideVertices(geom)
+	if err != nil {
+		return nil, err
+	}
+	u := bearingVector(bearing)
+	n := orb.Point{u[1], -u[0]}
+	sMin, sMax := math.Inf(1), math.Inf(-1)
+	tMin, tMax := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		s := p[0]*n[0] + p[1]*n[1]
+		t := p[0]*u[0] + p[1]*u[1]
+		sMin, sMax = math.Min(sMin, s), math.Max(sMax, s)
+		tMin, tMax = math.Min(tMin, t), math.Max(tMax, t)
+	}
+	margin := 1 + (tMax-tMin)*0.01
+	tMin -= margin
+	tMax += margin
+	at := func(s, t float64) orb.Point {
+		return orb.Point{s*n[0] + t*u[0], s*n[1] + t*u[1]}
+	}
+	return &SubdivideSweep{
+		Lo: sMin - 0.001,
+		Hi: sMax + 0.001,
+		Region: func(from, to float64) orb.Polygon {
+			if to <= from {
+				return nil
+			}
+			return orb.Polygon{orb.Ring{at(from, tMin), at(from, tMax), at(to, tMax), at(to, tMin), at(from, tMin)}}
+		},
+	}, nil
+}
+
+// RotateSweep 绕点旋转分割，切割线从pivot出发，自startBearing起顺时针(或逆时针)扫过0-360度
+func RotateSweep(geom orb.Geometry, pivot orb.Point, startBearing float64, counterClockwise bool) (*SubdivideSweep, error) {
+	points, err := subdivideVertices(geom)
+	if err != nil {
+		return nil, err
+	}
+	radius := 0.0
+	for _, p := range points {
+		radius = math.Max(radius, math.Hypot(p[0]-pivot[0], p[1]-pivot[1]))
+	}
+	radius = radius*2 + 1
+	sign := 1.0
+	if counterClockwise {
+		sign = -1
+	}
+	at := func(angle float64) orb.Point {
+		v := bearingVector(startBearing + sign*angle)
+		return orb.Point{pivot[0] + v[0]*radius, pivot[1] + v[1]*radius}
+	}
+	return &SubdivideSweep{
+		Lo: 0,
+		Hi: 360,
+		Region: func(from, to float64) orb.Polygon {
+			if to <= from {
+				return nil
+			}
+			if to-from >= 360 {
+				r := radius
+				return orb.Polygon{orb.Ring{
+					{pivot[0] - r, pivot[1] - r}, {pivot[0] + r, pivot[1] - r},
+					{pivot[0] + r, pivot[1] + r}, {pivot[0] - r, pivot[1] + r}, {pivot[0] - r, pivot[1] - r},
+				}}
+			}
+			// 扇形以折线逼近，半径足够大时弧段不与宗地相交
+			steps := int(math.Ceil((to-from)/5)) + 1
+			ring := orb.Ring{pivot}
+			for i := 0; i <= steps; i++ {
+				ring = append(ring, at(from+(to-from)*float64(i)/float64(steps)))
+			}
+			ring = append(ring, pivot)
+			return orb.Polygon{ring}
+		},
+	}, nil
+}
+
+// SubdivideBySweep 依次求各切割位置：前n-1块按累计面积二分查找切割位置，最后一块为剩余部分
+func SubdivideBySweep(targets []float64, sweep *SubdivideSweep, area SubdivideAreaFunc, tolerance float64, maxIter int) ([]SubdividePart, error) {
+	if len(targets) < 2 {
+		return nil, fmt.Errorf("至少需要分割为2块")
+	}
+	if tolerance <= 0 {
+		tolerance = 0.01
+	}
+	if maxIter <= 0 {
+		maxIter = 100
+	}
+	parts := make([]SubdividePart, 0, len(targets))
+	prev := sweep.Lo
+	cumulative := 0.0
+	for i, target := range targets {
+		part := SubdividePart{Index: i + 1, Target: target, From: prev, To: sweep.Hi}
+		if i < len(targets)-1 {
+			cumulative += target
+			lo, hi := prev, sweep.Hi
+			// 累计面积限差取一半，保证单块误差不超过限差
+			for part.Iterations < maxIter {
+				part.Iterations++
+				mid := (lo + hi) / 2
+				a, err := area(sweep.Region(sweep.Lo, mid))
+				if err != nil {
+					return nil, err
+				}
+				part.To = mid
+				if math.Abs(a-cumulative) <= tolerance/2 {
+					break
+				}
+				if a < cumulative {
+					lo = mid
+				} else {
+					hi = mid
+				}
+				if hi-lo < 1e-9 {
+					break
+				}
+			}
+		}
+		part.Region = sweep.Region(part.From, part.To)
+		a, err := area(part.Region)
+		if err != nil {
+			return nil, err
+		}
+		part.Area = a
+		part.Diff = a - target
+		part.Converged = math.Abs(part.Diff) <= tolerance
+		if a <= 0 {
+			return nil, fmt.Errorf("第%d块面积为0，请检查分割参数", i+1)
+		}
+		parts = append(parts, part)
+		prev = part.To
+	}
+	return parts, nil
+}
+
+// SubdivideTargets 根据面积或比例计算各块目标面积；
+// 给定面积之和小于总面积时剩余部分作为最后一块
+func SubdivideTargets(total float64, areas, ratios []float64, tolerance float64) ([]float64, error) {
+	switch {
+	case len(ratios) > 0:
+		sum := 0.0
+		for _, r := range ratios {
+			if r <= 0 {
+				return nil, fmt.Errorf("比例必须大于0")
+			}
+			sum += r
+		}
+		targets := make([]float64, len(ratios))
+		for i, r := range ratios {
+			targets[i] = total * r / sum
+		}
+		return targets, nil
+	case len(areas) > 0:
+		sum := 0.0
+		for _, a := range areas {
+			if a <= 0 {
+				return nil, fmt.Errorf("面积必须大于0")
+			}
+			sum += a
+		}
+		if sum > total+tolerance {
+			return nil, fmt.Errorf("分割面积之和 %.2f 超过宗地面积 %.2f", sum, total)
+		}
+		targets := append([]float64{}, areas...)
+		if total-sum > tolerance {
+			targets = append(targets, total-sum)
+		}
+		return targets, nil
+	}
+	return nil, fmt.Errorf("需要指定分割面积或比例")
+}
@@ -0,0 +1,216 @@
+package methods
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 要素空间顺序编号：按行带、蛇形、Hilbert曲线或最近邻路径排序，自左上角开始编号
+
+// NumberingItem 参与编号的要素，X/Y为投影坐标的代表点
+type NumberingItem struct {
+	ID     int32   `json:"id"`
+	Group  string  `json:"group"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Number int     `json:"number"`
+	Code   string  `json:"code"`
+}
+
+// NumberingOptions 编号参数
+type NumberingOptions struct {
+	Method     string  // row 行带 / serpentine 蛇形 / hilbert / nearest 最近邻
+	BandHeight float64 // 行带高度(米)，为0时按平均间距
+	Pattern    string  // 编号格式，支持 {group}、{n}、{n:4}
+	Start      int
+	Step       int
+}
+
+var numberingPlaceholder = regexp.MustCompile(`\{(group|n)(?::(\d+))?\}`)
+
+// FormatNumber 按格式生成编号，{n:4} 表示补零到4位
+func FormatNumber(pattern, group string, n int) string {
+	return numberingPlaceholder.ReplaceAllStringFunc(pattern, func(m string) string {
+		sub := numberingPlaceholder.FindStringSubmatch(m)
+		if sub[1] == "group" {
+			return group
+		}
+		if sub[2] != "" {
+			width, _ := strconv.Atoi(sub[2])
+			return fmt.Sprintf("%0*d", width, n)
+		}
+		return strconv.Itoa(n)
+	})
+}
+
+// ValidateNumberingPattern 检查编号格式中是否包含序号
+func ValidateNumberingPattern(pattern string) error {
+	for _, sub := range numberingPlaceholder.FindAllStringSubmatch(pattern, -1) {
+		if sub[1] == "n" {
+			return nil
+		}
+	}
+	return fmt.Errorf("编号格式中必须包含 {n}")
+}
+
+// NumberFeatures 分组排序并生成编号，分组按分组值排序，每组从Start重新编号
+func NumberFeatures(items []NumberingItem, opts NumberingOptions) ([]NumberingItem, error) {
+	if opts.Pattern == "" {
+		opts.Pattern = "{n}"
+	}
+	if err := ValidateNumberingPattern(opts.Pattern); err != nil {
+		return nil, err
+	}
+	if opts.Step == 0 {
+		opts.Step = 1
+	}
+	groups := map[string][]NumberingItem{}
+	var names []string
+	for _, item := range items {
+		if _, ok := groups[item.Group]; !ok {
+			names = append(names, item.Group)
+		}
+		groups[item.Group] = append(groups[item.Group], item)
+	}
+	sort.Strings(names)
+
+	result := make([]NumberingItem, 0, len(items))
+	for _, name := range names {
+		ordered, err := orderNumberingItems(groups[name], opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range ordered {
+			ordered[i].Number = opts.Start + i*opts.Step
+			ordered[i].Code = FormatNumber(opts.Pattern, name, ordered[i].Number)
+		}
+		result = append(result, ordered...)
+	}
+	return result, nil
+}
+
+func orderNumberingItems(items []NumberingItem, opts NumberingOptions) ([]NumberingItem, error) {
+	if len(items) < 2 {
+		return items, nil
+	}
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, item := range items {
+		minX, maxX = math.Min(minX, item.X), math.Max(maxX, item.X)
+		minY, maxY = math.Min(minY, item.Y), math.Max(maxY, item.Y)
+	}
+
+	switch strings.ToLower(opts.Method) {
+	case "", "row", "serpentine":
+		band := opts.BandHeight
+		if band <= 0 {
+			// 平均间距
+			band = math.Sqrt(math.Max((maxX-minX)*(maxY-minY), 1) / float64(len(items)))
+		}
+		// 行号按有要素的行带重新排列，蛇形时奇偶行交替方向
+		bands := map[int]bool{}
+		for _, item := range items {
+			bands[int(math.Floor((maxY-item.Y)/band))] = true
+		}
+		var bandList []int
+		for b := range bands {
+			bandList = append(bandList, b)
+		}
+		sort.Ints(bandList)
+		rank := make(map[int]int, len(bandList))
+		for i, b := range bandList {
+			rank[b] = i
+		}
+		rowOf := func(item NumberingItem) int {
+			return rank[int(math.Floor((maxY-item.Y)/band))]
+		}
+		serpentine := strings.ToLower(opts.Method) == "serpentine"
+		sort.SliceStable(items, func(i, j int) bool {
+			ri, rj := rowOf(items[i]), rowOf(items[j])
+			if ri != rj {
+				return ri < rj
+			}
+			if serpentine && ri%2 == 1 {
+				return items[i].X > items[j].X
+			}
+			return items[i].X < items[j].X
+		})
+	case "hilbert":
+		const order = 16
+		size := math.Max(maxX-minX, maxY-minY)
+		if size <= 0 {
+			size = 1
+		}
+		cells := float64(int(1)<<order - 1)
+		keys := make(map[int32]uint64, len(items))
+		for _, item := range items {
+			// 以左上角为原点
+			x := uint32((item.X - minX) / size * cells)
+			y := uint32((maxY - item.Y) / size * cells)
+			keys[item.ID] = hilbertIndex(order, x, y)
+		}
+		sort.SliceStable(items, func(i, j int) bool { return keys[items[i].ID] < keys[items[j].ID] })
+	case "nearest":
+		// 从最靠近左上角的要素出发，每次走向最近的未编号要素
+		start := 0
+		best := math.Inf(1)
+		for i, item := range items {
+			if d := math.Hypot(item.X-minX, item.Y-maxY); d < best {
+				best, start = d, i
+			}
+		}
+		visited := make([]bool, len(items))
+		ordered := make([]NumberingItem, 0, len(items))
+		current := start
+		for len(ordered) < len(items) {
+			visited[current] = true
+			ordered = append(ordered, items[current])
+			next, nextDist := -1, math.Inf(1)
+			for i, item := range items {
+				if visited[i] {
+					continue
+				}
+				if d := math.Hypot(item.X-items[current].X, item.Y-items[current].Y); d < nextDist {
+					next, nextDist = i, d
+				}
+			}
+			if next < 0 {
+				break
+			}
+			current = next
+		}
+		return ordered, nil
+	default:
+		return nil, fmt.Errorf("不支持的排序方式: %s", opts.Method)
+	}
+	return items, nil
+}
+
+// hilbertIndex 计算点在 2^order 网格Hilbert曲线上的序号
+func hilbertIndex(order uint, x, y uint32) uint64 {
+	var d uint64
+	n := uint32(1) << order
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// 旋转象限
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
@@ -18,6 +18,9 @@ type GeoRecord struct {
 	SeqNo     int            `gorm:"default:0"`  // 会话内操作序号，用于有序回退
 	InputIDs  datatypes.JSON `gorm:"type:jsonb"` // 输入的PostGIS IDs
 	OutputIDs datatypes.JSON `gorm:"type:jsonb"`
+	// 撤销/重做
+	Status   string `gorm:"type:varchar(50);default:'applied';index"` // applied / undone
+	UndoneAt string `gorm:"type:varchar(255)"`
 }
 
 type FieldRecord struct {
@@ -30,3 +33,18 @@ type FieldRecord struct {
 	NewFieldName string `gorm:"type:varchar(255)"`
 	NewFieldType string `gorm:"type:varchar(255)"`
 }
+
+// AttributeRecord 属性变更明细，每个要素每个字段一行
+// 单要素属性修改和批量字段计算以GeoRecord记录一次操作，明细通过GeoRecordID关联；要素修改也写入明细以便按字段查询
+type AttributeRecord struct {
+	ID          int64   `gorm:"primary_key;autoIncrement"`
+	GeoRecordID int64   `gorm:"index"`
+	TableName   string  `gorm:"type:varchar(255);index:idx_attr_feature"`
+	GeoID       int32   `gorm:"index:idx_attr_feature"`
+	FieldName   string  `gorm:"type:varchar(255);index"`
+	OldValue    *string `gorm:"type:text"`
+	NewValue    *string `gorm:"type:text"`
+	Username    string  `gorm:"type:varchar(255)"`
+	SessionID   int64   `gorm:"index"`
+	Date        string  `gorm:"type:varchar(255)"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "gorm.io/datatypes"
+
+// AttributeDomain 属性域（对应GDB中的编码值域/范围域）
+type AttributeDomain struct {
+	ID          int64          `gorm:"primaryKey;autoIncrement"`
+	Name        string         `gorm:"type:varchar(255);uniqueIndex"`
+	Description string         `gorm:"type:varchar(255)"`
+	DomainType  string         `gorm:"type:varchar(50)"`  // coded / range
+	FieldType   string         `gorm:"type:varchar(100)"` // esriFieldTypeString 等，导出GDB时使用
+	CodedValues datatypes.JSON `gorm:"type:jsonb"`        // [{"Code":"0101","Name":"水田"}]
+	MinValue    *float64
+	MaxValue    *float64
+	Source      string `gorm:"type:varchar(50)"` // gdb / manual
+	SourcePath  string `gorm:"type:varchar(500)"`
+	UpdatedAt   string `gorm:"type:varchar(255)"`
+}
+
+// CodedValue 编码值
+type CodedValue struct {
+	Code string `json:"Code"`
+	Name string `json:"Name"`
+}
+
+// FieldRule 字段校验规则
+type FieldRule struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement"`
+	TableName  string `gorm:"type:varchar(255);index:idx_rule_table_field"`
+	FieldName  string `gorm:"type:varchar(255);index:idx_rule_table_field"` // PG中的字段名
+	RuleType   string `gorm:"type:varchar(50)"`                             // domain / regex / required / range
+	DomainName string `gorm:"type:varchar(255)"`                            // RuleType为domain时引用AttributeDomain
+	Pattern    string `gorm:"type:varchar(500)"`                            // 正则表达式
+	MinValue   *float64
+	MaxValue   *float64
+	Message    string `gorm:"type:varchar(500)"` // 自定义错误提示
+	Enabled    bool   `gorm:"default:true"`
+	Source     string `gorm:"type:varchar(50)"` // gdb / manual
+}
@@ -0,0 +1,25 @@
+package models
+
+import "gorm.io/datatypes"
+
+// ChangeDetection 图层变更检测记录，逐要素的变化明细保存在变更图层中
+type ChangeDetection struct {
+	ID               int64          `gorm:"primaryKey;autoIncrement"`
+	BaseTable        string         `gorm:"type:varchar(255);index"` // 现有图层
+	NewTable         string         `gorm:"type:varchar(255)"`       // 新交付的图层
+	MatchMode        string         `gorm:"type:varchar(50)"`        // key 按关键字段 / geometry 按几何相似度
+	KeyField         string         `gorm:"type:varchar(255)"`
+	Fields           datatypes.JSON `gorm:"type:jsonb"` // 参与比较的字段
+	ChangeLayer      string         `gorm:"type:varchar(255)"`
+	BaseRecordID     int64          // 检测开始时GeoRecord的最大ID，应用前据此判断现有要素是否已被修改
+	Added            int64
+	Deleted          int64
+	GeometryChanged  int64
+	AttributeChanged int64
+	Unchanged        int64
+	FieldStats       datatypes.JSON `gorm:"type:jsonb"`       // 各字段发生变化的要素数
+	Status           string         `gorm:"type:varchar(50)"` // 已检测 / 已应用
+	Username         string         `gorm:"type:varchar(255)"`
+	CreatedAt        string         `gorm:"type:varchar(255)"`
+	AppliedAt        string         `gorm:"type:varchar(255)"`
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"errors"
 	"fmt"
@@ -127,6 +128,12 @@ func InitDB() {
 	// 初始化默认用户
 	initDefaultUser(DB)
 
+	// 授予配置中的锁管理员等级
+	initLockAdmins(DB)
+
+	// 初始化内置土地利用分类
+	initDefaultLandClasses(DB)
+
 }
 
 // migrateAllTables 批量迁移所有表
@@ -154,6 +161,17 @@ func migrateAllTables(db *gorm.DB) error {
 		&RasterRecord{},
 		&EditSession{},
 		&OriginMapping{},
+		&VersionEdit{},
+		&FeatureLock{},
+		&AttributeDomain{},
+		&FieldRule{},
+		&GeometryCheckTask{},
+		&AttributeRecord{},
+		&LandOccupationRule{},
+		&LandClass{},
+		&NetworkDataset{},
+		&NetworkTurn{},
+		&ChangeDetection{},
 	}
 
 	return db.AutoMigrate(models...)
@@ -308,3 +326,27 @@ func initDefaultUser(db *gorm.DB) {
 		}
 	}
 }
+
+// initLockAdmins 将配置文件LockAdmins中列出的用户等级设为admin，其他admin用户降为普通用户，
+// admin用户可以强制释放他人的要素锁
+func initLockAdmins(db *gorm.DB) {
+	var admins []string
+	for _, name := range strings.Split(config.MainConfig.LockAdmins, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins = append(admins, name)
+		}
+	}
+	revoke := db.Model(&LoginUser{}).Where("grade = ?", "admin")
+	if len(admins) > 0 {
+		revoke = revoke.Where("username NOT IN ?", admins)
+	}
+	if err := revoke.Update("grade", "").Error; err != nil {
+		log.Printf("Failed to revoke lock admins: %v", err)
+	}
+	if len(admins) == 0 {
+		return
+	}
+	if err := db.Model(&LoginUser{}).Where("username IN ?", admins).Update("grade", "admin").Error; err != nil {
+		log.Printf("Failed to grant lock admins: %v", err)
+	}
+}
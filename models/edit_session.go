@@ -1,5 +1,7 @@
 package models
 
+import "gorm.io/datatypes"
+
 // models/edit_session.go
 
 // EditSession 编辑会话，追踪一组关联操作
@@ -9,6 +11,25 @@ type EditSession struct {
 	Username  string `gorm:"type:varchar(255)"`
 	CreatedAt string `gorm:"type:varchar(255)"`
 	Status    string `gorm:"type:varchar(50)"` // active / committed / rolledback
+	// 版本化编辑：变更先写入VersionEdit，提交(Post)后才写入线上表
+	Versioned    bool   `gorm:"default:false"`
+	Name         string `gorm:"type:varchar(255)"`
+	BaseRecordID int64  // 会话创建时GeoRecord的最大ID
+	PostedAt     string `gorm:"type:varchar(255)"`
+}
+
+// VersionEdit 版本化会话中的要素变更，提交前仅对该会话及其瓦片可见
+type VersionEdit struct {
+	ID           int64          `gorm:"primaryKey;autoIncrement"`
+	SessionID    int64          `gorm:"index:idx_version_feature"`
+	TableName    string         `gorm:"type:varchar(255);index:idx_version_feature"`
+	FeatureID    int32          `gorm:"index:idx_version_feature"` // 修改/删除为线上要素id，新增为会话内临时id（负数）
+	Action       string         `gorm:"type:varchar(50)"`          // insert / update / delete
+	BaseGeojson  datatypes.JSON `gorm:"type:jsonb"`                // 首次编辑时线上要素的快照
+	Geojson      datatypes.JSON `gorm:"type:jsonb"`                // 会话内的当前版本（Feature）
+	BaseRecordID int64          // 首次编辑时GeoRecord的最大ID，用于冲突检测
+	Conflict     string         `gorm:"type:varchar(50)"` // 空 / pending / session / live
+	UpdatedAt    string         `gorm:"type:varchar(255)"`
 }
 
 // OriginMapping 要素溯源映射表
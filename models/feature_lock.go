@@ -0,0 +1,17 @@
+package models
+
+import "gorm.io/datatypes"
+
+// FeatureLock 要素检出锁，防止多用户/多设备同时编辑同一要素或同一范围
+type FeatureLock struct {
+	ID         int64          `gorm:"primaryKey;autoIncrement"`
+	TableName  string         `gorm:"type:varchar(255);index:idx_lock_table_feature"`
+	LockType   string         `gorm:"type:varchar(50)"`             // feature / extent
+	FeatureID  int32          `gorm:"index:idx_lock_table_feature"` // 要素锁对应的PostGIS id，范围锁为0
+	Extent     datatypes.JSON `gorm:"type:jsonb"`                   // 范围锁的GeoJSON几何（EPSG:4326）
+	Username   string         `gorm:"type:varchar(255);index"`
+	SessionID  int64          `gorm:"index"` // 关联的编辑会话，会话提交/放弃时自动释放
+	DeviceName string         `gorm:"type:varchar(255)"`
+	CreatedAt  string         `gorm:"type:varchar(255)"`
+	ExpiresAt  string         `gorm:"type:varchar(255);index"`
+}
@@ -241,6 +241,8 @@ type FieldCalculatorRequest struct {
 	Condition     string               `json:"condition,omitempty"`               // 过滤条件 (WHERE子句)
 	DecimalPlaces *int                 `json:"decimal_places,omitempty"`          // 小数位数 (用于round操作)
 	ReplaceConfig *ReplaceConfig       `json:"replace_config,omitempty"`          // 替换配置 (用于replace操作)
+	Username      string               `json:"username,omitempty"`                // 操作用户，用于属性变更记录
+	BZ            string               `json:"bz,omitempty"`                      // 备注
 }
 
 // ReplaceConfig 字符串替换配置
@@ -271,6 +273,7 @@ type FieldCalculatorResponse struct {
 	OperationType string `json:"operation_type"`
 	AffectedRows  int64  `json:"affected_rows"`
 	SQLStatement  string `json:"sql_statement"`
+	RecordID      int64  `json:"record_id,omitempty"` // 属性变更记录，可用于回退
 }
 
 // CalcType 计算类型
@@ -299,6 +302,8 @@ type GeometryUpdateRequest struct {
 	CalcType    CalcType `json:"calc_type" binding:"required"`    // 计算类型
 	AreaType    AreaType `json:"area_type,omitempty"`             // 面积类型(仅area时需要)
 	WhereClause string   `json:"where_clause,omitempty"`          // 可选的WHERE条件
+	Username    string   `json:"username,omitempty"`              // 操作用户，用于属性变更记录
+	BZ          string   `json:"bz,omitempty"`                    // 备注
 }
 
 // GeometryUpdateResponse 几何字段更新响应
@@ -309,4 +314,5 @@ type GeometryUpdateResponse struct {
 	RowsAffected int64  `json:"rows_affected"` // 影响的行数
 	Success      bool   `json:"success"`
 	Message      string `json:"message"`
+	RecordID     int64  `json:"record_id,omitempty"` // 属性变更记录，可用于回退
 }
@@ -0,0 +1,24 @@
+package models
+
+import "gorm.io/datatypes"
+
+// GeometryCheckTask 几何质量检查/修复任务
+type GeometryCheckTask struct {
+	ID         int64          `gorm:"primaryKey;autoIncrement"`
+	TaskID     string         `gorm:"type:varchar(200);uniqueIndex"`
+	TableName  string         `gorm:"type:varchar(255);index"`
+	Username   string         `gorm:"type:varchar(255)"`
+	Status     int            // 0 运行中 1 执行完成 2 执行失败
+	Progress   int            // 0-100
+	Total      int64          // 要素总数
+	Checked    int64          // 已检查要素数
+	IssueCount int            // 发现的问题数
+	FixedCount int            // 已修复/删除的要素数
+	Args       datatypes.JSON `gorm:"type:jsonb"` // 检查参数
+	Summary    datatypes.JSON `gorm:"type:jsonb"` // 各类问题数量
+	ReportPath string         `gorm:"type:varchar(500)"`
+	SessionID  int64          // 修复操作所在的编辑会话，可整体撤销
+	Message    string         `gorm:"type:text"`
+	CreatedAt  string         `gorm:"type:varchar(255)"`
+	FinishedAt string         `gorm:"type:varchar(255)"`
+}
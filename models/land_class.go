@@ -0,0 +1,125 @@
+package models
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// DefaultLandClassVersion 内置的第三次全国国土调查工作分类
+const DefaultLandClassVersion = "三调工作分类"
+
+// LandClass 土地利用分类字典，同一版本内编码唯一
+type LandClass struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement"`
+	Version    string `gorm:"type:varchar(100);uniqueIndex:idx_land_class_code"` // 分类版本，如 三调工作分类、GB/T 21010-2017
+	Code       string `gorm:"type:varchar(50);uniqueIndex:idx_land_class_code"`  // 地类编码 DLBM
+	Name       string `gorm:"type:varchar(255)"`                                 // 地类名称 DLMC
+	ParentCode string `gorm:"type:varchar(50)"`                                  // 上级地类编码，一级类为空
+	Level      int    // 1 一级类 / 2 二级类
+	Category   string `gorm:"type:varchar(50)"` // 三大类：农用地 / 建设用地 / 未利用地
+	Color      string `gorm:"type:varchar(100)"`
+	Sort       int
+}
+
+// 三调工作分类，湿地类下的二级类沿用原编码，上级为00
+var defaultLandClasses = []LandClass{
+	{Code: "00", Name: "湿地", Level: 1, Color: "RGB(100,200,200)"},
+	{Code: "0303", Name: "红树林地", ParentCode: "00", Level: 2, Category: "农用地", Color: "RGB(0,150,100)"},
+	{Code: "0304", Name: "森林沼泽", ParentCode: "00", Level: 2, Category: "农用地", Color: "RGB(50,170,120)"},
+	{Code: "0306", Name: "灌丛沼泽", ParentCode: "00", Level: 2, Category: "农用地", Color: "RGB(100,180,140)"},
+	{Code: "0402", Name: "沼泽草地", ParentCode: "00", Level: 2, Category: "农用地", Color: "RGB(130,200,150)"},
+	{Code: "0603", Name: "盐田", ParentCode: "00", Level: 2, Category: "建设用地", Color: "RGB(190,190,230)"},
+	{Code: "1105", Name: "沿海滩涂", ParentCode: "00", Level: 2, Category: "未利用地", Color: "RGB(180,230,230)"},
+	{Code: "1106", Name: "内陆滩涂", ParentCode: "00", Level: 2, Category: "未利用地", Color: "RGB(200,230,230)"},
+	{Code: "1108", Name: "沼泽地", ParentCode: "00", Level: 2, Category: "未利用地", Color: "RGB(120,190,190)"},
+
+	{Code: "01", Name: "耕地", Level: 1, Category: "农用地", Color: "RGB(255,255,100)"},
+	{Code: "0101", Name: "水田", ParentCode: "01", Level: 2, Category: "农用地", Color: "RGB(255,255,100)"},
+	{Code: "0102", Name: "水浇地", ParentCode: "01", Level: 2, Category: "农用地", Color: "RGB(255,245,120)"},
+	{Code: "0103", Name: "旱地", ParentCode: "01", Level: 2, Category: "农用地", Color: "RGB(255,235,140)"},
+
+	{Code: "02", Name: "种植园用地", Level: 1, Category: "农用地", Color: "RGB(200,230,100)"},
+	{Code: "0201", Name: "果园", ParentCode: "02", Level: 2, Category: "农用地", Color: "RGB(210,235,100)"},
+	{Code: "0202", Name: "茶园", ParentCode: "02", Level: 2, Category: "农用地", Color: "RGB(190,225,110)"},
+	{Code: "0203", Name: "橡胶园", ParentCode: "02", Level: 2, Category: "农用地", Color: "RGB(180,220,120)"},
+	{Code: "0204", Name: "其他园地", ParentCode: "02", Level: 2, Category: "农用地", Color: "RGB(200,230,140)"},
+
+	{Code: "03", Name: "林地", Level: 1, Category: "农用地", Color: "RGB(50,180,80)"},
+	{Code: "0301", Name: "乔木林地", ParentCode: "03", Level: 2, Category: "农用地", Color: "RGB(40,170,70)"},
+	{Code: "0302", Name: "竹林地", ParentCode: "03", Level: 2, Category: "农用地", Color: "RGB(80,190,90)"},
+	{Code: "0305", Name: "灌木林地", ParentCode: "03", Level: 2, Category: "农用地", Color: "RGB(110,200,110)"},
+	{Code: "0307", Name: "其他林地", ParentCode: "03", Level: 2, Category: "农用地", Color: "RGB(140,210,130)"},
+
+	{Code: "04", Name: "草地", Level: 1, Color: "RGB(180,230,150)"},
+	{Code: "0401", Name: "天然牧草地", ParentCode: "04", Level: 2, Category: "农用地", Color: "RGB(170,225,140)"},
+	{Code: "0403", Name: "人工牧草地", ParentCode: "04", Level: 2, Category: "农用地", Color: "RGB(190,235,160)"},
+	{Code: "0404", Name: "其他草地", ParentCode: "04", Level: 2, Category: "未利用地", Color: "RGB(205,240,175)"},
+
+	{Code: "05", Name: "商业服务业用地", Level: 1, Category: "建设用地", Color: "RGB(255,100,100)"},
+	{Code: "05H1", Name: "商业服务业设施用地", ParentCode: "05", Level: 2, Category: "建设用地", Color: "RGB(255,100,100)"},
+	{Code: "0508", Name: "物流仓储用地", ParentCode: "05", Level: 2, Category: "建设用地", Color: "RGB(230,120,150)"},
+
+	{Code: "06", Name: "工矿用地", Level: 1, Category: "建设用地", Color: "RGB(200,140,200)"},
+	{Code: "0601", Name: "工业用地", ParentCode: "06", Level: 2, Category: "建设用地", Color: "RGB(200,140,200)"},
+	{Code: "0602", Name: "采矿用地", ParentCode: "06", Level: 2, Category: "建设用地", Color: "RGB(180,120,180)"},
+
+	{Code: "07", Name: "住宅用地", Level: 1, Category: "建设用地", Color: "RGB(255,170,100)"},
+	{Code: "0701", Name: "城镇住宅用地", ParentCode: "07", Level: 2, Category: "建设用地", Color: "RGB(255,170,100)"},
+	{Code: "0702", Name: "农村宅基地", ParentCode: "07", Level: 2, Category: "建设用地", Color: "RGB(255,190,130)"},
+
+	{Code: "08", Name: "公共管理与公共服务用地", Level: 1, Category: "建设用地", Color: "RGB(255,130,170)"},
+	{Code: "08H1", Name: "机关团体新闻出版用地", ParentCode: "08", Level: 2, Category: "建设用地", Color: "RGB(255,130,170)"},
+	{Code: "08H2", Name: "科教文卫用地", ParentCode: "08", Level: 2, Category: "建设用地", Color: "RGB(255,150,190)"},
+	{Code: "0809", Name: "公用设施用地", ParentCode: "08", Level: 2, Category: "建设用地", Color: "RGB(230,130,130)"},
+	{Code: "0810", Name: "公园与绿地", ParentCode: "08", Level: 2, Category: "建设用地", Color: "RGB(120,210,120)"},
+
+	{Code: "09", Name: "特殊用地", Level: 1, Category: "建设用地", Color: "RGB(150,120,90)"},
+
+	{Code: "10", Name: "交通运输用地", Level: 1, Color: "RGB(170,170,170)"},
+	{Code: "1001", Name: "铁路用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(150,150,150)"},
+	{Code: "1002", Name: "轨道交通用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(160,160,160)"},
+	{Code: "1003", Name: "公路用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(190,190,190)"},
+	{Code: "1004", Name: "城镇村道路用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(200,200,200)"},
+	{Code: "1005", Name: "交通服务场站用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(175,175,175)"},
+	{Code: "1006", Name: "农村道路", ParentCode: "10", Level: 2, Category: "农用地", Color: "RGB(220,210,180)"},
+	{Code: "1007", Name: "机场用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(140,140,160)"},
+	{Code: "1008", Name: "港口码头用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(150,160,180)"},
+	{Code: "1009", Name: "管道运输用地", ParentCode: "10", Level: 2, Category: "建设用地", Color: "RGB(165,165,165)"},
+
+	{Code: "11", Name: "水域及水利设施用地", Level: 1, Color: "RGB(100,180,255)"},
+	{Code: "1101", Name: "河流水面", ParentCode: "11", Level: 2, Category: "未利用地", Color: "RGB(90,170,250)"},
+	{Code: "1102", Name: "湖泊水面", ParentCode: "11", Level: 2, Category: "未利用地", Color: "RGB(110,180,250)"},
+	{Code: "1103", Name: "水库水面", ParentCode: "11", Level: 2, Category: "建设用地", Color: "RGB(70,150,240)"},
+	{Code: "1104", Name: "坑塘水面", ParentCode: "11", Level: 2, Category: "农用地", Color: "RGB(130,200,250)"},
+	{Code: "1107", Name: "沟渠", ParentCode: "11", Level: 2, Category: "农用地", Color: "RGB(150,210,250)"},
+	{Code: "1109", Name: "水工建筑用地", ParentCode: "11", Level: 2, Category: "建设用地", Color: "RGB(100,140,200)"},
+	{Code: "1110", Name: "冰川及常年积雪", ParentCode: "11", Level: 2, Category: "未利用地", Color: "RGB(230,245,255)"},
+
+	{Code: "12", Name: "其他土地", Level: 1, Color: "RGB(220,200,170)"},
+	{Code: "1201", Name: "空闲地", ParentCode: "12", Level: 2, Category: "建设用地", Color: "RGB(230,200,170)"},
+	{Code: "1202", Name: "设施农用地", ParentCode: "12", Level: 2, Category: "农用地", Color: "RGB(240,220,150)"},
+	{Code: "1203", Name: "田坎", ParentCode: "12", Level: 2, Category: "农用地", Color: "RGB(230,230,160)"},
+	{Code: "1204", Name: "盐碱地", ParentCode: "12", Level: 2, Category: "未利用地", Color: "RGB(230,225,210)"},
+	{Code: "1205", Name: "沙地", ParentCode: "12", Level: 2, Category: "未利用地", Color: "RGB(240,230,190)"},
+	{Code: "1206", Name: "裸土地", ParentCode: "12", Level: 2, Category: "未利用地", Color: "RGB(215,200,180)"},
+	{Code: "1207", Name: "裸岩石砾地", ParentCode: "12", Level: 2, Category: "未利用地", Color: "RGB(200,190,180)"},
+}
+
+// initDefaultLandClasses 内置分类版本不存在时写入
+func initDefaultLandClasses(db *gorm.DB) {
+	var count int64
+	db.Model(&LandClass{}).Where("version = ?", DefaultLandClassVersion).Count(&count)
+	if count > 0 {
+		return
+	}
+	classes := make([]LandClass, len(defaultLandClasses))
+	for i, item := range defaultLandClasses {
+		item.Version = DefaultLandClassVersion
+		item.Sort = i + 1
+		classes[i] = item
+	}
+	if err := db.Create(&classes).Error; err != nil {
+		log.Printf("Failed to create default land classes: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "gorm.io/datatypes"
+
+// LandOccupationRule 用地压占分析规则，每个分析图层一条，未配置时按图层字段自动推断
+type LandOccupationRule struct {
+	ID               int64          `gorm:"primaryKey;autoIncrement"`
+	LayerName        string         `gorm:"type:varchar(255);uniqueIndex"`
+	ClassFields      string         `gorm:"type:varchar(255)"` // 地类字段，逗号分隔，如 "dlbm,dlmc"，统计字段属于其中时才扣除
+	DeductMode       string         `gorm:"type:varchar(50)"`  // none / coefficient 按扣除系数扣除田坎
+	DeductField      string         `gorm:"type:varchar(255)"` // 扣除系数字段，如 kcxs
+	DeductLabel      string         `gorm:"type:varchar(255)"` // 扣除面积归入的地类，默认"田坎"
+	DeductLabels     datatypes.JSON `gorm:"type:jsonb"`        // 按统计字段指定扣除地类，如 {"dlbm":"1203"}
+	LinearLayer      string         `gorm:"type:varchar(255)"` // 线状地物图层，面积从所在图斑地类中扣除
+	LinearWidthField string         `gorm:"type:varchar(255)"` // 线状地物宽度字段(米)
+	LinearLabel      string         `gorm:"type:varchar(255)"` // 线状地物图层缺少统计字段时使用的地类
+	AreaMode         string         `gorm:"type:varchar(50)"`  // geodesic 椭球面积 / planar 投影面积
+	EPSG             int            // planar时的投影坐标系，0时按分析范围自动选择3度带
+	Decimals         *int           // 面积保留小数位，默认2
+	MinArea          float64        // 小于该面积的地类不统计
+	BalanceRounding  bool           // 取整后调整尾差，使各地类面积之和等于总面积
+	UpdatedAt        string         `gorm:"type:varchar(255)"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "gorm.io/datatypes"
+
+// NetworkDataset 网络数据集，由道路、管线等线图层构建，边和节点保存在 net_<ID>_edge、net_<ID>_node 表中
+type NetworkDataset struct {
+	ID             int64          `gorm:"primaryKey;autoIncrement"`
+	Name           string         `gorm:"type:varchar(255);uniqueIndex"`
+	Layers         datatypes.JSON `gorm:"type:jsonb"`        // 参与构建的线图层，如 ["dl","xcdl"]
+	Connectivity   string         `gorm:"type:varchar(50)"`  // endpoint 仅端点相连 / intersection 相交处打断相连
+	CostMode       string         `gorm:"type:varchar(50)"`  // length 长度(米) / field 字段值 / speed 按速度计算时间(分钟)
+	CostField      string         `gorm:"type:varchar(255)"` // field时每个要素的通行成本，按长度比例分配到打断后的边
+	SpeedField     string         `gorm:"type:varchar(255)"` // speed时的速度字段(千米/小时)
+	DefaultSpeed   float64        // 速度字段为空或图层没有该字段时的速度
+	OnewayField    string         `gorm:"type:varchar(255)"` // 单行字段
+	ForwardValues  string         `gorm:"type:varchar(255)"` // 只能沿数字化方向通行的取值，逗号分隔，如 "FT,1"
+	BackwardValues string         `gorm:"type:varchar(255)"` // 只能逆数字化方向通行的取值，如 "TF,-1"
+	AllowUTurn     bool           // 存在转向规则时是否允许原路掉头
+	Status         string         `gorm:"type:varchar(50)"` // 未构建 / 构建中 / 已构建 / 构建失败
+	Message        string         `gorm:"type:text"`
+	EdgeCount      int64
+	NodeCount      int64
+	BuiltAt        string `gorm:"type:varchar(255)"`
+	UpdatedAt      string `gorm:"type:varchar(255)"`
+}
+
+// NetworkTurn 转向规则，从一个要素进入相连的另一个要素时附加成本或禁止通行
+type NetworkTurn struct {
+	ID        int64   `gorm:"primaryKey;autoIncrement"`
+	NetworkID int64   `gorm:"index"`
+	FromLayer string  `gorm:"type:varchar(255)"`
+	FromID    int64   // 驶出要素ID
+	ToLayer   string  `gorm:"type:varchar(255)"`
+	ToID      int64   // 驶入要素ID
+	Penalty   float64 // 附加成本，小于0表示禁止转向
+}
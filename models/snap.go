@@ -0,0 +1,42 @@
+// models/snap.go
+package models
+
+// SnapLayer 参与捕捉的图层及其捕捉类型，Types为空时使用请求中的类型
+type SnapLayer struct {
+	LayerName string   `json:"layer_name"`
+	Types     []string `json:"types,omitempty"` // vertex / edge / midpoint / intersection / perpendicular / endpoint
+}
+
+// SnapRequest 捕捉请求
+type SnapRequest struct {
+	Action    string      `json:"action,omitempty"`    // WebSocket中使用: extent / snap / refresh
+	Layers    []SnapLayer `json:"layers,omitempty"`    // 参与捕捉的图层
+	Extent    []float64   `json:"extent,omitempty"`    // 当前视角范围 [minx, miny, maxx, maxy]
+	Point     []float64   `json:"point,omitempty"`     // 光标位置 [x, y]
+	From      []float64   `json:"from,omitempty"`      // 正在绘制的上一个顶点，用于垂足捕捉
+	Tolerance float64     `json:"tolerance,omitempty"` // 捕捉容差(像素)，默认10
+	Zoom      float64     `json:"zoom,omitempty"`      // 当前缩放级别
+	TileSize  float64     `json:"tile_size,omitempty"` // 瓦片像素大小，默认256
+	Types     []string    `json:"types,omitempty"`     // 启用的捕捉类型，为空时全部启用
+}
+
+// SnapCandidate 捕捉结果
+type SnapCandidate struct {
+	Type      string      `json:"type"`              // 捕捉类型
+	Point     []float64   `json:"point"`             // 捕捉到的点 [x, y]
+	Distance  float64     `json:"distance"`          // 与光标的距离(米)
+	LayerName string      `json:"layer_name"`        // 所在图层
+	FeatureID int64       `json:"feature_id"`        // 所在要素
+	Segment   [][]float64 `json:"segment,omitempty"` // 边、中点、垂足捕捉所在线段
+}
+
+// SnapResponse 捕捉响应
+type SnapResponse struct {
+	Type       string          `json:"type"` // "snap" / "extent" / "error"
+	Snapped    bool            `json:"snapped"`
+	Best       *SnapCandidate  `json:"best,omitempty"`
+	Candidates []SnapCandidate `json:"candidates,omitempty"`
+	Tolerance  float64         `json:"tolerance,omitempty"` // 换算后的容差(米)
+	Truncated  bool            `json:"truncated,omitempty"` // 视野内要素过多，索引只包含部分要素
+	Message    string          `json:"message,omitempty"`
+}
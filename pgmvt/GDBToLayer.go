@@ -463,6 +463,14 @@ func AddGDBDirectlyOptimized(DB *gorm.DB, gdbPath string, targetLayers []string,
 		log.Printf("读取GDB元数据失败: %v, 将使用默认值", err)
 		metadataCollection = nil
 	}
+	// 读取属性域
+	domainInfo, err := ReadGDBDomains(gdbPath)
+	if err != nil {
+		log.Printf("读取GDB属性域失败: %v", err)
+		domainInfo = nil
+	} else {
+		SaveGDBDomains(DB, domainInfo)
+	}
 	// 获取GDB文件名（不含扩展名）
 	gdbFileName := filepath.Base(gdbPath)
 	gdbFileName = strings.TrimSuffix(gdbFileName, filepath.Ext(gdbFileName))
@@ -522,6 +530,9 @@ func AddGDBDirectlyOptimized(DB *gorm.DB, gdbPath string, targetLayers []string,
 		geoType := mapGeoTypeToStandard(layer.GeoType)
 		handleSchemaRecord(DB, tableName, layerCN, fullMain, Color, Opacity, geoType, replacer, Userunits, LineWidth, SC)
 		InitOriginMapping(DB, tableName, "fid")
+		if domainInfo != nil {
+			SaveGDBFieldRules(DB, domainInfo, layer.LayerName, tableName, AttMap)
+		}
 		processedTables = append(processedTables, tableName)
 	}
 
@@ -0,0 +1,208 @@
+package pgmvt
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/GrainArc/Gogeo"
+	"github.com/GrainArc/SouceMap/models"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GDB_Items中属性域与要素类定义的XML结构（仅解析用到的字段）
+type gdbCodedValueDomain struct {
+	DomainName  string `xml:"DomainName"`
+	FieldType   string `xml:"FieldType"`
+	Description string `xml:"Description"`
+	CodedValues []struct {
+		Name string `xml:"Name"`
+		Code string `xml:"Code"`
+	} `xml:"CodedValues>CodedValue"`
+}
+
+type gdbRangeDomain struct {
+	DomainName  string  `xml:"DomainName"`
+	FieldType   string  `xml:"FieldType"`
+	Description string  `xml:"Description"`
+	MinValue    float64 `xml:"MinValue"`
+	MaxValue    float64 `xml:"MaxValue"`
+}
+
+type gdbClassInfo struct {
+	Name   string `xml:"Name"`
+	Fields []struct {
+		Name       string `xml:"Name"`
+		FieldType  string `xml:"FieldType"`
+		IsNullable bool   `xml:"IsNullable"`
+		DomainName string `xml:"DomainName"`
+	} `xml:"GPFieldInfoExs>GPFieldInfoEx"`
+}
+
+// GDBFieldDomain 图层字段与属性域的绑定
+type GDBFieldDomain struct {
+	FieldName  string
+	DomainName string
+	IsNullable bool
+}
+
+// GDBDomainInfo GDB中的属性域及各图层字段绑定
+type GDBDomainInfo struct {
+	Domains     []models.AttributeDomain
+	LayerFields map[string][]GDBFieldDomain // key为小写图层名
+}
+
+var (
+	codedDomainReg = regexp.MustCompile(`(?s)<GPCodedValueDomain2\b.*?</GPCodedValueDomain2>`)
+	rangeDomainReg = regexp.MustCompile(`(?s)<GPRangeDomain2\b.*?</GPRangeDomain2>`)
+	featureInfoReg = regexp.MustCompile(`(?s)<DEFeatureClassInfo\b.*?</DEFeatureClassInfo>`)
+	tableInfoReg   = regexp.MustCompile(`(?s)<DETableInfo\b.*?</DETableInfo>`)
+)
+
+// ReadGDBDomains 读取GDB中的编码值域、范围域及字段绑定
+// Gogeo未提供属性域接口，这里借助SaveGDBDefinitionsToFile导出GDB_Items定义后解析XML
+func ReadGDBDomains(gdbPath string) (*GDBDomainInfo, error) {
+	tmpFile := filepath.Join(os.TempDir(), fmt.Sprintf("gdb_definitions_%d.txt", time.Now().UnixNano()))
+	defer os.Remove(tmpFile)
+	if _, err := Gogeo.SaveGDBDefinitionsToFile(gdbPath, tmpFile); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseGDBDomains(string(content), gdbPath), nil
+}
+
+func parseGDBDomains(content string, gdbPath string) *GDBDomainInfo {
+	info := &GDBDomainInfo{LayerFields: make(map[string][]GDBFieldDomain)}
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	for _, block := range codedDomainReg.FindAllString(content, -1) {
+		var d gdbCodedValueDomain
+		if err := xml.Unmarshal([]byte(block), &d); err != nil || d.DomainName == "" {
+			continue
+		}
+		values := make([]models.CodedValue, 0, len(d.CodedValues))
+		for _, v := range d.CodedValues {
+			values = append(values, models.CodedValue{Code: strings.TrimSpace(v.Code), Name: strings.TrimSpace(v.Name)})
+		}
+		valuesJSON, _ := json.Marshal(values)
+		info.Domains = append(info.Domains, models.AttributeDomain{
+			Name:        d.DomainName,
+			Description: d.Description,
+			DomainType:  "coded",
+			FieldType:   d.FieldType,
+			CodedValues: valuesJSON,
+			Source:      "gdb",
+			SourcePath:  gdbPath,
+			UpdatedAt:   now,
+		})
+	}
+
+	for _, block := range rangeDomainReg.FindAllString(content, -1) {
+		var d gdbRangeDomain
+		if err := xml.Unmarshal([]byte(block), &d); err != nil || d.DomainName == "" {
+			continue
+		}
+		minValue, maxValue := d.MinValue, d.MaxValue
+		info.Domains = append(info.Domains, models.AttributeDomain{
+			Name:        d.DomainName,
+			Description: d.Description,
+			DomainType:  "range",
+			FieldType:   d.FieldType,
+			MinValue:    &minValue,
+			MaxValue:    &maxValue,
+			Source:      "gdb",
+			SourcePath:  gdbPath,
+			UpdatedAt:   now,
+		})
+	}
+
+	blocks := append(featureInfoReg.FindAllString(content, -1), tableInfoReg.FindAllString(content, -1)...)
+	for _, block := range blocks {
+		var ci gdbClassInfo
+		if err := xml.Unmarshal([]byte(block), &ci); err != nil || ci.Name == "" {
+			continue
+		}
+		var fields []GDBFieldDomain
+		for _, f := range ci.Fields {
+			// 跳过系统字段
+			if f.FieldType == "esriFieldTypeOID" || f.FieldType == "esriFieldTypeGeometry" ||
+				strings.HasPrefix(strings.ToLower(f.Name), "shape_") {
+				continue
+			}
+			if f.DomainName == "" && f.IsNullable {
+				continue
+			}
+			fields = append(fields, GDBFieldDomain{FieldName: f.Name, DomainName: f.DomainName, IsNullable: f.IsNullable})
+		}
+		// 要素类名可能带有数据集前缀
+		name := ci.Name
+		if idx := strings.LastIndex(name, "."); idx >= 0 {
+			name = name[idx+1:]
+		}
+		info.LayerFields[strings.ToLower(name)] = fields
+	}
+	return info
+}
+
+// SaveGDBDomains 保存GDB属性域，同名属性域覆盖
+func SaveGDBDomains(DB *gorm.DB, info *GDBDomainInfo) {
+	for _, d := range info.Domains {
+		var existing models.AttributeDomain
+		if err := DB.Where("name = ?", d.Name).First(&existing).Error; err == nil {
+			d.ID = existing.ID
+		}
+		if err := DB.Save(&d).Error; err != nil {
+			log.Printf("保存属性域 %s 失败: %v", d.Name, err)
+		}
+	}
+}
+
+// SaveGDBFieldRules 根据GDB字段绑定生成图层的字段规则，原有来源为gdb的规则会被替换
+func SaveGDBFieldRules(DB *gorm.DB, info *GDBDomainInfo, layerName string, tableName string, attMap []ProcessedFieldInfo) int {
+	fields := info.LayerFields[strings.ToLower(layerName)]
+	DB.Where("table_name = ? AND source = ?", tableName, "gdb").Delete(&models.FieldRule{})
+	if len(fields) == 0 {
+		return 0
+	}
+	nameMap := make(map[string]string)
+	for _, f := range attMap {
+		nameMap[strings.ToLower(f.OriginalName)] = f.ProcessedName
+	}
+	count := 0
+	for _, f := range fields {
+		pgName, ok := nameMap[strings.ToLower(f.FieldName)]
+		if !ok {
+			continue
+		}
+		if f.DomainName != "" {
+			DB.Create(&models.FieldRule{
+				TableName:  tableName,
+				FieldName:  pgName,
+				RuleType:   "domain",
+				DomainName: f.DomainName,
+				Enabled:    true,
+				Source:     "gdb",
+			})
+			count++
+		}
+		if !f.IsNullable {
+			DB.Create(&models.FieldRule{
+				TableName: tableName,
+				FieldName: pgName,
+				RuleType:  "required",
+				Enabled:   true,
+				Source:    "gdb",
+			})
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,230 @@
+package pgmvt
+
+/*
+#cgo windows CFLAGS: -IC:/OSGeo4W/include -IC:/OSGeo4W/include/gdal -Wno-unused-result
+#cgo windows LDFLAGS: -LC:/OSGeo4W/lib -lgdal_i -lstdc++ -static-libgcc -static-libstdc++
+#cgo linux CFLAGS: -I/usr/include/gdal -Wno-unused-result
+#cgo linux LDFLAGS: -L/usr/lib -lgdal -lstdc++
+#include <stdlib.h>
+#include <stdbool.h>
+#include <gdal.h>
+#include <ogr_api.h>
+#include <cpl_conv.h>
+
+static GDALDatasetH openGDBForUpdate(const char* path) {
+	return GDALOpenEx(path, GDAL_OF_VECTOR | GDAL_OF_UPDATE, NULL, NULL, NULL);
+}
+
+static OGRCodedValue* newCodedValues(int n) {
+	return (OGRCodedValue*)calloc(n + 1, sizeof(OGRCodedValue));
+}
+
+static void setCodedValue(OGRCodedValue* values, int i, char* code, char* name) {
+	values[i].pszCode = code;
+	values[i].pszValue = name;
+}
+
+static void freeCodedValues(OGRCodedValue* values, int n) {
+	for (int i = 0; i < n; i++) {
+		free(values[i].pszCode);
+		free(values[i].pszValue);
+	}
+	free(values);
+}
+
+static OGRFieldDomainH createRangeDomain(const char* name, const char* desc, OGRFieldType type, OGRFieldSubType subType,
+	int hasMin, double minValue, int hasMax, double maxValue) {
+	OGRField min, max;
+	if (type == OFTInteger) {
+		min.Integer = (int)minValue;
+		max.Integer = (int)maxValue;
+	} else if (type == OFTInteger64) {
+		min.Integer64 = (GIntBig)minValue;
+		max.Integer64 = (GIntBig)maxValue;
+	} else {
+		min.Real = minValue;
+		max.Real = maxValue;
+	}
+	return OGR_RangeFldDomain_Create(name, desc, type, subType, hasMin ? &min : NULL, true, hasMax ? &max : NULL, true);
+}
+
+// writeDomain 新增属性域，GDB中已有同名属性域时更新，失败原因写入reason
+static int writeDomain(GDALDatasetH ds, OGRFieldDomainH domain, char** reason) {
+	if (GDALDatasetGetFieldDomain(ds, OGR_FldDomain_GetName(domain)) != NULL) {
+		return GDALDatasetUpdateFieldDomain(ds, domain, reason);
+	}
+	return GDALDatasetAddFieldDomain(ds, domain, reason);
+}
+
+// bindFieldDomain 设置字段的属性域和可空性，field为-1表示字段不存在
+static int bindFieldDomain(OGRLayerH layer, const char* fieldName, const char* domainName, int nullable) {
+	OGRFeatureDefnH defn = OGR_L_GetLayerDefn(layer);
+	int field = OGR_FD_GetFieldIndex(defn, fieldName);
+	if (field < 0) {
+		return -1;
+	}
+	OGRFieldDefnH current = OGR_FD_GetFieldDefn(defn, field);
+	OGRFieldDefnH altered = OGR_Fld_Create(OGR_Fld_GetNameRef(current), OGR_Fld_GetType(current));
+	int flags = 0;
+	if (domainName != NULL) {
+		OGR_Fld_SetDomainName(altered, domainName);
+		flags |= ALTER_DOMAIN_FLAG;
+	}
+	if (!nullable) {
+		OGR_Fld_SetNullable(altered, 0);
+		flags |= ALTER_NULLABLE_FLAG;
+	}
+	OGRErr err = OGRERR_NONE;
+	if (flags != 0) {
+		err = OGR_L_AlterFieldDefn(layer, field, altered, flags);
+	}
+	OGR_Fld_Destroy(altered);
+	return err == OGRERR_NONE ? 1 : 0;
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"unsafe"
+
+	"github.com/GrainArc/SouceMap/models"
+)
+
+// gdbDomainFieldType 属性域记录的字段类型转为OGR字段类型，未知类型时编码值域按文本、范围域按浮点处理
+func gdbDomainFieldType(d models.AttributeDomain) (C.OGRFieldType, C.OGRFieldSubType) {
+	switch d.FieldType {
+	case "esriFieldTypeSmallInteger":
+		return C.OFTInteger, C.OFSTInt16
+	case "esriFieldTypeInteger":
+		return C.OFTInteger, C.OFSTNone
+	case "esriFieldTypeBigInteger":
+		return C.OFTInteger64, C.OFSTNone
+	case "esriFieldTypeSingle":
+		return C.OFTReal, C.OFSTFloat32
+	case "esriFieldTypeDouble":
+		return C.OFTReal, C.OFSTNone
+	case "esriFieldTypeString":
+		return C.OFTString, C.OFSTNone
+	case "esriFieldTypeDate":
+		return C.OFTDateTime, C.OFSTNone
+	}
+	if d.DomainType == "range" {
+		return C.OFTReal, C.OFSTNone
+	}
+	return C.OFTString, C.OFSTNone
+}
+
+// createGDALDomain 根据属性域记录创建GDAL属性域，调用方负责OGR_FldDomain_Destroy
+func createGDALDomain(d models.AttributeDomain) (C.OGRFieldDomainH, error) {
+	cName := C.CString(d.Name)
+	defer C.free(unsafe.Pointer(cName))
+	cDesc := C.CString(d.Description)
+	defer C.free(unsafe.Pointer(cDesc))
+	fieldType, subType := gdbDomainFieldType(d)
+
+	switch d.DomainType {
+	case "coded":
+		var values []models.CodedValue
+		json.Unmarshal(d.CodedValues, &values)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("编码值域%s没有编码值", d.Name)
+		}
+		cValues := C.newCodedValues(C.int(len(values)))
+		defer C.freeCodedValues(cValues, C.int(len(values)))
+		for i, v := range values {
+			C.setCodedValue(cValues, C.int(i), C.CString(v.Code), C.CString(v.Name))
+		}
+		return C.OGR_CodedFldDomain_Create(cName, cDesc, fieldType, subType, cValues), nil
+	case "range":
+		var hasMin, hasMax C.int
+		var minValue, maxValue C.double
+		if d.MinValue != nil {
+			hasMin, minValue = 1, C.double(*d.MinValue)
+		}
+		if d.MaxValue != nil {
+			hasMax, maxValue = 1, C.double(*d.MaxValue)
+		}
+		return C.createRangeDomain(cName, cDesc, fieldType, subType, hasMin, minValue, hasMax, maxValue), nil
+	}
+	return nil, fmt.Errorf("不支持的属性域类型: %s", d.DomainType)
+}
+
+// WriteGDBDomains 将属性域写入GDB并绑定到各图层字段，GDB中已有的同名属性域以数据库中的定义覆盖
+// 需要GDAL 3.6及以上的OpenFileGDB驱动，返回写入的属性域数量
+func WriteGDBDomains(gdbPath string, info *GDBDomainInfo) (int, error) {
+	if info == nil || len(info.LayerFields) == 0 {
+		return 0, nil
+	}
+	cPath := C.CString(gdbPath)
+	defer C.free(unsafe.Pointer(cPath))
+	hDS := C.openGDBForUpdate(cPath)
+	if hDS == nil {
+		return 0, fmt.Errorf("无法以编辑模式打开GDB: %s", gdbPath)
+	}
+	defer C.GDALClose(hDS)
+
+	written := make(map[string]bool)
+	for _, d := range info.Domains {
+		domain, err := createGDALDomain(d)
+		if err != nil {
+			log.Printf("导出属性域失败: %v", err)
+			continue
+		}
+		if domain == nil {
+			log.Printf("导出属性域%s失败: 无法创建属性域", d.Name)
+			continue
+		}
+		var reason *C.char
+		ok := C.writeDomain(hDS, domain, &reason)
+		C.OGR_FldDomain_Destroy(domain)
+		if ok == 0 {
+			msg := "驱动不支持写入属性域"
+			if reason != nil {
+				msg = C.GoString(reason)
+				C.CPLFree(unsafe.Pointer(reason))
+			}
+			log.Printf("导出属性域%s失败: %s", d.Name, msg)
+			continue
+		}
+		written[d.Name] = true
+	}
+
+	for layerName, fields := range info.LayerFields {
+		cLayer := C.CString(layerName)
+		hLayer := C.GDALDatasetGetLayerByName(hDS, cLayer)
+		C.free(unsafe.Pointer(cLayer))
+		if hLayer == nil {
+			log.Printf("GDB中未找到图层%s，跳过属性域绑定", layerName)
+			continue
+		}
+		for _, f := range fields {
+			var cDomain *C.char
+			if f.DomainName != "" && written[f.DomainName] {
+				cDomain = C.CString(f.DomainName)
+			}
+			if cDomain == nil && f.IsNullable {
+				continue
+			}
+			nullable := C.int(0)
+			if f.IsNullable {
+				nullable = 1
+			}
+			cField := C.CString(f.FieldName)
+			result := C.bindFieldDomain(hLayer, cField, cDomain, nullable)
+			C.free(unsafe.Pointer(cField))
+			if cDomain != nil {
+				C.free(unsafe.Pointer(cDomain))
+			}
+			switch result {
+			case -1:
+				log.Printf("图层%s中未找到字段%s，跳过属性域绑定", layerName, f.FieldName)
+			case 0:
+				log.Printf("图层%s字段%s绑定属性域失败", layerName, f.FieldName)
+			}
+		}
+	}
+	return len(written), nil
+}
@@ -189,3 +189,51 @@ func DelMVTALL(DB *gorm.DB, tablename string) {
 	}
 
 }
+
+// MakeSessionMvt 生成版本化会话的瓦片：线上要素叠加会话内的变更，不写入瓦片缓存
+func MakeSessionMvt(x int, y int, z int, tableName string, sessionID int64, db *gorm.DB) []byte {
+	var Tb models.MySchema
+	db.Where("en = ?", tableName).First(&Tb)
+	var tileSize int64
+	if Tb.TileSize != 0 {
+		tileSize = Tb.TileSize
+	}
+	fieldNames, _ := GetTableColumns(db, tableName)
+	quotedFields := make([]string, len(fieldNames))
+	sessionFields := make([]string, len(fieldNames))
+	for i, field := range fieldNames {
+		quotedFields[i] = fmt.Sprintf("\"%s\"", field)
+		sessionFields[i] = fmt.Sprintf("r.\"%s\"", field)
+	}
+	result := strings.Join(quotedFields, ",")
+
+	boundbox_min := XyzLonLat(float64(x), float64(y), float64(z))
+	boundbox_max := XyzLonLat(float64(x)+1, float64(y)+1, float64(z))
+	envelope := fmt.Sprintf("ST_MakeEnvelope(%v, %v, %v, %v, 4326)", boundbox_min[0], boundbox_min[1], boundbox_max[0], boundbox_max[1])
+	sql := fmt.Sprintf(`
+		WITH merged AS (
+			SELECT geom, %s FROM "%s"
+			WHERE geom && %s
+			AND id NOT IN (SELECT feature_id FROM version_edit WHERE session_id = %d AND table_name = '%s')
+			UNION ALL
+			SELECT ST_SetSRID(ST_GeomFromGeoJSON(v.geojson->>'geometry'), 4326) AS geom, %s
+			FROM version_edit v, jsonb_populate_record(NULL::"%s", v.geojson->'properties') r
+			WHERE v.session_id = %d AND v.table_name = '%s' AND v.action <> 'delete'
+		)
+		SELECT ST_AsMVT(P, 'polygon', %d, 'geom') AS "mvt"
+		FROM (SELECT ST_AsMVTGeom(ST_Simplify(ST_Transform(geom, 3857), 0.1), ST_Transform(%s, 3857), %d, 32, TRUE) AS geom, %s
+		FROM merged WHERE geom && %s) AS P`,
+		result, tableName, envelope, sessionID, tableName,
+		strings.Join(sessionFields, ","), tableName, sessionID, tableName,
+		tileSize, envelope, tileSize, result, envelope)
+
+	var mvttile MVTTile
+	if err := db.Raw(sql).Scan(&mvttile).Error; err != nil {
+		log.Printf("生成会话瓦片失败: %v", err)
+		return nil
+	}
+	if len(mvttile.MVT) == 0 {
+		return nil
+	}
+	return mvttile.MVT
+}
@@ -9,6 +9,7 @@ import (
 func GDALRouters(r *gin.Engine) {
 	UserController := &GdalView.UserController{}
 	trackHandler := views.NewTrackHandler()
+	snapHandler := views.NewSnapHandler()
 	mapRouter := r.Group("/gdal")
 	{
 		// POST用于提交分析任务配置
@@ -74,6 +75,10 @@ func GDALRouters(r *gin.Engine) {
 		mapRouter.POST("/track/init", trackHandler.InitTrack)     // 初始化
 		mapRouter.GET("/track/ws", trackHandler.ConnectWebSocket) // WebSocket 连接
 	}
+	{
+		mapRouter.POST("/snap/point", snapHandler.SnapPoint) // 单次捕捉
+		mapRouter.GET("/snap/ws", snapHandler.SnapWebSocket) // 连续捕捉
+	}
 	{
 		mapRouter.POST("/raster/ClipRaster", UserController.ClipRaster) // 初始化
 		mapRouter.GET("/raster/GetRasterTaskStatus", UserController.GetRasterTaskStatus)
@@ -200,6 +205,11 @@ func GDALRouters(r *gin.Engine) {
 		mapRouter.POST("/raster/calc/Block", UserController.CalculateBlock)                           // 分块计算（大影像）
 		mapRouter.POST("/raster/calc/ValidateExpression", UserController.ValidateExpression)          // 验证表达式
 
+		// ==================== 空间插值 ====================
+		mapRouter.POST("/raster/interp/IDW", UserController.InterpolateIDW)                         // 反距离权重插值
+		mapRouter.POST("/raster/interp/Kriging", UserController.InterpolateKriging)                 // 普通克里金插值
+		mapRouter.POST("/raster/interp/NaturalNeighbor", UserController.InterpolateNaturalNeighbor) // 自然邻域插值
+
 		// ==================== 遥感指数计算 ====================
 		mapRouter.POST("/raster/index/NDVI", UserController.CalculateNDVI)   // 植被指数
 		mapRouter.POST("/raster/index/NDWI", UserController.CalculateNDWI)   // 水体指数
@@ -28,6 +28,9 @@ func GeoRouters(r *gin.Engine) {
 		mapRouter.POST("/ShowGeoByBox", UserController.ShowGeoByBox)
 		mapRouter.GET("/ShowSingleGeo", UserController.ShowSingleGeo)
 		mapRouter.POST("/SpaceIntersect", UserController.SpaceIntersect)
+		mapRouter.GET("/ListLandRules", UserController.ListLandRules)
+		mapRouter.POST("/SaveLandRule", UserController.SaveLandRule)
+		mapRouter.GET("/DelLandRule", UserController.DelLandRule)
 		mapRouter.GET("/GetTableAttributes", UserController.GetTableAttributes)
 		mapRouter.POST("/Area", UserController.Area)
 		mapRouter.POST("/GeodesicArea", UserController.GeodesicArea)
@@ -38,6 +41,7 @@ func GeoRouters(r *gin.Engine) {
 		mapRouter.POST("/ChangeLayerStyle", UserController.ChangeLayerStyle)
 		mapRouter.POST("/GetExcavationFillVolume", UserController.GetExcavationFillVolume)
 		mapRouter.POST("/GetHeightFromDEM", UserController.GetHeightFromDEM)
+		mapRouter.POST("/ElevationProfile", UserController.ElevationProfile)
 		mapRouter.Static("/OutFile", OutFilePath)
 		mapRouter.POST("/OutIntersect", UserController.OutIntersect)
 		mapRouter.GET("/OutLayer", UserController.OutLayer)
@@ -59,9 +63,13 @@ func GeoRouters(r *gin.Engine) {
 		editRouter.POST("/DeAggregatorFeature", UserController.ExplodeFeature)
 		editRouter.POST("/AreaOnAreaAnalysis", UserController.AreaOnAreaAnalysis)
 		editRouter.POST("/SplitFeature", UserController.SplitFeature)
+		editRouter.POST("/SubdivideParcel", UserController.SubdivideParcel)
+		editRouter.POST("/AreaAdjust", UserController.AreaAdjust)
 		editRouter.POST("/DissolveFeature", UserController.DissolveFeature)
 		editRouter.POST("/DonutBuilder", UserController.DonutBuilder)
 		editRouter.POST("/AggregatorFeature", UserController.AggregatorFeature)
+		editRouter.POST("/VoronoiPolygons", UserController.VoronoiPolygons)
+		editRouter.POST("/MinimumBounding", UserController.MinimumBounding)
 		editRouter.POST("/Capture", UserController.Capture)
 		editRouter.POST("/AutoPolygon", UserController.AutoPolygon)
 		editRouter.POST("/SplitGeo", UserController.SplitGeo)
@@ -72,12 +80,58 @@ func GeoRouters(r *gin.Engine) {
 		editRouter.GET("/DelChangeRecord", UserController.DelChangeRecord)
 		editRouter.POST("/ChangeGeoToSchema", UserController.ChangeGeoToSchema)
 		editRouter.GET("/BackUpRecord", UserController.BackUpRecord)
+		editRouter.GET("/PreviewBackUpRecord", UserController.PreviewBackUpRecord)
+		editRouter.GET("/RedoRecord", UserController.RedoRecord)
+		editRouter.GET("/UndoSession", UserController.UndoSession)
+		editRouter.GET("/RedoSession", UserController.RedoSession)
+		editRouter.GET("/GetSessionHistory", UserController.GetSessionHistory)
+		editRouter.GET("/GetFeatureLineage", UserController.GetFeatureLineage)
+		editRouter.GET("/GetFeaturesAsOf", UserController.GetFeaturesAsOf)
+		editRouter.POST("/ChangeAttributes", UserController.ChangeAttributes)
+		editRouter.GET("/GetAttributeHistory", UserController.GetAttributeHistory)
+		editRouter.POST("/StartGeometryCheck", UserController.StartGeometryCheck)
+		editRouter.GET("/GetGeometryCheckTask", UserController.GetGeometryCheckTask)
+		editRouter.GET("/ListGeometryCheckTasks", UserController.ListGeometryCheckTasks)
+		editRouter.GET("/DownloadGeometryCheckReport", UserController.DownloadGeometryCheckReport)
+		editRouter.GET("/UndoGeometryRepair", UserController.UndoGeometryRepair)
 		editRouter.GET("/SyncToFile", UserController.SyncToFile)
 		editRouter.POST("/GetGeoFromSchema", UserController.GetGeoFromSchema)
 		editRouter.POST("/AddGeoToSchema", UserController.AddGeoToSchema)
+		editRouter.POST("/CogoTraverse", UserController.CogoTraverse)
 		editRouter.POST("/DelGeoToSchema", UserController.DelGeoToSchema)
 		editRouter.POST("/DelGeosToSchema", UserController.DelGeosToSchema)
 	}
+	versionRouter := r.Group("/version")
+	{
+		versionRouter.POST("/CreateVersion", UserController.CreateVersion)
+		versionRouter.GET("/ListVersions", UserController.ListVersions)
+		versionRouter.GET("/GetVersionEdits", UserController.GetVersionEdits)
+		versionRouter.POST("/GetGeoFromVersion", UserController.GetGeoFromVersion)
+		versionRouter.GET("/tile/:session/:tablename/:z/:x/:y.pbf", UserController.VersionMVT)
+		versionRouter.GET("/Reconcile", UserController.ReconcileVersion)
+		versionRouter.POST("/ResolveConflicts", UserController.ResolveVersionConflicts)
+		versionRouter.GET("/Post", UserController.PostVersion)
+		versionRouter.GET("/Abandon", UserController.AbandonVersion)
+	}
+	lockRouter := r.Group("/lock")
+	{
+		lockRouter.POST("/AcquireLock", UserController.AcquireLock)
+		lockRouter.POST("/ReleaseLock", UserController.ReleaseLock)
+		lockRouter.POST("/ForceReleaseLock", UserController.ForceReleaseLock)
+		lockRouter.GET("/ListLocks", UserController.ListLocks)
+	}
+	domainRouter := r.Group("/domain")
+	{
+		domainRouter.GET("/ListDomains", UserController.ListDomains)
+		domainRouter.POST("/SaveDomain", UserController.SaveDomain)
+		domainRouter.GET("/DelDomain", UserController.DelDomain)
+		domainRouter.POST("/ImportGDBDomains", UserController.ImportGDBDomains)
+		domainRouter.GET("/ListFieldRules", UserController.ListFieldRules)
+		domainRouter.POST("/SaveFieldRule", UserController.SaveFieldRule)
+		domainRouter.GET("/DelFieldRule", UserController.DelFieldRule)
+		domainRouter.POST("/ValidateAttributes", UserController.ValidateAttributes)
+		domainRouter.GET("/ValidateLayer", UserController.ValidateLayer)
+	}
 	tempLayerRouter := r.Group("/temp_layer")
 	{
 		tempLayerRouter.POST("/ShowTempLayerHeader", UserController.ShowTempLayerHeader)
@@ -100,11 +154,54 @@ func GeoRouters(r *gin.Engine) {
 		ShareRouter.POST("/ChangeDeviceName", UserController.ChangeDeviceName)
 		mapRouter.GET("/DownloadOfflineLayer", UserController.DownloadOfflineLayer)
 	}
+	landClassRouter := r.Group("/landclass")
+	{
+		landClassRouter.GET("/ListVersions", UserController.ListLandClassVersions)
+		landClassRouter.GET("/ListClasses", UserController.ListLandClasses)
+		landClassRouter.POST("/Import", UserController.ImportLandClasses)
+		landClassRouter.GET("/DelVersion", UserController.DelLandClassVersion)
+		landClassRouter.POST("/CheckField", UserController.CheckLandClassField)
+		landClassRouter.POST("/ApplyColors", UserController.ApplyLandClassColors)
+		landClassRouter.POST("/ToDomain", UserController.LandClassToDomain)
+	}
+	mapSheetRouter := r.Group("/mapsheet")
+	{
+		mapSheetRouter.GET("/Locate", UserController.MapSheetLocate)
+		mapSheetRouter.GET("/Search", UserController.MapSheetSearch)
+		mapSheetRouter.POST("/Cover", UserController.MapSheetCover)
+		mapSheetRouter.POST("/Grid", UserController.MapSheetGrid)
+	}
+	analysisRouter := r.Group("/analysis")
+	{
+		analysisRouter.POST("/Buffer/start", UserController.StartBuffer)
+		analysisRouter.POST("/SpatialJoin/start", UserController.StartSpatialJoin)
+		analysisRouter.POST("/KernelDensity/start", UserController.StartKernelDensity)
+		analysisRouter.POST("/GridAggregate/start", UserController.StartGridAggregate)
+		analysisRouter.POST("/ChangeDetect/start", UserController.StartChangeDetect)
+		analysisRouter.GET("/ChangeDetect/List", UserController.ListChangeDetections)
+		analysisRouter.POST("/ChangeDetect/Apply", UserController.ApplyChangeDetection)
+		analysisRouter.GET("/ws/:taskId", UserController.AnalysisTaskWebSocket)
+		analysisRouter.GET("/status/:taskId", UserController.GetAnalysisTaskStatus)
+	}
+	networkRouter := r.Group("/network")
+	{
+		networkRouter.GET("/List", UserController.ListNetworks)
+		networkRouter.POST("/Save", UserController.SaveNetwork)
+		networkRouter.GET("/Delete", UserController.DelNetwork)
+		networkRouter.POST("/Build/start", UserController.StartBuildNetwork)
+		networkRouter.GET("/Turns", UserController.ListNetworkTurns)
+		networkRouter.POST("/Turns", UserController.SaveNetworkTurns)
+		networkRouter.POST("/Route", UserController.NetworkRoute)
+		networkRouter.POST("/ServiceArea", UserController.NetworkServiceArea)
+		networkRouter.POST("/ClosestFacility", UserController.NetworkClosestFacility)
+		networkRouter.POST("/ODMatrix", UserController.NetworkODMatrix)
+	}
 	SurveyRouter := r.Group("/Survey")
 	PICPath := filepath.Join(homeDir, "BoundlessMap", "PIC")
 	{
 		SurveyRouter.Static("/PIC", PICPath)
 		SurveyRouter.POST("/MsgUpload", UserController.MsgUpload)
+		SurveyRouter.POST("/ExtractBoundary", UserController.ExtractBoundary)
 		SurveyRouter.POST("/PicUpload", UserController.PicUpload)
 		SurveyRouter.POST("/ZDTUpload", UserController.ZDTUpload)
 		SurveyRouter.GET("/PicDelete", UserController.PicDel)
@@ -164,6 +261,10 @@ func GeoRouters(r *gin.Engine) {
 		fields.POST("/UpdateGeometryField", UserController.UpdateGeometryField) // 预览结果
 		fields.GET("/GetFieldInfo", UserController.GetFieldInfo)                // 获取单个字段信息
 		fields.POST("/LayerStatistics", UserController.LayerStatistics)
+		fields.GET("/ExpressionFunctions", UserController.ExpressionFunctions)
+		fields.POST("/PreviewExpression", UserController.PreviewExpression)
+		fields.POST("/CalculateExpression", UserController.CalculateExpression)
+		fields.POST("/NumberFeatures", UserController.NumberFeatures)
 
 	}
 	report := r.Group("/report")
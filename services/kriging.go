@@ -0,0 +1,302 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ==================== 普通克里金 ====================
+// 经验半变异函数按距离分组计算，理论模型在给定变程下用加权最小二乘求块金值和偏基台值，
+// 逐个候选变程比较残差平方和取最优
+
+// variogramModels 归一化的理论半变异函数，h为距离，a为变程
+var variogramModels = map[string]func(h, a float64) float64{
+	"spherical": func(h, a float64) float64 {
+		if h >= a {
+			return 1
+		}
+		r := h / a
+		return 1.5*r - 0.5*r*r*r
+	},
+	"exponential": func(h, a float64) float64 {
+		return 1 - math.Exp(-3*h/a)
+	},
+	"gaussian": func(h, a float64) float64 {
+		r := h / a
+		return 1 - math.Exp(-3*r*r)
+	},
+}
+
+// VariogramModel 拟合的半变异函数模型
+type VariogramModel struct {
+	Model  string  `json:"model"`
+	Nugget float64 `json:"nugget"` // 块金值
+	Sill   float64 `json:"sill"`   // 偏基台值
+	Range  float64 `json:"range"`  // 变程(米)
+	SSE    float64 `json:"sse"`    // 按点对数加权的残差平方和
+}
+
+func (m VariogramModel) gamma(h float64) float64 {
+	if h == 0 {
+		return 0
+	}
+	return m.Nugget + m.Sill*variogramModels[m.Model](h, m.Range)
+}
+
+// VariogramLag 经验半变异函数的一个距离分组
+type VariogramLag struct {
+	Distance     float64 `json:"distance"` // 组内点对的平均距离(米)
+	Semivariance float64 `json:"semivariance"`
+	Pairs        int     `json:"pairs"`
+}
+
+// KrigingCrossValidation 留一法交叉验证
+type KrigingCrossValidation struct {
+	Samples   int     `json:"samples"`
+	MeanError float64 `json:"mean_error"`
+	RMSE      float64 `json:"rmse"`
+}
+
+// VariogramReport 半变异函数报告
+type VariogramReport struct {
+	Samples         int                     `json:"samples"`
+	LagSize         float64                 `json:"lag_size"`
+	Lags            []VariogramLag          `json:"lags"`
+	Candidates      []VariogramModel        `json:"candidates"` // 各模型的最优拟合
+	Fitted          VariogramModel          `json:"fitted"`
+	CrossValidation *KrigingCrossValidation `json:"cross_validation,omitempty"`
+}
+
+// variogramMaxSamples 计算经验半变异函数时使用的最大样本数，超过时等间隔抽样
+const variogramMaxSamples = 2000
+
+// fitVariogram 计算经验半变异函数并拟合理论模型，model为auto时在全部模型中取残差最小者
+func fitVariogram(samples []interpSample, model string, lags int) (*VariogramReport, error) {
+	subset := samples
+	if len(samples) > variogramMaxSamples {
+		step := float64(len(samples)) / variogramMaxSamples
+		subset = make([]interpSample, 0, variogramMaxSamples)
+		for i := 0; i < variogramMaxSamples; i++ {
+			subset = append(subset, samples[int(float64(i)*step)])
+		}
+	}
+	minX, minY, maxX, maxY := subset[0].X, subset[0].Y, subset[0].X, subset[0].Y
+	for _, s := range subset {
+		minX, maxX = math.Min(minX, s.X), math.Max(maxX, s.X)
+		minY, maxY = math.Min(minY, s.Y), math.Max(maxY, s.Y)
+	}
+	// 最大分组距离取样本范围对角线的一半
+	maxLag := math.Hypot(maxX-minX, maxY-minY) / 2
+	if maxLag <= 0 {
+		return nil, fmt.Errorf("样本范围过小，无法计算半变异函数")
+	}
+	lagSize := maxLag / float64(lags)
+	sumDist := make([]float64, lags)
+	sumGamma := make([]float64, lags)
+	pairs := make([]int, lags)
+	for i := range subset {
+		for j := i + 1; j < len(subset); j++ {
+			d := math.Hypot(subset[i].X-subset[j].X, subset[i].Y-subset[j].Y)
+			bin := int(d / lagSize)
+			if bin >= lags {
+				continue
+			}
+			dv := subset[i].V - subset[j].V
+			sumDist[bin] += d
+			sumGamma[bin] += dv * dv / 2
+			pairs[bin]++
+		}
+	}
+	report := &VariogramReport{Samples: len(samples), LagSize: lagSize}
+	for i := 0; i < lags; i++ {
+		if pairs[i] > 0 {
+			report.Lags = append(report.Lags, VariogramLag{
+				Distance:     sumDist[i] / float64(pairs[i]),
+				Semivariance: sumGamma[i] / float64(pairs[i]),
+				Pairs:        pairs[i],
+			})
+		}
+	}
+	if len(report.Lags) < 3 {
+		return nil, fmt.Errorf("有效距离分组不足3个，无法拟合半变异函数")
+	}
+
+	names := []string{model}
+	if model == "auto" {
+		names = []string{"spherical", "exponential", "gaussian"}
+	}
+	for _, name := range names {
+		report.Candidates = append(report.Candidates, fitVariogramModel(report.Lags, name, lagSize, maxLag))
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool { return report.Candidates[i].SSE < report.Candidates[j].SSE })
+	report.Fitted = report.Candidates[0]
+	return report, nil
+}
+
+// fitVariogramModel 在候选变程中搜索，每个变程下块金值和偏基台值为线性最小二乘解(约束为非负)
+func fitVariogramModel(lags []VariogramLag, name string, lagSize, maxLag float64) VariogramModel {
+	f := variogramModels[name]
+	best := VariogramModel{Model: name, SSE: math.Inf(1)}
+	const steps = 60
+	for k := 1; k <= steps; k++ {
+		a := lagSize + (2*maxLag-lagSize)*float64(k-1)/float64(steps-1)
+		var sw, sf, sg, sff, sfg float64
+		for _, lag := range lags {
+			w := float64(lag.Pairs)
+			fv := f(lag.Distance, a)
+			sw += w
+			sf += w * fv
+			sg += w * lag.Semivariance
+			sff += w * fv * fv
+			sfg += w * fv * lag.Semivariance
+		}
+		var c0, c float64
+		if det := sw*sff - sf*sf; math.Abs(det) > 1e-12 {
+			c0 = (sff*sg - sf*sfg) / det
+			c = (sw*sfg - sf*sg) / det
+		}
+		if c0 < 0 {
+			c0 = 0
+			if sff > 0 {
+				c = sfg / sff
+			}
+		}
+		if c < 0 {
+			c, c0 = 0, sg/sw
+		}
+		m := VariogramModel{Model: name, Nugget: c0, Sill: c, Range: a}
+		for _, lag := range lags {
+			r := lag.Semivariance - m.gamma(lag.Distance)
+			m.SSE += float64(lag.Pairs) * r * r
+		}
+		if m.SSE < best.SSE {
+			best = m
+		}
+	}
+	return best
+}
+
+// solveLinear 列主元高斯消元，矩阵奇异时返回false
+func solveLinear(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < n; r++ {
+			factor := a[r][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[r][k] -= factor * a[col][k]
+			}
+			b[r] -= factor * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := b[r]
+		for k := r + 1; k < n; k++ {
+			sum -= a[r][k] * x[k]
+		}
+		x[r] = sum / a[r][r]
+	}
+	return x, true
+}
+
+// krigingEstimate 用邻近样本求解普通克里金方程组，返回估计值和克里金方差
+func krigingEstimate(samples []interpSample, neighbors []sampleNeighbor, m VariogramModel) (float64, float64) {
+	n := len(neighbors)
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	if neighbors[0].dist < 1e-9 {
+		return samples[neighbors[0].index].V, 0
+	}
+	a := make([][]float64, n+1)
+	b := make([]float64, n+1)
+	for i := 0; i < n; i++ {
+		a[i] = make([]float64, n+1)
+		si := samples[neighbors[i].index]
+		for j := 0; j < n; j++ {
+			sj := samples[neighbors[j].index]
+			a[i][j] = m.gamma(math.Hypot(si.X-sj.X, si.Y-sj.Y))
+		}
+		a[i][n] = 1
+		b[i] = m.gamma(neighbors[i].dist)
+	}
+	a[n] = make([]float64, n+1)
+	for j := 0; j < n; j++ {
+		a[n][j] = 1
+	}
+	b[n] = 1
+	rhs := append([]float64(nil), b...)
+	weights, ok := solveLinear(a, b)
+	if !ok {
+		// 方程组奇异时退化为邻近样本的平均值
+		var sum float64
+		for _, nb := range neighbors {
+			sum += samples[nb.index].V
+		}
+		return sum / float64(n), math.NaN()
+	}
+	var value, variance float64
+	for i := 0; i < n; i++ {
+		value += weights[i] * samples[neighbors[i].index].V
+		variance += weights[i] * rhs[i]
+	}
+	return value, variance + weights[n]
+}
+
+// krigingInterpolate 逐像元估计，返回估计值和克里金方差
+func krigingInterpolate(samples []interpSample, grid interpGrid, m VariogramModel, neighbors int) ([]float64, []float64) {
+	idx := newSampleIndex(samples, grid)
+	values := make([]float64, grid.Width*grid.Height)
+	variances := make([]float64, grid.Width*grid.Height)
+	for row := 0; row < grid.Height; row++ {
+		for col := 0; col < grid.Width; col++ {
+			x, y := grid.center(row, col)
+			i := row*grid.Width + col
+			values[i], variances[i] = krigingEstimate(samples, idx.nearest(x, y, neighbors, 0), m)
+		}
+	}
+	return values, variances
+}
+
+// krigingCrossValidate 留一法交叉验证，样本较多时等间隔抽取500个
+func krigingCrossValidate(samples []interpSample, grid interpGrid, m VariogramModel, neighbors int) *KrigingCrossValidation {
+	if len(samples) <= neighbors {
+		return nil
+	}
+	idx := newSampleIndex(samples, grid)
+	count := len(samples)
+	if count > 500 {
+		count = 500
+	}
+	step := float64(len(samples)) / float64(count)
+	cv := &KrigingCrossValidation{Samples: count}
+	for k := 0; k < count; k++ {
+		target := int(float64(k) * step)
+		s := samples[target]
+		var others []sampleNeighbor
+		for _, nb := range idx.nearest(s.X, s.Y, neighbors+1, 0) {
+			if nb.index != target {
+				others = append(others, nb)
+			}
+		}
+		value, _ := krigingEstimate(samples, others, m)
+		e := value - s.V
+		cv.MeanError += e
+		cv.RMSE += e * e
+	}
+	cv.MeanError /= float64(count)
+	cv.RMSE = math.Sqrt(cv.RMSE / float64(count))
+	return cv
+}
@@ -0,0 +1,849 @@
+// services/network_service.go
+package services
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 网络分析：由线图层构建持久化的网络数据集(边表和节点表)，
+// 分析时将边读入内存建图，在图上计算最短路径、服务区、最近设施和OD成本矩阵
+
+// networkNodePrecision 端点坐标保留的小数位数，相同坐标的端点视为同一节点(约1厘米)
+const networkNodePrecision = 7
+
+// NetworkEdgeTable 网络数据集的边表
+func NetworkEdgeTable(id int64) string {
+	return fmt.Sprintf("net_%d_edge", id)
+}
+
+// NetworkNodeTable 网络数据集的节点表
+func NetworkNodeTable(id int64) string {
+	return fmt.Sprintf("net_%d_node", id)
+}
+
+// NetworkCostUnit 成本的单位
+func NetworkCostUnit(ds models.NetworkDataset) string {
+	switch ds.CostMode {
+	case "speed":
+		return "分钟"
+	case "field":
+		return ds.CostField
+	}
+	return "米"
+}
+
+// NetworkLayers 网络数据集的源图层
+func NetworkLayers(ds models.NetworkDataset) []string {
+	var layers []string
+	json.Unmarshal(ds.Layers, &layers)
+	return layers
+}
+
+func networkColumns(db *gorm.DB, table string) map[string]bool {
+	var columns []string
+	db.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = ?`,
+		table).Scan(&columns)
+	result := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		result[strings.ToLower(col)] = true
+	}
+	return result
+}
+
+func networkValueList(values string) string {
+	var quoted []string
+	for _, v := range strings.Split(values, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			quoted = append(quoted, "'"+strings.ReplaceAll(v, "'", "''")+"'")
+		}
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// networkRateSQL 每米的通行成本表达式(正向、逆向)，不可通行时为-1
+func networkRateSQL(ds models.NetworkDataset, cols map[string]bool) (string, string) {
+	rate := "1.0"
+	switch ds.CostMode {
+	case "field":
+		// 按要素总长度折算为每米成本，成本为空的要素不可通行
+		rate = fmt.Sprintf(`COALESCE(t."%s"::float8 / NULLIF(ST_Length(t.geom::geography), 0), -1)`, ds.CostField)
+	case "speed":
+		// 千米/小时 换算为 分钟/米
+		speed := fmt.Sprintf("%g", ds.DefaultSpeed)
+		if ds.SpeedField != "" && cols[ds.SpeedField] {
+			speed = fmt.Sprintf(`COALESCE(NULLIF(t."%s"::float8, 0), %g)`, ds.SpeedField, ds.DefaultSpeed)
+		}
+		rate = fmt.Sprintf("COALESCE(0.06 / NULLIF(%s, 0), -1)", speed)
+	}
+	forward, backward := rate, rate
+	if ds.OnewayField != "" && cols[ds.OnewayField] {
+		if list := networkValueList(ds.BackwardValues); list != "" {
+			forward = fmt.Sprintf(`CASE WHEN t."%s"::text IN (%s) THEN -1 ELSE %s END`, ds.OnewayField, list, rate)
+		}
+		if list := networkValueList(ds.ForwardValues); list != "" {
+			backward = fmt.Sprintf(`CASE WHEN t."%s"::text IN (%s) THEN -1 ELSE %s END`, ds.OnewayField, list, rate)
+		}
+	}
+	return forward, backward
+}
+
+// BuildNetwork 重建网络数据集的边表和节点表，返回边数和节点数
+func BuildNetwork(ctx context.Context, db *gorm.DB, ds models.NetworkDataset, progress func(float64, string) bool) (int64, int64, error) {
+	layers := NetworkLayers(ds)
+	if len(layers) == 0 {
+		return 0, 0, fmt.Errorf("网络数据集没有源图层")
+	}
+	db = db.WithContext(ctx)
+	edgeTable := NetworkEdgeTable(ds.ID)
+	nodeTable := NetworkNodeTable(ds.ID)
+	rawTable := fmt.Sprintf("net_%d_raw", ds.ID)
+	defer models.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, rawTable))
+
+	stmts := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, rawTable),
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, edgeTable),
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, nodeTable),
+		fmt.Sprintf(`CREATE TABLE "%s" (layer VARCHAR(255), src_id INTEGER, fwd_rate DOUBLE PRECISION, rev_rate DOUBLE PRECISION, geom GEOMETRY(LineString, 4326))`, rawTable),
+		fmt.Sprintf(`CREATE TABLE "%s" (id SERIAL PRIMARY KEY, layer VARCHAR(255), src_id INTEGER, source INTEGER, target INTEGER,
+			length DOUBLE PRECISION, cost DOUBLE PRECISION, reverse_cost DOUBLE PRECISION,
+			fwd_rate DOUBLE PRECISION, rev_rate DOUBLE PRECISION, geom GEOMETRY(LineString, 4326))`, edgeTable),
+		fmt.Sprintf(`CREATE TABLE "%s" (id SERIAL PRIMARY KEY, x NUMERIC, y NUMERIC, geom GEOMETRY(Point, 4326))`, nodeTable),
+	}
+	for _, sql := range stmts {
+		if err := db.Exec(sql).Error; err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// 读取各图层的线，多部件拆分为单线
+	for i, layer := range layers {
+		cols := networkColumns(db, layer)
+		if !cols["geom"] {
+			return 0, 0, fmt.Errorf("图层不存在: %s", layer)
+		}
+		forward, backward := networkRateSQL(ds, cols)
+		err := db.Exec(fmt.Sprintf(`
+			INSERT INTO "%s" (layer, src_id, fwd_rate, rev_rate, geom)
+			SELECT '%s', s.id, s.fwd, s.rev, s.geom FROM (
+				SELECT t.id, %s AS fwd, %s AS rev, (ST_Dump(ST_Force2D(t.geom))).geom AS geom
+				FROM "%s" AS t WHERE t.geom IS NOT NULL
+			) s WHERE ST_GeometryType(s.geom) = 'ST_LineString' AND ST_NumPoints(s.geom) > 1`,
+			rawTable, layer, forward, backward, layer)).Error
+		if err != nil {
+			return 0, 0, fmt.Errorf("读取图层 %s 失败: %v", layer, err)
+		}
+		if !progress(0.4*float64(i+1)/float64(len(layers)), fmt.Sprintf("已读取图层 %s", layer)) {
+			return 0, 0, ctx.Err()
+		}
+	}
+
+	if ds.Connectivity == "intersection" {
+		// 在相交处打断，打断后的线按中点归属到原要素
+		progress(0.45, "正在打断相交线")
+		stmts = []string{
+			fmt.Sprintf(`CREATE INDEX ON "%s" USING GIST (geom)`, rawTable),
+			fmt.Sprintf(`
+				INSERT INTO "%s" (layer, src_id, fwd_rate, rev_rate, geom)
+				SELECT r.layer, r.src_id, r.fwd_rate, r.rev_rate, s.geom
+				FROM (SELECT (ST_Dump(ST_Node(ST_Collect(geom)))).geom AS geom FROM "%s") s
+				CROSS JOIN LATERAL (
+					SELECT * FROM "%s" r
+					WHERE ST_DWithin(r.geom, ST_LineInterpolatePoint(s.geom, 0.5), 1e-8)
+					ORDER BY ST_Distance(r.geom, ST_LineInterpolatePoint(s.geom, 0.5)) LIMIT 1
+				) r
+				WHERE ST_GeometryType(s.geom) = 'ST_LineString'`, edgeTable, rawTable, rawTable),
+		}
+	} else {
+		stmts = []string{
+			fmt.Sprintf(`INSERT INTO "%s" (layer, src_id, fwd_rate, rev_rate, geom) SELECT layer, src_id, fwd_rate, rev_rate, geom FROM "%s"`,
+				edgeTable, rawTable),
+		}
+	}
+	for _, sql := range stmts {
+		if err := db.Exec(sql).Error; err != nil {
+			return 0, 0, fmt.Errorf("生成网络边失败: %v", err)
+		}
+	}
+	if !progress(0.7, "正在生成节点") {
+		return 0, 0, ctx.Err()
+	}
+
+	start := fmt.Sprintf("round(ST_X(ST_StartPoint(e.geom))::numeric, %d), round(ST_Y(ST_StartPoint(e.geom))::numeric, %d)", networkNodePrecision, networkNodePrecision)
+	end := fmt.Sprintf("round(ST_X(ST_EndPoint(e.geom))::numeric, %d), round(ST_Y(ST_EndPoint(e.geom))::numeric, %d)", networkNodePrecision, networkNodePrecision)
+	stmts = []string{
+		fmt.Sprintf(`UPDATE "%s" SET length = ST_Length(geom::geography)`, edgeTable),
+		fmt.Sprintf(`DELETE FROM "%s" WHERE length = 0`, edgeTable),
+		fmt.Sprintf(`UPDATE "%s" SET
+			cost = CASE WHEN fwd_rate IS NULL OR fwd_rate < 0 THEN -1 ELSE length * fwd_rate END,
+			reverse_cost = CASE WHEN rev_rate IS NULL OR rev_rate < 0 THEN -1 ELSE length * rev_rate END`, edgeTable),
+		fmt.Sprintf(`INSERT INTO "%s" (x, y) SELECT DISTINCT x, y FROM (
+			SELECT %s FROM "%s" e UNION SELECT %s FROM "%s" e) p(x, y)`, nodeTable, start, edgeTable, end, edgeTable),
+		fmt.Sprintf(`UPDATE "%s" SET geom = ST_SetSRID(ST_MakePoint(x::float8, y::float8), 4326)`, nodeTable),
+		fmt.Sprintf(`CREATE INDEX ON "%s" (x, y)`, nodeTable),
+		fmt.Sprintf(`UPDATE "%s" e SET source = n.id FROM "%s" n WHERE (n.x, n.y) = (%s)`, edgeTable, nodeTable, start),
+		fmt.Sprintf(`UPDATE "%s" e SET target = n.id FROM "%s" n WHERE (n.x, n.y) = (%s)`, edgeTable, nodeTable, end),
+		fmt.Sprintf(`CREATE INDEX ON "%s" USING GIST (geom)`, edgeTable),
+		fmt.Sprintf(`CREATE INDEX ON "%s" USING GIST (geom)`, nodeTable),
+	}
+	for i, sql := range stmts {
+		if err := db.Exec(sql).Error; err != nil {
+			return 0, 0, fmt.Errorf("生成网络节点失败: %v", err)
+		}
+		if !progress(0.7+0.3*float64(i+1)/float64(len(stmts)), "正在生成节点") {
+			return 0, 0, ctx.Err()
+		}
+	}
+
+	var edges, nodes int64
+	db.Table(edgeTable).Count(&edges)
+	db.Table(nodeTable).Count(&nodes)
+	if edges == 0 {
+		return 0, 0, fmt.Errorf("源图层中没有可用的线")
+	}
+	InvalidateNetworkGraph(ds.ID)
+	return edges, nodes, nil
+}
+
+// NetworkEdge 网络中的一条边
+type NetworkEdge struct {
+	ID          int64
+	Layer       string
+	SrcID       int64
+	Source      int64
+	Target      int64
+	Length      float64
+	Cost        float64 // 沿数字化方向的成本，小于0不可通行
+	ReverseCost float64
+	Line        orb.LineString
+}
+
+type networkArc struct {
+	edge    *NetworkEdge
+	to      int64
+	cost    float64
+	length  float64
+	forward bool
+}
+
+// NetworkGraph 读入内存的网络
+type NetworkGraph struct {
+	Dataset models.NetworkDataset
+	Edges   map[int64]*NetworkEdge
+	arcs    map[int64][]networkArc
+	turns   map[[2]int64]float64 // (驶出边, 驶入边) -> 附加成本
+}
+
+var networkGraphs = struct {
+	graphs map[int64]*NetworkGraph
+	mutex  sync.Mutex
+}{graphs: make(map[int64]*NetworkGraph)}
+
+// InvalidateNetworkGraph 网络重建或转向规则修改后清除缓存
+func InvalidateNetworkGraph(id int64) {
+	networkGraphs.mutex.Lock()
+	defer networkGraphs.mutex.Unlock()
+	delete(networkGraphs.graphs, id)
+}
+
+// LoadNetworkGraph 读取网络数据集，已读取的网络使用缓存
+func LoadNetworkGraph(db *gorm.DB, id int64) (*NetworkGraph, error) {
+	var ds models.NetworkDataset
+	if err := db.Where("id = ?", id).First(&ds).Error; err != nil {
+		return nil, fmt.Errorf("网络数据集不存在")
+	}
+	if ds.Status != "已构建" {
+		return nil, fmt.Errorf("网络数据集尚未构建")
+	}
+
+	networkGraphs.mutex.Lock()
+	defer networkGraphs.mutex.Unlock()
+	if g, ok := networkGraphs.graphs[id]; ok && g.Dataset.BuiltAt == ds.BuiltAt {
+		return g, nil
+	}
+
+	var rows []struct {
+		ID          int64
+		Layer       string
+		SrcID       int64
+		Source      int64
+		Target      int64
+		Length      float64
+		Cost        float64
+		ReverseCost float64
+		GeomJSON    string
+	}
+	err := db.Raw(fmt.Sprintf(`SELECT id, layer, src_id, source, target, length, cost, reverse_cost, ST_AsGeoJSON(geom) AS geom_json FROM "%s"`,
+		NetworkEdgeTable(id))).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("读取网络失败: %v", err)
+	}
+
+	g := &NetworkGraph{
+		Dataset: ds,
+		Edges:   make(map[int64]*NetworkEdge, len(rows)),
+		arcs:    make(map[int64][]networkArc),
+		turns:   make(map[[2]int64]float64),
+	}
+	bySource := make(map[string][]*NetworkEdge)
+	for _, row := range rows {
+		geom, err := geojson.UnmarshalGeometry([]byte(row.GeomJSON))
+		if err != nil {
+			continue
+		}
+		line, ok := geom.Geometry().(orb.LineString)
+		if !ok {
+			continue
+		}
+		e := &NetworkEdge{
+			ID: row.ID, Layer: row.Layer, SrcID: row.SrcID, Source: row.Source, Target: row.Target,
+			Length: row.Length, Cost: row.Cost, ReverseCost: row.ReverseCost, Line: line,
+		}
+		g.Edges[e.ID] = e
+		if e.Cost >= 0 {
+			g.arcs[e.Source] = append(g.arcs[e.Source], networkArc{edge: e, to: e.Target, cost: e.Cost, length: e.Length, forward: true})
+		}
+		if e.ReverseCost >= 0 {
+			g.arcs[e.Target] = append(g.arcs[e.Target], networkArc{edge: e, to: e.Source, cost: e.ReverseCost, length: e.Length, forward: false})
+		}
+		key := fmt.Sprintf("%s/%d", e.Layer, e.SrcID)
+		bySource[key] = append(bySource[key], e)
+	}
+
+	// 转向规则作用于两个要素在相接节点处的边
+	var turns []models.NetworkTurn
+	db.Where("network_id = ?", id).Find(&turns)
+	for _, turn := range turns {
+		for _, from := range bySource[fmt.Sprintf("%s/%d", turn.FromLayer, turn.FromID)] {
+			for _, to := range bySource[fmt.Sprintf("%s/%d", turn.ToLayer, turn.ToID)] {
+				if from.Source == to.Source || from.Source == to.Target || from.Target == to.Source || from.Target == to.Target {
+					g.turns[[2]int64{from.ID, to.ID}] = turn.Penalty
+				}
+			}
+		}
+	}
+	networkGraphs.graphs[id] = g
+	return g, nil
+}
+
+// NetworkLocation 停靠点、设施点等在网络上的位置
+type NetworkLocation struct {
+	Input    orb.Point
+	Edge     *NetworkEdge
+	Fraction float64 // 在边上的位置，0为边的起点
+	Snapped  orb.Point
+	Distance float64 // 输入点到网络的距离(米)
+}
+
+// Locate 将输入点吸附到最近的可通行边上，距离超过tolerance(米)时报错
+func (g *NetworkGraph) Locate(db *gorm.DB, points [][]float64, tolerance float64) ([]NetworkLocation, error) {
+	locations := make([]NetworkLocation, 0, len(points))
+	for i, p := range points {
+		if len(p) < 2 {
+			return nil, fmt.Errorf("第%d个点坐标无效", i+1)
+		}
+		var row struct {
+			ID       int64
+			Fraction float64
+			Distance float64
+		}
+		err := db.Raw(fmt.Sprintf(`
+			SELECT e.id, ST_LineLocatePoint(e.geom, q.p) AS fraction, ST_Distance(e.geom::geography, q.p::geography) AS distance
+			FROM "%s" e, (SELECT ST_SetSRID(ST_MakePoint(?, ?), 4326) AS p) q
+			WHERE e.cost >= 0 OR e.reverse_cost >= 0
+			ORDER BY e.geom <-> q.p LIMIT 1`, NetworkEdgeTable(g.Dataset.ID)), p[0], p[1]).Scan(&row).Error
+		if err != nil {
+			return nil, err
+		}
+		edge, ok := g.Edges[row.ID]
+		if !ok {
+			return nil, fmt.Errorf("第%d个点附近没有网络", i+1)
+		}
+		if tolerance > 0 && row.Distance > tolerance {
+			return nil, fmt.Errorf("第%d个点距离网络%.0f米，超出搜索范围", i+1, row.Distance)
+		}
+		locations = append(locations, NetworkLocation{
+			Input:    orb.Point{p[0], p[1]},
+			Edge:     edge,
+			Fraction: row.Fraction,
+			Snapped:  lineInterpolate(edge.Line, row.Fraction),
+			Distance: row.Distance,
+		})
+	}
+	return locations, nil
+}
+
+// lineFractions 各顶点处的累计长度比例(平面)，与ST_LineLocatePoint一致
+func lineFractions(ls orb.LineString) []float64 {
+	fractions := make([]float64, len(ls))
+	total := 0.0
+	for i := 1; i < len(ls); i++ {
+		total += math.Hypot(ls[i][0]-ls[i-1][0], ls[i][1]-ls[i-1][1])
+		fractions[i] = total
+	}
+	for i := range fractions {
+		if total > 0 {
+			fractions[i] /= total
+		}
+	}
+	return fractions
+}
+
+func lineInterpolate(ls orb.LineString, f float64) orb.Point {
+	fractions := lineFractions(ls)
+	for i := 1; i < len(ls); i++ {
+		if f <= fractions[i] {
+			seg := fractions[i] - fractions[i-1]
+			if seg == 0 {
+				return ls[i]
+			}
+			t := (f - fractions[i-1]) / seg
+			return orb.Point{ls[i-1][0] + t*(ls[i][0]-ls[i-1][0]), ls[i-1][1] + t*(ls[i][1]-ls[i-1][1])}
+		}
+	}
+	return ls[len(ls)-1]
+}
+
+// lineSubstring 截取线上from到to(比例)之间的部分
+func lineSubstring(ls orb.LineString, from, to float64) orb.LineString {
+	from, to = math.Max(0, from), math.Min(1, to)
+	if to <= from {
+		return nil
+	}
+	fractions := lineFractions(ls)
+	result := orb.LineString{lineInterpolate(ls, from)}
+	for i := 1; i < len(ls)-1; i++ {
+		if fractions[i] > from && fractions[i] < to {
+			result = append(result, ls[i])
+		}
+	}
+	return append(result, lineInterpolate(ls, to))
+}
+
+// appendLine 拼接路径，去掉相接处的重复点
+func appendLine(path, part orb.LineString) orb.LineString {
+	for _, p := range part {
+		if len(path) == 0 || path[len(path)-1] != p {
+			path = append(path, p)
+		}
+	}
+	return path
+}
+
+type networkState struct {
+	node int64
+	edge int64 // 到达节点时经过的边，没有转向规则时为0
+}
+
+type networkLabel struct {
+	cost, length float64
+	prev         networkState
+	arc          *networkArc // 为空时为起始标记
+	origin       int
+	forward      bool // 起始标记沿边的方向
+}
+
+// NetworkTree 从一个或多个起点出发的最短路径树
+type NetworkTree struct {
+	g       *NetworkGraph
+	origins []NetworkLocation
+	labels  map[networkState]*networkLabel
+	settled map[networkState]bool
+	byNode  map[int64][]networkState
+}
+
+type networkItem struct {
+	state networkState
+	cost  float64
+}
+
+type networkQueue []networkItem
+
+func (q networkQueue) Len() int            { return len(q) }
+func (q networkQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q networkQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *networkQueue) Push(x interface{}) { *q = append(*q, x.(networkItem)) }
+func (q *networkQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func (g *NetworkGraph) stateEdge(e *NetworkEdge) int64 {
+	if len(g.turns) == 0 {
+		return 0
+	}
+	return e.ID
+}
+
+// turnCost 从in边进入out边的附加成本，禁止时返回false
+func (g *NetworkGraph) turnCost(in int64, out *NetworkEdge) (float64, bool) {
+	if len(g.turns) == 0 || in == 0 {
+		return 0, true
+	}
+	if in == out.ID && !g.Dataset.AllowUTurn {
+		return 0, false
+	}
+	penalty, ok := g.turns[[2]int64{in, out.ID}]
+	if ok && penalty < 0 {
+		return 0, false
+	}
+	return penalty, true
+}
+
+// Search 计算最短路径树，成本超过limit的部分不再搜索；
+// 指定targets且没有转向规则时，所有目标所在边的端点确定后提前结束
+func (g *NetworkGraph) Search(origins []NetworkLocation, limit float64, targets []NetworkLocation) *NetworkTree {
+	t := &NetworkTree{
+		g:       g,
+		origins: origins,
+		labels:  make(map[networkState]*networkLabel),
+		settled: make(map[networkState]bool),
+		byNode:  make(map[int64][]networkState),
+	}
+	queue := &networkQueue{}
+	push := func(state networkState, label *networkLabel) {
+		if old, ok := t.labels[state]; ok && old.cost <= label.cost {
+			return
+		}
+		if _, ok := t.labels[state]; !ok {
+			t.byNode[state.node] = append(t.byNode[state.node], state)
+		}
+		t.labels[state] = label
+		heap.Push(queue, networkItem{state: state, cost: label.cost})
+	}
+	for i, o := range origins {
+		e := o.Edge
+		if e.Cost >= 0 && (1-o.Fraction)*e.Cost <= limit {
+			push(networkState{e.Target, g.stateEdge(e)}, &networkLabel{cost: (1 - o.Fraction) * e.Cost, length: (1 - o.Fraction) * e.Length, origin: i, forward: true})
+		}
+		if e.ReverseCost >= 0 && o.Fraction*e.ReverseCost <= limit {
+			push(networkState{e.Source, g.stateEdge(e)}, &networkLabel{cost: o.Fraction * e.ReverseCost, length: o.Fraction * e.Length, origin: i})
+		}
+	}
+
+	pending := map[int64]bool{}
+	if len(targets) > 0 && len(g.turns) == 0 {
+		for _, target := range targets {
+			pending[target.Edge.Source] = true
+			pending[target.Edge.Target] = true
+		}
+	}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(networkItem)
+		if t.settled[item.state] {
+			continue
+		}
+		label := t.labels[item.state]
+		if item.cost > label.cost {
+			continue
+		}
+		t.settled[item.state] = true
+		if len(pending) > 0 {
+			delete(pending, item.state.node)
+			if len(pending) == 0 {
+				break
+			}
+		}
+		for i := range g.arcs[item.state.node] {
+			arc := &g.arcs[item.state.node][i]
+			penalty, ok := g.turnCost(item.state.edge, arc.edge)
+			if !ok {
+				continue
+			}
+			cost := label.cost + arc.cost + penalty
+			if cost > limit {
+				continue
+			}
+			next := networkState{arc.to, g.stateEdge(arc.edge)}
+			if t.settled[next] {
+				continue
+			}
+			push(next, &networkLabel{cost: cost, length: label.length + arc.length, prev: item.state, arc: arc, origin: label.origin})
+		}
+	}
+	return t
+}
+
+// NetworkArrival 到达某一位置的方式
+type NetworkArrival struct {
+	Cost, Length float64
+	Origin       int
+	state        networkState
+	viaSource    bool // 经边的起点沿正向到达，否则经终点逆向到达
+	direct       bool // 与起点在同一条边上直接到达
+}
+
+// Reach 到达指定位置的最小成本
+func (t *NetworkTree) Reach(loc NetworkLocation) (NetworkArrival, bool) {
+	e := loc.Edge
+	best := NetworkArrival{Cost: math.Inf(1)}
+	consider := func(node int64, viaSource bool, partCost, partLength float64) {
+		for _, state := range t.byNode[node] {
+			if !t.settled[state] {
+				continue
+			}
+			penalty, ok := t.g.turnCost(state.edge, e)
+			if !ok {
+				continue
+			}
+			label := t.labels[state]
+			if cost := label.cost + penalty + partCost; cost < best.Cost {
+				best = NetworkArrival{Cost: cost, Length: label.length + partLength, Origin: label.origin, state: state, viaSource: viaSource}
+			}
+		}
+	}
+	if e.Cost >= 0 {
+		consider(e.Source, true, loc.Fraction*e.Cost, loc.Fraction*e.Length)
+	}
+	if e.ReverseCost >= 0 {
+		consider(e.Target, false, (1-loc.Fraction)*e.ReverseCost, (1-loc.Fraction)*e.Length)
+	}
+	for i, o := range t.origins {
+		if o.Edge.ID != e.ID {
+			continue
+		}
+		delta := loc.Fraction - o.Fraction
+		var cost float64
+		switch {
+		case delta >= 0 && e.Cost >= 0:
+			cost = delta * e.Cost
+		case delta <= 0 && e.ReverseCost >= 0:
+			cost = -delta * e.ReverseCost
+		default:
+			continue
+		}
+		if cost < best.Cost {
+			best = NetworkArrival{Cost: cost, Length: math.Abs(delta) * e.Length, Origin: i, direct: true, viaSource: delta >= 0}
+		}
+	}
+	return best, !math.IsInf(best.Cost, 1)
+}
+
+// Path 到达位置的路径几何
+func (t *NetworkTree) Path(a NetworkArrival, loc NetworkLocation) orb.LineString {
+	e := loc.Edge
+	if a.direct {
+		o := t.origins[a.Origin]
+		if a.viaSource {
+			return lineSubstring(e.Line, o.Fraction, loc.Fraction)
+		}
+		return reverseLineString(lineSubstring(e.Line, loc.Fraction, o.Fraction))
+	}
+
+	var parts []orb.LineString
+	if a.viaSource {
+		parts = append(parts, lineSubstring(e.Line, 0, loc.Fraction))
+	} else {
+		parts = append(parts, reverseLineString(lineSubstring(e.Line, loc.Fraction, 1)))
+	}
+	for state := a.state; ; {
+		label := t.labels[state]
+		if label.arc == nil {
+			o := t.origins[label.origin]
+			if label.forward {
+				parts = append(parts, lineSubstring(o.Edge.Line, o.Fraction, 1))
+			} else {
+				parts = append(parts, reverseLineString(lineSubstring(o.Edge.Line, 0, o.Fraction)))
+			}
+			break
+		}
+		if label.arc.forward {
+			parts = append(parts, label.arc.edge.Line)
+		} else {
+			parts = append(parts, reverseLineString(label.arc.edge.Line))
+		}
+		state = label.prev
+	}
+	var path orb.LineString
+	for i := len(parts) - 1; i >= 0; i-- {
+		path = appendLine(path, parts[i])
+	}
+	return path
+}
+
+// ReachedLines 成本不超过limit的网络部分，边的两端分别按剩余成本截取
+func (t *NetworkTree) ReachedLines(limit float64) orb.MultiLineString {
+	nodeCost := make(map[int64]float64)
+	for state := range t.settled {
+		if c, ok := nodeCost[state.node]; !ok || t.labels[state].cost < c {
+			nodeCost[state.node] = t.labels[state].cost
+		}
+	}
+	portion := func(remain, cost float64) float64 {
+		if cost <= 0 {
+			return 1
+		}
+		return math.Min(1, remain/cost)
+	}
+
+	var lines orb.MultiLineString
+	add := func(ls orb.LineString) {
+		if len(ls) > 1 {
+			lines = append(lines, ls)
+		}
+	}
+	for _, e := range t.g.Edges {
+		from, to := 0.0, 0.0
+		if c, ok := nodeCost[e.Source]; ok && e.Cost >= 0 && c <= limit {
+			from = portion(limit-c, e.Cost)
+		}
+		if c, ok := nodeCost[e.Target]; ok && e.ReverseCost >= 0 && c <= limit {
+			to = portion(limit-c, e.ReverseCost)
+		}
+		switch {
+		case from+to >= 1:
+			add(e.Line)
+		default:
+			if from > 0 {
+				add(lineSubstring(e.Line, 0, from))
+			}
+			if to > 0 {
+				add(lineSubstring(e.Line, 1-to, 1))
+			}
+		}
+	}
+	for _, o := range t.origins {
+		e := o.Edge
+		if e.Cost >= 0 {
+			add(lineSubstring(e.Line, o.Fraction, o.Fraction+portion(limit, e.Cost)))
+		}
+		if e.ReverseCost >= 0 {
+			add(lineSubstring(e.Line, o.Fraction-portion(limit, e.ReverseCost), o.Fraction))
+		}
+	}
+	return lines
+}
+
+// ServiceAreaPolygon 由可达的网络线生成服务区面
+func ServiceAreaPolygon(db *gorm.DB, lines orb.MultiLineString, concavity float64) (orb.Geometry, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(geojson.NewGeometry(lines))
+	if err != nil {
+		return nil, err
+	}
+	var polygon string
+	err = db.Raw(`
+		SELECT ST_AsGeoJSON(CASE WHEN ST_Dimension(h) < 2 THEN ST_Buffer(h::geography, 10)::geometry ELSE h END)
+		FROM (SELECT ST_ConcaveHull(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), ?) AS h) s`,
+		string(data), concavity).Scan(&polygon).Error
+	if err != nil {
+		return nil, err
+	}
+	geom, err := geojson.UnmarshalGeometry([]byte(polygon))
+	if err != nil {
+		return nil, err
+	}
+	return geom.Geometry(), nil
+}
+
+// NetworkLeg 路径中相邻两个停靠点之间的一段
+type NetworkLeg struct {
+	From, To     int
+	Cost, Length float64
+	Line         orb.LineString
+}
+
+// Route 依次经过各停靠点的最短路径
+func (g *NetworkGraph) Route(stops []NetworkLocation) ([]NetworkLeg, error) {
+	if len(stops) < 2 {
+		return nil, fmt.Errorf("至少需要两个停靠点")
+	}
+	legs := make([]NetworkLeg, 0, len(stops)-1)
+	for i := 0; i+1 < len(stops); i++ {
+		tree := g.Search(stops[i:i+1], math.Inf(1), stops[i+1:i+2])
+		arrival, ok := tree.Reach(stops[i+1])
+		if !ok {
+			return nil, fmt.Errorf("第%d个停靠点到第%d个停靠点之间没有可通行的路径", i+1, i+2)
+		}
+		legs = append(legs, NetworkLeg{From: i, To: i + 1, Cost: arrival.Cost, Length: arrival.Length, Line: tree.Path(arrival, stops[i+1])})
+	}
+	return legs, nil
+}
+
+// NetworkFacilityRoute 事件点与设施之间的路径
+type NetworkFacilityRoute struct {
+	Incident, Facility int
+	Rank               int
+	Cost, Length       float64
+	Line               orb.LineString
+}
+
+// ClosestFacilities 每个事件点成本最小的count个设施，toFacility为true时从事件点前往设施，否则从设施前往事件点
+func (g *NetworkGraph) ClosestFacilities(ctx context.Context, incidents, facilities []NetworkLocation, count int, cutoff float64, toFacility bool) ([][]NetworkFacilityRoute, error) {
+	results := make([][]NetworkFacilityRoute, len(incidents))
+	if toFacility {
+		for i := range incidents {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			tree := g.Search(incidents[i:i+1], cutoff, facilities)
+			for j, f := range facilities {
+				if a, ok := tree.Reach(f); ok && a.Cost <= cutoff {
+					results[i] = append(results[i], NetworkFacilityRoute{Incident: i, Facility: j, Cost: a.Cost, Length: a.Length, Line: tree.Path(a, f)})
+				}
+			}
+		}
+	} else {
+		for j := range facilities {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			tree := g.Search(facilities[j:j+1], cutoff, incidents)
+			for i, inc := range incidents {
+				if a, ok := tree.Reach(inc); ok && a.Cost <= cutoff {
+					results[i] = append(results[i], NetworkFacilityRoute{Incident: i, Facility: j, Cost: a.Cost, Length: a.Length, Line: tree.Path(a, inc)})
+				}
+			}
+		}
+	}
+	for i := range results {
+		sort.SliceStable(results[i], func(a, b int) bool { return results[i][a].Cost < results[i][b].Cost })
+		if count > 0 && len(results[i]) > count {
+			results[i] = results[i][:count]
+		}
+		for k := range results[i] {
+			results[i][k].Rank = k + 1
+		}
+	}
+	return results, nil
+}
+
+// NetworkODCost OD成本矩阵中的一项
+type NetworkODCost struct {
+	Cost   float64 `json:"cost"`
+	Length float64 `json:"length"`
+}
+
+// ODMatrix 起点到终点的成本矩阵，不可达或超过cutoff时为nil
+func (g *NetworkGraph) ODMatrix(ctx context.Context, origins, destinations []NetworkLocation, cutoff float64) ([][]*NetworkODCost, error) {
+	matrix := make([][]*NetworkODCost, len(origins))
+	for i := range origins {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tree := g.Search(origins[i:i+1], cutoff, destinations)
+		matrix[i] = make([]*NetworkODCost, len(destinations))
+		for j, d := range destinations {
+			if a, ok := tree.Reach(d); ok && a.Cost <= cutoff {
+				matrix[i][j] = &NetworkODCost{Cost: a.Cost, Length: a.Length}
+			}
+		}
+	}
+	return matrix, nil
+}
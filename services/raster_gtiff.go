@@ -0,0 +1,116 @@
+package services
+
+/*
+#cgo windows CFLAGS: -IC:/OSGeo4W/include -IC:/OSGeo4W/include/gdal -Wno-unused-result
+#cgo windows LDFLAGS: -LC:/OSGeo4W/lib -lgdal_i -lstdc++ -static-libgcc -static-libstdc++
+#cgo linux CFLAGS: -I/usr/include/gdal -Wno-unused-result
+#cgo linux LDFLAGS: -L/usr/lib -lgdal -lstdc++
+#include <stdlib.h>
+#include <gdal.h>
+#include <ogr_srs_api.h>
+#include <cpl_conv.h>
+
+static GDALDatasetH createGTiff(const char* path, int width, int height, int bands, GDALDataType type) {
+	GDALAllRegister();
+	GDALDriverH driver = GDALGetDriverByName("GTiff");
+	if (driver == NULL) {
+		return NULL;
+	}
+	return GDALCreate(driver, path, width, height, bands, type, NULL);
+}
+
+static int setDatasetEPSG(GDALDatasetH ds, int epsg) {
+	OGRSpatialReferenceH srs = OSRNewSpatialReference(NULL);
+	if (OSRImportFromEPSG(srs, epsg) != OGRERR_NONE) {
+		OSRDestroySpatialReference(srs);
+		return 0;
+	}
+	char* wkt = NULL;
+	OSRExportToWkt(srs, &wkt);
+	CPLErr err = GDALSetProjection(ds, wkt);
+	CPLFree(wkt);
+	OSRDestroySpatialReference(srs);
+	return err == CE_None;
+}
+
+// writePixelInterleaved 写入按行排列、波段交错的像素
+static CPLErr writePixelInterleaved(GDALDatasetH ds, void* data, int width, int height, int bands, GDALDataType type, int typeSize) {
+	return GDALDatasetRasterIO(ds, GF_Write, 0, 0, width, height, data, width, height, type, bands, NULL,
+		(GSpacing)typeSize * bands, (GSpacing)typeSize * bands * width, typeSize);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// RasterGrid 待写出的分析结果栅格，Float32与Uint8二选一，像素按行排列、波段交错
+type RasterGrid struct {
+	Width, Height int
+	Bands         int
+	Float32       []float32
+	Uint8         []uint8
+	GeoTransform  [6]float64 // 左上角X、像元宽、0、左上角Y、0、像元高(负值)
+	EPSG          int
+	NoData        *float64
+}
+
+// WriteGTiff 通过GDAL的GTiff驱动写出栅格，4波段Byte栅格按RGBA设置波段颜色解释
+func WriteGTiff(path string, r RasterGrid) error {
+	if r.Width <= 0 || r.Height <= 0 || r.Bands <= 0 {
+		return fmt.Errorf("栅格尺寸无效")
+	}
+	pixels := r.Width * r.Height * r.Bands
+	var data unsafe.Pointer
+	var dataType C.GDALDataType
+	var typeSize int
+	switch {
+	case r.Float32 != nil:
+		if len(r.Float32) != pixels {
+			return fmt.Errorf("像素数量与栅格尺寸不一致")
+		}
+		data, dataType, typeSize = unsafe.Pointer(&r.Float32[0]), C.GDT_Float32, 4
+	case r.Uint8 != nil:
+		if len(r.Uint8) != pixels {
+			return fmt.Errorf("像素数量与栅格尺寸不一致")
+		}
+		data, dataType, typeSize = unsafe.Pointer(&r.Uint8[0]), C.GDT_Byte, 1
+	default:
+		return fmt.Errorf("没有像素数据")
+	}
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	ds := C.createGTiff(cPath, C.int(r.Width), C.int(r.Height), C.int(r.Bands), dataType)
+	if ds == nil {
+		return fmt.Errorf("创建GeoTIFF失败: %s", path)
+	}
+	defer C.GDALClose(ds)
+
+	gt := make([]C.double, 6)
+	for i, v := range r.GeoTransform {
+		gt[i] = C.double(v)
+	}
+	if C.GDALSetGeoTransform(ds, &gt[0]) != C.CE_None {
+		return fmt.Errorf("设置地理变换失败")
+	}
+	if r.EPSG != 0 && C.setDatasetEPSG(ds, C.int(r.EPSG)) == 0 {
+		return fmt.Errorf("设置坐标系EPSG:%d失败", r.EPSG)
+	}
+	for b := 1; b <= r.Bands; b++ {
+		band := C.GDALGetRasterBand(ds, C.int(b))
+		if r.NoData != nil {
+			C.GDALSetRasterNoDataValue(band, C.double(*r.NoData))
+		}
+		if r.Bands == 4 && dataType == C.GDT_Byte {
+			interp := []C.GDALColorInterp{C.GCI_RedBand, C.GCI_GreenBand, C.GCI_BlueBand, C.GCI_AlphaBand}[b-1]
+			C.GDALSetRasterColorInterpretation(band, interp)
+		}
+	}
+	if C.writePixelInterleaved(ds, data, C.int(r.Width), C.int(r.Height), C.int(r.Bands), dataType, C.int(typeSize)) != C.CE_None {
+		return fmt.Errorf("写入像素失败")
+	}
+	return nil
+}
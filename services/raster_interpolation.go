@@ -0,0 +1,557 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/Tin"
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/google/uuid"
+)
+
+// ==================== 空间插值 ====================
+// 点图层样本插值为连续表面，输出Float32 GeoTIFF(EPSG:4326)，
+// 距离在样本范围中心纬度处换算为米计算，任务状态记录在RasterRecord中
+
+// interpolationNoData 插值结果的无效值
+const interpolationNoData = -9999
+
+// interpolationMaxSize 输出栅格的最大行列数
+const interpolationMaxSize = 4000
+
+// interpolationMetersPerDegree 一度纬度的近似长度
+const interpolationMetersPerDegree = 111320.0
+
+// InterpolationSource 插值样本和输出栅格参数
+type InterpolationSource struct {
+	TableName  string    `json:"table_name" binding:"required"`
+	ValueField string    `json:"value_field"` // 样本值字段，为空时取点的Z值
+	Filter     string    `json:"filter"`      // 筛选条件，使用表达式语法
+	CellSize   float64   `json:"cell_size"`   // 像元大小(米)，为0时取范围短边的1/250
+	Extent     []float64 `json:"extent"`      // 输出范围 minLon,minLat,maxLon,maxLat，默认为样本范围
+	OutputName string    `json:"output_name"`
+}
+
+// IDWRequest 反距离权重插值请求
+type IDWRequest struct {
+	InterpolationSource
+	Power        float64 `json:"power"`         // 距离幂，默认2
+	SearchRadius float64 `json:"search_radius"` // 搜索半径(米)，为0时不限
+	Neighbors    int     `json:"neighbors"`     // 参与插值的最近样本数，默认12
+}
+
+// KrigingRequest 普通克里金插值请求
+type KrigingRequest struct {
+	InterpolationSource
+	Model          string `json:"model"`           // auto / spherical / exponential / gaussian
+	Lags           int    `json:"lags"`            // 经验半变异函数的分组数，默认12
+	Neighbors      int    `json:"neighbors"`       // 参与插值的最近样本数，默认16
+	OutputVariance bool   `json:"output_variance"` // 同时输出克里金方差栅格
+}
+
+// NaturalNeighborRequest 自然邻域插值请求
+type NaturalNeighborRequest struct {
+	InterpolationSource
+}
+
+// InterpolationResponse 插值任务响应
+type InterpolationResponse struct {
+	TaskID       string `json:"task_id"`
+	OutputPath   string `json:"output_path"`
+	VariancePath string `json:"variance_path,omitempty"`
+	ReportPath   string `json:"report_path,omitempty"`
+	Message      string `json:"message"`
+}
+
+// interpSample 样本点，X、Y为相对输出范围左下角的米坐标
+type interpSample struct {
+	X, Y, V float64
+}
+
+// interpGrid 输出栅格，像元中心的米坐标由行列号计算
+type interpGrid struct {
+	Width, Height  int
+	MinLon, MaxLat float64
+	DLon, DLat     float64
+	CellSize       float64
+	HeightM        float64
+}
+
+// center 像元中心相对左下角的米坐标
+func (g interpGrid) center(row, col int) (float64, float64) {
+	return (float64(col) + 0.5) * g.CellSize, g.HeightM - (float64(row)+0.5)*g.CellSize
+}
+
+// validateInterpolationSource 检查图层和字段
+func validateInterpolationSource(src *InterpolationSource) error {
+	src.TableName = strings.ToLower(src.TableName)
+	src.ValueField = strings.ToLower(src.ValueField)
+	var columns []string
+	models.DB.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = ?`, src.TableName).Scan(&columns)
+	hasGeom, hasField := false, src.ValueField == ""
+	for _, col := range columns {
+		hasGeom = hasGeom || col == "geom"
+		hasField = hasField || col == src.ValueField
+	}
+	if !hasGeom {
+		return fmt.Errorf("图层不存在或没有几何字段: %s", src.TableName)
+	}
+	if !hasField {
+		return fmt.Errorf("字段不存在: %s", src.ValueField)
+	}
+	if src.CellSize < 0 {
+		return fmt.Errorf("像元大小不能为负数")
+	}
+	if len(src.Extent) != 0 && (len(src.Extent) != 4 || src.Extent[0] >= src.Extent[2] || src.Extent[1] >= src.Extent[3]) {
+		return fmt.Errorf("extent应为 minLon,minLat,maxLon,maxLat")
+	}
+	return nil
+}
+
+// loadInterpolationSamples 读取样本并确定输出栅格，坐标相同的样本取平均值
+func loadInterpolationSamples(src InterpolationSource) ([]interpSample, interpGrid, error) {
+	var grid interpGrid
+	where := "t.geom IS NOT NULL"
+	if strings.TrimSpace(src.Filter) != "" {
+		filter, err := methods.CompileFilterExpression(models.DB, src.TableName, src.Filter)
+		if err != nil {
+			return nil, grid, fmt.Errorf("筛选条件错误: %v", err)
+		}
+		where += " AND " + filter.SQL
+	}
+	value := "ST_Z(p)"
+	if src.ValueField != "" {
+		value = fmt.Sprintf(`(t."%s")::float8`, src.ValueField)
+	}
+	var rows []struct{ X, Y, V float64 }
+	sql := fmt.Sprintf(`SELECT ST_X(p) AS x, ST_Y(p) AS y, v FROM (
+			SELECT p, %s AS v FROM (SELECT t.*, (ST_Dump(ST_PointOnSurface(t.geom))).geom AS p FROM "%s" AS t WHERE %s) t
+		) s WHERE v IS NOT NULL AND v <> 'NaN'`, value, src.TableName, where)
+	if src.ValueField == "" {
+		sql = fmt.Sprintf(`SELECT ST_X(p) AS x, ST_Y(p) AS y, ST_Z(p) AS v FROM (SELECT (ST_Dump(t.geom)).geom AS p FROM "%s" AS t WHERE %s) s WHERE ST_Z(p) IS NOT NULL`,
+			src.TableName, where)
+	}
+	if err := models.DB.Raw(sql).Scan(&rows).Error; err != nil {
+		return nil, grid, err
+	}
+
+	type key struct{ x, y float64 }
+	merged := make(map[key][]float64)
+	var order []key
+	for _, r := range rows {
+		k := key{r.X, r.Y}
+		if _, ok := merged[k]; !ok {
+			order = append(order, k)
+		}
+		merged[k] = append(merged[k], r.V)
+	}
+	if len(order) < 3 {
+		return nil, grid, fmt.Errorf("有效样本不足3个")
+	}
+
+	minX, minY, maxX, maxY := order[0].x, order[0].y, order[0].x, order[0].y
+	for _, k := range order {
+		minX, maxX = math.Min(minX, k.x), math.Max(maxX, k.x)
+		minY, maxY = math.Min(minY, k.y), math.Max(maxY, k.y)
+	}
+	if len(src.Extent) == 4 {
+		minX, minY, maxX, maxY = src.Extent[0], src.Extent[1], src.Extent[2], src.Extent[3]
+	}
+	if maxX <= minX || maxY <= minY {
+		return nil, grid, fmt.Errorf("样本范围无效，请指定extent")
+	}
+	my := interpolationMetersPerDegree
+	mx := interpolationMetersPerDegree * math.Cos((minY+maxY)/2*math.Pi/180)
+	cellSize := src.CellSize
+	if cellSize == 0 {
+		cellSize = math.Min((maxX-minX)*mx, (maxY-minY)*my) / 250
+	}
+	grid = interpGrid{
+		Width:    int(math.Ceil((maxX - minX) * mx / cellSize)),
+		Height:   int(math.Ceil((maxY - minY) * my / cellSize)),
+		MinLon:   minX,
+		DLon:     cellSize / mx,
+		DLat:     cellSize / my,
+		CellSize: cellSize,
+	}
+	if grid.Width > interpolationMaxSize || grid.Height > interpolationMaxSize {
+		return nil, grid, fmt.Errorf("输出栅格为 %d×%d，超过 %d×%d，请增大像元大小", grid.Width, grid.Height, interpolationMaxSize, interpolationMaxSize)
+	}
+	grid.HeightM = float64(grid.Height) * cellSize
+	grid.MaxLat = minY + float64(grid.Height)*grid.DLat
+
+	samples := make([]interpSample, len(order))
+	for i, k := range order {
+		var sum float64
+		for _, v := range merged[k] {
+			sum += v
+		}
+		samples[i] = interpSample{X: (k.x - minX) * mx, Y: (k.y - (grid.MaxLat - grid.HeightM/my)) * my, V: sum / float64(len(merged[k]))}
+	}
+	return samples, grid, nil
+}
+
+// writeInterpolationRaster 写出插值结果，NaN写为无效值
+func writeInterpolationRaster(path string, grid interpGrid, values []float64) error {
+	data := make([]float32, len(values))
+	for i, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			data[i] = interpolationNoData
+		} else {
+			data[i] = float32(v)
+		}
+	}
+	nodata := float64(interpolationNoData)
+	return WriteGTiff(path, RasterGrid{
+		Width:        grid.Width,
+		Height:       grid.Height,
+		Bands:        1,
+		Float32:      data,
+		GeoTransform: [6]float64{grid.MinLon, grid.DLon, 0, grid.MaxLat, 0, -grid.DLat},
+		EPSG:         4326,
+		NoData:       &nodata,
+	})
+}
+
+// ==================== 样本邻域查询 ====================
+
+// sampleIndex 按方格分桶的样本索引，用于查找最近的若干样本
+type sampleIndex struct {
+	samples []interpSample
+	size    float64
+	cols    int
+	rows    int
+	buckets [][]int
+}
+
+func newSampleIndex(samples []interpSample, grid interpGrid) *sampleIndex {
+	widthM := float64(grid.Width) * grid.CellSize
+	// 平均每桶约2个样本
+	size := math.Max(math.Sqrt(widthM*grid.HeightM/float64(len(samples))*2), grid.CellSize)
+	idx := &sampleIndex{samples: samples, size: size}
+	idx.cols = int(widthM/size) + 1
+	idx.rows = int(grid.HeightM/size) + 1
+	idx.buckets = make([][]int, idx.cols*idx.rows)
+	for i, s := range samples {
+		c, r := idx.bucket(s.X, s.Y)
+		idx.buckets[r*idx.cols+c] = append(idx.buckets[r*idx.cols+c], i)
+	}
+	return idx
+}
+
+func (idx *sampleIndex) bucket(x, y float64) (int, int) {
+	c := int(math.Floor(x / idx.size))
+	r := int(math.Floor(y / idx.size))
+	c = int(math.Max(0, math.Min(float64(idx.cols-1), float64(c))))
+	r = int(math.Max(0, math.Min(float64(idx.rows-1), float64(r))))
+	return c, r
+}
+
+type sampleNeighbor struct {
+	index int
+	dist  float64
+}
+
+// nearest 距离(x, y)最近的k个样本，radius大于0时只取半径内的样本，结果按距离排序
+func (idx *sampleIndex) nearest(x, y float64, k int, radius float64) []sampleNeighbor {
+	c0, r0 := idx.bucket(x, y)
+	var found []sampleNeighbor
+	maxRing := idx.cols + idx.rows
+	for ring := 0; ring <= maxRing; ring++ {
+		for r := r0 - ring; r <= r0+ring; r++ {
+			if r < 0 || r >= idx.rows {
+				continue
+			}
+			for c := c0 - ring; c <= c0+ring; c++ {
+				if c < 0 || c >= idx.cols || (r != r0-ring && r != r0+ring && c != c0-ring && c != c0+ring) {
+					continue
+				}
+				for _, i := range idx.buckets[r*idx.cols+c] {
+					d := math.Hypot(idx.samples[i].X-x, idx.samples[i].Y-y)
+					if radius <= 0 || d <= radius {
+						found = append(found, sampleNeighbor{index: i, dist: d})
+					}
+				}
+			}
+		}
+		// 当前环以外的样本距离不小于 ring*size
+		reach := float64(ring) * idx.size
+		if radius > 0 && reach > radius {
+			break
+		}
+		if len(found) >= k {
+			sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+			if found[k-1].dist <= reach {
+				return found[:k]
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	if len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+// ==================== 反距离权重 ====================
+
+func idwInterpolate(samples []interpSample, grid interpGrid, req IDWRequest) []float64 {
+	idx := newSampleIndex(samples, grid)
+	values := make([]float64, grid.Width*grid.Height)
+	for row := 0; row < grid.Height; row++ {
+		for col := 0; col < grid.Width; col++ {
+			x, y := grid.center(row, col)
+			neighbors := idx.nearest(x, y, req.Neighbors, req.SearchRadius)
+			if len(neighbors) == 0 {
+				values[row*grid.Width+col] = math.NaN()
+				continue
+			}
+			if neighbors[0].dist < 1e-9 {
+				values[row*grid.Width+col] = samples[neighbors[0].index].V
+				continue
+			}
+			var sw, sv float64
+			for _, n := range neighbors {
+				w := 1 / math.Pow(n.dist, req.Power)
+				sw += w
+				sv += w * samples[n.index].V
+			}
+			values[row*grid.Width+col] = sv / sw
+		}
+	}
+	return values
+}
+
+// ==================== 自然邻域 ====================
+
+// circumcenter 三角形外接圆圆心，三点共线时ok为false
+func circumcenter(ax, ay, bx, by, cx, cy float64) (float64, float64, bool) {
+	d := 2 * (ax*(by-cy) + bx*(cy-ay) + cx*(ay-by))
+	if math.Abs(d) < 1e-12 {
+		return 0, 0, false
+	}
+	a2, b2, c2 := ax*ax+ay*ay, bx*bx+by*by, cx*cx+cy*cy
+	return (a2*(by-cy) + b2*(cy-ay) + c2*(ay-by)) / d, (a2*(cx-bx) + b2*(ax-cx) + c2*(bx-ax)) / d, true
+}
+
+func signedArea(ax, ay, bx, by, cx, cy float64) float64 {
+	return ((bx-ax)*(cy-ay) - (cx-ax)*(by-ay)) / 2
+}
+
+// nnTriangle 逆时针排列的三角形及外接圆
+type nnTriangle struct {
+	p      [3]*Tin.Point3D
+	cx, cy float64
+	r2     float64
+}
+
+// naturalNeighborInterpolate 基于Tin包的Delaunay三角网计算Sibson自然邻域权重(Watson算法)，凸包以外为无效值
+func naturalNeighborInterpolate(samples []interpSample, grid interpGrid) []float64 {
+	points := make([]*Tin.Point3D, len(samples))
+	for i, s := range samples {
+		points[i] = &Tin.Point3D{X: s.X, Y: s.Y, Z: s.V, ID: i}
+	}
+	tin := Tin.CreateTIN3D(&Tin.Polygon2D{}, points)
+
+	var triangles []nnTriangle
+	for _, t := range tin.Triangles {
+		p := [3]*Tin.Point3D{t.P1, t.P2, t.P3}
+		if signedArea(p[0].X, p[0].Y, p[1].X, p[1].Y, p[2].X, p[2].Y) < 0 {
+			p[1], p[2] = p[2], p[1]
+		}
+		cx, cy, ok := circumcenter(p[0].X, p[0].Y, p[1].X, p[1].Y, p[2].X, p[2].Y)
+		if !ok {
+			continue
+		}
+		triangles = append(triangles, nnTriangle{p: p, cx: cx, cy: cy, r2: (p[0].X-cx)*(p[0].X-cx) + (p[0].Y-cy)*(p[0].Y-cy)})
+	}
+
+	// 按外接圆外包框将三角形登记到与栅格对齐的分桶中
+	const bucketCells = 8
+	bucketSize := grid.CellSize * bucketCells
+	bcols, brows := grid.Width/bucketCells+1, grid.Height/bucketCells+1
+	buckets := make([][]int, bcols*brows)
+	clamp := func(v float64, n int) int {
+		return int(math.Max(0, math.Min(float64(n-1), math.Floor(v/bucketSize))))
+	}
+	for i, t := range triangles {
+		r := math.Sqrt(t.r2)
+		for br := clamp(t.cy-r, brows); br <= clamp(t.cy+r, brows); br++ {
+			for bc := clamp(t.cx-r, bcols); bc <= clamp(t.cx+r, bcols); bc++ {
+				buckets[br*bcols+bc] = append(buckets[br*bcols+bc], i)
+			}
+		}
+	}
+
+	values := make([]float64, grid.Width*grid.Height)
+	weights := make(map[*Tin.Point3D]float64)
+	for row := 0; row < grid.Height; row++ {
+		for col := 0; col < grid.Width; col++ {
+			x, y := grid.center(row, col)
+			value := math.NaN()
+			for k := range weights {
+				delete(weights, k)
+			}
+			inside, exact := false, false
+			for _, i := range buckets[clamp(y, brows)*bcols+clamp(x, bcols)] {
+				t := triangles[i]
+				if (x-t.cx)*(x-t.cx)+(y-t.cy)*(y-t.cy) >= t.r2 {
+					continue
+				}
+				for j := 0; j < 3; j++ {
+					if math.Hypot(t.p[j].X-x, t.p[j].Y-y) < 1e-9 {
+						value, exact = t.p[j].Z, true
+					}
+				}
+				if exact {
+					break
+				}
+				if !inside {
+					inside = signedArea(t.p[0].X, t.p[0].Y, t.p[1].X, t.p[1].Y, x, y) >= 0 &&
+						signedArea(t.p[1].X, t.p[1].Y, t.p[2].X, t.p[2].Y, x, y) >= 0 &&
+						signedArea(t.p[2].X, t.p[2].Y, t.p[0].X, t.p[0].Y, x, y) >= 0
+				}
+				// 插入点后各顶点被占用的Voronoi面积：外接圆圆心与新三角形外接圆圆心构成的有向面积之和
+				var g [3][2]float64
+				valid := true
+				for j := 0; j < 3; j++ {
+					a, b := t.p[(j+1)%3], t.p[(j+2)%3]
+					gx, gy, ok := circumcenter(x, y, a.X, a.Y, b.X, b.Y)
+					if !ok {
+						valid = false
+						break
+					}
+					g[j] = [2]float64{gx, gy}
+				}
+				if !valid {
+					continue
+				}
+				for j := 0; j < 3; j++ {
+					// 顶点j两侧的边(j-1, j)、(j, j+1)对应的新圆心分别为g[j+1]、g[j+2]
+					prev, next := g[(j+1)%3], g[(j+2)%3]
+					weights[t.p[j]] += signedArea(t.cx, t.cy, prev[0], prev[1], next[0], next[1])
+				}
+			}
+			if !exact && inside {
+				var sw, sv float64
+				for p, w := range weights {
+					if w > 0 {
+						sw += w
+						sv += w * p.Z
+					}
+				}
+				if sw > 0 {
+					value = sv / sw
+				}
+			}
+			values[row*grid.Width+col] = value
+		}
+	}
+	return values
+}
+
+// ==================== 任务 ====================
+
+// startInterpolationTask 登记插值任务并在后台执行，run返回各输出文件的写入结果
+func (s *RasterService) startInterpolationTask(src InterpolationSource, typeName, defaultName string, req interface{}, withVariance, withReport bool,
+	run func(samples []interpSample, grid interpGrid, outputPath, variancePath, reportPath string) error) (*InterpolationResponse, error) {
+	if err := validateInterpolationSource(&src); err != nil {
+		return nil, err
+	}
+	taskID := uuid.New().String()
+	outputPath, err := s.prepareOutputPath(taskID, src.OutputName, "GTiff", defaultName)
+	if err != nil {
+		return nil, err
+	}
+	resp := &InterpolationResponse{TaskID: taskID, OutputPath: outputPath, Message: "插值任务已提交"}
+	base := strings.TrimSuffix(outputPath, ".tif")
+	if withVariance {
+		resp.VariancePath = base + "_variance.tif"
+	}
+	if withReport {
+		resp.ReportPath = base + "_variogram.json"
+	}
+	argsJSON, _ := json.Marshal(req)
+	if err := s.createTaskRecord(taskID, src.TableName, outputPath, typeName, argsJSON); err != nil {
+		return nil, err
+	}
+	go s.executeWithRecover(taskID, func() error {
+		samples, grid, err := loadInterpolationSamples(src)
+		if err != nil {
+			return err
+		}
+		return run(samples, grid, outputPath, resp.VariancePath, resp.ReportPath)
+	})
+	return resp, nil
+}
+
+// StartIDWTask 启动反距离权重插值任务
+func (s *RasterService) StartIDWTask(req *IDWRequest) (*InterpolationResponse, error) {
+	if req.Power <= 0 {
+		req.Power = 2
+	}
+	if req.Neighbors <= 0 {
+		req.Neighbors = 12
+	}
+	if req.SearchRadius < 0 {
+		return nil, fmt.Errorf("搜索半径不能为负数")
+	}
+	params := *req
+	return s.startInterpolationTask(req.InterpolationSource, "IDW插值", "idw", req, false, false,
+		func(samples []interpSample, grid interpGrid, outputPath, _, _ string) error {
+			return writeInterpolationRaster(outputPath, grid, idwInterpolate(samples, grid, params))
+		})
+}
+
+// StartNaturalNeighborTask 启动自然邻域插值任务
+func (s *RasterService) StartNaturalNeighborTask(req *NaturalNeighborRequest) (*InterpolationResponse, error) {
+	return s.startInterpolationTask(req.InterpolationSource, "自然邻域插值", "natural_neighbor", req, false, false,
+		func(samples []interpSample, grid interpGrid, outputPath, _, _ string) error {
+			if len(samples) > 10000 {
+				return fmt.Errorf("自然邻域插值的样本数不能超过10000")
+			}
+			return writeInterpolationRaster(outputPath, grid, naturalNeighborInterpolate(samples, grid))
+		})
+}
+
+// StartKrigingTask 启动普通克里金插值任务，半变异函数拟合结果写入报告文件
+func (s *RasterService) StartKrigingTask(req *KrigingRequest) (*InterpolationResponse, error) {
+	if req.Model == "" {
+		req.Model = "auto"
+	}
+	if req.Model != "auto" && variogramModels[req.Model] == nil {
+		return nil, fmt.Errorf("model只能为auto、spherical、exponential或gaussian")
+	}
+	if req.Lags <= 0 {
+		req.Lags = 12
+	}
+	if req.Neighbors <= 0 {
+		req.Neighbors = 16
+	}
+	params := *req
+	return s.startInterpolationTask(req.InterpolationSource, "克里金插值", "kriging", req, req.OutputVariance, true,
+		func(samples []interpSample, grid interpGrid, outputPath, variancePath, reportPath string) error {
+			report, err := fitVariogram(samples, params.Model, params.Lags)
+			if err != nil {
+				return err
+			}
+			report.CrossValidation = krigingCrossValidate(samples, grid, report.Fitted, params.Neighbors)
+			reportJSON, _ := json.MarshalIndent(report, "", "  ")
+			if err := os.WriteFile(reportPath, reportJSON, 0644); err != nil {
+				return err
+			}
+			values, variances := krigingInterpolate(samples, grid, report.Fitted, params.Neighbors)
+			if err := writeInterpolationRaster(outputPath, grid, values); err != nil {
+				return err
+			}
+			if variancePath != "" {
+				return writeInterpolationRaster(variancePath, grid, variances)
+			}
+			return nil
+		})
+}
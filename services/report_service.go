@@ -166,8 +166,8 @@ func (db *DocumentBuilder) AddAnalysisParagraphConfig(config models.AnalysisPara
 
 	// 应用文本样式
 	db.applyTextStyle(run, config.Style)
-	text := methods.GeoIntersect(*db.Geo, config.SourceLayer, config.Attributes)
-	area := methods.CalculateArea(db.Geo.Features[0])
+	// 总面积与各地类面积按图层的用地分析规则同口径计算
+	text, area := methods.GeoIntersectArea(*db.Geo, config.SourceLayer, config.Attributes)
 	var Text string
 	if len(text) == 1 && text[0].Dlmc == "未占用" {
 		Text = "未涉及"
@@ -244,7 +244,6 @@ func attToCN(att string, en string) string {
 // AddTable 插入表格
 func (db *DocumentBuilder) AddTable(config models.TableConfig) error {
 
-	area := methods.CalculateArea(db.Geo.Features[0])
 	// 添加表格标题（如果有）
 	if config.Caption != "" {
 		captionPara := db.doc.AddParagraph()
@@ -254,7 +253,7 @@ func (db *DocumentBuilder) AddTable(config models.TableConfig) error {
 		captionRun.AddText(config.Caption)
 	}
 
-	text := methods.GeoIntersect(*db.Geo, config.SourceLayer, config.Attributes)
+	text, area := methods.GeoIntersectArea(*db.Geo, config.SourceLayer, config.Attributes)
 
 	//插入表格
 	table := db.doc.AddTable()
@@ -348,6 +347,38 @@ func (db *DocumentBuilder) AddTable(config models.TableConfig) error {
 	return nil
 }
 
+// AddDataTable 插入普通数据表格，首行为表头，lastBold为true时末行(合计)加粗
+func (db *DocumentBuilder) AddDataTable(caption string, headers []string, rows [][]string, lastBold bool) *DocumentBuilder {
+	if caption != "" {
+		captionPara := db.doc.AddParagraph()
+		captionPara.Properties().SetAlignment(wml.ST_JcCenter)
+		captionRun := captionPara.AddRun()
+		captionRun.Properties().SetBold(true)
+		captionRun.AddText(caption)
+	}
+	table := db.doc.AddTable()
+	table.Properties().SetAlignment(wml.ST_JcTableCenter)
+	table.Properties().SetWidthPercent(100)
+	borders := table.Properties().Borders()
+	borders.SetAll(wml.ST_BorderSingle, color.Auto, 1*measurement.Point)
+	addRow := func(cells []string, bold bool) {
+		row := table.AddRow()
+		for _, text := range cells {
+			Paragraph := row.AddCell().AddParagraph()
+			Paragraph.Properties().SetAlignment(wml.ST_JcCenter)
+			run := Paragraph.AddRun()
+			run.Properties().SetBold(bold)
+			run.Properties().SetSize(12)
+			run.AddText(text)
+		}
+	}
+	addRow(headers, true)
+	for i, cells := range rows {
+		addRow(cells, lastBold && i == len(rows)-1)
+	}
+	return db
+}
+
 // AddImage 插入图片
 func (db *DocumentBuilder) AddImage(config models.ImageConfig, Img []byte) error {
 	// 添加图片标题（如果有）
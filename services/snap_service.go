@@ -0,0 +1,386 @@
+// services/snap_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 捕捉类型及优先级，数值越小越优先
+var snapTypePriority = map[string]int{
+	"endpoint":      0,
+	"vertex":        1,
+	"intersection":  2,
+	"midpoint":      3,
+	"perpendicular": 4,
+	"edge":          5,
+}
+
+const (
+	snapGridSize        = 64   // 空间索引网格行列数
+	snapMaxLayerFeature = 5000 // 单个图层最多载入的要素数
+	metersPerDegree     = 111320.0
+)
+
+type snapVertex struct {
+	point     orb.Point
+	layer     string
+	featureID int64
+	endpoint  bool
+}
+
+type snapSegment struct {
+	a, b      orb.Point
+	layer     string
+	featureID int64
+}
+
+// SnapIndex 视野范围内的捕捉索引（均匀网格）
+type SnapIndex struct {
+	Layers    []string
+	Bound     orb.Bound
+	Truncated bool
+	BuiltAt   time.Time
+	Version   int64 // 构建时相关图层的最新编辑记录ID
+
+	layerTypes  map[string]map[string]bool
+	vertices    []snapVertex
+	segments    []snapSegment
+	cellW       float64
+	cellH       float64
+	vertexGrid  map[[2]int][]int
+	segmentGrid map[[2]int][]int
+}
+
+type SnapService struct{}
+
+func NewSnapService() *SnapService {
+	return &SnapService{}
+}
+
+// SnapLayerVersion 相关图层的最新编辑记录ID，用于判断索引是否过期
+func (s *SnapService) SnapLayerVersion(ctx context.Context, layers []string) int64 {
+	var version int64
+	models.DB.WithContext(ctx).Raw(`SELECT COALESCE(MAX(id), 0) FROM geo_record WHERE table_name IN ?`, layers).Scan(&version)
+	return version
+}
+
+// BuildSnapIndex 载入范围内各图层的几何并建立网格索引
+func (s *SnapService) BuildSnapIndex(ctx context.Context, layers []models.SnapLayer, bound orb.Bound) (*SnapIndex, error) {
+	if bound.IsEmpty() || bound.Max[0] <= bound.Min[0] || bound.Max[1] <= bound.Min[1] {
+		return nil, fmt.Errorf("invalid extent")
+	}
+	idx := &SnapIndex{
+		Bound:       bound,
+		BuiltAt:     time.Now(),
+		layerTypes:  make(map[string]map[string]bool),
+		cellW:       (bound.Max[0] - bound.Min[0]) / snapGridSize,
+		cellH:       (bound.Max[1] - bound.Min[1]) / snapGridSize,
+		vertexGrid:  make(map[[2]int][]int),
+		segmentGrid: make(map[[2]int][]int),
+	}
+
+	for _, layer := range layers {
+		if layer.LayerName == "" {
+			continue
+		}
+		idx.Layers = append(idx.Layers, layer.LayerName)
+		if len(layer.Types) > 0 {
+			types := make(map[string]bool)
+			for _, t := range layer.Types {
+				types[t] = true
+			}
+			idx.layerTypes[layer.LayerName] = types
+		}
+
+		// 按扩展后的范围裁剪，避免大面要素整体载入
+		query := fmt.Sprintf(`
+			WITH box AS (SELECT ST_MakeEnvelope(?, ?, ?, ?, 4326) AS geom)
+			SELECT t.id,
+				ST_AsGeoJSON(CASE WHEN ST_Dimension(t.geom) = 0 THEN t.geom ELSE ST_ClipByBox2D(t.geom, box.geom) END, 12)::text AS geom_json
+			FROM "%s" t, box
+			WHERE t.geom && box.geom
+			LIMIT %d`, layer.LayerName, snapMaxLayerFeature+1)
+		var rows []struct {
+			ID       int64  `gorm:"column:id"`
+			GeomJSON string `gorm:"column:geom_json"`
+		}
+		if err := models.DB.WithContext(ctx).Raw(query, bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]).Scan(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load layer %s: %w", layer.LayerName, err)
+		}
+		if len(rows) > snapMaxLayerFeature {
+			rows = rows[:snapMaxLayerFeature]
+			idx.Truncated = true
+		}
+		for _, row := range rows {
+			if row.GeomJSON == "" {
+				continue
+			}
+			var geom geojson.Geometry
+			if err := json.Unmarshal([]byte(row.GeomJSON), &geom); err != nil || geom.Geometry() == nil {
+				continue
+			}
+			idx.addGeometry(geom.Geometry(), layer.LayerName, row.ID)
+		}
+	}
+	return idx, nil
+}
+
+func (idx *SnapIndex) cellOf(p orb.Point) [2]int {
+	return [2]int{int(math.Floor((p[0] - idx.Bound.Min[0]) / idx.cellW)), int(math.Floor((p[1] - idx.Bound.Min[1]) / idx.cellH))}
+}
+
+func (idx *SnapIndex) addVertex(p orb.Point, layer string, featureID int64, endpoint bool) {
+	idx.vertices = append(idx.vertices, snapVertex{point: p, layer: layer, featureID: featureID, endpoint: endpoint})
+	cell := idx.cellOf(p)
+	idx.vertexGrid[cell] = append(idx.vertexGrid[cell], len(idx.vertices)-1)
+}
+
+func (idx *SnapIndex) addLine(ls []orb.Point, layer string, featureID int64, closed bool) {
+	for i, p := range ls {
+		endpoint := !closed && (i == 0 || i == len(ls)-1)
+		// 闭合环的终点与起点重复
+		if closed && i == len(ls)-1 {
+			continue
+		}
+		idx.addVertex(p, layer, featureID, endpoint)
+	}
+	for i := 0; i+1 < len(ls); i++ {
+		a, b := ls[i], ls[i+1]
+		if a == b {
+			continue
+		}
+		idx.segments = append(idx.segments, snapSegment{a: a, b: b, layer: layer, featureID: featureID})
+		segID := len(idx.segments) - 1
+		c1, c2 := idx.cellOf(a), idx.cellOf(b)
+		for x := minInt(c1[0], c2[0]); x <= maxInt(c1[0], c2[0]); x++ {
+			for y := minInt(c1[1], c2[1]); y <= maxInt(c1[1], c2[1]); y++ {
+				idx.segmentGrid[[2]int{x, y}] = append(idx.segmentGrid[[2]int{x, y}], segID)
+			}
+		}
+	}
+}
+
+func (idx *SnapIndex) addGeometry(geom orb.Geometry, layer string, featureID int64) {
+	switch g := geom.(type) {
+	case orb.Point:
+		idx.addVertex(g, layer, featureID, true)
+	case orb.MultiPoint:
+		for _, p := range g {
+			idx.addVertex(p, layer, featureID, true)
+		}
+	case orb.LineString:
+		idx.addLine(g, layer, featureID, false)
+	case orb.MultiLineString:
+		for _, ls := range g {
+			idx.addLine(ls, layer, featureID, false)
+		}
+	case orb.Ring:
+		idx.addLine(g, layer, featureID, true)
+	case orb.Polygon:
+		for _, ring := range g {
+			idx.addLine(ring, layer, featureID, true)
+		}
+	case orb.MultiPolygon:
+		for _, poly := range g {
+			for _, ring := range poly {
+				idx.addLine(ring, layer, featureID, true)
+			}
+		}
+	case orb.Collection:
+		for _, sub := range g {
+			idx.addGeometry(sub, layer, featureID)
+		}
+	}
+}
+
+// Contains 索引范围是否覆盖指定范围
+func (idx *SnapIndex) Contains(bound orb.Bound) bool {
+	return idx.Bound.Contains(bound.Min) && idx.Bound.Contains(bound.Max)
+}
+
+// PixelToleranceMeters 按Web墨卡托分辨率将像素容差换算为米
+func PixelToleranceMeters(pixels, zoom, tileSize, lat float64) float64 {
+	if pixels <= 0 {
+		pixels = 10
+	}
+	if tileSize <= 0 {
+		tileSize = 256
+	}
+	resolution := 2 * math.Pi * 6378137 * math.Cos(lat*math.Pi/180) / (tileSize * math.Pow(2, zoom))
+	return pixels * resolution
+}
+
+// localDistance 小范围内的平面近似距离（米）
+func localDistance(p1, p2 orb.Point) float64 {
+	cosLat := math.Cos((p1[1] + p2[1]) / 2 * math.Pi / 180)
+	dx := (p2[0] - p1[0]) * metersPerDegree * cosLat
+	dy := (p2[1] - p1[1]) * metersPerDegree
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// projectOnLine 点在直线上的投影及参数t（t在[0,1]内时位于线段上）
+func projectOnLine(p, a, b orb.Point) (orb.Point, float64) {
+	cosLat := math.Cos(p[1] * math.Pi / 180)
+	dx := (b[0] - a[0]) * cosLat
+	dy := b[1] - a[1]
+	if dx == 0 && dy == 0 {
+		return a, 0
+	}
+	t := ((p[0]-a[0])*cosLat*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	return orb.Point{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}, t
+}
+
+// segmentIntersection 两条线段的交点
+func segmentIntersection(s1, s2 snapSegment) (orb.Point, bool) {
+	d1x, d1y := s1.b[0]-s1.a[0], s1.b[1]-s1.a[1]
+	d2x, d2y := s2.b[0]-s2.a[0], s2.b[1]-s2.a[1]
+	denom := d1x*d2y - d1y*d2x
+	if denom == 0 {
+		return orb.Point{}, false
+	}
+	t := ((s2.a[0]-s1.a[0])*d2y - (s2.a[1]-s1.a[1])*d2x) / denom
+	u := ((s2.a[0]-s1.a[0])*d1y - (s2.a[1]-s1.a[1])*d1x) / denom
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return orb.Point{}, false
+	}
+	return orb.Point{s1.a[0] + t*d1x, s1.a[1] + t*d1y}, true
+}
+
+func (idx *SnapIndex) allowed(layer string, snapType string, types map[string]bool) bool {
+	if len(types) > 0 && !types[snapType] {
+		return false
+	}
+	if layerTypes, ok := idx.layerTypes[layer]; ok {
+		return layerTypes[snapType]
+	}
+	return true
+}
+
+// Snap 查找容差范围内的捕捉候选，按类型优先级和距离排序
+func (idx *SnapIndex) Snap(point orb.Point, toleranceMeters float64, types []string, from *orb.Point) []models.SnapCandidate {
+	typeSet := make(map[string]bool)
+	for _, t := range types {
+		typeSet[t] = true
+	}
+	tolLat := toleranceMeters / metersPerDegree
+	tolLon := tolLat / math.Max(math.Cos(point[1]*math.Pi/180), 0.01)
+	minCell := idx.cellOf(orb.Point{point[0] - tolLon, point[1] - tolLat})
+	maxCell := idx.cellOf(orb.Point{point[0] + tolLon, point[1] + tolLat})
+
+	var candidates []models.SnapCandidate
+	add := func(snapType string, p orb.Point, layer string, featureID int64, seg *snapSegment) {
+		dist := localDistance(point, p)
+		if dist > toleranceMeters {
+			return
+		}
+		c := models.SnapCandidate{Type: snapType, Point: []float64{p[0], p[1]}, Distance: dist, LayerName: layer, FeatureID: featureID}
+		if seg != nil {
+			c.Segment = [][]float64{{seg.a[0], seg.a[1]}, {seg.b[0], seg.b[1]}}
+		}
+		candidates = append(candidates, c)
+	}
+
+	seenVertex := make(map[int]bool)
+	seenSegment := make(map[int]bool)
+	var nearSegments []int
+	for x := minCell[0]; x <= maxCell[0]; x++ {
+		for y := minCell[1]; y <= maxCell[1]; y++ {
+			cell := [2]int{x, y}
+			for _, i := range idx.vertexGrid[cell] {
+				if seenVertex[i] {
+					continue
+				}
+				seenVertex[i] = true
+				v := idx.vertices[i]
+				if v.endpoint && idx.allowed(v.layer, "endpoint", typeSet) {
+					add("endpoint", v.point, v.layer, v.featureID, nil)
+				} else if idx.allowed(v.layer, "vertex", typeSet) {
+					add("vertex", v.point, v.layer, v.featureID, nil)
+				}
+			}
+			for _, i := range idx.segmentGrid[cell] {
+				if !seenSegment[i] {
+					seenSegment[i] = true
+					nearSegments = append(nearSegments, i)
+				}
+			}
+		}
+	}
+
+	for _, i := range nearSegments {
+		seg := idx.segments[i]
+		if idx.allowed(seg.layer, "midpoint", typeSet) {
+			add("midpoint", orb.Point{(seg.a[0] + seg.b[0]) / 2, (seg.a[1] + seg.b[1]) / 2}, seg.layer, seg.featureID, &seg)
+		}
+		if idx.allowed(seg.layer, "edge", typeSet) {
+			p, t := projectOnLine(point, seg.a, seg.b)
+			if t >= 0 && t <= 1 {
+				add("edge", p, seg.layer, seg.featureID, &seg)
+			}
+		}
+		if from != nil && idx.allowed(seg.layer, "perpendicular", typeSet) {
+			p, t := projectOnLine(*from, seg.a, seg.b)
+			if t >= 0 && t <= 1 {
+				add("perpendicular", p, seg.layer, seg.featureID, &seg)
+			}
+		}
+	}
+
+	// 交点：只在光标附近的线段之间计算，线段共用的端点已作为顶点捕捉
+	for m := 0; m < len(nearSegments); m++ {
+		s1 := idx.segments[nearSegments[m]]
+		for n := m + 1; n < len(nearSegments); n++ {
+			s2 := idx.segments[nearSegments[n]]
+			if !idx.allowed(s1.layer, "intersection", typeSet) && !idx.allowed(s2.layer, "intersection", typeSet) {
+				continue
+			}
+			p, ok := segmentIntersection(s1, s2)
+			if !ok || p == s1.a || p == s1.b || p == s2.a || p == s2.b {
+				continue
+			}
+			add("intersection", p, s1.layer, s1.featureID, nil)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		pi, pj := snapTypePriority[candidates[i].Type], snapTypePriority[candidates[j].Type]
+		if pi != pj {
+			return pi < pj
+		}
+		return candidates[i].Distance < candidates[j].Distance
+	})
+	return candidates
+}
+
+// SnapIndexKey 图层组合的缓存键
+func SnapIndexKey(layers []models.SnapLayer) string {
+	names := make([]string, 0, len(layers))
+	for _, l := range layers {
+		names = append(names, l.LayerName+":"+strings.Join(l.Types, ","))
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
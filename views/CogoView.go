@@ -0,0 +1,221 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 坐标几何(COGO)：起点坐标+方位角/距离(圆弧)导线生成线面要素
+
+type cogoRequest struct {
+	Start       []float64         `json:"Start"`       // 起点 [x, y]
+	StartSRID   int               `json:"StartSRID"`   // 起点坐标系，0时按坐标值判断经纬度或投影坐标
+	EPSG        int               `json:"EPSG"`        // CGCS2000 3度带投影 4513-4554，0时按起点自动选择
+	Legs        []methods.CogoLeg `json:"Legs"`        // 导线边
+	Close       bool              `json:"Close"`       // 闭合回起点
+	End         []float64         `json:"End"`         // 附合的已知终点，坐标系同起点
+	Adjust      bool              `json:"Adjust"`      // 罗盘仪法则平差
+	MinRatio    float64           `json:"MinRatio"`    // 保存时要求的最低相对闭合差分母，如4000表示1/4000
+	ArcSegments int               `json:"ArcSegments"` // 圆弧每90度加密段数
+	Geometry    string            `json:"Geometry"`    // polygon / line，默认闭合时为面
+	Save        bool              `json:"Save"`        // 是否保存到图层，否则仅预览
+	TableName   string            `json:"TableName"`
+	Username    string
+	BZ          string
+	SessionID   int64
+	Properties  map[string]interface{} `json:"Properties"`
+}
+
+// cgcs2000ZoneEPSG 确定CGCS2000 3度带投影EPSG
+func cgcs2000ZoneEPSG(epsg int, start []float64, srid int) (int, error) {
+	if epsg != 0 {
+		if epsg < 4513 || epsg > 4554 {
+			return 0, fmt.Errorf("EPSG:%d 不是CGCS2000 3度带投影坐标系", epsg)
+		}
+		return epsg, nil
+	}
+	switch {
+	case srid == 4326 || srid == 4490:
+		// 带号 = round(经度/3)，4513对应25带
+		return 4488 + int(math.Round(start[0]/3)), nil
+	case start[0] >= 25000000 && start[0] < 46000000:
+		// 带号前缀的东坐标
+		return 4488 + int(start[0]/1000000), nil
+	}
+	return 0, fmt.Errorf("无法从起点坐标判断投影带，请指定EPSG")
+}
+
+// transformGeometry 使用PostGIS转换坐标系
+func transformGeometry(db *gorm.DB, geom orb.Geometry, from, to int) (orb.Geometry, error) {
+	if from == to {
+		return geom, nil
+	}
+	geomJSON, err := json.Marshal(geojson.NewGeometry(geom))
+	if err != nil {
+		return nil, err
+	}
+	var result string
+	err = db.Raw(`SELECT ST_AsGeoJSON(ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON(?), ?), ?), 10)`,
+		string(geomJSON), from, to).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	if result == "" {
+		return nil, fmt.Errorf("坐标转换失败")
+	}
+	g, err := geojson.UnmarshalGeometry([]byte(result))
+	if err != nil {
+		return nil, err
+	}
+	return g.Geometry(), nil
+}
+
+// CogoTraverse 导线计算：报告闭合差并可平差，保存时走新增要素的编辑记录流程
+func (uc *UserController) CogoTraverse(c *gin.Context) {
+	var req cogoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Start) != 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "起点坐标格式应为 [x, y]"})
+		return
+	}
+	if len(req.End) != 0 && len(req.End) != 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "终点坐标格式应为 [x, y]"})
+		return
+	}
+	geomType := strings.ToLower(req.Geometry)
+	if geomType == "" {
+		geomType = "line"
+		if req.Close && len(req.End) == 0 {
+			geomType = "polygon"
+		}
+	}
+	if geomType == "polygon" && (!req.Close || len(req.End) != 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "生成面要素时导线必须闭合回起点"})
+		return
+	}
+	if geomType != "polygon" && geomType != "line" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geometry 只能为 polygon 或 line"})
+		return
+	}
+	srid := req.StartSRID
+	if srid == 0 {
+		srid = 4326
+		if math.Abs(req.Start[0]) > 180 || math.Abs(req.Start[1]) > 90 {
+			srid = 0
+		}
+	}
+	epsg, err := cgcs2000ZoneEPSG(req.EPSG, req.Start, srid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if srid == 0 {
+		srid = epsg
+	}
+
+	DB := models.DB
+	// 起点、终点转换到投影带
+	toZone := func(p []float64) (orb.Point, error) {
+		g, err := transformGeometry(DB, orb.Point{p[0], p[1]}, srid, epsg)
+		if err != nil {
+			return orb.Point{}, err
+		}
+		pt, ok := g.(orb.Point)
+		if !ok {
+			return orb.Point{}, fmt.Errorf("坐标转换失败")
+		}
+		return pt, nil
+	}
+	start, err := toZone(req.Start)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var end *orb.Point
+	if len(req.End) == 2 {
+		pt, err := toZone(req.End)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		end = &pt
+	}
+
+	result, err := methods.ComputeTraverse(start, req.Legs, end, req.Close, req.Adjust, req.ArcSegments)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var zoneGeom orb.Geometry = result.Path
+	if geomType == "polygon" {
+		if len(result.Path) < 4 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "闭合导线至少需要3条边"})
+			return
+		}
+		zoneGeom = orb.Polygon{orb.Ring(result.Path)}
+	}
+	geom, err := transformGeometry(DB, zoneGeom, epsg, 4326)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	properties := map[string]interface{}{}
+	for k, v := range req.Properties {
+		properties[k] = v
+	}
+	feature := geojson.NewFeature(geom)
+	feature.Properties = properties
+	fc := geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}
+
+	data := gin.H{
+		"epsg":    epsg,
+		"result":  result,
+		"geojson": fc,
+		"saved":   false,
+	}
+	if !req.Save {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": "计算完成", "data": data})
+		return
+	}
+
+	if req.TableName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "保存时需要指定TableName"})
+		return
+	}
+	if req.MinRatio > 0 && result.Misclosure != nil && result.Misclosure.Linear > 1e-9 &&
+		result.Misclosure.Ratio < req.MinRatio {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": fmt.Sprintf("相对闭合差 %s 低于限差 1/%.0f，未保存", result.Misclosure.Precision, req.MinRatio),
+			"data":    data,
+		})
+		return
+	}
+	saved, ok := saveNewGeo(c, DB, geoData{
+		TableName: req.TableName,
+		GeoJson:   fc,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		SessionID: req.SessionID,
+	})
+	if !ok {
+		return
+	}
+	data["geojson"] = saved
+	data["saved"] = true
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功", "data": data})
+}
@@ -874,7 +874,7 @@ func (uc *UserController) UpdateLayer(c *gin.Context) {
 			MSG.MSG = fmt.Sprintf(`%s 在 %s 时间，更新了数据: %s,请及时完成移动端的数据同步`, MSG.UpdatedUser, MSG.Date, MSG.LayerNameCN)
 			DB.Create(&MSG)
 			if aa == true {
-				c.String(http.StatusOK, "ok")
+				respondImportResult(c, DB, "ok", []string{tablename})
 			} else {
 				c.String(http.StatusBadRequest, "矢量更新失败")
 			}
@@ -896,7 +896,7 @@ func (uc *UserController) UpdateLayer(c *gin.Context) {
 			MSG.MSG = fmt.Sprintf(`%s 在 %s 时间，更新了数据: %s,请及时完成移动端的数据同步`, MSG.UpdatedUser, MSG.Date, MSG.LayerNameCN)
 			DB.Create(&MSG)
 			if aa == true {
-				c.String(http.StatusOK, "ok")
+				respondImportResult(c, DB, "ok", []string{tablename})
 			} else {
 				c.String(http.StatusBadRequest, "矢量更新失败")
 			}
@@ -920,7 +920,7 @@ func (uc *UserController) UpdateLayer(c *gin.Context) {
 			MSG.MSG = fmt.Sprintf(`%s 在 %s 时间，更新了数据: %s,请及时完成移动端的数据同步`, MSG.UpdatedUser, MSG.Date, MSG.LayerNameCN)
 			DB.Create(&MSG)
 			if aa == true {
-				c.String(http.StatusOK, "ok")
+				respondImportResult(c, DB, "ok", []string{tablename})
 			} else {
 				c.String(http.StatusBadRequest, "矢量更新失败")
 			}
@@ -941,7 +941,7 @@ func (uc *UserController) UpdateLayer(c *gin.Context) {
 			MSG.MSG = fmt.Sprintf(`%s 在 %s 时间，更新了数据: %s,请及时完成移动端的数据同步`, MSG.UpdatedUser, MSG.Date, MSG.LayerNameCN)
 			DB.Create(&MSG)
 			if aa == true {
-				c.String(http.StatusOK, "ok")
+				respondImportResult(c, DB, "ok", []string{tablename})
 			} else {
 				c.String(http.StatusBadRequest, "矢量更新失败")
 			}
@@ -133,6 +133,7 @@ type geoData struct {
 	Username  string
 	ID        int32
 	BZ        string
+	SessionID int64 // 版本化会话ID，为空时直接编辑线上表
 }
 
 func (uc *UserController) AddGeoToSchema(c *gin.Context) {
@@ -141,29 +142,61 @@ func (uc *UserController) AddGeoToSchema(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	DB := models.DB
+	if fc, ok := saveNewGeo(c, models.DB, jsonData); ok {
+		c.JSON(http.StatusOK, fc)
+	}
+}
+
+// saveNewGeo 新增要素的统一入口：校验编辑锁和字段规则，版本化会话写入会话变更，否则写入线上表并记录历史
+// 校验失败时已写入响应，返回false
+func saveNewGeo(c *gin.Context, DB *gorm.DB, jsonData geoData) (geojson.FeatureCollection, bool) {
+	if len(jsonData.GeoJson.Features) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "geojson中没有要素"})
+		return jsonData.GeoJson, false
+	}
+	if !checkEditLocks(c, DB, jsonData.TableName, jsonData.Username, nil, jsonData.GeoJson.Features[0].Geometry) {
+		return jsonData.GeoJson, false
+	}
+	if !checkAttributeRules(c, DB, jsonData.TableName, jsonData.GeoJson, true) {
+		return jsonData.GeoJson, false
+	}
+	// 版本化会话：写入会话变更，提交前不影响线上表
+	if session, ok := versionSession(DB, jsonData.SessionID, jsonData.TableName); ok {
+		feature, err := versionAddFeature(DB, session, jsonData.GeoJson)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return jsonData.GeoJson, false
+		}
+		return geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}, true
+	}
+	// 创建会话
+	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
+	addFeatureWithRecord(DB, jsonData.TableName, jsonData.GeoJson, jsonData.Username, jsonData.BZ, session)
+	return jsonData.GeoJson, true
+}
+
+// addFeatureWithRecord 新增要素，维护映射表并写入编辑记录，返回新要素ID
+func addFeatureWithRecord(DB *gorm.DB, tableName string, fc geojson.FeatureCollection, username string, bz string, session models.EditSession) int32 {
 	// 获取最大ID
-	sql := fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) AS max_id FROM "%s";`, jsonData.TableName)
+	sql := fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) AS max_id FROM "%s";`, tableName)
 	var maxid int
 	DB.Raw(sql).Scan(&maxid)
 	newID := int32(maxid + 1)
-	jsonData.GeoJson.Features[0].Properties["id"] = newID
-	methods.SavaGeojsonToTable(DB, jsonData.GeoJson, jsonData.TableName)
-	// 创建会话
-	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
+	fc.Features[0].Properties["id"] = newID
+	methods.SavaGeojsonToTable(DB, fc, tableName)
 	// 维护映射表：新增要素，源文件中无对应
-	CreateDerivedMappings(DB, jsonData.TableName, []int32{newID}, 0, session.ID)
+	CreateDerivedMappings(DB, tableName, []int32{newID}, 0, session.ID)
 	// 记录操作
-	NewGeojson, _ := json.Marshal(jsonData.GeoJson)
+	NewGeojson, _ := json.Marshal(fc)
 	outputIDs := MarshalIDs([]int32{newID})
 	result := models.GeoRecord{
-		TableName:  jsonData.TableName,
+		TableName:  tableName,
 		GeoID:      newID,
-		Username:   jsonData.Username,
+		Username:   username,
 		Type:       "要素添加",
 		Date:       timeNowStr(),
 		NewGeojson: NewGeojson,
-		BZ:         jsonData.BZ,
+		BZ:         bz,
 		SessionID:  session.ID,
 		SeqNo:      GetNextSeqNo(DB, session.ID),
 		InputIDs:   MarshalIDs([]int32{}),
@@ -171,9 +204,9 @@ func (uc *UserController) AddGeoToSchema(c *gin.Context) {
 	}
 	DB.Create(&result)
 	// 删除MVT
-	geom := jsonData.GeoJson.Features[0].Geometry
-	pgmvt.DelMVT(DB, jsonData.TableName, geom)
-	c.JSON(http.StatusOK, jsonData.GeoJson)
+	geom := fc.Features[0].Geometry
+	pgmvt.DelMVT(DB, tableName, geom)
+	return newID
 }
 
 // 图层要素删除\
@@ -183,6 +216,7 @@ type delData struct {
 	ID        int32  `json:"ID"`
 	Username  string
 	BZ        string
+	SessionID int64
 }
 
 // 提取并转换 ObjectID 的辅助函数
@@ -234,29 +268,46 @@ func (uc *UserController) DelGeoToSchema(c *gin.Context) {
 		return
 	}
 	DB := models.DB
-	getData := getData{ID: jsonData.ID, TableName: jsonData.TableName}
+	if !checkEditLocks(c, DB, jsonData.TableName, jsonData.Username, []int32{jsonData.ID}) {
+		return
+	}
+	if session, ok := versionSession(DB, jsonData.SessionID, jsonData.TableName); ok {
+		if err := versionDeleteFeature(DB, session, jsonData.ID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "ok")
+		return
+	}
+	// 创建会话
+	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
+	deleteFeatureWithRecord(DB, jsonData.TableName, jsonData.ID, jsonData.Username, jsonData.BZ, session)
+	c.JSON(http.StatusOK, "ok")
+}
+
+// deleteFeatureWithRecord 删除要素，维护映射表并写入编辑记录
+func deleteFeatureWithRecord(DB *gorm.DB, tableName string, id int32, username string, bz string, session models.EditSession) {
+	getData := getData{ID: id, TableName: tableName}
 	geo := GetGeo(getData)
 	OldGeojson, _ := json.Marshal(geo)
-	sql := fmt.Sprintf(`DELETE FROM "%s" WHERE id = %d;`, jsonData.TableName, jsonData.ID)
+	sql := fmt.Sprintf(`DELETE FROM "%s" WHERE id = %d;`, tableName, id)
 	aa := DB.Exec(sql)
 	if err := aa.Error; err != nil {
 		log.Printf("Failed to delete record: %v", err)
 	}
-	// 创建会话
-	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
 	// 维护映射表：标记为已删除
-	MarkMappingDeleted(DB, jsonData.TableName, []int32{jsonData.ID})
+	MarkMappingDeleted(DB, tableName, []int32{id})
 	delObjJSON := DelIDGen(geo)
-	inputIDs := MarshalIDs([]int32{jsonData.ID})
+	inputIDs := MarshalIDs([]int32{id})
 	result := &models.GeoRecord{
-		TableName:    jsonData.TableName,
-		GeoID:        jsonData.ID,
-		Username:     jsonData.Username,
+		TableName:    tableName,
+		GeoID:        id,
+		Username:     username,
 		Type:         "要素删除",
 		Date:         timeNowStr(),
 		DelObjectIDs: delObjJSON,
 		OldGeojson:   OldGeojson,
-		BZ:           jsonData.BZ,
+		BZ:           bz,
 		SessionID:    session.ID,
 		SeqNo:        GetNextSeqNo(DB, session.ID),
 		InputIDs:     inputIDs,
@@ -265,9 +316,10 @@ func (uc *UserController) DelGeoToSchema(c *gin.Context) {
 	if err := DB.Create(&result).Error; err != nil {
 		log.Printf("Failed to create geo record: %v", err)
 	}
-	geom := geo.Features[0].Geometry
-	pgmvt.DelMVT(DB, jsonData.TableName, geom)
-	c.JSON(http.StatusOK, "ok")
+	// 空几何要素没有需要清理的瓦片
+	if geom := geo.Features[0].Geometry; geom != nil {
+		pgmvt.DelMVT(DB, tableName, geom)
+	}
 }
 
 func DelIDGen(geom geojson.FeatureCollection) []byte {
@@ -288,27 +340,52 @@ func (uc *UserController) ChangeGeoToSchema(c *gin.Context) {
 	var jsonData geoData
 	c.BindJSON(&jsonData)
 	DB := models.DB
-	getData := getData{ID: jsonData.ID, TableName: jsonData.TableName}
+	if len(jsonData.GeoJson.Features) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "geojson中没有要素"})
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.TableName, jsonData.Username, []int32{jsonData.ID}, jsonData.GeoJson.Features[0].Geometry) {
+		return
+	}
+	if !checkAttributeRules(c, DB, jsonData.TableName, jsonData.GeoJson, false) {
+		return
+	}
+	if session, ok := versionSession(DB, jsonData.SessionID, jsonData.TableName); ok {
+		feature, err := versionChangeFeature(DB, session, jsonData.ID, jsonData.GeoJson)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}})
+		return
+	}
+	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
+	changeFeatureWithRecord(DB, jsonData.TableName, jsonData.ID, jsonData.GeoJson, jsonData.Username, jsonData.BZ, session)
+	c.JSON(http.StatusOK, jsonData.GeoJson)
+}
+
+// changeFeatureWithRecord 修改要素并写入编辑记录
+func changeFeatureWithRecord(DB *gorm.DB, tableName string, id int32, fc geojson.FeatureCollection, username string, bz string, session models.EditSession) {
+	getData := getData{ID: id, TableName: tableName}
 	geo := GetGeo(getData)
 	OldGeojson, _ := json.MarshalIndent(geo, "", "  ")
-	methods.UpdateGeojsonToTable(DB, jsonData.GeoJson, jsonData.TableName, jsonData.ID)
-	NewGeojson, _ := json.MarshalIndent(jsonData.GeoJson, "", "  ")
+	methods.UpdateGeojsonToTable(DB, fc, tableName, id)
+	NewGeojson, _ := json.MarshalIndent(fc, "", "  ")
 	delObjJSON := DelIDGen(geo)
 
-	session := GetOrCreateSession(DB, jsonData.TableName, jsonData.Username)
-	inputIDs := MarshalIDs([]int32{jsonData.ID})
-	outputIDs := MarshalIDs([]int32{jsonData.ID})
+	inputIDs := MarshalIDs([]int32{id})
+	outputIDs := MarshalIDs([]int32{id})
 
 	result := models.GeoRecord{
-		TableName:    jsonData.TableName,
-		GeoID:        jsonData.ID,
-		Username:     jsonData.Username,
+		TableName:    tableName,
+		GeoID:        id,
+		Username:     username,
 		Type:         "要素修改",
 		Date:         timeNowStr(),
 		OldGeojson:   OldGeojson,
 		NewGeojson:   NewGeojson,
 		DelObjectIDs: delObjJSON,
-		BZ:           jsonData.BZ,
+		BZ:           bz,
 		SessionID:    session.ID,
 		SeqNo:        GetNextSeqNo(DB, session.ID),
 		InputIDs:     inputIDs,
@@ -317,12 +394,13 @@ func (uc *UserController) ChangeGeoToSchema(c *gin.Context) {
 	err := DB.Create(&result).Error
 	if err != nil {
 		log.Printf("Failed to create geo record: %v", err)
+	} else if len(geo.Features) > 0 {
+		recordFeatureAttributeDiffs(DB, result, geo.Features[0].Properties, fc.Features[0].Properties)
 	}
 	geom := geo.Features[0].Geometry
-	geom2 := jsonData.GeoJson.Features[0].Geometry
-	pgmvt.DelMVT(DB, jsonData.TableName, geom)
-	pgmvt.DelMVT(DB, jsonData.TableName, geom2)
-	c.JSON(http.StatusOK, jsonData.GeoJson)
+	geom2 := fc.Features[0].Geometry
+	pgmvt.DelMVT(DB, tableName, geom)
+	pgmvt.DelMVT(DB, tableName, geom2)
 }
 
 // 图层要素查询
@@ -560,6 +638,21 @@ func (uc *UserController) SearchGeoFromSchema(c *gin.Context) {
 	var jsonData searchData
 	c.BindJSON(&jsonData) //将前端geojson转换为geo对象
 	DB := models.DB
+	if jsonData.TableName == mapSheetSearchTable {
+		number, scale, epsg := searchMapSheetRule(jsonData.Rule)
+		sheet, err := locateMapSheet(number, scale, epsg)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"code": 400, "message": err.Error(), "data": nil, "TableName": jsonData.TableName})
+			return
+		}
+		fc, err := mapSheetFeatures(DB, []*methods.MapSheet{sheet})
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"code": 500, "message": err.Error(), "data": nil, "TableName": jsonData.TableName})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": fc, "total": 1, "page": 1, "pageSize": 1, "totalPages": 1, "TableName": jsonData.TableName, "code": 200})
+		return
+	}
 	result, err := queryTable(DB, jsonData)
 	if err != nil {
 		fmt.Println(err.Error())
@@ -631,6 +724,9 @@ type GeoRecordResponse struct {
 	BZ        string
 	ID        int64
 	GeoID     int32
+	SessionID int64
+	SeqNo     int
+	Status    string
 }
 
 func (uc *UserController) GetChangeRecord(c *gin.Context) {
@@ -640,19 +736,7 @@ func (uc *UserController) GetChangeRecord(c *gin.Context) {
 	DB.Where("username = ?", username).Find(&aa)
 
 	// 转换为响应结构体
-	response := make([]GeoRecordResponse, 0) // Initialize as empty slice
-
-	for _, record := range aa {
-		response = append(response, GeoRecordResponse{
-			TableName: record.TableName,
-			Username:  record.Username,
-			Type:      record.Type,
-			Date:      record.Date,
-			BZ:        record.BZ,
-			ID:        record.ID,
-			GeoID:     record.GeoID,
-		})
-	}
+	response := toRecordResponses(aa)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -668,6 +752,7 @@ func (uc *UserController) DelChangeRecord(c *gin.Context) {
 	// 使用 Model 指定表，Where 指定条件，Delete 执行删除
 	// result 包含操作结果信息
 	result := DB.Where("username = ?", username).Delete(&models.GeoRecord{})
+	DB.Where("username = ?", username).Delete(&models.AttributeRecord{})
 
 	// 检查数据库操作是否发生错误
 	if result.Error != nil {
@@ -705,6 +790,7 @@ type SplitData struct {
 	LayerName string                    `json:"LayerName"`
 	Username  string                    `json:"Username"`
 	ID        int32                     `json:"ID"`
+	SessionID int64                     `json:"SessionID"`
 }
 
 func (uc *UserController) SplitFeature(c *gin.Context) {
@@ -714,6 +800,12 @@ func (uc *UserController) SplitFeature(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, []int32{jsonData.ID}) {
+		return
+	}
 	line := Transformer.GetGeometryString(jsonData.Line.Features[0])
 	LayerName := jsonData.LayerName
 	var schema models.MySchema
@@ -845,7 +937,7 @@ func (uc *UserController) SplitFeature(c *gin.Context) {
 	}
 	splitGeojson := GetGeos(getdata2)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "") // Username可从jsonData中取，SplitData需加Username字段
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, []int32{jsonData.ID})
 	CreateDerivedMappings(DB, LayerName, newIDList, jsonData.ID, session.ID)
 	// ========== 记录操作（带InputIDs/OutputIDs） ==========
@@ -876,6 +968,8 @@ type DissolveData struct {
 	LayerName string  `json:"LayerName"`
 	IDs       []int32 `json:"ids"`
 	MainID    int32   `json:"mainId"`
+	Username  string  `json:"Username"`
+	SessionID int64   `json:"SessionID"`
 }
 
 func (uc *UserController) DissolveFeature(c *gin.Context) {
@@ -900,6 +994,12 @@ func (uc *UserController) DissolveFeature(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, jsonData.IDs) {
+		return
+	}
 	LayerName := jsonData.LayerName
 	var schema models.MySchema
 	result := DB.Where("en = ?", LayerName).First(&schema)
@@ -1069,7 +1169,7 @@ func (uc *UserController) DissolveFeature(c *gin.Context) {
 	newGeo := GetGeo(GetPdata)
 	newGeoJson, _ := json.Marshal(newGeo)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, jsonData.IDs)
 	CreateDerivedMappingsMultiParent(DB, LayerName, []int32{dissolveResult.ID}, jsonData.IDs, session.ID)
 	// ========== 记录操作 ==========
@@ -1104,6 +1204,8 @@ func (uc *UserController) DissolveFeature(c *gin.Context) {
 type ExplodeData struct {
 	LayerName string  `json:"LayerName"`
 	IDs       []int32 `json:"ids"`
+	Username  string  `json:"Username"`
+	SessionID int64   `json:"SessionID"`
 }
 
 func (uc *UserController) ExplodeFeature(c *gin.Context) {
@@ -1117,6 +1219,12 @@ func (uc *UserController) ExplodeFeature(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, jsonData.IDs) {
+		return
+	}
 	LayerName := jsonData.LayerName
 	var schema models.MySchema
 	result := DB.Where("en = ?", LayerName).First(&schema)
@@ -1284,7 +1392,7 @@ func (uc *UserController) ExplodeFeature(c *gin.Context) {
 	}
 	explodedGeojson := GetGeos(getdata2)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, jsonData.IDs)
 	// 为每个新要素创建映射，关联到对应的原父要素
 	// 由于打散可能涉及多个原要素，需要建立正确的父子关系
@@ -1339,6 +1447,8 @@ type DonutData struct {
 	LayerName string                    `json:"LayerName"`
 	ID        int32                     `json:"ID"`
 	Donut     geojson.FeatureCollection `json:"Donut"`
+	Username  string                    `json:"Username"`
+	SessionID int64                     `json:"SessionID"`
 }
 
 // 要素环岛构造
@@ -1349,6 +1459,12 @@ func (uc *UserController) DonutBuilder(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, []int32{jsonData.ID}) {
+		return
+	}
 	donut := Transformer.GetGeometryString(jsonData.Donut.Features[0])
 	LayerName := jsonData.LayerName
 	var schema models.MySchema
@@ -1500,7 +1616,7 @@ func (uc *UserController) DonutBuilder(c *gin.Context) {
 	}
 	donutGeojson := GetGeos(getdata2)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, []int32{jsonData.ID})
 	CreateDerivedMappings(DB, LayerName, newIDList, jsonData.ID, session.ID)
 	// ========== 记录操作 ==========
@@ -1531,6 +1647,8 @@ type AggregatorData struct {
 	LayerName string  `json:"LayerName"`
 	IDs       []int32 `json:"ids"`
 	MainID    int32   `json:"mainId"`
+	Username  string  `json:"Username"`
+	SessionID int64   `json:"SessionID"`
 }
 
 func (uc *UserController) AggregatorFeature(c *gin.Context) {
@@ -1555,6 +1673,12 @@ func (uc *UserController) AggregatorFeature(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, jsonData.IDs) {
+		return
+	}
 	LayerName := jsonData.LayerName
 	var schema models.MySchema
 	result := DB.Where("en = ?", LayerName).First(&schema)
@@ -1725,7 +1849,7 @@ func (uc *UserController) AggregatorFeature(c *gin.Context) {
 	newGeo := GetGeo(GetPdata)
 	newGeoJson, _ := json.Marshal(newGeo)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, jsonData.IDs)
 	CreateDerivedMappingsMultiParent(DB, LayerName, []int32{aggregatorResult.ID}, jsonData.IDs, session.ID)
 	// ========== 记录操作 ==========
@@ -1761,6 +1885,8 @@ type OffsetData struct {
 	IDs       []int32 `json:"ids"`
 	XOffset   float64 `json:"xOffset"` // 米
 	YOffset   float64 `json:"yOffset"` // 米
+	Username  string  `json:"Username"`
+	SessionID int64   `json:"SessionID"`
 }
 
 const (
@@ -1867,6 +1993,12 @@ func (uc *UserController) OffsetFeature(c *gin.Context) {
 	}
 
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, jsonData.IDs) {
+		return
+	}
 	LayerName := jsonData.LayerName
 
 	var schema models.MySchema
@@ -1997,7 +2129,7 @@ func (uc *UserController) OffsetFeature(c *gin.Context) {
 
 	delObjectIDs := DelIDGen(oldGeo)
 
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	inputIDs := MarshalIDs(jsonData.IDs)
 	outputIDs := MarshalIDs(jsonData.IDs)
 
@@ -2032,6 +2164,8 @@ func (uc *UserController) OffsetFeature(c *gin.Context) {
 type AreaOnAreaData struct {
 	LayerName string  `json:"LayerName"`
 	IDs       []int32 `json:"ids"`
+	Username  string  `json:"Username"`
+	SessionID int64   `json:"SessionID"`
 }
 
 func (uc *UserController) AreaOnAreaAnalysis(c *gin.Context) {
@@ -2045,6 +2179,12 @@ func (uc *UserController) AreaOnAreaAnalysis(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if rejectInVersion(c, DB, jsonData.LayerName, jsonData.Username, jsonData.SessionID) {
+		return
+	}
+	if !checkEditLocks(c, DB, jsonData.LayerName, jsonData.Username, jsonData.IDs) {
+		return
+	}
 	LayerName := jsonData.LayerName
 	EXT := GetFileExt(LayerName)
 	var schema models.MySchema
@@ -2241,7 +2381,7 @@ func (uc *UserController) AreaOnAreaAnalysis(c *gin.Context) {
 	newGeo := GetGeos(getdata2)
 	newGeojson, _ := json.Marshal(newGeo)
 	// ========== 维护映射表 ==========
-	session := GetOrCreateSession(DB, LayerName, "")
+	session := GetOrCreateSession(DB, LayerName, jsonData.Username)
 	MarkMappingDeleted(DB, LayerName, jsonData.IDs)
 	// 为每个新要素创建派生映射
 	for _, nid := range newFeatureIDs {
@@ -2632,6 +2772,7 @@ type delDatas struct {
 	IDs       []int32 `json:"IDs"`
 	Username  string  `json:"Username"`
 	BZ        string  `json:"BZ"`
+	SessionID int64   `json:"SessionID"` // 版本化会话ID，为空时直接编辑线上表
 }
 
 func (uc *UserController) DelGeosToSchema(c *gin.Context) {
@@ -2645,6 +2786,26 @@ func (uc *UserController) DelGeosToSchema(c *gin.Context) {
 		return
 	}
 	DB := models.DB
+	if !checkEditLocks(c, DB, jsonData.TableName, jsonData.Username, jsonData.IDs) {
+		return
+	}
+	// 版本化会话：逐个写入会话删除变更，提交前不影响线上表
+	if session, ok := versionSession(DB, jsonData.SessionID, jsonData.TableName); ok {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			for _, id := range jsonData.IDs {
+				if err := versionDeleteFeature(tx, session, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": fmt.Sprintf("成功删除%d个要素", len(jsonData.IDs)), "count": len(jsonData.IDs)})
+		return
+	}
 	getdata := getDatas{TableName: jsonData.TableName, ID: jsonData.IDs}
 	geos := GetGeos(getdata)
 	if len(geos.Features) == 0 {
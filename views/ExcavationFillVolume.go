@@ -42,9 +42,14 @@ func GetRGBToDEM(lon float64, lat float64, ResultTiles []models.Tile, maxZoom in
 		return 0
 	}
 
+	return demImageElevation(img, maxZoom, realDem.TileColumn, realDem.TileRow, lon, lat)
+}
+
+// demImageElevation 从已解码的地形瓦片中读取lon、lat处的高程，超出瓦片范围时返回0
+func demImageElevation(img image.Image, zoom, column, row int64, lon, lat float64) float64 {
 	// 计算lat，lon在该照片上的相对坐标x,y
 	//计算所在瓦片的经纬度坐标
-	topLeft, _, bottomLeft, bottomRight := pgmvt.TileToLatLon(int(maxZoom), int(realDem.TileColumn), int(realDem.TileRow))
+	topLeft, _, bottomLeft, bottomRight := pgmvt.TileToLatLon(int(zoom), int(column), int(row))
 
 	// 计算瓦片经纬度范围
 	tileLeft := topLeft[0]
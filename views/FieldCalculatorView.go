@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/GrainArc/SouceMap/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
 	"strings"
 )
@@ -19,14 +20,33 @@ func (uc *UserController) CalculateField(c *gin.Context) {
 		return
 	}
 
-	result, err := uc.calculatorService.CalculateField(req)
+	var result *models.FieldCalculatorResponse
+	geoRecord, _, err := auditAttributeUpdate(models.DB, attributeAudit{
+		TableName: req.TableName,
+		Fields:    []string{req.TargetField},
+		Where:     req.Condition,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		Type:      attributeBulkEditType,
+	}, func(tx *gorm.DB) error {
+		var err error
+		result, err = uc.calculatorService.CalculateFieldTx(tx, req)
+		return err
+	})
 	if err != nil {
+		if attributeAuditRejected(err) {
+			respondAttributeAuditError(c, err)
+			return
+		}
 		c.JSON(http.StatusBadRequest, models.Response{
 			Code:    400,
 			Message: "计算失败: " + err.Error(),
 		})
 		return
 	}
+	if geoRecord != nil {
+		result.RecordID = geoRecord.ID
+	}
 	record := &models.FieldRecord{
 		TableName:    req.TableName,
 		Type:         "value", // 操作类型：删除
@@ -64,11 +84,30 @@ func (uc *UserController) UpdateGeometryField(c *gin.Context) {
 		return
 	}
 
-	result, err := uc.service.UpdateGeometryField(DB, c.Request.Context(), &req)
+	var result *models.GeometryUpdateResponse
+	geoRecord, _, err := auditAttributeUpdate(DB, attributeAudit{
+		TableName: req.TableName,
+		Fields:    []string{req.TargetField},
+		Where:     req.WhereClause,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		Type:      attributeBulkEditType,
+	}, func(tx *gorm.DB) error {
+		var err error
+		result, err = uc.service.UpdateGeometryField(tx, c.Request.Context(), &req)
+		return err
+	})
 	if err != nil {
+		if attributeAuditRejected(err) {
+			respondAttributeAuditError(c, err)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if geoRecord != nil {
+		result.RecordID = geoRecord.ID
+	}
 	// 保存字段操作记录
 	record := &models.FieldRecord{
 		TableName:    req.TableName,
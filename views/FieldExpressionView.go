@@ -0,0 +1,192 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 表达式字段计算
+
+const (
+	expressionPreviewDefault = 20
+	expressionPreviewMax     = 500
+)
+
+type fieldExpressionRequest struct {
+	TableName   string  `json:"table_name" binding:"required"`
+	TargetField string  `json:"target_field"` // 预览时可为空
+	Expression  string  `json:"expression" binding:"required"`
+	Filter      string  `json:"filter"` // 筛选条件，使用同样的表达式语法
+	IDs         []int32 `json:"ids"`    // 选中的要素，为空时不限
+	Limit       int     `json:"limit"`  // 预览行数
+	Username    string  `json:"username"`
+	BZ          string  `json:"bz"`
+}
+
+type expressionPreviewRow struct {
+	ID           int64   `json:"id"`
+	CurrentValue *string `json:"current_value"`
+	NewValue     *string `json:"new_value"`
+}
+
+// compileExpressionRequest 编译表达式和筛选条件，返回赋值表达式和WHERE子句
+// 编译结果中包含字符串常量，执行时不能再带占位参数
+func compileExpressionRequest(db *gorm.DB, req fieldExpressionRequest) (*methods.CompiledExpression, string, string, error) {
+	compiled, err := methods.CompileFieldExpression(db, req.TableName, req.Expression)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("表达式错误: %v", err)
+	}
+	var conditions []string
+	if len(req.IDs) > 0 {
+		ids := make([]string, len(req.IDs))
+		for i, id := range req.IDs {
+			ids[i] = fmt.Sprintf("%d", id)
+		}
+		conditions = append(conditions, fmt.Sprintf("t.id IN (%s)", strings.Join(ids, ",")))
+	}
+	if strings.TrimSpace(req.Filter) != "" {
+		filter, err := methods.CompileFilterExpression(db, req.TableName, req.Filter)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("筛选条件错误: %v", err)
+		}
+		conditions = append(conditions, filter.SQL)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	valueSQL := compiled.SQL
+	if req.TargetField != "" {
+		fieldType, err := attributeFieldType(db, req.TableName, strings.ToLower(req.TargetField))
+		if err != nil {
+			return nil, "", "", err
+		}
+		valueSQL = fmt.Sprintf("(%s)::%s", compiled.SQL, fieldType)
+	}
+	return compiled, valueSQL, where, nil
+}
+
+// ExpressionFunctions 表达式可用的函数和变量
+func (uc *UserController) ExpressionFunctions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Response{Code: 200, Message: "success", Data: methods.ExpressionFunctions()})
+}
+
+// PreviewExpression 预览表达式计算结果，不修改数据
+func (uc *UserController) PreviewExpression(c *gin.Context) {
+	var req fieldExpressionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "参数错误: " + err.Error()})
+		return
+	}
+	DB := models.DB
+	compiled, valueSQL, where, err := compileExpressionRequest(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: err.Error()})
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = expressionPreviewDefault
+	}
+	if limit > expressionPreviewMax {
+		limit = expressionPreviewMax
+	}
+	whereSQL := ""
+	if where != "" {
+		whereSQL = " WHERE " + where
+	}
+	current := "NULL::text"
+	if req.TargetField != "" {
+		current = fmt.Sprintf(`t."%s"::text`, strings.ToLower(req.TargetField))
+	}
+
+	var total int64
+	if err := DB.Raw(fmt.Sprintf(`SELECT count(*) FROM "%s" AS t%s`, req.TableName, whereSQL)).Scan(&total).Error; err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "筛选失败: " + err.Error()})
+		return
+	}
+	var rows []expressionPreviewRow
+	sql := fmt.Sprintf(`SELECT t.id, %s AS current_value, (%s)::text AS new_value FROM "%s" AS t%s ORDER BY t.id LIMIT %d`,
+		current, valueSQL, req.TableName, whereSQL, limit)
+	if err := DB.Raw(sql).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "计算失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.Response{Code: 200, Message: "success", Data: gin.H{
+		"total":       total,
+		"rows":        rows,
+		"result_type": compiled.Type,
+		"fields":      compiled.Fields,
+		"layers":      compiled.Layers,
+		"sql":         compiled.SQL,
+	}})
+}
+
+// CalculateExpression 按表达式批量更新字段，写入属性变更记录，可通过BackUpRecord撤销
+func (uc *UserController) CalculateExpression(c *gin.Context) {
+	var req fieldExpressionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "参数错误: " + err.Error()})
+		return
+	}
+	if req.TargetField == "" {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "需要target_field"})
+		return
+	}
+	DB := models.DB
+	targetField := strings.ToLower(req.TargetField)
+	_, valueSQL, where, err := compileExpressionRequest(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: err.Error()})
+		return
+	}
+
+	var affected int64
+	record, changed, err := auditAttributeUpdate(DB, attributeAudit{
+		TableName: req.TableName,
+		Fields:    []string{targetField},
+		Where:     where,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		Type:      attributeBulkEditType,
+	}, func(tx *gorm.DB) error {
+		sql := fmt.Sprintf(`UPDATE "%s" AS t SET "%s" = %s`, req.TableName, targetField, valueSQL)
+		if where != "" {
+			sql += " WHERE " + where
+		}
+		result := tx.Exec(sql)
+		if result.Error != nil {
+			return fmt.Errorf("计算失败: %v", result.Error)
+		}
+		affected = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		respondAttributeAuditError(c, err)
+		return
+	}
+
+	fieldRecord := &models.FieldRecord{
+		TableName:    req.TableName,
+		Type:         "value",
+		OldFieldName: targetField,
+	}
+	if err := uc.fieldService.SaveFieldRecord(fieldRecord); err != nil {
+		fmt.Printf("保存字段操作记录失败: %v\n", err)
+	}
+
+	data := gin.H{
+		"table_name":    req.TableName,
+		"target_field":  targetField,
+		"affected_rows": affected,
+		"changed_rows":  changed,
+	}
+	if record != nil {
+		data["record_id"] = record.ID
+	}
+	c.JSON(http.StatusOK, models.Response{Code: 200, Message: "字段计算成功", Data: data})
+}
@@ -0,0 +1,167 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 要素空间顺序编号
+
+type featureNumberingRequest struct {
+	TableName   string  `json:"table_name" binding:"required"`
+	TargetField string  `json:"target_field"`
+	Method      string  `json:"method"`      // row / serpentine / hilbert / nearest
+	GroupField  string  `json:"group_field"` // 分组字段，如行政区代码，每组单独编号
+	BandHeight  float64 `json:"band_height"` // 行带高度(米)
+	Pattern     string  `json:"pattern"`     // 编号格式，如 "{group}-{n:4}"，为空时由prefix和width生成
+	Prefix      string  `json:"prefix"`
+	Width       int     `json:"width"` // 序号补零位数
+	Start       *int    `json:"start"` // 起始序号，默认1
+	Step        int     `json:"step"`
+	Filter      string  `json:"filter"` // 筛选条件，使用表达式语法
+	IDs         []int32 `json:"ids"`
+	Preview     bool    `json:"preview"`
+	Username    string  `json:"username"`
+	BZ          string  `json:"bz"`
+}
+
+// loadNumberingItems 读取参与编号要素的分组值和投影坐标代表点
+func loadNumberingItems(db *gorm.DB, req featureNumberingRequest) ([]methods.NumberingItem, string, error) {
+	var conditions []string
+	if len(req.IDs) > 0 {
+		ids := make([]string, len(req.IDs))
+		for i, id := range req.IDs {
+			ids[i] = fmt.Sprintf("%d", id)
+		}
+		conditions = append(conditions, fmt.Sprintf("t.id IN (%s)", strings.Join(ids, ",")))
+	}
+	if strings.TrimSpace(req.Filter) != "" {
+		filter, err := methods.CompileFilterExpression(db, req.TableName, req.Filter)
+		if err != nil {
+			return nil, "", fmt.Errorf("筛选条件错误: %v", err)
+		}
+		conditions = append(conditions, filter.SQL)
+	}
+	whereSQL := ""
+	if len(conditions) > 0 {
+		whereSQL = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// 按图层中心所在3度带投影，行带高度和距离以米计
+	var centerX float64
+	if err := db.Raw(fmt.Sprintf(`SELECT ST_X(ST_Centroid(ST_Extent(t.geom))) FROM "%s" AS t%s`, req.TableName, whereSQL)).
+		Scan(&centerX).Error; err != nil {
+		return nil, "", err
+	}
+	epsg := methods.CGCS2000ZoneEPSG(centerX)
+
+	groupExpr := "''"
+	if req.GroupField != "" {
+		groupExpr = fmt.Sprintf(`COALESCE(t."%s"::text, '')`, strings.ToLower(req.GroupField))
+	}
+	var items []methods.NumberingItem
+	sql := fmt.Sprintf(`
+		SELECT id, "group", ST_X(p) AS x, ST_Y(p) AS y FROM (
+			SELECT t.id, %s AS "group", ST_Transform(ST_PointOnSurface(t.geom), %d) AS p
+			FROM "%s" AS t%s
+		) s WHERE p IS NOT NULL`, groupExpr, epsg, req.TableName, whereSQL)
+	if err := db.Raw(sql).Scan(&items).Error; err != nil {
+		return nil, "", err
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = fmt.Sprintf("%d", item.ID)
+	}
+	return items, fmt.Sprintf("t.id IN (%s)", strings.Join(ids, ",")), nil
+}
+
+// NumberFeatures 按空间顺序给要素编号，写入时记录属性变更可撤销
+func (uc *UserController) NumberFeatures(c *gin.Context) {
+	var req featureNumberingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "参数错误: " + err.Error()})
+		return
+	}
+	if !req.Preview && req.TargetField == "" {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "需要target_field"})
+		return
+	}
+	DB := models.DB
+	pattern := req.Pattern
+	if pattern == "" {
+		pattern = req.Prefix + "{n}"
+		if req.Width > 0 {
+			pattern = fmt.Sprintf("%s{n:%d}", req.Prefix, req.Width)
+		}
+	}
+	start := 1
+	if req.Start != nil {
+		start = *req.Start
+	}
+	items, where, err := loadNumberingItems(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "读取要素失败: " + err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: "没有需要编号的要素"})
+		return
+	}
+	numbered, err := methods.NumberFeatures(items, methods.NumberingOptions{
+		Method:     req.Method,
+		BandHeight: req.BandHeight,
+		Pattern:    pattern,
+		Start:      start,
+		Step:       req.Step,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: err.Error()})
+		return
+	}
+	data := gin.H{"table_name": req.TableName, "total": len(numbered), "items": numbered}
+	if req.Preview {
+		c.JSON(http.StatusOK, models.Response{Code: 200, Message: "success", Data: data})
+		return
+	}
+
+	targetField := strings.ToLower(req.TargetField)
+	fieldType, err := attributeFieldType(DB, req.TableName, targetField)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Code: 400, Message: err.Error()})
+		return
+	}
+	values := make([]string, len(numbered))
+	for i, item := range numbered {
+		values[i] = fmt.Sprintf("(%d, '%s')", item.ID, strings.ReplaceAll(item.Code, "'", "''"))
+	}
+	record, changed, err := auditAttributeUpdate(DB, attributeAudit{
+		TableName: req.TableName,
+		Fields:    []string{targetField},
+		Where:     where,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		Type:      attributeBulkEditType,
+	}, func(tx *gorm.DB) error {
+		sql := fmt.Sprintf(`UPDATE "%s" AS t SET "%s" = v.code::%s FROM (VALUES %s) AS v(id, code) WHERE t.id = v.id`,
+			req.TableName, targetField, fieldType, strings.Join(values, ", "))
+		if err := tx.Exec(sql).Error; err != nil {
+			return fmt.Errorf("写入编号失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		respondAttributeAuditError(c, err)
+		return
+	}
+	data["changed_rows"] = changed
+	if record != nil {
+		data["record_id"] = record.ID
+	}
+	c.JSON(http.StatusOK, models.Response{Code: 200, Message: "编号完成", Data: data})
+}
@@ -6,9 +6,11 @@ import (
 	"github.com/GrainArc/Gogeo"
 	"github.com/GrainArc/SouceMap/config"
 	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"log"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -57,6 +59,7 @@ type ImportPGToGDBResponse struct {
 	SuccessCount int                  `json:"success_count"`
 	FailedCount  int                  `json:"failed_count"`
 	Results      []*LayerImportResult `json:"results"`
+	DomainCount  int                  `json:"domain_count,omitempty"` // 写入GDB的属性域数量
 }
 
 // ImportPGToGDB 将多个PG表导入到GDB
@@ -117,10 +120,12 @@ func ImportPGToGDB(req *ImportPGToGDBRequest) (*ImportPGToGDBResponse, error) {
 		Results:     make([]*LayerImportResult, 0, len(req.TableNames)),
 	}
 
+	// 属性域随图层一并导出，全部图层写入后统一写入GDB
+	domainInfo := &pgmvt.GDBDomainInfo{LayerFields: make(map[string][]pgmvt.GDBFieldDomain)}
 	for _, tableName := range req.TableNames {
 		// 传递目标信息给单表导入函数
 		result := importSingleTable(DB, basePostGISConfig, gdbPath, tableName, req.TargetMain,
-			targetSourceConfigs[0].SourcePath, targetEPSG)
+			targetSourceConfigs[0].SourcePath, targetEPSG, domainInfo)
 		response.Results = append(response.Results, result)
 
 		if result.Success {
@@ -130,6 +135,12 @@ func ImportPGToGDB(req *ImportPGToGDBRequest) (*ImportPGToGDBResponse, error) {
 		}
 	}
 
+	domainCount, err := pgmvt.WriteGDBDomains(gdbPath, domainInfo)
+	if err != nil {
+		log.Printf("导出属性域失败: %v", err)
+	}
+	response.DomainCount = domainCount
+
 	// 设置总体结果
 	response.Success = response.FailedCount == 0
 	if response.Success {
@@ -164,7 +175,7 @@ func transformFieldAliases(fieldAliases, fieldMapping map[string]string) map[str
 
 // importSingleTable 导入单个表
 func importSingleTable(DB *gorm.DB, baseConfig *Gogeo.PostGISConfig, gdbPath, tableName,
-	targetMain, targetSourcePath string, targetEPSG int) *LayerImportResult {
+	targetMain, targetSourcePath string, targetEPSG int, domainInfo *pgmvt.GDBDomainInfo) *LayerImportResult {
 	result := &LayerImportResult{
 		TableName: tableName,
 	}
@@ -269,6 +280,8 @@ func importSingleTable(DB *gorm.DB, baseConfig *Gogeo.PostGISConfig, gdbPath, ta
 		return result
 	}
 
+	exportLayerDomains(DB, pgTableName, layerName, fieldMapping, domainInfo)
+
 	result.Success = true
 	result.Message = "导入成功"
 	result.TotalCount = importResult.TotalCount
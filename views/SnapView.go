@@ -0,0 +1,215 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/services"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/paulmach/orb"
+)
+
+// 多图层捕捉
+
+const (
+	snapIndexTTL     = 2 * time.Minute // 索引缓存有效期
+	snapExtentScale  = 1.5             // 建索引时视野范围的放大倍数，小范围平移无需重建
+	snapMaxCached    = 32
+	snapMaxCandidate = 10
+)
+
+type cachedSnapIndex struct {
+	key   string
+	index *services.SnapIndex
+}
+
+type SnapHandler struct {
+	snapService *services.SnapService
+	mu          sync.Mutex
+	cache       []cachedSnapIndex
+}
+
+func NewSnapHandler() *SnapHandler {
+	return &SnapHandler{
+		snapService: services.NewSnapService(),
+	}
+}
+
+func extentToBound(extent []float64) (orb.Bound, error) {
+	if len(extent) != 4 {
+		return orb.Bound{}, fmt.Errorf("extent must be [minx, miny, maxx, maxy]")
+	}
+	return orb.Bound{Min: orb.Point{extent[0], extent[1]}, Max: orb.Point{extent[2], extent[3]}}, nil
+}
+
+func scaleBound(b orb.Bound, scale float64) orb.Bound {
+	center := b.Center()
+	halfW := (b.Max[0] - b.Min[0]) * scale / 2
+	halfH := (b.Max[1] - b.Min[1]) * scale / 2
+	return orb.Bound{Min: orb.Point{center[0] - halfW, center[1] - halfH}, Max: orb.Point{center[0] + halfW, center[1] + halfH}}
+}
+
+// getIndex 获取覆盖当前视野的索引，缓存过期、图层被编辑或视野超出时重建
+func (h *SnapHandler) getIndex(ctx context.Context, layers []models.SnapLayer, extent orb.Bound, force bool) (*services.SnapIndex, error) {
+	key := services.SnapIndexKey(layers)
+	names := make([]string, 0, len(layers))
+	for _, l := range layers {
+		names = append(names, l.LayerName)
+	}
+	version := h.snapService.SnapLayerVersion(ctx, names)
+
+	h.mu.Lock()
+	if !force {
+		for _, item := range h.cache {
+			if item.key == key && item.index.Version == version && item.index.Contains(extent) &&
+				time.Since(item.index.BuiltAt) < snapIndexTTL {
+				h.mu.Unlock()
+				return item.index, nil
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	index, err := h.snapService.BuildSnapIndex(ctx, layers, scaleBound(extent, snapExtentScale))
+	if err != nil {
+		return nil, err
+	}
+	index.Version = version
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// 清理过期和同图层组合的旧索引
+	kept := h.cache[:0]
+	for _, item := range h.cache {
+		if item.key != key && time.Since(item.index.BuiltAt) < snapIndexTTL {
+			kept = append(kept, item)
+		}
+	}
+	h.cache = append(kept, cachedSnapIndex{key: key, index: index})
+	if len(h.cache) > snapMaxCached {
+		h.cache = h.cache[len(h.cache)-snapMaxCached:]
+	}
+	return index, nil
+}
+
+// snap 在索引中查找捕捉点
+func (h *SnapHandler) snap(index *services.SnapIndex, req models.SnapRequest) models.SnapResponse {
+	if len(req.Point) != 2 {
+		return models.SnapResponse{Type: "error", Message: "invalid point"}
+	}
+	point := orb.Point{req.Point[0], req.Point[1]}
+	tolerance := services.PixelToleranceMeters(req.Tolerance, req.Zoom, req.TileSize, point[1])
+	var from *orb.Point
+	if len(req.From) == 2 {
+		from = &orb.Point{req.From[0], req.From[1]}
+	}
+	candidates := index.Snap(point, tolerance, req.Types, from)
+	response := models.SnapResponse{
+		Type:      "snap",
+		Snapped:   len(candidates) > 0,
+		Tolerance: tolerance,
+		Truncated: index.Truncated,
+	}
+	if len(candidates) > 0 {
+		best := candidates[0]
+		response.Best = &best
+		if len(candidates) > snapMaxCandidate {
+			candidates = candidates[:snapMaxCandidate]
+		}
+		response.Candidates = candidates
+	}
+	return response
+}
+
+// SnapPoint 单次捕捉（HTTP）
+func (h *SnapHandler) SnapPoint(c *gin.Context) {
+	var req models.SnapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Layers) == 0 {
+		c.JSON(400, gin.H{"error": "layers required"})
+		return
+	}
+	extent, err := extentToBound(req.Extent)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	index, err := h.getIndex(c.Request.Context(), req.Layers, extent, false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("failed to build snap index: %v", err)})
+		return
+	}
+	c.JSON(200, h.snap(index, req))
+}
+
+// SnapWebSocket 连续捕捉（WebSocket）
+// 先发送 {"action":"extent", layers, extent}，视野变化时再次发送；之后发送 {"action":"snap", point, tolerance, zoom}
+func (h *SnapHandler) SnapWebSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to websocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var index *services.SnapIndex
+	var layers []models.SnapLayer
+	var extent orb.Bound
+	for {
+		var req models.SnapRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response models.SnapResponse
+		switch req.Action {
+		case "extent", "refresh":
+			if len(req.Layers) > 0 {
+				layers = req.Layers
+			}
+			if len(req.Extent) > 0 {
+				if extent, err = extentToBound(req.Extent); err != nil {
+					response = models.SnapResponse{Type: "error", Message: err.Error()}
+					break
+				}
+			}
+			if len(layers) == 0 {
+				response = models.SnapResponse{Type: "error", Message: "layers required"}
+				break
+			}
+			index, err = h.getIndex(ctx, layers, extent, req.Action == "refresh")
+			if err != nil {
+				response = models.SnapResponse{Type: "error", Message: err.Error()}
+				break
+			}
+			response = models.SnapResponse{Type: "extent", Truncated: index.Truncated, Message: "snap index ready"}
+		case "snap":
+			if index == nil {
+				response = models.SnapResponse{Type: "error", Message: "extent not set"}
+				break
+			}
+			response = h.snap(index, req)
+		default:
+			response = models.SnapResponse{Type: "error", Message: fmt.Sprintf("unknown action: %s", req.Action)}
+		}
+
+		if err := conn.WriteJSON(response); err != nil {
+			log.Printf("Failed to send snap response: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 后台分析任务：提交后返回任务ID，前端连接WebSocket后开始执行并接收进度，与/gdal下叠加分析任务的消息格式一致
+
+type analysisTaskStatus string
+
+const (
+	analysisTaskPending   analysisTaskStatus = "pending"
+	analysisTaskRunning   analysisTaskStatus = "running"
+	analysisTaskCompleted analysisTaskStatus = "completed"
+	analysisTaskFailed    analysisTaskStatus = "failed"
+	analysisTaskCancelled analysisTaskStatus = "cancelled"
+)
+
+// analysisProgressFunc 进度回调，返回false表示任务已取消
+type analysisProgressFunc func(complete float64, message string) bool
+
+// analysisRunFunc 任务执行函数，返回的结果在完成消息和状态查询中返回
+type analysisRunFunc func(ctx context.Context, progress analysisProgressFunc) (interface{}, error)
+
+type analysisTask struct {
+	ID        string             `json:"task_id"`
+	Kind      string             `json:"kind"`
+	Status    analysisTaskStatus `json:"status"`
+	CreatedAt time.Time          `json:"created_at"`
+	StartedAt *time.Time         `json:"started_at,omitempty"`
+	EndedAt   *time.Time         `json:"ended_at,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Result    interface{}        `json:"result,omitempty"`
+	run       analysisRunFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mutex     sync.RWMutex
+}
+
+// analysisMessage WebSocket消息
+type analysisMessage struct {
+	Type       string      `json:"type"` // progress / complete / error / cancelled
+	Percentage int         `json:"percentage,omitempty"`
+	Message    string      `json:"message"`
+	Timestamp  int64       `json:"timestamp"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+var analysisTasks = struct {
+	tasks map[string]*analysisTask
+	mutex sync.RWMutex
+}{tasks: make(map[string]*analysisTask)}
+
+// analysisTaskTTL 已结束任务的保留时间
+const analysisTaskTTL = 24 * time.Hour
+
+// newAnalysisTask 登记任务，同时清理过期任务
+func newAnalysisTask(kind string, run analysisRunFunc) *analysisTask {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &analysisTask{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		Status:    analysisTaskPending,
+		CreatedAt: time.Now(),
+		run:       run,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	analysisTasks.mutex.Lock()
+	defer analysisTasks.mutex.Unlock()
+	for id, t := range analysisTasks.tasks {
+		t.mutex.RLock()
+		expired := t.EndedAt != nil && time.Since(*t.EndedAt) > analysisTaskTTL
+		t.mutex.RUnlock()
+		if expired {
+			delete(analysisTasks.tasks, id)
+		}
+	}
+	analysisTasks.tasks[task.ID] = task
+	return task
+}
+
+func getAnalysisTask(id string) (*analysisTask, bool) {
+	analysisTasks.mutex.RLock()
+	defer analysisTasks.mutex.RUnlock()
+	task, ok := analysisTasks.tasks[id]
+	return task, ok
+}
+
+func (task *analysisTask) setStatus(status analysisTaskStatus, result interface{}, err error) {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+	task.Status = status
+	now := time.Now()
+	switch status {
+	case analysisTaskRunning:
+		task.StartedAt = &now
+	case analysisTaskCompleted, analysisTaskFailed, analysisTaskCancelled:
+		task.EndedAt = &now
+	}
+	if result != nil {
+		task.Result = result
+	}
+	if err != nil {
+		task.Error = err.Error()
+	}
+}
+
+// respondAnalysisTask 返回新建任务的ID和WebSocket地址
+func respondAnalysisTask(c *gin.Context, task *analysisTask, message string) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"task_id": task.ID,
+		"status":  task.Status,
+		"message": message + "任务已创建，请使用WebSocket连接开始执行",
+		"ws_url":  fmt.Sprintf("/analysis/ws/%s", task.ID),
+	})
+}
+
+// AnalysisTaskWebSocket 连接后执行任务并推送进度，客户端发送 {"action":"cancel"} 取消
+func (uc *UserController) AnalysisTaskWebSocket(c *gin.Context) {
+	task, ok := getAnalysisTask(c.Param("taskId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	task.mutex.Lock()
+	if task.Status != analysisTaskPending {
+		task.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务已经开始或已完成"})
+		return
+	}
+	task.Status = analysisTaskRunning
+	task.mutex.Unlock()
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		task.setStatus(analysisTaskFailed, nil, fmt.Errorf("WebSocket升级失败"))
+		return
+	}
+	defer ws.Close()
+	task.setStatus(analysisTaskRunning, nil, nil)
+
+	// 客户端断开或发送取消时取消任务
+	go func() {
+		for {
+			var msg struct {
+				Action string `json:"action"`
+			}
+			if err := ws.ReadJSON(&msg); err != nil {
+				task.cancel()
+				return
+			}
+			if msg.Action == "cancel" {
+				task.cancel()
+				return
+			}
+		}
+	}()
+
+	var writeMutex sync.Mutex
+	send := func(msg analysisMessage) {
+		msg.Timestamp = time.Now().UnixMilli()
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		ws.WriteJSON(msg)
+	}
+	progress := func(complete float64, message string) bool {
+		select {
+		case <-task.ctx.Done():
+			return false
+		default:
+		}
+		send(analysisMessage{Type: "progress", Percentage: int(complete * 100), Message: message})
+		return true
+	}
+
+	start := time.Now()
+	result, err := task.run(task.ctx, progress)
+	switch {
+	case task.ctx.Err() != nil:
+		task.setStatus(analysisTaskCancelled, nil, nil)
+		send(analysisMessage{Type: "cancelled", Message: fmt.Sprintf("任务 %s 已被取消", task.ID)})
+	case err != nil:
+		task.setStatus(analysisTaskFailed, nil, err)
+		send(analysisMessage{Type: "error", Message: err.Error()})
+	default:
+		task.setStatus(analysisTaskCompleted, result, nil)
+		send(analysisMessage{
+			Type:       "complete",
+			Percentage: 100,
+			Message:    fmt.Sprintf("分析完成，耗时: %v", time.Since(start).Round(time.Millisecond)),
+			Data:       result,
+		})
+	}
+	task.cancel()
+}
+
+// GetAnalysisTaskStatus 查询任务状态和结果
+func (uc *UserController) GetAnalysisTaskStatus(c *gin.Context) {
+	task, ok := getAnalysisTask(c.Param("taskId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	task.mutex.RLock()
+	defer task.mutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"task_id":    task.ID,
+		"kind":       task.Kind,
+		"status":     task.Status,
+		"created_at": task.CreatedAt,
+		"started_at": task.StartedAt,
+		"ended_at":   task.EndedAt,
+		"error":      task.Error,
+		"result":     task.Result,
+	})
+}
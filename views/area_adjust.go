@@ -0,0 +1,234 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 面积平差：子要素面积之和调整到控制面积
+
+type areaAdjustRequest struct {
+	TableName     string  `json:"TableName"`
+	IDs           []int32 `json:"IDs"`           // 参与平差的要素，为空时取控制范围内的要素
+	ControlTable  string  `json:"ControlTable"`  // 控制范围所在图层
+	ControlID     int32   `json:"ControlID"`     // 控制范围要素ID
+	ControlArea   float64 `json:"ControlArea"`   // 控制面积(平方米)，为0时取控制范围的椭球面积
+	AreaField     string  `json:"AreaField"`     // 平差前面积字段，为空时按几何计算椭球面积
+	AdjustedField string  `json:"AdjustedField"` // 写入平差后面积的字段
+	WeightField   string  `json:"WeightField"`   // 权重字段，为空时按面积比例分配
+	Decimals      *int    `json:"Decimals"`      // 保留小数位，默认2
+	Apply         bool    `json:"Apply"`         // 写入字段，否则仅预览
+	Report        bool    `json:"Report"`        // 生成平差报告
+	ReportName    string  `json:"ReportName"`
+	Username      string  `json:"Username"`
+	BZ            string  `json:"BZ"`
+}
+
+// loadAreaAdjustItems 读取参与平差的要素面积和权重
+func loadAreaAdjustItems(db *gorm.DB, req areaAdjustRequest, areaField, weightField string) ([]methods.AreaAdjustItem, string, error) {
+	var where string
+	var args []interface{}
+	switch {
+	case len(req.IDs) > 0:
+		ids := make([]string, len(req.IDs))
+		for i, id := range req.IDs {
+			ids[i] = fmt.Sprintf("%d", id)
+		}
+		where = fmt.Sprintf("t.id IN (%s)", strings.Join(ids, ","))
+	case req.ControlTable != "":
+		where = fmt.Sprintf(`ST_Within(ST_PointOnSurface(t.geom), (SELECT geom FROM "%s" WHERE id = ?))`, req.ControlTable)
+		args = append(args, req.ControlID)
+	default:
+		return nil, "", fmt.Errorf("需要指定要素IDs或控制范围")
+	}
+	areaExpr := "ST_Area(t.geom::geography)"
+	if areaField != "" {
+		areaExpr = fmt.Sprintf(`t."%s"::float8`, areaField)
+	}
+	weightExpr := "0"
+	if weightField != "" {
+		weightExpr = fmt.Sprintf(`COALESCE(t."%s"::float8, 0)`, weightField)
+	}
+	var items []methods.AreaAdjustItem
+	sql := fmt.Sprintf(`SELECT t.id, COALESCE(%s, 0) AS area, %s AS weight FROM "%s" AS t WHERE %s ORDER BY t.id`,
+		areaExpr, weightExpr, req.TableName, where)
+	if err := db.Raw(sql, args...).Scan(&items).Error; err != nil {
+		return nil, "", err
+	}
+	if len(items) == 0 {
+		return nil, "", fmt.Errorf("没有找到参与平差的要素")
+	}
+	// 记录和更新只涉及实际参与平差的要素
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = fmt.Sprintf("%d", item.ID)
+	}
+	return items, fmt.Sprintf("t.id IN (%s)", strings.Join(ids, ",")), nil
+}
+
+// areaAdjustReport 将平差前后对照表写入Word报告，返回下载地址
+func areaAdjustReport(c *gin.Context, req areaAdjustRequest, result *methods.AreaAdjustResult, decimals int) (string, error) {
+	templateData, err := templateFS.ReadFile("fonts/template.docx")
+	if err != nil {
+		return "", fmt.Errorf("读取模板文件失败: %v", err)
+	}
+	builder, err := services.NewDocumentBuilderFromBytes(templateData)
+	if err != nil {
+		return "", fmt.Errorf("创建文档构建器失败: %v", err)
+	}
+	defer builder.Close()
+
+	reportName := req.ReportName
+	if reportName == "" {
+		reportName = "面积平差表"
+	}
+	format := fmt.Sprintf("%%.%df", decimals)
+	builder.AddHeading1(models.Heading1Config{Text: reportName, Alignment: "center"})
+	builder.AddParagraph(models.ParagraphConfig{
+		Text: fmt.Sprintf("控制面积%s平方米，平差前面积合计%s平方米，差值%s平方米（相对差值%.4f%%），共%d个要素参与平差。",
+			fmt.Sprintf(format, result.ControlArea), fmt.Sprintf(format, result.OriginalSum),
+			fmt.Sprintf(format, result.Discrepancy), result.RelativeDiff*100, len(result.Items)),
+		Indent: 2,
+	})
+	rows := make([][]string, 0, len(result.Items)+1)
+	for i, item := range result.Items {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			fmt.Sprintf("%d", item.ID),
+			fmt.Sprintf(format, item.Area),
+			fmt.Sprintf(format, item.Diff),
+			fmt.Sprintf(format, item.Adjusted),
+		})
+	}
+	rows = append(rows, []string{"合计", "", fmt.Sprintf(format, result.OriginalSum),
+		fmt.Sprintf(format, result.AdjustedSum-result.OriginalSum), fmt.Sprintf(format, result.AdjustedSum)})
+	builder.AddDataTable(reportName, []string{"序号", "要素ID", "平差前面积（平方米）", "改正数（平方米）", "平差后面积（平方米）"}, rows, true)
+
+	taskid := uuid.New().String()
+	homeDir, _ := os.UserHomeDir()
+	path := filepath.Join(homeDir, "BoundlessMap", "OutFile", taskid)
+	os.MkdirAll(path, os.ModePerm)
+	fileName := fmt.Sprintf("%s_%s.docx", reportName, time.Now().Format("200601021504"))
+	if err := builder.Save(filepath.Join(path, fileName)); err != nil {
+		return "", fmt.Errorf("保存文档失败: %v", err)
+	}
+	u := &url.URL{
+		Scheme: "http",
+		Host:   c.Request.Host,
+		Path:   "/geo/OutFile/" + taskid + "/" + fileName,
+	}
+	return u.String(), nil
+}
+
+// AreaAdjust 面积平差：差值按面积比例或权重分配到平差面积字段，写入时记录属性变更可撤销
+func (uc *UserController) AreaAdjust(c *gin.Context) {
+	var req areaAdjustRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	if req.TableName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "需要指定TableName", "data": ""})
+		return
+	}
+	decimals := 2
+	if req.Decimals != nil {
+		decimals = *req.Decimals
+	}
+	areaField := strings.ToLower(req.AreaField)
+	weightField := strings.ToLower(req.WeightField)
+	adjustedField := strings.ToLower(req.AdjustedField)
+	columns := tableColumns(DB, req.TableName)
+	for _, f := range []string{areaField, weightField, adjustedField} {
+		if f != "" && !columns[f] {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": fmt.Sprintf("字段 %s 在表 %s 中不存在", f, req.TableName), "data": ""})
+			return
+		}
+	}
+
+	controlArea := req.ControlArea
+	if controlArea <= 0 && req.ControlTable != "" {
+		sql := fmt.Sprintf(`SELECT COALESCE(ST_Area(geom::geography), 0) FROM "%s" WHERE id = ?`, req.ControlTable)
+		if err := DB.Raw(sql, req.ControlID).Scan(&controlArea).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "读取控制范围失败: " + err.Error(), "data": ""})
+			return
+		}
+	}
+	items, where, err := loadAreaAdjustItems(DB, req, areaField, weightField)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	result, err := methods.AdjustAreas(items, controlArea, weightField != "", decimals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	data := gin.H{"result": result, "applied": false}
+
+	if req.Apply {
+		if adjustedField == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "写入时需要指定AdjustedField", "data": data})
+			return
+		}
+		fieldType, err := attributeFieldType(DB, req.TableName, adjustedField)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+			return
+		}
+		values := make([]string, len(result.Items))
+		for i, item := range result.Items {
+			values[i] = fmt.Sprintf("(%d, %.*f)", item.ID, decimals, item.Adjusted)
+		}
+		bz := req.BZ
+		if bz == "" {
+			bz = fmt.Sprintf("面积平差：控制面积%.*f", decimals, controlArea)
+		}
+		record, _, err := auditAttributeUpdate(DB, attributeAudit{
+			TableName: req.TableName,
+			Fields:    []string{adjustedField},
+			Where:     where,
+			Username:  req.Username,
+			BZ:        bz,
+			Type:      attributeBulkEditType,
+		}, func(tx *gorm.DB) error {
+			sql := fmt.Sprintf(`UPDATE "%s" AS t SET "%s" = v.area::%s FROM (VALUES %s) AS v(id, area) WHERE t.id = v.id`,
+				req.TableName, adjustedField, fieldType, strings.Join(values, ", "))
+			if err := tx.Exec(sql).Error; err != nil {
+				return fmt.Errorf("写入平差面积失败: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			respondAttributeAuditError(c, err)
+			return
+		}
+		data["applied"] = true
+		if record != nil {
+			data["record_id"] = record.ID
+		}
+	}
+
+	if req.Report {
+		reportURL, err := areaAdjustReport(c, req, result, decimals)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": data})
+			return
+		}
+		data["report_url"] = reportURL
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "平差完成", "data": data})
+}
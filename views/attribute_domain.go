@@ -0,0 +1,564 @@
+// views/attribute_domain.go
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// FieldViolation 字段校验错误
+type FieldViolation struct {
+	FeatureID int32       `json:"FeatureID,omitempty"`
+	Field     string      `json:"Field"`
+	Rule      string      `json:"Rule"`
+	Value     interface{} `json:"Value"`
+	Message   string      `json:"Message"`
+}
+
+// ==================== 校验 ====================
+
+// loadFieldRules 读取图层启用的字段规则及其引用的属性域
+func loadFieldRules(db *gorm.DB, tableName string) ([]models.FieldRule, map[string]models.AttributeDomain) {
+	var rules []models.FieldRule
+	db.Where("table_name = ? AND enabled = ?", tableName, true).Order("id").Find(&rules)
+	domains := make(map[string]models.AttributeDomain)
+	var names []string
+	for _, r := range rules {
+		if r.DomainName != "" {
+			names = append(names, r.DomainName)
+		}
+	}
+	if len(names) > 0 {
+		var list []models.AttributeDomain
+		db.Where("name IN ?", names).Find(&list)
+		for _, d := range list {
+			domains[d.Name] = d
+		}
+	}
+	return rules, domains
+}
+
+// valueToString 属性值转字符串，数值不带多余的小数位
+func valueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func isEmptyValue(value interface{}) bool {
+	return value == nil || strings.TrimSpace(valueToString(value)) == ""
+}
+
+func ruleMessage(rule models.FieldRule, defaultMsg string) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+	return defaultMsg
+}
+
+// checkRange 检查数值范围，min/max为空时不限制
+func checkRange(value interface{}, minValue, maxValue *float64) (bool, string) {
+	num, err := strconv.ParseFloat(strings.TrimSpace(valueToString(value)), 64)
+	if err != nil {
+		return false, "不是有效数值"
+	}
+	if minValue != nil && num < *minValue {
+		return false, fmt.Sprintf("不能小于%v", *minValue)
+	}
+	if maxValue != nil && num > *maxValue {
+		return false, fmt.Sprintf("不能大于%v", *maxValue)
+	}
+	return true, ""
+}
+
+// validateAttributes 按字段规则校验属性
+// isInsert为true时必填字段缺失也视为错误；修改时只校验提交的字段
+func validateAttributes(db *gorm.DB, tableName string, props map[string]interface{}, isInsert bool) []FieldViolation {
+	rules, domains := loadFieldRules(db, tableName)
+	if len(rules) == 0 {
+		return nil
+	}
+	props = lowerProperties(props)
+	var violations []FieldViolation
+	for _, rule := range rules {
+		field := strings.ToLower(rule.FieldName)
+		value, present := props[field]
+		if !present && !(isInsert && rule.RuleType == "required") {
+			continue
+		}
+		if rule.RuleType == "required" {
+			if isEmptyValue(value) {
+				violations = append(violations, FieldViolation{Field: rule.FieldName, Rule: "required", Value: value,
+					Message: ruleMessage(rule, fmt.Sprintf("字段%s不能为空", rule.FieldName))})
+			}
+			continue
+		}
+		// 空值交给必填规则处理
+		if isEmptyValue(value) {
+			continue
+		}
+		switch rule.RuleType {
+		case "domain":
+			domain, ok := domains[rule.DomainName]
+			if !ok {
+				continue
+			}
+			if domain.DomainType == "range" {
+				if ok, msg := checkRange(value, domain.MinValue, domain.MaxValue); !ok {
+					violations = append(violations, FieldViolation{Field: rule.FieldName, Rule: "domain", Value: value,
+						Message: ruleMessage(rule, fmt.Sprintf("字段%s%s（属性域%s）", rule.FieldName, msg, domain.Name))})
+				}
+				continue
+			}
+			var codes []models.CodedValue
+			json.Unmarshal(domain.CodedValues, &codes)
+			str := strings.TrimSpace(valueToString(value))
+			matched := false
+			for _, code := range codes {
+				if code.Code == str {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				violations = append(violations, FieldViolation{Field: rule.FieldName, Rule: "domain", Value: value,
+					Message: ruleMessage(rule, fmt.Sprintf("字段%s的值%s不在属性域%s中", rule.FieldName, str, domain.Name))})
+			}
+		case "range":
+			if ok, msg := checkRange(value, rule.MinValue, rule.MaxValue); !ok {
+				violations = append(violations, FieldViolation{Field: rule.FieldName, Rule: "range", Value: value,
+					Message: ruleMessage(rule, fmt.Sprintf("字段%s%s", rule.FieldName, msg))})
+			}
+		case "regex":
+			reg, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				continue
+			}
+			if !reg.MatchString(valueToString(value)) {
+				violations = append(violations, FieldViolation{Field: rule.FieldName, Rule: "regex", Value: value,
+					Message: ruleMessage(rule, fmt.Sprintf("字段%s格式不正确", rule.FieldName))})
+			}
+		}
+	}
+	return violations
+}
+
+// checkAttributeRules 编辑前校验属性，未通过时返回422并中止请求
+func checkAttributeRules(c *gin.Context, db *gorm.DB, tableName string, fc geojson.FeatureCollection, isInsert bool) bool {
+	var violations []FieldViolation
+	for _, feature := range fc.Features {
+		if feature == nil {
+			continue
+		}
+		violations = append(violations, validateAttributes(db, tableName, feature.Properties, isInsert)...)
+	}
+	if len(violations) == 0 {
+		return true
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"code":    422,
+		"message": "属性校验未通过: " + violations[0].Message,
+		"errors":  violations,
+	})
+	return false
+}
+
+// validateLayerRules 按字段规则检查图层中已有数据，limit为每条规则最多返回的错误数
+func validateLayerRules(db *gorm.DB, tableName string, limit int) []FieldViolation {
+	return queryRuleViolations(db, tableName, limit, nil)
+}
+
+// queryRuleViolations 按字段规则查询违规要素，scope返回字段（小写）附加的过滤条件，
+// 返回false时跳过该字段的规则，scope为nil时检查全表
+func queryRuleViolations(db *gorm.DB, tableName string, limit int, scope func(field string) (string, bool)) []FieldViolation {
+	rules, domains := loadFieldRules(db, tableName)
+	var violations []FieldViolation
+	numReg := `'^\s*-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?\s*$'`
+	for _, rule := range rules {
+		col := fmt.Sprintf(`"%s"`, strings.ToLower(rule.FieldName))
+		extra := ""
+		if scope != nil {
+			cond, ok := scope(strings.ToLower(rule.FieldName))
+			if !ok {
+				continue
+			}
+			extra = " AND " + cond
+		}
+		var where string
+		var args []interface{}
+		message := ""
+		ruleType := rule.RuleType
+		minValue, maxValue := rule.MinValue, rule.MaxValue
+		switch rule.RuleType {
+		case "required":
+			where = fmt.Sprintf(`%s IS NULL OR TRIM(%s::text) = ''`, col, col)
+			message = ruleMessage(rule, fmt.Sprintf("字段%s不能为空", rule.FieldName))
+		case "regex":
+			where = fmt.Sprintf(`%s IS NOT NULL AND %s::text <> '' AND %s::text !~ ?`, col, col, col)
+			args = append(args, rule.Pattern)
+			message = ruleMessage(rule, fmt.Sprintf("字段%s格式不正确", rule.FieldName))
+		case "domain":
+			domain, ok := domains[rule.DomainName]
+			if !ok {
+				continue
+			}
+			if domain.DomainType == "range" {
+				ruleType = "range"
+				minValue, maxValue = domain.MinValue, domain.MaxValue
+				message = ruleMessage(rule, fmt.Sprintf("字段%s超出属性域%s的范围", rule.FieldName, domain.Name))
+				break
+			}
+			var codes []models.CodedValue
+			json.Unmarshal(domain.CodedValues, &codes)
+			codeList := make([]string, 0, len(codes))
+			for _, code := range codes {
+				codeList = append(codeList, code.Code)
+			}
+			if len(codeList) == 0 {
+				continue
+			}
+			where = fmt.Sprintf(`%s IS NOT NULL AND TRIM(%s::text) <> '' AND TRIM(%s::text) NOT IN ?`, col, col, col)
+			args = append(args, codeList)
+			message = ruleMessage(rule, fmt.Sprintf("字段%s的值不在属性域%s中", rule.FieldName, domain.Name))
+		case "range":
+			message = ruleMessage(rule, fmt.Sprintf("字段%s超出范围", rule.FieldName))
+		default:
+			continue
+		}
+		if ruleType == "range" {
+			num := fmt.Sprintf(`(CASE WHEN %s::text ~ %s THEN %s::text::float8 END)`, col, numReg, col)
+			conds := []string{fmt.Sprintf(`%s::text !~ %s`, col, numReg)}
+			if minValue != nil {
+				conds = append(conds, num+" < ?")
+				args = append(args, *minValue)
+			}
+			if maxValue != nil {
+				conds = append(conds, num+" > ?")
+				args = append(args, *maxValue)
+			}
+			where = fmt.Sprintf(`%s IS NOT NULL AND TRIM(%s::text) <> '' AND (%s)`, col, col, strings.Join(conds, " OR "))
+		}
+
+		var rows []struct {
+			ID    int32
+			Value *string
+		}
+		sql := fmt.Sprintf(`SELECT id, %s::text AS value FROM "%s" WHERE (%s)%s ORDER BY id LIMIT %d`, col, tableName, where, extra, limit)
+		if err := db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+			continue
+		}
+		for _, row := range rows {
+			var value interface{}
+			if row.Value != nil {
+				value = *row.Value
+			}
+			violations = append(violations, FieldViolation{FeatureID: row.ID, Field: rule.FieldName, Rule: rule.RuleType, Value: value, Message: message})
+		}
+	}
+	return violations
+}
+
+// ==================== 属性域管理 ====================
+
+// ListDomains 查询属性域
+func (uc *UserController) ListDomains(c *gin.Context) {
+	DB := models.DB
+	var domains []models.AttributeDomain
+	query := DB.Order("name")
+	if name := c.Query("Name"); name != "" {
+		query = query.Where("name LIKE ?", "%"+name+"%")
+	}
+	query.Find(&domains)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": domains})
+}
+
+// SaveDomain 新增或修改属性域
+func (uc *UserController) SaveDomain(c *gin.Context) {
+	var jsonData models.AttributeDomain
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if jsonData.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "属性域名称不能为空"})
+		return
+	}
+	switch jsonData.DomainType {
+	case "coded":
+		var codes []models.CodedValue
+		if err := json.Unmarshal(jsonData.CodedValues, &codes); err != nil || len(codes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "编码值域的CodedValues不能为空"})
+			return
+		}
+	case "range":
+		if jsonData.MinValue == nil && jsonData.MaxValue == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "范围域至少需要MinValue或MaxValue"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "DomainType只能为coded或range"})
+		return
+	}
+	DB := models.DB
+	var existing models.AttributeDomain
+	if err := DB.Where("name = ?", jsonData.Name).First(&existing).Error; err == nil {
+		jsonData.ID = existing.ID
+	}
+	if jsonData.Source == "" {
+		jsonData.Source = "manual"
+	}
+	jsonData.UpdatedAt = timeNowStr()
+	if err := DB.Save(&jsonData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功", "data": jsonData})
+}
+
+// DelDomain 删除属性域，被字段规则引用时不允许删除
+func (uc *UserController) DelDomain(c *gin.Context) {
+	Name := c.Query("Name")
+	DB := models.DB
+	var count int64
+	DB.Model(&models.FieldRule{}).Where("domain_name = ?", Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": fmt.Sprintf("属性域仍被%d条字段规则引用", count)})
+		return
+	}
+	DB.Where("name = ?", Name).Delete(&models.AttributeDomain{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "删除成功"})
+}
+
+// ImportGDBDomains 从GDB导入属性域，并为来源于该GDB的图层重建字段规则
+func (uc *UserController) ImportGDBDomains(c *gin.Context) {
+	GDBPath := c.PostForm("VectorPath")
+	if GDBPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "VectorPath不能为空"})
+		return
+	}
+	info, err := pgmvt.ReadGDBDomains(GDBPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "读取属性域失败: " + err.Error()})
+		return
+	}
+	DB := models.DB
+	pgmvt.SaveGDBDomains(DB, info)
+
+	ruleCount := 0
+	var schemas []models.MySchema
+	DB.Where("source::text LIKE ?", "%"+strings.ReplaceAll(GDBPath, `\`, `\\\\`)+"%").Find(&schemas)
+	for _, schema := range schemas {
+		configs, err := parseSourceConfig(schema.Source)
+		if err != nil || len(configs) == 0 {
+			continue
+		}
+		attMap := make([]pgmvt.ProcessedFieldInfo, 0, len(configs[0].AttMap))
+		for _, f := range configs[0].AttMap {
+			attMap = append(attMap, pgmvt.ProcessedFieldInfo{OriginalName: f.OriginalName, ProcessedName: f.ProcessedName, DBType: f.DBType})
+		}
+		ruleCount += pgmvt.SaveGDBFieldRules(DB, info, configs[0].SourceLayerName, schema.EN, attMap)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": fmt.Sprintf("导入属性域%d个，字段规则%d条", len(info.Domains), ruleCount),
+		"data":    info.Domains,
+	})
+}
+
+// ==================== 字段规则管理 ====================
+
+// ListFieldRules 查询图层字段规则
+func (uc *UserController) ListFieldRules(c *gin.Context) {
+	TableName := c.Query("TableName")
+	DB := models.DB
+	var rules []models.FieldRule
+	DB.Where("table_name = ?", TableName).Order("field_name, id").Find(&rules)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": rules})
+}
+
+// SaveFieldRule 新增或修改字段规则
+func (uc *UserController) SaveFieldRule(c *gin.Context) {
+	var jsonData models.FieldRule
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if jsonData.TableName == "" || jsonData.FieldName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "TableName和FieldName不能为空"})
+		return
+	}
+	DB := models.DB
+	switch jsonData.RuleType {
+	case "domain":
+		var count int64
+		DB.Model(&models.AttributeDomain{}).Where("name = ?", jsonData.DomainName).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "属性域不存在: " + jsonData.DomainName})
+			return
+		}
+	case "regex":
+		if _, err := regexp.Compile(jsonData.Pattern); err != nil || jsonData.Pattern == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "正则表达式无效"})
+			return
+		}
+	case "range":
+		if jsonData.MinValue == nil && jsonData.MaxValue == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "范围规则至少需要MinValue或MaxValue"})
+			return
+		}
+	case "required":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "RuleType只能为domain、range、regex或required"})
+		return
+	}
+	jsonData.FieldName = strings.ToLower(jsonData.FieldName)
+	if jsonData.Source == "" {
+		jsonData.Source = "manual"
+	}
+	if jsonData.ID == 0 {
+		jsonData.Enabled = true
+	}
+	if err := DB.Save(&jsonData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功", "data": jsonData})
+}
+
+// DelFieldRule 删除字段规则
+func (uc *UserController) DelFieldRule(c *gin.Context) {
+	ID := c.Query("ID")
+	DB := models.DB
+	DB.Where("id = ?", ID).Delete(&models.FieldRule{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "删除成功"})
+}
+
+type validateAttributesData struct {
+	TableName  string                 `json:"TableName"`
+	Properties map[string]interface{} `json:"Properties"`
+	IsInsert   bool                   `json:"IsInsert"`
+}
+
+// ValidateAttributes 编辑前预校验属性
+func (uc *UserController) ValidateAttributes(c *gin.Context) {
+	var jsonData validateAttributesData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	violations := validateAttributes(models.DB, jsonData.TableName, jsonData.Properties, jsonData.IsInsert)
+	if violations == nil {
+		violations = []FieldViolation{}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "valid": len(violations) == 0, "errors": violations})
+}
+
+// ValidateLayer 检查图层现有数据是否符合字段规则
+func (uc *UserController) ValidateLayer(c *gin.Context) {
+	TableName := c.Query("TableName")
+	limit, _ := strconv.Atoi(c.DefaultQuery("Limit", "1000"))
+	if limit <= 0 {
+		limit = 1000
+	}
+	violations := validateLayerRules(models.DB, TableName, limit)
+	if violations == nil {
+		violations = []FieldViolation{}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "valid": len(violations) == 0, "count": len(violations), "errors": violations})
+}
+
+// exportLayerDomains 收集图层字段规则引用的属性域，字段名映射回GDB字段名
+func exportLayerDomains(db *gorm.DB, tableName string, layerName string, fieldMapping map[string]string, info *pgmvt.GDBDomainInfo) {
+	var rules []models.FieldRule
+	db.Where("table_name = ? AND enabled = ? AND rule_type IN ?", tableName, true, []string{"domain", "required"}).Find(&rules)
+	if len(rules) == 0 {
+		return
+	}
+	bindings := make(map[string]*pgmvt.GDBFieldDomain)
+	var order []string
+	for _, rule := range rules {
+		gdbField := rule.FieldName
+		if mapped, ok := fieldMapping[rule.FieldName]; ok {
+			gdbField = mapped
+		}
+		b, ok := bindings[gdbField]
+		if !ok {
+			b = &pgmvt.GDBFieldDomain{FieldName: gdbField, IsNullable: true}
+			bindings[gdbField] = b
+			order = append(order, gdbField)
+		}
+		if rule.RuleType == "required" {
+			b.IsNullable = false
+		} else {
+			b.DomainName = rule.DomainName
+		}
+	}
+	index := make(map[string]int)
+	for i, d := range info.Domains {
+		index[d.Name] = i
+	}
+	fields := make([]pgmvt.GDBFieldDomain, 0, len(order))
+	for _, name := range order {
+		b := bindings[name]
+		fields = append(fields, *b)
+		if b.DomainName == "" {
+			continue
+		}
+		// 以数据库中的定义为准
+		var domain models.AttributeDomain
+		if err := db.Where("name = ?", b.DomainName).First(&domain).Error; err != nil {
+			continue
+		}
+		if i, ok := index[domain.Name]; ok {
+			info.Domains[i] = domain
+		} else {
+			index[domain.Name] = len(info.Domains)
+			info.Domains = append(info.Domains, domain)
+		}
+	}
+	info.LayerFields[strings.ToLower(layerName)] = fields
+}
+
+// respondImportResult 导入后按字段规则检查数据，没有违规时保持原有的文本响应，
+// 有违规时以JSON返回各图层的违规明细
+func respondImportResult(c *gin.Context, db *gorm.DB, message string, tables []string) {
+	violations := make(map[string][]FieldViolation)
+	for _, table := range tables {
+		if table == "" {
+			continue
+		}
+		if v := validateLayerRules(db, table, 1000); len(v) > 0 {
+			violations[table] = v
+		}
+	}
+	if len(violations) == 0 {
+		c.String(http.StatusOK, message)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":       200,
+		"message":    message + "，部分属性不符合字段规则",
+		"violations": violations,
+	})
+}
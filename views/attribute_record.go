@@ -0,0 +1,449 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 属性变更记录：单要素属性修改和批量字段计算记录每个字段的前后值，可通过BackUpRecord回退
+
+const (
+	attributeEditType     = "属性修改"
+	attributeBulkEditType = "批量属性修改"
+)
+
+// attributeAudit 一次属性更新的记录参数
+type attributeAudit struct {
+	TableName string
+	Fields    []string
+	Where     string // 受影响要素的条件，为空时为全表
+	Args      []interface{}
+	Username  string
+	BZ        string
+	Type      string
+	GeoID     int32
+}
+
+// attributeLockError 更新范围内的要素被他人锁定
+type attributeLockError struct {
+	Conflicts []models.FeatureLock
+}
+
+func (e *attributeLockError) Error() string {
+	return fmt.Sprintf("要素已被%s锁定，暂不能编辑", e.Conflicts[0].Username)
+}
+
+// attributeRuleError 更新后的属性不符合字段规则
+type attributeRuleError struct {
+	Violations []FieldViolation
+}
+
+func (e *attributeRuleError) Error() string {
+	return "属性校验未通过: " + e.Violations[0].Message
+}
+
+// attributeValueString 将属性值转为记录中保存的文本，与PostgreSQL的 ::text 结果保持一致
+func attributeValueString(v interface{}) *string {
+	var s string
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		s = val
+	case float64:
+		s = strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(val)
+	case json.Number:
+		s = val.String()
+	default:
+		data, _ := json.Marshal(val)
+		s = string(data)
+	}
+	return &s
+}
+
+// tableColumns 返回表的字段名（小写）
+func tableColumns(db *gorm.DB, tableName string) map[string]bool {
+	var columns []string
+	db.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = ?`,
+		tableName).Scan(&columns)
+	result := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		result[strings.ToLower(col)] = true
+	}
+	return result
+}
+
+// attributeFieldType 字段的完整类型定义，回退时用于将文本转回原类型
+func attributeFieldType(db *gorm.DB, tableName, fieldName string) (string, error) {
+	var fieldType string
+	err := db.Raw(`
+		SELECT format_type(a.atttypid, a.atttypmod) FROM pg_attribute a
+		WHERE a.attrelid = ?::regclass AND a.attname = ? AND NOT a.attisdropped`,
+		fmt.Sprintf(`"%s"`, tableName), fieldName).Scan(&fieldType).Error
+	if err != nil {
+		return "", err
+	}
+	if fieldType == "" {
+		return "", fmt.Errorf("字段 %s 在表 %s 中不存在", fieldName, tableName)
+	}
+	return fieldType, nil
+}
+
+// auditAttributeUpdate 在事务中执行属性更新并记录变更
+// 更新前对受影响要素的字段做快照，更新后逐字段比较写入AttributeRecord，并生成一条可回退的GeoRecord
+// 没有实际变化时不生成记录，返回nil
+func auditAttributeUpdate(db *gorm.DB, audit attributeAudit, update func(tx *gorm.DB) error) (*models.GeoRecord, int64, error) {
+	columns := tableColumns(db, audit.TableName)
+	fields := make([]string, 0, len(audit.Fields))
+	for _, f := range audit.Fields {
+		f = strings.ToLower(f)
+		if f == "id" || f == "geom" {
+			continue
+		}
+		if !columns[f] {
+			return nil, 0, fmt.Errorf("字段 %s 在表 %s 中不存在", f, audit.TableName)
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, 0, fmt.Errorf("没有需要更新的字段")
+	}
+	// 批量属性更新直接写线上表，无法在版本中隔离
+	if inActiveVersion(db, audit.TableName, audit.Username, 0) {
+		return nil, 0, errVersionUnsupported
+	}
+
+	var record *models.GeoRecord
+	var changed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 快照
+		selects := make([]string, 0, len(fields))
+		for i, f := range fields {
+			selects = append(selects, fmt.Sprintf(`"%s"::text AS f%d`, f, i))
+		}
+		snapshot := fmt.Sprintf(`CREATE TEMP TABLE attr_before ON COMMIT DROP AS SELECT t.id, %s FROM "%s" AS t`,
+			strings.Join(selects, ", "), audit.TableName)
+		if audit.Where != "" {
+			snapshot += " WHERE " + audit.Where
+		}
+		if err := tx.Exec(snapshot, audit.Args...).Error; err != nil {
+			return fmt.Errorf("属性快照失败: %v", err)
+		}
+
+		// 锁检查
+		var conflicts []models.FeatureLock
+		tx.Raw(fmt.Sprintf(`
+			SELECT l.* FROM feature_lock l
+			WHERE l.table_name = ? AND l.username <> ? AND l.expires_at > ? AND (
+				(l.lock_type = 'feature' AND l.feature_id IN (SELECT id FROM attr_before))
+				OR (l.lock_type = 'extent' AND EXISTS (
+					SELECT 1 FROM "%s" t JOIN attr_before b ON b.id = t.id
+					WHERE ST_Intersects(t.geom, ST_SetSRID(ST_GeomFromGeoJSON(l.extent::text), 4326))
+				))
+			)`, audit.TableName), audit.TableName, audit.Username, timeNowStr()).Scan(&conflicts)
+		if len(conflicts) > 0 {
+			return &attributeLockError{Conflicts: conflicts}
+		}
+
+		if err := update(tx); err != nil {
+			return err
+		}
+
+		// 按字段规则校验更新后的值，只检查本次实际发生变化的要素
+		fieldIndex := make(map[string]int, len(fields))
+		for i, f := range fields {
+			fieldIndex[f] = i
+		}
+		violations := queryRuleViolations(tx, audit.TableName, 100, func(field string) (string, bool) {
+			i, ok := fieldIndex[field]
+			if !ok {
+				return "", false
+			}
+			return fmt.Sprintf(`id IN (SELECT b.id FROM attr_before b JOIN "%s" t ON t.id = b.id WHERE t."%s"::text IS DISTINCT FROM b.f%d)`,
+				audit.TableName, field, i), true
+		})
+		if len(violations) > 0 {
+			return &attributeRuleError{Violations: violations}
+		}
+
+		// 实际发生变化的要素，没有变化时不占用会话序号、不清理重做记录
+		diffs := make([]string, 0, len(fields))
+		for i, f := range fields {
+			diffs = append(diffs, fmt.Sprintf(`t."%s"::text IS DISTINCT FROM b.f%d`, f, i))
+		}
+		var ids []int32
+		if err := tx.Raw(fmt.Sprintf(`SELECT t.id FROM "%s" t JOIN attr_before b ON b.id = t.id WHERE %s ORDER BY t.id`,
+			audit.TableName, strings.Join(diffs, " OR "))).Scan(&ids).Error; err != nil {
+			return fmt.Errorf("比较属性变化失败: %v", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		changed = int64(len(ids))
+
+		session := GetOrCreateSession(tx, audit.TableName, audit.Username)
+		// 属性修改的输入即输出，后续对这些要素的操作依赖本次修改，回退时级联
+		record = &models.GeoRecord{
+			TableName: audit.TableName,
+			GeoID:     audit.GeoID,
+			Username:  audit.Username,
+			Type:      audit.Type,
+			Date:      timeNowStr(),
+			BZ:        audit.BZ,
+			SessionID: session.ID,
+			SeqNo:     GetNextSeqNo(tx, session.ID),
+			InputIDs:  MarshalIDs(ids),
+			OutputIDs: MarshalIDs(ids),
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		for i, f := range fields {
+			err := tx.Exec(fmt.Sprintf(`
+				INSERT INTO attribute_record (geo_record_id, table_name, geo_id, field_name, old_value, new_value, username, session_id, date)
+				SELECT ?, ?, t.id, ?, b.f%d, t."%s"::text, ?, ?, ?
+				FROM "%s" t JOIN attr_before b ON b.id = t.id
+				WHERE t."%s"::text IS DISTINCT FROM b.f%d`, i, f, audit.TableName, f, i),
+				record.ID, audit.TableName, f, audit.Username, session.ID, record.Date).Error
+			if err != nil {
+				return fmt.Errorf("写入属性变更记录失败: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if record != nil {
+		pgmvt.DelMVTALL(db, audit.TableName)
+	}
+	return record, changed, nil
+}
+
+// recordFeatureAttributeDiffs 要素修改时写入属性变更明细，仅供查询，回退仍使用GeoRecord中的完整要素
+func recordFeatureAttributeDiffs(db *gorm.DB, record models.GeoRecord, oldProps, newProps map[string]interface{}) {
+	oldLower := lowerProperties(oldProps)
+	var rows []models.AttributeRecord
+	for key, value := range lowerProperties(newProps) {
+		if key == "id" || key == "geom" {
+			continue
+		}
+		oldValue, newValue := attributeValueString(oldLower[key]), attributeValueString(value)
+		if (oldValue == nil) == (newValue == nil) && (oldValue == nil || *oldValue == *newValue) {
+			continue
+		}
+		rows = append(rows, models.AttributeRecord{
+			GeoRecordID: record.ID,
+			TableName:   record.TableName,
+			GeoID:       record.GeoID,
+			FieldName:   key,
+			OldValue:    oldValue,
+			NewValue:    newValue,
+			Username:    record.Username,
+			SessionID:   record.SessionID,
+			Date:        record.Date,
+		})
+	}
+	if len(rows) > 0 {
+		db.Create(&rows)
+	}
+}
+
+// applyAttributeRecord 将属性修改记录中的旧值(回退)或新值(重做)写回图层
+func applyAttributeRecord(db *gorm.DB, record models.GeoRecord, useOld bool) error {
+	var fields []string
+	db.Model(&models.AttributeRecord{}).Where("geo_record_id = ?", record.ID).
+		Distinct("field_name").Pluck("field_name", &fields)
+	valueColumn := "new_value"
+	if useOld {
+		valueColumn = "old_value"
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, f := range fields {
+			fieldType, err := attributeFieldType(tx, record.TableName, f)
+			if err != nil {
+				return err
+			}
+			err = tx.Exec(fmt.Sprintf(`
+				UPDATE "%s" AS t SET "%s" = a.%s::%s
+				FROM attribute_record a
+				WHERE a.geo_record_id = ? AND a.field_name = ? AND a.geo_id = t.id`,
+				record.TableName, f, valueColumn, fieldType), record.ID, f).Error
+			if err != nil {
+				return fmt.Errorf("字段 %s 写回失败: %v", f, err)
+			}
+		}
+		return nil
+	})
+}
+
+// attributeAuditRejected 更新因锁冲突或字段规则被拒绝，需要返回结构化的冲突或违规明细
+func attributeAuditRejected(err error) bool {
+	var lockErr *attributeLockError
+	var ruleErr *attributeRuleError
+	return errors.As(err, &lockErr) || errors.As(err, &ruleErr) || errors.Is(err, errVersionUnsupported)
+}
+
+// respondAttributeAuditError 属性更新失败时的响应，锁冲突返回423，不符合字段规则返回422，版本中不支持时返回409
+func respondAttributeAuditError(c *gin.Context, err error) {
+	if errors.Is(err, errVersionUnsupported) {
+		c.JSON(http.StatusConflict, gin.H{"code": 409, "message": err.Error(), "data": ""})
+		return
+	}
+	var lockErr *attributeLockError
+	if errors.As(err, &lockErr) {
+		c.JSON(http.StatusLocked, gin.H{"code": 423, "message": lockErr.Error(), "data": lockErr.Conflicts})
+		return
+	}
+	var ruleErr *attributeRuleError
+	if errors.As(err, &ruleErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"code": 422, "message": ruleErr.Error(), "errors": ruleErr.Violations})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+}
+
+type changeAttributesData struct {
+	TableName  string                 `json:"TableName"`
+	ID         int32                  `json:"ID"`
+	Properties map[string]interface{} `json:"Properties"`
+	Username   string
+	BZ         string
+	SessionID  int64
+}
+
+// ChangeAttributes 只修改要素属性，记录各字段前后值
+func (uc *UserController) ChangeAttributes(c *gin.Context) {
+	var jsonData changeAttributesData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	props := lowerProperties(jsonData.Properties)
+	delete(props, "id")
+	delete(props, "geom")
+	if jsonData.TableName == "" || len(props) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "需要TableName和Properties"})
+		return
+	}
+	DB := models.DB
+	checkFC := geojson.FeatureCollection{Features: []*geojson.Feature{{Type: "Feature", Properties: props}}}
+	if !checkAttributeRules(c, DB, jsonData.TableName, checkFC, false) {
+		return
+	}
+	current := GetGeo(getData{ID: jsonData.ID, TableName: jsonData.TableName})
+	if !liveFeatureExists(DB, jsonData.TableName, jsonData.ID) || len(current.Features) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "要素不存在"})
+		return
+	}
+
+	// 版本化会话：合并属性后写入会话变更
+	if session, ok := versionSession(DB, jsonData.SessionID, jsonData.TableName); ok {
+		if !checkEditLocks(c, DB, jsonData.TableName, jsonData.Username, []int32{jsonData.ID}) {
+			return
+		}
+		feature := current.Features[0]
+		for k, v := range props {
+			feature.Properties[k] = v
+		}
+		fc := geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}
+		updated, err := versionChangeFeature(DB, session, jsonData.ID, fc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": "修改成功", "data": updated})
+		return
+	}
+
+	fields := make([]string, 0, len(props))
+	for k := range props {
+		fields = append(fields, k)
+	}
+	record, changed, err := auditAttributeUpdate(DB, attributeAudit{
+		TableName: jsonData.TableName,
+		Fields:    fields,
+		Where:     "id = ?",
+		Args:      []interface{}{jsonData.ID},
+		Username:  jsonData.Username,
+		BZ:        jsonData.BZ,
+		Type:      attributeEditType,
+		GeoID:     jsonData.ID,
+	}, func(tx *gorm.DB) error {
+		return tx.Table(jsonData.TableName).Where("id = ?", jsonData.ID).Updates(props).Error
+	})
+	if err != nil {
+		respondAttributeAuditError(c, err)
+		return
+	}
+	var details []models.AttributeRecord
+	if record != nil {
+		DB.Where("geo_record_id = ?", record.ID).Find(&details)
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "修改成功", "data": gin.H{
+		"record":  record,
+		"changed": changed,
+		"details": details,
+	}})
+}
+
+// GetAttributeHistory 查询属性变更记录，可按要素、字段、操作记录过滤
+func (uc *UserController) GetAttributeHistory(c *gin.Context) {
+	tableName := c.Query("TableName")
+	if tableName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "需要TableName"})
+		return
+	}
+	DB := models.DB
+	query := DB.Model(&models.AttributeRecord{}).Where("attribute_record.table_name = ?", tableName)
+	if id := c.Query("ID"); id != "" {
+		query = query.Where("attribute_record.geo_id = ?", id)
+	}
+	if field := c.Query("Field"); field != "" {
+		query = query.Where("attribute_record.field_name = ?", strings.ToLower(field))
+	}
+	if recordID := c.Query("RecordID"); recordID != "" {
+		query = query.Where("attribute_record.geo_record_id = ?", recordID)
+	}
+	if username := c.Query("Username"); username != "" {
+		query = query.Where("attribute_record.username = ?", username)
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("Page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("PageSize", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 1000 {
+		pageSize = 50
+	}
+	var total int64
+	query.Count(&total)
+
+	type historyRow struct {
+		models.AttributeRecord
+		RecordType   string `json:"RecordType"`
+		RecordStatus string `json:"RecordStatus"`
+	}
+	var rows []historyRow
+	query.Select("attribute_record.*, geo_record.type AS record_type, geo_record.status AS record_status").
+		Joins("LEFT JOIN geo_record ON geo_record.id = attribute_record.geo_record_id").
+		Order("attribute_record.id DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Scan(&rows)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{
+		"total": total,
+		"list":  rows,
+	}})
+}
@@ -38,8 +38,8 @@ func findDependentRecordsRecursive(db *gorm.DB, tableName string, outputIDs []in
 	for _, oid := range outputIDs {
 		var records []models.GeoRecord
 		// 使用jsonb @> 操作符查找InputIDs中包含该oid的记录
-		db.Where("table_name = ? AND id > ? AND input_ids @> ?",
-			tableName, afterRecordID, fmt.Sprintf("[%d]", oid)).
+		db.Where("table_name = ? AND id > ? AND status = ? AND input_ids @> ?",
+			tableName, afterRecordID, "applied", fmt.Sprintf("[%d]", oid)).
 			Find(&records)
 
 		for _, r := range records {
@@ -73,12 +73,15 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		for _, oid := range outputIDs {
 			db.Table(record.TableName).Where("id = ?", oid).Delete(nil)
 		}
-		// 映射：删除新要素的映射
-		deleteDerivedMappings(db, record.TableName, outputIDs)
+		// 映射：标记新要素的映射为已删除（保留以便重做）
+		MarkMappingDeleted(db, record.TableName, outputIDs)
 
 		var fc geojson.FeatureCollection
 		json.Unmarshal(record.NewGeojson, &fc)
-		if len(fc.Features) > 0 {
+		// 一条记录添加了多个要素（如变更检测应用）时清理全部瓦片
+		if len(outputIDs) > 1 {
+			pgmvt.DelMVTALL(db, record.TableName)
+		} else if len(fc.Features) > 0 {
 			pgmvt.DelMVT(db, record.TableName, fc.Features[0].Geometry)
 		}
 
@@ -98,7 +101,7 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		RestoreMappingActive(db, record.TableName, inputIDs)
 		pgmvt.DelMVTALL(db, record.TableName)
 
-	case "要素修改", "要素环岛构造":
+	case "要素修改":
 		// 修改的回退：用旧数据覆盖
 		var oldFC geojson.FeatureCollection
 		json.Unmarshal(record.OldGeojson, &oldFC)
@@ -106,16 +109,16 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		// 修改不改变映射关系，无需处理映射
 		pgmvt.DelMVTALL(db, record.TableName)
 
-	case "要素分割", "要素打散", "要素批量打散":
-		// 分割/打散的回退：删除新要素，恢复原要素
+	case "要素分割", "要素面积分割", "要素打散", "要素批量打散", "要素环岛构造":
+		// 分割/打散/环岛构造的回退：删除新要素，恢复原要素
 		for _, oid := range outputIDs {
 			db.Table(record.TableName).Where("id = ?", oid).Delete(nil)
 		}
 		var fc geojson.FeatureCollection
 		json.Unmarshal(record.OldGeojson, &fc)
 		methods.SavaGeojsonToTable(db, fc, record.TableName)
-		// 映射：删除派生映射，恢复原映射
-		deleteDerivedMappings(db, record.TableName, outputIDs)
+		// 映射：标记派生映射为已删除，恢复原映射
+		MarkMappingDeleted(db, record.TableName, outputIDs)
 		RestoreMappingActive(db, record.TableName, inputIDs)
 		pgmvt.DelMVTALL(db, record.TableName)
 
@@ -127,11 +130,11 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		var fc geojson.FeatureCollection
 		json.Unmarshal(record.OldGeojson, &fc)
 		methods.SavaGeojsonToTable(db, fc, record.TableName)
-		deleteDerivedMappings(db, record.TableName, outputIDs)
+		MarkMappingDeleted(db, record.TableName, outputIDs)
 		RestoreMappingActive(db, record.TableName, inputIDs)
 		pgmvt.DelMVTALL(db, record.TableName)
 
-	case "要素平移":
+	case "要素平移", changeApplyUpdateType:
 		// 平移的回退：用旧几何覆盖
 		var oldFC geojson.FeatureCollection
 		json.Unmarshal(record.OldGeojson, &oldFC)
@@ -156,6 +159,13 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		// 平移不改变映射关系
 		pgmvt.DelMVTALL(db, record.TableName)
 
+	case attributeEditType, attributeBulkEditType:
+		// 属性修改的回退：按明细写回旧值
+		if err := applyAttributeRecord(db, record, true); err != nil {
+			return err
+		}
+		pgmvt.DelMVTALL(db, record.TableName)
+
 	case "面要素去重叠":
 		// 去重叠的回退：删除分析结果，恢复原要素
 		for _, oid := range outputIDs {
@@ -164,7 +174,7 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		var fc geojson.FeatureCollection
 		json.Unmarshal(record.OldGeojson, &fc)
 		methods.SavaGeojsonToTable(db, fc, record.TableName)
-		deleteDerivedMappings(db, record.TableName, outputIDs)
+		MarkMappingDeleted(db, record.TableName, outputIDs)
 		RestoreMappingActive(db, record.TableName, inputIDs)
 		pgmvt.DelMVTALL(db, record.TableName)
 
@@ -173,51 +183,266 @@ func rollbackSingleRecord(db *gorm.DB, record models.GeoRecord) error {
 		return fmt.Errorf("未知的操作类型: %s", record.Type)
 	}
 
-	// 删除该条记录
-	db.Delete(&record)
-	return nil
+	// 保留记录并标记为已撤销，以便重做
+	return db.Model(&models.GeoRecord{}).Where("id = ?", record.ID).
+		Updates(map[string]interface{}{"status": "undone", "undone_at": timeNowStr()}).Error
 }
 
-// deleteDerivedMappings 删除派生要素的映射记录
-func deleteDerivedMappings(db *gorm.DB, tableName string, postGISIDs []int32) {
+// deleteDerivedMappings 删除会话内派生要素的映射记录
+// 要素ID按MAX(id)+1复用，限定会话避免误删其他会话中同ID的线上要素映射
+func deleteDerivedMappings(db *gorm.DB, tableName string, sessionID int64, postGISIDs []int32) {
 	if len(postGISIDs) == 0 {
 		return
 	}
-	db.Where("table_name = ? AND post_gis_id IN ?", tableName, postGISIDs).
+	db.Where("table_name = ? AND session_id = ? AND post_gis_id IN ?", tableName, sessionID, postGISIDs).
 		Delete(&models.OriginMapping{})
 }
 
-// ==================== 回退入口 ====================
+// ==================== 单条重做 ====================
 
-func (uc *UserController) BackUpRecord(c *gin.Context) {
-	ID := c.Query("ID")
-	DB := models.DB
+// findPrerequisiteRecords 递归查找重做给定记录前必须先重做的已撤销操作（其输出是当前记录的输入）
+func findPrerequisiteRecords(db *gorm.DB, tableName string, inputIDs []int32, beforeRecordID int64, seen map[int64]bool) []models.GeoRecord {
+	if len(inputIDs) == 0 {
+		return nil
+	}
 
-	var record models.GeoRecord
-	if err := DB.Where("id = ?", ID).First(&record).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    404,
-			"message": "未找到指定的编辑记录",
-		})
+	var allPrerequisites []models.GeoRecord
+
+	for _, iid := range inputIDs {
+		var records []models.GeoRecord
+		db.Where("table_name = ? AND id < ? AND status = ? AND output_ids @> ?",
+			tableName, beforeRecordID, "undone", fmt.Sprintf("[%d]", iid)).
+			Find(&records)
+
+		for _, r := range records {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				allPrerequisites = append(allPrerequisites, r)
+
+				var preInputIDs []int32
+				json.Unmarshal(r.InputIDs, &preInputIDs)
+				deeper := findPrerequisiteRecords(db, tableName, preInputIDs, r.ID, seen)
+				allPrerequisites = append(allPrerequisites, deeper...)
+			}
+		}
+	}
+
+	return allPrerequisites
+}
+
+// checkRedoConflict 检查重做前要素状态：被消费的输入要素必须存在，新生成的输出要素ID不能被占用
+func checkRedoConflict(db *gorm.DB, record models.GeoRecord, inputIDs []int32, outputIDs []int32) error {
+	consumed := make(map[int32]bool)
+	for _, id := range inputIDs {
+		consumed[id] = true
+	}
+	for _, id := range inputIDs {
+		var count int64
+		db.Table(record.TableName).Where("id = ?", id).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("输入要素ID=%d已不存在，无法重做", id)
+		}
+	}
+	for _, id := range outputIDs {
+		if consumed[id] {
+			continue
+		}
+		var count int64
+		db.Table(record.TableName).Where("id = ?", id).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("要素ID=%d已被其他操作占用，无法重做", id)
+		}
+	}
+	return nil
+}
+
+func redoSingleRecord(db *gorm.DB, record models.GeoRecord) error {
+	var inputIDs []int32
+	var outputIDs []int32
+	json.Unmarshal(record.InputIDs, &inputIDs)
+	json.Unmarshal(record.OutputIDs, &outputIDs)
+
+	if err := checkRedoConflict(db, record, inputIDs, outputIDs); err != nil {
+		return err
+	}
+
+	switch record.Type {
+	case "要素添加":
+		// 添加的重做：重新插入新要素
+		var fc geojson.FeatureCollection
+		json.Unmarshal(record.NewGeojson, &fc)
+		methods.SavaGeojsonToTable(db, fc, record.TableName)
+		RestoreMappingActive(db, record.TableName, outputIDs)
+		if len(outputIDs) > 1 {
+			pgmvt.DelMVTALL(db, record.TableName)
+		} else if len(fc.Features) > 0 {
+			pgmvt.DelMVT(db, record.TableName, fc.Features[0].Geometry)
+		}
+
+	case "要素删除", "批量要素删除":
+		// 删除的重做：再次删除原要素
+		for _, iid := range inputIDs {
+			db.Table(record.TableName).Where("id = ?", iid).Delete(nil)
+		}
+		MarkMappingDeleted(db, record.TableName, inputIDs)
+		pgmvt.DelMVTALL(db, record.TableName)
+
+	case "要素修改":
+		// 修改的重做：用新数据覆盖
+		var newFC geojson.FeatureCollection
+		json.Unmarshal(record.NewGeojson, &newFC)
+		methods.UpdateGeojsonToTable(db, newFC, record.TableName, record.GeoID)
+		pgmvt.DelMVTALL(db, record.TableName)
+
+	case "要素分割", "要素面积分割", "要素打散", "要素批量打散", "要素环岛构造", "要素合并", "要素聚合", "面要素去重叠":
+		// 派生类操作的重做：删除原要素，插入新要素
+		for _, iid := range inputIDs {
+			db.Table(record.TableName).Where("id = ?", iid).Delete(nil)
+		}
+		var fc geojson.FeatureCollection
+		json.Unmarshal(record.NewGeojson, &fc)
+		methods.SavaGeojsonToTable(db, fc, record.TableName)
+		MarkMappingDeleted(db, record.TableName, inputIDs)
+		RestoreMappingActive(db, record.TableName, outputIDs)
+		pgmvt.DelMVTALL(db, record.TableName)
+
+	case "要素平移", changeApplyUpdateType:
+		// 平移的重做：用新几何覆盖
+		var newFC geojson.FeatureCollection
+		json.Unmarshal(record.NewGeojson, &newFC)
+		for _, feature := range newFC.Features {
+			var id int32
+			switch v := feature.Properties["id"].(type) {
+			case float64:
+				id = int32(v)
+			case int:
+				id = int32(v)
+			case int32:
+				id = v
+			default:
+				log.Printf("unexpected type for id: %T", v)
+				continue
+			}
+			singleFC := geojson.FeatureCollection{}
+			singleFC.Features = append(singleFC.Features, feature)
+			methods.UpdateGeojsonToTable(db, singleFC, record.TableName, id)
+		}
+		pgmvt.DelMVTALL(db, record.TableName)
+
+	case attributeEditType, attributeBulkEditType:
+		// 属性修改的重做：按明细写入新值
+		if err := applyAttributeRecord(db, record, false); err != nil {
+			return err
+		}
+		pgmvt.DelMVTALL(db, record.TableName)
+
+	default:
+		log.Printf("未知的操作类型: %s", record.Type)
+		return fmt.Errorf("未知的操作类型: %s", record.Type)
+	}
+
+	return db.Model(&models.GeoRecord{}).Where("id = ?", record.ID).
+		Updates(map[string]interface{}{"status": "applied", "undone_at": ""}).Error
+}
+
+// ClearRedoStack 清理会话内已撤销的记录（新操作写入前调用，之后这些记录不再可重做）
+func ClearRedoStack(db *gorm.DB, sessionID int64) {
+	if sessionID == 0 {
 		return
 	}
+	var undone []models.GeoRecord
+	db.Where("session_id = ? AND status = ?", sessionID, "undone").Find(&undone)
+	for _, r := range undone {
+		var inputIDs []int32
+		var outputIDs []int32
+		json.Unmarshal(r.InputIDs, &inputIDs)
+		json.Unmarshal(r.OutputIDs, &outputIDs)
+		// 仅清理该操作新生成要素的映射，原地修改类操作的输出即输入，不能删除
+		consumed := make(map[int32]bool)
+		for _, id := range inputIDs {
+			consumed[id] = true
+		}
+		var derived []int32
+		for _, id := range outputIDs {
+			if !consumed[id] {
+				derived = append(derived, id)
+			}
+		}
+		deleteDerivedMappings(db, r.TableName, r.SessionID, derived)
+		db.Where("geo_record_id = ?", r.ID).Delete(&models.AttributeRecord{})
+		db.Delete(&r)
+	}
+}
 
-	// 获取当前记录的输出IDs
+// collectRollbackRecords 构建回退给定记录所需的完整列表（包含级联依赖），按ID倒序
+func collectRollbackRecords(db *gorm.DB, record models.GeoRecord) ([]models.GeoRecord, []models.GeoRecord) {
 	var outputIDs []int32
 	json.Unmarshal(record.OutputIDs, &outputIDs)
 
 	// 查找所有依赖于当前操作输出的后续操作
-	dependentRecords := findDependentRecords(DB, record.TableName, outputIDs, record.ID)
+	dependentRecords := findDependentRecords(db, record.TableName, outputIDs, record.ID)
 
-	// 构建需要回退的完整列表（包含当前记录）
-	allRecordsToRollback := make([]models.GeoRecord, 0, len(dependentRecords)+1)
-	allRecordsToRollback = append(allRecordsToRollback, dependentRecords...)
-	allRecordsToRollback = append(allRecordsToRollback, record)
+	allRecords := make([]models.GeoRecord, 0, len(dependentRecords)+1)
+	allRecords = append(allRecords, dependentRecords...)
+	allRecords = append(allRecords, record)
 
 	// 按ID倒序排列（最新的先回退）
-	sort.Slice(allRecordsToRollback, func(i, j int) bool {
-		return allRecordsToRollback[i].ID > allRecordsToRollback[j].ID
+	sort.Slice(allRecords, func(i, j int) bool {
+		return allRecords[i].ID > allRecords[j].ID
 	})
+	return allRecords, dependentRecords
+}
+
+// collectRedoRecords 构建重做给定记录所需的完整列表（包含前置的已撤销操作），按ID正序
+func collectRedoRecords(db *gorm.DB, record models.GeoRecord) []models.GeoRecord {
+	var inputIDs []int32
+	json.Unmarshal(record.InputIDs, &inputIDs)
+
+	seen := map[int64]bool{record.ID: true}
+	prerequisites := findPrerequisiteRecords(db, record.TableName, inputIDs, record.ID, seen)
+
+	allRecords := make([]models.GeoRecord, 0, len(prerequisites)+1)
+	allRecords = append(allRecords, prerequisites...)
+	allRecords = append(allRecords, record)
+
+	sort.Slice(allRecords, func(i, j int) bool {
+		return allRecords[i].ID < allRecords[j].ID
+	})
+	return allRecords
+}
+
+func toRecordResponses(records []models.GeoRecord) []GeoRecordResponse {
+	response := make([]GeoRecordResponse, 0, len(records))
+	for _, record := range records {
+		response = append(response, GeoRecordResponse{
+			TableName: record.TableName,
+			Username:  record.Username,
+			Type:      record.Type,
+			Date:      record.Date,
+			BZ:        record.BZ,
+			ID:        record.ID,
+			GeoID:     record.GeoID,
+			SessionID: record.SessionID,
+			SeqNo:     record.SeqNo,
+			Status:    record.Status,
+		})
+	}
+	return response
+}
+
+// ==================== 回退入口 ====================
+
+// undoRecord 回退一条记录及其级联依赖
+func undoRecord(c *gin.Context, DB *gorm.DB, record models.GeoRecord) {
+	if record.Status == "undone" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该编辑记录已撤销",
+		})
+		return
+	}
+
+	allRecordsToRollback, dependentRecords := collectRollbackRecords(DB, record)
 
 	// 逐条回退
 	rollbackErrors := make([]string, 0)
@@ -255,3 +480,168 @@ func (uc *UserController) BackUpRecord(c *gin.Context) {
 		}(),
 	})
 }
+
+// redoRecord 重做一条已撤销记录及其前置操作
+func redoRecord(c *gin.Context, DB *gorm.DB, record models.GeoRecord) {
+	if record.Status != "undone" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该编辑记录未撤销，无需重做",
+		})
+		return
+	}
+
+	allRecordsToRedo := collectRedoRecords(DB, record)
+
+	redoCount := 0
+	for _, rec := range allRecordsToRedo {
+		if err := redoSingleRecord(DB, rec); err != nil {
+			// 前置操作失败时后续操作无法继续重做
+			c.JSON(http.StatusOK, gin.H{
+				"code":      207,
+				"message":   "部分重做成功",
+				"redone":    redoCount,
+				"total":     len(allRecordsToRedo),
+				"errors":    []string{fmt.Sprintf("重做记录ID=%d失败: %v", rec.ID, err)},
+				"had_chain": len(allRecordsToRedo) > 1,
+			})
+			return
+		}
+		redoCount++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":      200,
+		"message":   "重做成功",
+		"redone":    redoCount,
+		"had_chain": len(allRecordsToRedo) > 1,
+	})
+}
+
+func (uc *UserController) BackUpRecord(c *gin.Context) {
+	ID := c.Query("ID")
+	DB := models.DB
+
+	var record models.GeoRecord
+	if err := DB.Where("id = ?", ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "未找到指定的编辑记录",
+		})
+		return
+	}
+	undoRecord(c, DB, record)
+}
+
+// PreviewBackUpRecord 预览回退某条记录时会被级联回退的依赖操作
+func (uc *UserController) PreviewBackUpRecord(c *gin.Context) {
+	ID := c.Query("ID")
+	DB := models.DB
+
+	var record models.GeoRecord
+	if err := DB.Where("id = ?", ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "未找到指定的编辑记录",
+		})
+		return
+	}
+	if record.Status == "undone" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该编辑记录已撤销",
+		})
+		return
+	}
+
+	allRecords, dependentRecords := collectRollbackRecords(DB, record)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "success",
+		"data": gin.H{
+			"record":     toRecordResponses([]models.GeoRecord{record})[0],
+			"dependents": toRecordResponses(dependentRecords),
+			"order":      toRecordResponses(allRecords),
+		},
+	})
+}
+
+// RedoRecord 重做指定的已撤销记录
+func (uc *UserController) RedoRecord(c *gin.Context) {
+	ID := c.Query("ID")
+	DB := models.DB
+
+	var record models.GeoRecord
+	if err := DB.Where("id = ?", ID).First(&record).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "未找到指定的编辑记录",
+		})
+		return
+	}
+	redoRecord(c, DB, record)
+}
+
+// UndoSession 撤销会话内最近一次生效的操作
+func (uc *UserController) UndoSession(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+
+	var record models.GeoRecord
+	if err := DB.Where("session_id = ? AND status = ?", SessionID, "applied").
+		Order("seq_no DESC").First(&record).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "该会话没有可撤销的操作",
+		})
+		return
+	}
+	undoRecord(c, DB, record)
+}
+
+// RedoSession 重做会话内最早一次撤销的操作
+func (uc *UserController) RedoSession(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+
+	var record models.GeoRecord
+	if err := DB.Where("session_id = ? AND status = ?", SessionID, "undone").
+		Order("seq_no ASC").First(&record).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "该会话没有可重做的操作",
+		})
+		return
+	}
+	redoRecord(c, DB, record)
+}
+
+// GetSessionHistory 获取会话的撤销/重做栈
+func (uc *UserController) GetSessionHistory(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+
+	var session models.EditSession
+	if err := DB.Where("id = ?", SessionID).First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    404,
+			"message": "未找到指定的编辑会话",
+		})
+		return
+	}
+
+	var applied []models.GeoRecord
+	DB.Where("session_id = ? AND status = ?", session.ID, "applied").Order("seq_no DESC").Find(&applied)
+	var undone []models.GeoRecord
+	DB.Where("session_id = ? AND status = ?", session.ID, "undone").Order("seq_no ASC").Find(&undone)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "success",
+		"data": gin.H{
+			"session":    session,
+			"undo_stack": toRecordResponses(applied),
+			"redo_stack": toRecordResponses(undone),
+		},
+	})
+}
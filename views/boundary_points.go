@@ -0,0 +1,273 @@
+package views
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/Transformer"
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 四至与界址点自动提取
+
+type boundaryRefLayer struct {
+	TableName string `json:"TableName"`
+	NameField string `json:"NameField"` // 相邻要素名称字段，为空时使用图层中文名
+}
+
+type boundaryRequest struct {
+	TBID      string             `json:"TBID"`      // 调查图斑，提取结果可写入四至字段
+	TableName string             `json:"TableName"` // 或图层要素
+	ID        int32              `json:"ID"`
+	RefLayers []boundaryRefLayer `json:"RefLayers"` // 参考图层
+	Offset    float64            `json:"Offset"`    // 采样点向外偏移距离(米)，默认0.5
+	Tolerance float64            `json:"Tolerance"` // 相邻要素搜索距离(米)，默认2
+	Interval  float64            `json:"Interval"`  // 沿边采样间距(米)，默认5
+	Default   string             `json:"Default"`   // 没有相邻要素时的四至描述
+	Save      bool               `json:"Save"`      // 写入调查图斑的四至字段
+}
+
+// boundarySample 边界外侧采样点
+type boundarySample struct {
+	Side   string
+	Weight float64
+	X      float64
+	Y      float64
+}
+
+// boundaryOuterRing 取面要素外环，多面时取面积最大的部分
+func boundaryOuterRing(geom orb.Geometry) (orb.Ring, error) {
+	switch g := geom.(type) {
+	case orb.Polygon:
+		return g[0], nil
+	case orb.MultiPolygon:
+		var best orb.Ring
+		bestArea := -1.0
+		for _, poly := range g {
+			b := poly.Bound()
+			if area := (b.Max[0] - b.Min[0]) * (b.Max[1] - b.Min[1]); area > bestArea {
+				best, bestArea = poly[0], area
+			}
+		}
+		return best, nil
+	}
+	return nil, fmt.Errorf("只有面要素才能提取界址点")
+}
+
+// boundarySamples 沿每条边等间距采样，并沿外法线偏移到宗地外侧
+func boundarySamples(points []methods.BoundaryPoint, interval, offset float64) []boundarySample {
+	var samples []boundarySample
+	for i, p := range points {
+		next := points[(i+1)%len(points)]
+		if p.Length <= 0 {
+			continue
+		}
+		dx, dy := (next.X-p.X)/p.Length, (next.Y-p.Y)/p.Length
+		// 顺时针时外侧在前进方向左边
+		nx, ny := -dy, dx
+		n := int(math.Ceil(p.Length / interval))
+		if n < 1 {
+			n = 1
+		}
+		for k := 0; k < n; k++ {
+			t := (float64(k) + 0.5) / float64(n) * p.Length
+			samples = append(samples, boundarySample{
+				Side:   p.Side,
+				Weight: p.Length / float64(n),
+				X:      p.X + dx*t + nx*offset,
+				Y:      p.Y + dy*t + ny*offset,
+			})
+		}
+	}
+	return samples
+}
+
+// ExtractBoundary 提取界址点和四至：界址点自西北角起顺时针编号，边长按所在3度带计算，
+// 四至由各边外侧最近的参考图层要素确定
+func (uc *UserController) ExtractBoundary(c *gin.Context) {
+	var req boundaryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	offset, tolerance, interval := req.Offset, req.Tolerance, req.Interval
+	if offset <= 0 {
+		offset = 0.5
+	}
+	if tolerance <= 0 {
+		tolerance = 2
+	}
+	if interval <= 0 {
+		interval = 5
+	}
+
+	var geom orb.Geometry
+	switch {
+	case req.TBID != "":
+		var layer models.TempLayer
+		if err := DB.Where("tb_id = ?", req.TBID).First(&layer).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到调查图斑", "data": ""})
+			return
+		}
+		fc, err := geojson.UnmarshalFeatureCollection(layer.Geojson)
+		if err != nil || len(fc.Features) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "调查图斑几何无效", "data": ""})
+			return
+		}
+		geom = fc.Features[0].Geometry
+	case req.TableName != "":
+		fc := GetGeo(getData{TableName: req.TableName, ID: req.ID})
+		if len(fc.Features) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到指定ID的几何数据", "data": ""})
+			return
+		}
+		geom = fc.Features[0].Geometry
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "需要指定TBID或TableName和ID", "data": ""})
+		return
+	}
+	ring, err := boundaryOuterRing(geom)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+
+	epsg := Transformer.CGCS2000ZoneEPSG(ring.Bound().Center()[0])
+	zoneGeom, err := transformGeometry(DB, orb.Polygon{ring}, 4326, epsg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "坐标转换失败: " + err.Error(), "data": ""})
+		return
+	}
+	zonePoly, ok := zoneGeom.(orb.Polygon)
+	if !ok || len(zonePoly) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "坐标转换失败", "data": ""})
+		return
+	}
+	points := methods.OrderBoundaryPoints(zonePoly[0])
+	if len(points) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "界址点不足3个", "data": ""})
+		return
+	}
+	// 界址点经纬度
+	multi := make(orb.MultiPoint, len(points))
+	for i, p := range points {
+		multi[i] = orb.Point{p.X, p.Y}
+	}
+	if lonlat, err := transformGeometry(DB, multi, epsg, 4326); err == nil {
+		if mp, ok := lonlat.(orb.MultiPoint); ok && len(mp) == len(points) {
+			for i := range points {
+				points[i].Lon, points[i].Lat = mp[i][0], mp[i][1]
+			}
+		}
+	}
+
+	// 各采样点在参考图层中的最近要素
+	samples := boundarySamples(points, interval, offset)
+	type sampleMatch struct {
+		Idx  int
+		Name string
+		Dist float64
+	}
+	best := make(map[int]sampleMatch)
+	bestLayer := make(map[int]string)
+	if len(samples) > 0 {
+		values := make([]string, len(samples))
+		for i, s := range samples {
+			values[i] = fmt.Sprintf("(%d, %f, %f)", i, s.X, s.Y)
+		}
+		// 按纬度估算的搜索范围(度)，用于空间索引过滤
+		degree := tolerance / 111000 / math.Max(math.Cos(points[0].Lat*math.Pi/180), 0.1)
+		for _, ref := range req.RefLayers {
+			var schema models.MySchema
+			if err := DB.Where("en = ?", ref.TableName).First(&schema).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": fmt.Sprintf("参考图层 %s 不存在", ref.TableName), "data": ""})
+				return
+			}
+			nameExpr := "?::text"
+			args := []interface{}{schema.CN}
+			if ref.NameField != "" {
+				nameExpr = fmt.Sprintf(`t."%s"::text`, strings.ToLower(ref.NameField))
+				args = nil
+			}
+			exclude := ""
+			if ref.TableName == req.TableName && req.TBID == "" {
+				exclude = fmt.Sprintf(" AND t.id <> %d", req.ID)
+			}
+			sql := fmt.Sprintf(`
+				SELECT s.idx, n.name, n.dist FROM (VALUES %s) AS s(idx, x, y)
+				CROSS JOIN LATERAL (SELECT ST_Transform(ST_SetSRID(ST_MakePoint(s.x, s.y), %d), 4326) AS g) p
+				CROSS JOIN LATERAL (
+					SELECT %s AS name, ST_Distance(t.geom::geography, p.g::geography) AS dist
+					FROM "%s" AS t
+					WHERE t.geom && ST_Expand(p.g, %f) AND ST_DWithin(t.geom::geography, p.g::geography, %f)%s
+					ORDER BY dist LIMIT 1
+				) n`, strings.Join(values, ", "), epsg, nameExpr, ref.TableName, degree, tolerance, exclude)
+			var matches []sampleMatch
+			if err := DB.Raw(sql, args...).Scan(&matches).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": fmt.Sprintf("查询参考图层 %s 失败: %v", ref.TableName, err), "data": ""})
+				return
+			}
+			for _, m := range matches {
+				if old, ok := best[m.Idx]; !ok || m.Dist < old.Dist {
+					best[m.Idx] = m
+					bestLayer[m.Idx] = ref.TableName
+				}
+			}
+		}
+	}
+	sides := map[string][]methods.BoundaryNeighbour{}
+	for i, s := range samples {
+		m, ok := best[i]
+		if !ok {
+			continue
+		}
+		sides[s.Side] = append(sides[s.Side], methods.BoundaryNeighbour{Name: m.Name, Layer: bestLayer[i], Length: s.Weight})
+	}
+	neighbours := methods.SummarizeNeighbours(sides, req.Default)
+
+	perimeter := 0.0
+	for _, p := range points {
+		perimeter += p.Length
+	}
+	data := gin.H{
+		"epsg":       epsg,
+		"points":     points,
+		"geojson":    boundaryPointsGeoJSON(points),
+		"perimeter":  perimeter,
+		"neighbours": neighbours,
+		"saved":      false,
+	}
+	if req.Save && req.TBID != "" {
+		result := DB.Model(&models.TempLayerAttribute{}).Where("tb_id = ?", req.TBID).Updates(map[string]interface{}{
+			"b": neighbours.B,
+			"d": neighbours.D,
+			"n": neighbours.N,
+			"x": neighbours.X,
+		})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "保存四至失败: " + result.Error.Error(), "data": data})
+			return
+		}
+		data["saved"] = result.RowsAffected > 0
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "提取完成", "data": data})
+}
+
+// boundaryPointsGeoJSON 界址点转为点要素，便于前端标注
+func boundaryPointsGeoJSON(points []methods.BoundaryPoint) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, p := range points {
+		f := geojson.NewFeature(orb.Point{p.Lon, p.Lat})
+		f.Properties["name"] = p.Name
+		f.Properties["length"] = p.Length
+		fc.Append(f)
+	}
+	return fc
+}
@@ -0,0 +1,299 @@
+package views
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 泰森多边形与最小外接几何：结果先写入工作表，再输出为新图层或临时图层
+
+// derivedOutput 结果输出方式
+type derivedOutput struct {
+	Output   string `json:"Output"`   // layer 新图层 / temp 临时图层
+	Main     string `json:"Main"`     // 新图层所属目录
+	OutTable string `json:"OutTable"` // 结果图层名称
+	MAC      string `json:"mac"`      // 临时图层所属设备
+}
+
+type voronoiRequest struct {
+	TableName  string    `json:"TableName"`
+	Filter     string    `json:"Filter"`
+	IDs        []int32   `json:"ids"`        // 只使用选中的要素
+	GroupField string    `json:"GroupField"` // 按字段分组分别生成
+	Extent     []float64 `json:"Extent"`     // 裁剪范围 minLon,minLat,maxLon,maxLat，默认为点范围外扩10%
+	derivedOutput
+}
+
+type minimumBoundingRequest struct {
+	TableName    string  `json:"TableName"`
+	Filter       string  `json:"Filter"`
+	IDs          []int32 `json:"ids"`
+	Method       string  `json:"Method"`       // convex_hull / concave_hull / rotated_rectangle / bounding_circle
+	GroupField   string  `json:"GroupField"`   // 按字段分组，为空时所有要素生成一个结果
+	ConcaveRatio float64 `json:"ConcaveRatio"` // 凹包的凸度，0最凹、1等同凸包，默认0.5
+	AllowHoles   bool    `json:"AllowHoles"`   // 凹包是否允许内洞
+	derivedOutput
+}
+
+func validateDerivedOutput(out *derivedOutput) error {
+	if out.Output == "" {
+		out.Output = "layer"
+	}
+	if out.Output != "layer" && out.Output != "temp" {
+		return fmt.Errorf("Output只能为layer或temp")
+	}
+	if out.OutTable == "" {
+		return fmt.Errorf("OutTable不能为空")
+	}
+	return nil
+}
+
+// derivedSourceWhere 源图层筛选条件，字段以t.限定
+func derivedSourceWhere(db *gorm.DB, table, filter, groupField string, ids []int32) (string, error) {
+	if !tableColumns(db, table)["geom"] {
+		return "", fmt.Errorf("图层不存在或没有几何字段: %s", table)
+	}
+	if groupField != "" && !tableColumns(db, table)[groupField] {
+		return "", fmt.Errorf("字段不存在: %s", groupField)
+	}
+	where, err := layerPointsWhere(db, table, filter)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) > 0 {
+		idList := make([]string, len(ids))
+		for i, id := range ids {
+			idList[i] = strconv.Itoa(int(id))
+		}
+		where += fmt.Sprintf(" AND t.id IN (%s)", strings.Join(idList, ","))
+	}
+	return where, nil
+}
+
+// writeDerivedOutput 将工作表写出为新图层或临时图层，工作表中除geom外的字段都作为结果属性
+func (uc *UserController) writeDerivedOutput(db *gorm.DB, work string, out derivedOutput, nameField string) (gin.H, error) {
+	var count int64
+	db.Table(work).Count(&count)
+	if count == 0 {
+		return nil, fmt.Errorf("没有生成结果")
+	}
+	if out.Output == "temp" {
+		var rows []string
+		if err := db.Raw(fmt.Sprintf(`SELECT ST_AsGeoJSON(w.*) FROM "%s" AS w`, work)).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		bsm := uuid.New().String()
+		for i, row := range rows {
+			feature, err := geojson.UnmarshalFeature([]byte(row))
+			if err != nil {
+				return nil, err
+			}
+			name := strconv.Itoa(i + 1)
+			if v, ok := feature.Properties[nameField]; ok && v != nil {
+				name = fmt.Sprint(v)
+			}
+			feature.Properties["name"] = name
+			uc.saveFeatureWithName(feature, name, out.OutTable, out.MAC, bsm, db)
+		}
+		uc.saveHeader(out.OutTable, out.MAC, bsm, time.Now().Format("2006-01-02 15:04:05"), db)
+		return gin.H{"bsm": bsm, "Count": len(rows)}, nil
+	}
+
+	fields, err := sourceLayerFields(db, work)
+	if err != nil {
+		return nil, err
+	}
+	en, err := createResultLayer(db, out.Main, out.OutTable, "polygon", fields, "")
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = fmt.Sprintf(`"%s"`, f.Name)
+	}
+	sql := fmt.Sprintf(`INSERT INTO "%s" (%s, geom) SELECT %s, ST_Multi(geom) FROM "%s"`,
+		en, strings.Join(cols, ", "), strings.Join(cols, ", "), work)
+	if err := db.Exec(sql).Error; err != nil {
+		dropResultLayer(db, en)
+		return nil, fmt.Errorf("写入结果图层失败: %v", err)
+	}
+	MakeGeoIndex(en)
+	return gin.H{"TableName": en, "CN": out.OutTable, "Count": count}, nil
+}
+
+func derivedWorkTable() string {
+	return "derived_" + strings.ReplaceAll(uuid.New().String()[:8], "-", "")
+}
+
+// VoronoiPolygons 由点图层生成泰森多边形，每个多边形携带生成点的属性
+func (uc *UserController) VoronoiPolygons(c *gin.Context) {
+	var req voronoiRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	req.TableName = strings.ToLower(req.TableName)
+	req.GroupField = strings.ToLower(req.GroupField)
+	if err := validateDerivedOutput(&req.derivedOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	if len(req.Extent) != 0 && (len(req.Extent) != 4 || req.Extent[0] >= req.Extent[2] || req.Extent[1] >= req.Extent[3]) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Extent应为 minLon,minLat,maxLon,maxLat", "data": ""})
+		return
+	}
+	where, err := derivedSourceWhere(DB, req.TableName, req.Filter, req.GroupField, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	if layerType, _ := sourceLayerType(DB, req.TableName); layerType != "point" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "泰森多边形只能由点图层生成", "data": ""})
+		return
+	}
+
+	extent := req.Extent
+	if len(extent) == 0 {
+		var box struct{ MinX, MinY, MaxX, MaxY *float64 }
+		DB.Raw(fmt.Sprintf(`SELECT ST_XMin(e) AS min_x, ST_YMin(e) AS min_y, ST_XMax(e) AS max_x, ST_YMax(e) AS max_y FROM (SELECT ST_Extent(t.geom) AS e FROM "%s" AS t WHERE %s) s`,
+			req.TableName, where)).Scan(&box)
+		if box.MinX == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "没有参与计算的点", "data": ""})
+			return
+		}
+		pad := 0.1 * math.Max(math.Max(*box.MaxX-*box.MinX, *box.MaxY-*box.MinY), 0.001)
+		extent = []float64{*box.MinX - pad, *box.MinY - pad, *box.MaxX + pad, *box.MaxY + pad}
+	}
+	envelope := fmt.Sprintf("ST_MakeEnvelope(%f, %f, %f, %f, 4326)", extent[0], extent[1], extent[2], extent[3])
+
+	fields, err := sourceLayerFields(DB, req.TableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	sel := []string{"p.id AS src_id"}
+	for _, f := range fields {
+		if f.Name != "src_id" {
+			sel = append(sel, fmt.Sprintf(`t."%s"`, f.Name))
+		}
+	}
+	// 不分组时所有点的grp均为NULL，按grp分组即为整体生成
+	grp := "NULL::text"
+	if req.GroupField != "" {
+		grp = fmt.Sprintf(`t."%s"`, req.GroupField)
+	}
+	// 生成点位于各自多边形内部，重复点合并为一个多边形时取ID最小的点
+	work := derivedWorkTable()
+	sql := fmt.Sprintf(`
+		CREATE TABLE "%s" AS
+		WITH pts AS (SELECT t.id, %s AS grp, (ST_Dump(t.geom)).geom AS g FROM "%s" AS t WHERE %s),
+		cells AS (
+			SELECT ROW_NUMBER() OVER () AS cid, grp, cell FROM (
+				SELECT grp, (ST_Dump(ST_VoronoiPolygons(ST_Collect(g), 0, %s))).geom AS cell FROM pts GROUP BY grp
+			) v
+		)
+		SELECT DISTINCT ON (c.cid) %s, ST_CollectionExtract(ST_Intersection(c.cell, %s), 3) AS geom
+		FROM cells AS c
+		JOIN pts AS p ON ST_Intersects(c.cell, p.g) AND p.grp IS NOT DISTINCT FROM c.grp
+		JOIN "%s" AS t ON t.id = p.id
+		ORDER BY c.cid, p.id`,
+		work, grp, req.TableName, where, envelope,
+		strings.Join(sel, ", "), envelope, req.TableName)
+	if err := DB.Exec(sql).Error; err != nil {
+		DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, work))
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "生成泰森多边形失败: " + err.Error(), "data": ""})
+		return
+	}
+	defer DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, work))
+	DB.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE geom IS NULL OR ST_IsEmpty(geom)`, work))
+
+	data, err := uc.writeDerivedOutput(DB, work, req.derivedOutput, "src_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "泰森多边形生成成功", "data": data})
+}
+
+// minimumBoundingExpr 最小外接几何表达式，geoms为聚合后的几何集合
+func minimumBoundingExpr(req minimumBoundingRequest, geoms string) (string, error) {
+	switch req.Method {
+	case "convex_hull":
+		return fmt.Sprintf("ST_ConvexHull(%s)", geoms), nil
+	case "concave_hull":
+		return fmt.Sprintf("ST_ConcaveHull(%s, %g, %t)", geoms, req.ConcaveRatio, req.AllowHoles), nil
+	case "rotated_rectangle":
+		return fmt.Sprintf("ST_OrientedEnvelope(%s)", geoms), nil
+	case "bounding_circle":
+		return fmt.Sprintf("ST_MinimumBoundingCircle(%s, 16)", geoms), nil
+	}
+	return "", fmt.Errorf("Method只能为convex_hull、concave_hull、rotated_rectangle或bounding_circle")
+}
+
+// MinimumBounding 按分组生成凸包、凹包、最小外接矩形或最小外接圆
+func (uc *UserController) MinimumBounding(c *gin.Context) {
+	var req minimumBoundingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	req.TableName = strings.ToLower(req.TableName)
+	req.GroupField = strings.ToLower(req.GroupField)
+	if err := validateDerivedOutput(&req.derivedOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	if req.ConcaveRatio <= 0 || req.ConcaveRatio > 1 {
+		req.ConcaveRatio = 0.5
+	}
+	expr, err := minimumBoundingExpr(req, "ST_Collect(t.geom)")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	where, err := derivedSourceWhere(DB, req.TableName, req.Filter, req.GroupField, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+
+	sel, groupBy := []string{}, ""
+	if req.GroupField != "" {
+		sel = append(sel, fmt.Sprintf(`t."%s"`, req.GroupField))
+		groupBy = fmt.Sprintf(`GROUP BY t."%s"`, req.GroupField)
+	}
+	sel = append(sel, "COUNT(*)::integer AS feat_count", fmt.Sprintf("ST_CollectionExtract(%s, 3) AS geom", expr))
+	// 共线或单个要素得到的点、线结果在提取面后为空，不输出
+	work := derivedWorkTable()
+	sql := fmt.Sprintf(`
+		CREATE TABLE "%s" AS
+		SELECT s.*, ST_Area(s.geom::geography) AS area_m2 FROM (
+			SELECT %s FROM "%s" AS t WHERE %s %s
+		) s WHERE NOT ST_IsEmpty(s.geom)`,
+		work, strings.Join(sel, ", "), req.TableName, where, groupBy)
+	if err := DB.Exec(sql).Error; err != nil {
+		DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, work))
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "生成外接几何失败: " + err.Error(), "data": ""})
+		return
+	}
+	defer DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, work))
+
+	data, err := uc.writeDerivedOutput(DB, work, req.derivedOutput, req.GroupField)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "外接几何生成成功", "data": data})
+}
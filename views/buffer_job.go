@@ -0,0 +1,361 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 缓冲区分析：距离以米计，经纬度数据按geography缓冲(PostGIS在要素所在的UTM等局部投影中计算)，
+// 支持按字段距离、多环缓冲、融合和端点样式，结果写入新图层
+
+type bufferRequest struct {
+	TableName     string          `json:"TableName"` // 源图层
+	Filter        string          `json:"Filter"`    // 源图层筛选条件，使用表达式语法
+	GeoJSON       json.RawMessage `json:"GeoJSON"`   // 或提交的FeatureCollection，经纬度
+	Distance      float64         `json:"Distance"`  // 缓冲距离(米)，面要素可为负
+	DistanceField string          `json:"DistanceField"`
+	Rings         []float64       `json:"Rings"`         // 多环缓冲的各环外侧距离
+	Dissolve      string          `json:"Dissolve"`      // none / all / field
+	DissolveField string          `json:"DissolveField"` // 按字段融合时的字段
+	EndCap        string          `json:"EndCap"`        // round / flat / square
+	Join          string          `json:"Join"`          // round / mitre / bevel
+	Segments      int             `json:"Segments"`      // 四分之一圆弧的分段数
+	KeepFields    bool            `json:"KeepFields"`    // 不融合时保留源图层字段
+	Main          string          `json:"Main"`
+	OutTable      string          `json:"OutTable"` // 结果图层名称
+}
+
+// bufferBatchSize 每批缓冲的要素数
+const bufferBatchSize = 200
+
+func validateBufferRequest(db *gorm.DB, req *bufferRequest) error {
+	req.TableName = strings.ToLower(req.TableName)
+	if (req.TableName == "") == (len(req.GeoJSON) == 0) {
+		return fmt.Errorf("TableName和GeoJSON需要且只能指定一个")
+	}
+	if req.OutTable == "" {
+		return fmt.Errorf("OutTable不能为空")
+	}
+	req.DistanceField = strings.ToLower(req.DistanceField)
+	req.DissolveField = strings.ToLower(req.DissolveField)
+	if len(req.Rings) > 0 {
+		if req.DistanceField != "" {
+			return fmt.Errorf("多环缓冲不能同时按字段指定距离")
+		}
+		sort.Float64s(req.Rings)
+		for i, d := range req.Rings {
+			if d <= 0 || (i > 0 && d == req.Rings[i-1]) {
+				return fmt.Errorf("多环缓冲距离必须为不重复的正数")
+			}
+		}
+	} else if req.DistanceField == "" && req.Distance == 0 {
+		return fmt.Errorf("需要指定缓冲距离")
+	}
+
+	switch req.Dissolve {
+	case "", "none":
+		req.Dissolve = "none"
+	case "all":
+	case "field":
+		if req.DissolveField == "" {
+			return fmt.Errorf("按字段融合需要指定DissolveField")
+		}
+	default:
+		return fmt.Errorf("Dissolve只能为none、all或field")
+	}
+	if req.KeepFields && req.Dissolve != "none" {
+		return fmt.Errorf("融合时不能保留源图层字段")
+	}
+	if req.EndCap == "" {
+		req.EndCap = "round"
+	}
+	if req.Join == "" {
+		req.Join = "round"
+	}
+	if !methods.IsStringInSlice(req.EndCap, []string{"round", "flat", "square"}) {
+		return fmt.Errorf("EndCap只能为round、flat或square")
+	}
+	if !methods.IsStringInSlice(req.Join, []string{"round", "mitre", "bevel"}) {
+		return fmt.Errorf("Join只能为round、mitre或bevel")
+	}
+	if req.Segments <= 0 {
+		req.Segments = 8
+	}
+	if req.Segments > 64 {
+		req.Segments = 64
+	}
+
+	if req.TableName != "" {
+		cols := tableColumns(db, req.TableName)
+		if !cols["geom"] {
+			return fmt.Errorf("图层不存在或没有几何字段: %s", req.TableName)
+		}
+		for _, f := range []string{req.DistanceField, req.DissolveField} {
+			if f != "" && !cols[f] {
+				return fmt.Errorf("字段不存在: %s", f)
+			}
+		}
+	} else if req.KeepFields {
+		return fmt.Errorf("GeoJSON数据不能保留源字段")
+	}
+	return nil
+}
+
+// bufferSource 缓冲的数据源，GeoJSON先写入临时表
+type bufferSource struct {
+	table    string
+	where    string
+	fromJSON bool
+}
+
+func (s bufferSource) field(name string) string {
+	if s.fromJSON {
+		return fmt.Sprintf(`(t.props->>'%s')`, strings.ReplaceAll(name, "'", "''"))
+	}
+	return fmt.Sprintf(`t."%s"`, name)
+}
+
+func prepareBufferSource(db *gorm.DB, req bufferRequest) (bufferSource, error) {
+	if req.TableName != "" {
+		src := bufferSource{table: req.TableName, where: "t.geom IS NOT NULL"}
+		if strings.TrimSpace(req.Filter) != "" {
+			filter, err := methods.CompileFilterExpression(db, req.TableName, req.Filter)
+			if err != nil {
+				return src, fmt.Errorf("筛选条件错误: %v", err)
+			}
+			src.where += " AND " + filter.SQL
+		}
+		return src, nil
+	}
+	table := "buffer_src_" + strings.ReplaceAll(uuid.New().String()[:8], "-", "")
+	if err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (id SERIAL PRIMARY KEY, props JSONB, geom GEOMETRY(Geometry, 4326))`, table)).Error; err != nil {
+		return bufferSource{}, err
+	}
+	err := db.Exec(fmt.Sprintf(`
+		INSERT INTO "%s" (props, geom)
+		SELECT f->'properties', ST_SetSRID(ST_GeomFromGeoJSON(f->>'geometry'), 4326)
+		FROM jsonb_array_elements((?)::jsonb->'features') AS f
+		WHERE f->'geometry' IS NOT NULL AND f->>'geometry' <> 'null'`, table), string(req.GeoJSON)).Error
+	if err != nil {
+		db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, table))
+		return bufferSource{}, fmt.Errorf("GeoJSON无效: %v", err)
+	}
+	return bufferSource{table: table, where: "t.geom IS NOT NULL", fromJSON: true}, nil
+}
+
+// bufferGeomExpr 单环缓冲几何，inner大于0时扣除内环
+func bufferGeomExpr(style string, outer, inner string) string {
+	buffer := func(d string) string {
+		return fmt.Sprintf("ST_Buffer(t.geom::geography, %s, '%s')::geometry", d, style)
+	}
+	if inner == "" {
+		return buffer(outer)
+	}
+	return fmt.Sprintf("ST_Difference(%s, %s)", buffer(outer), buffer(inner))
+}
+
+// runBuffer 执行缓冲分析
+func runBuffer(ctx context.Context, req bufferRequest, progress analysisProgressFunc) (interface{}, error) {
+	db := models.DB.WithContext(ctx)
+	src, err := prepareBufferSource(db, req)
+	if err != nil {
+		return nil, err
+	}
+	if src.fromJSON {
+		defer models.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, src.table))
+	}
+
+	var ids []int64
+	if err := db.Raw(fmt.Sprintf(`SELECT t.id FROM "%s" AS t WHERE %s ORDER BY t.id`, src.table, src.where)).Scan(&ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("没有需要缓冲的要素")
+	}
+
+	// 结果字段
+	fields := []resultLayerField{{Name: "src_id", Type: "INTEGER"}, {Name: "buf_dist", Type: "DOUBLE PRECISION"}}
+	if len(req.Rings) > 0 {
+		fields = append(fields, resultLayerField{Name: "inner_dist", Type: "DOUBLE PRECISION"})
+	}
+	var keep []resultLayerField
+	if req.KeepFields {
+		sourceFields, err := sourceLayerFields(db, src.table)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range sourceFields {
+			if !methods.IsStringInSlice(f.Name, []string{"src_id", "buf_dist", "inner_dist"}) {
+				keep = append(keep, f)
+			}
+		}
+		fields = append(fields, keep...)
+	}
+	if req.Dissolve == "field" {
+		fields = []resultLayerField{{Name: req.DissolveField, Type: "TEXT"}, {Name: "buf_dist", Type: "DOUBLE PRECISION"}}
+		if len(req.Rings) > 0 {
+			fields = append(fields, resultLayerField{Name: "inner_dist", Type: "DOUBLE PRECISION"})
+		}
+	} else if req.Dissolve == "all" {
+		fields = fields[1:]
+	}
+
+	en, err := createResultLayer(models.DB, req.Main, req.OutTable, "polygon", fields, "")
+	if err != nil {
+		return nil, err
+	}
+	success := false
+	target := en
+	if req.Dissolve != "none" {
+		target = en + "_work"
+		if err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (grp TEXT, buf_dist DOUBLE PRECISION, inner_dist DOUBLE PRECISION, geom GEOMETRY(Geometry, 4326))`, target)).Error; err != nil {
+			dropResultLayer(models.DB, en)
+			return nil, err
+		}
+		defer models.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, target))
+	}
+	defer func() {
+		if !success {
+			dropResultLayer(models.DB, en)
+		}
+	}()
+
+	style := fmt.Sprintf("endcap=%s join=%s quad_segs=%d", req.EndCap, req.Join, req.Segments)
+	type ring struct{ outer, inner string }
+	var rings []ring
+	switch {
+	case len(req.Rings) > 0:
+		for i, d := range req.Rings {
+			r := ring{outer: fmt.Sprintf("%g", d)}
+			if i > 0 {
+				r.inner = fmt.Sprintf("%g", req.Rings[i-1])
+			}
+			rings = append(rings, r)
+		}
+	case req.DistanceField != "":
+		rings = []ring{{outer: fmt.Sprintf("(%s)::float8", src.field(req.DistanceField))}}
+	default:
+		rings = []ring{{outer: fmt.Sprintf("%g", req.Distance)}}
+	}
+
+	// 逐批缓冲
+	bufferShare := 1.0
+	if req.Dissolve != "none" {
+		bufferShare = 0.8
+	}
+	keepNames := make([]string, len(keep))
+	keepSelect := make([]string, len(keep))
+	for i, f := range keep {
+		keepNames[i] = fmt.Sprintf(`"%s"`, strings.ToLower(f.Name))
+		keepSelect[i] = fmt.Sprintf(`t."%s"`, f.Name)
+	}
+	grpExpr := "NULL::text"
+	if req.Dissolve == "field" {
+		grpExpr = fmt.Sprintf("(%s)::text", src.field(req.DissolveField))
+	}
+	for start := 0; start < len(ids); start += bufferBatchSize {
+		end := start + bufferBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := make([]string, end-start)
+		for i, id := range ids[start:end] {
+			batch[i] = fmt.Sprintf("%d", id)
+		}
+		for _, r := range rings {
+			innerExpr := "NULL::float8"
+			if r.inner != "" {
+				innerExpr = r.inner
+			} else if len(req.Rings) > 0 {
+				innerExpr = "0"
+			}
+			geomExpr := bufferGeomExpr(style, r.outer, r.inner)
+			var sql string
+			if req.Dissolve == "none" {
+				cols := []string{"src_id", "buf_dist"}
+				sel := []string{"t.id", r.outer}
+				if len(req.Rings) > 0 {
+					cols = append(cols, "inner_dist")
+					sel = append(sel, innerExpr)
+				}
+				cols = append(append(cols, keepNames...), "geom")
+				sel = append(append(sel, keepSelect...), fmt.Sprintf("ST_Multi(ST_CollectionExtract(%s, 3))", geomExpr))
+				sql = fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT * FROM (SELECT %s FROM "%s" AS t WHERE t.id IN (%s)) s WHERE NOT ST_IsEmpty(s.geom)`,
+					target, strings.Join(cols, ", "), strings.Join(sel, ", ")+" AS geom", src.table, strings.Join(batch, ","))
+			} else {
+				sql = fmt.Sprintf(`INSERT INTO "%s" (grp, buf_dist, inner_dist, geom) SELECT * FROM (SELECT %s, %s, %s, ST_CollectionExtract(%s, 3) AS geom FROM "%s" AS t WHERE t.id IN (%s)) s WHERE NOT ST_IsEmpty(s.geom)`,
+					target, grpExpr, r.outer, innerExpr, geomExpr, src.table, strings.Join(batch, ","))
+			}
+			if err := db.Exec(sql).Error; err != nil {
+				return nil, fmt.Errorf("缓冲失败: %v", err)
+			}
+		}
+		if !progress(bufferShare*float64(end)/float64(len(ids)), fmt.Sprintf("已缓冲 %d/%d 个要素", end, len(ids))) {
+			return nil, ctx.Err()
+		}
+	}
+
+	if req.Dissolve != "none" {
+		progress(0.85, "正在融合缓冲区")
+		cols := []string{"buf_dist"}
+		sel := []string{"buf_dist"}
+		group := []string{"buf_dist"}
+		if len(req.Rings) > 0 {
+			cols = append(cols, "inner_dist")
+			sel = append(sel, "inner_dist")
+			group = append(group, "inner_dist")
+		}
+		if req.DistanceField != "" {
+			// 按字段距离缓冲时各要素距离不同，融合时不再区分距离
+			sel = []string{"NULL::float8"}
+			group = nil
+		}
+		if req.Dissolve == "field" {
+			cols = append([]string{fmt.Sprintf(`"%s"`, req.DissolveField)}, cols...)
+			sel = append([]string{"grp"}, sel...)
+			group = append([]string{"grp"}, group...)
+		}
+		sql := fmt.Sprintf(`INSERT INTO "%s" (%s, geom) SELECT %s, ST_Multi(ST_CollectionExtract(ST_Union(geom), 3)) FROM "%s"`,
+			en, strings.Join(cols, ", "), strings.Join(sel, ", "), target)
+		if len(group) > 0 {
+			sql += " GROUP BY " + strings.Join(group, ", ")
+		}
+		if err := db.Exec(sql).Error; err != nil {
+			return nil, fmt.Errorf("融合失败: %v", err)
+		}
+	}
+
+	var count int64
+	db.Table(en).Count(&count)
+	MakeGeoIndex(en)
+	success = true
+	progress(1, "缓冲分析完成")
+	return gin.H{"TableName": en, "CN": req.OutTable, "Count": count}, nil
+}
+
+// StartBuffer 创建缓冲区分析任务
+func (uc *UserController) StartBuffer(c *gin.Context) {
+	var req bufferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if err := validateBufferRequest(models.DB, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": err.Error()})
+		return
+	}
+	task := newAnalysisTask("buffer", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		return runBuffer(ctx, req, progress)
+	})
+	respondAnalysisTask(c, task, "缓冲区分析")
+}
@@ -0,0 +1,617 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 图层变更检测：按关键字段或几何相似度匹配现有图层与新交付图层的要素，
+// 逐要素判断新增、删除、几何变化、属性变化，结果写入变更图层，可选择将变化应用到现有图层并记录编辑历史
+
+// changeApplyUpdateType 应用变更时原地更新要素的记录类型，回退、重做方式与要素平移一致
+const changeApplyUpdateType = "变更检测更新"
+
+var changeTypes = []string{"added", "deleted", "geometry_changed", "attribute_changed"}
+
+type changeDetectRequest struct {
+	BaseTable        string   `json:"BaseTable"` // 现有图层
+	BaseFilter       string   `json:"BaseFilter"`
+	NewTable         string   `json:"NewTable"` // 新交付的图层
+	NewFilter        string   `json:"NewFilter"`
+	MatchMode        string   `json:"MatchMode"`     // key / geometry
+	KeyField         string   `json:"KeyField"`      // 按关键字段匹配时两表共有的字段
+	Similarity       float64  `json:"Similarity"`    // 面要素按重叠度(交集/并集)匹配的阈值，默认0.5
+	MatchDistance    float64  `json:"MatchDistance"` // 点、线要素按距离匹配的阈值(米)，默认5
+	GeomTolerance    float64  `json:"GeomTolerance"` // 判断几何变化的容差(米)，默认0.01
+	Fields           []string `json:"Fields"`        // 参与比较的字段，默认两表共有的全部字段
+	IgnoreFields     []string `json:"IgnoreFields"`
+	IncludeUnchanged bool     `json:"IncludeUnchanged"` // 变更图层中保留未变化的要素
+	Main             string   `json:"Main"`
+	OutTable         string   `json:"OutTable"`
+	Username         string   `json:"Username"`
+	layerType        string
+}
+
+func validateChangeDetectRequest(db *gorm.DB, req *changeDetectRequest) error {
+	req.BaseTable = strings.ToLower(req.BaseTable)
+	req.NewTable = strings.ToLower(req.NewTable)
+	req.KeyField = strings.ToLower(req.KeyField)
+	if req.BaseTable == req.NewTable {
+		return fmt.Errorf("需要指定两个不同的图层")
+	}
+	baseCols, newCols := tableColumns(db, req.BaseTable), tableColumns(db, req.NewTable)
+	if !baseCols["geom"] || !newCols["geom"] {
+		return fmt.Errorf("图层不存在或没有几何字段")
+	}
+	baseType, err := sourceLayerType(db, req.BaseTable)
+	if err != nil {
+		return err
+	}
+	if newType, err := sourceLayerType(db, req.NewTable); err != nil || newType != baseType {
+		return fmt.Errorf("两个图层的几何类型不一致")
+	}
+	req.layerType = baseType
+
+	switch req.MatchMode {
+	case "key":
+		if req.KeyField == "" || !baseCols[req.KeyField] || !newCols[req.KeyField] {
+			return fmt.Errorf("关键字段需要在两个图层中都存在")
+		}
+	case "geometry":
+		if req.Similarity <= 0 || req.Similarity > 1 {
+			req.Similarity = 0.5
+		}
+		if req.MatchDistance <= 0 {
+			req.MatchDistance = 5
+		}
+	default:
+		return fmt.Errorf("MatchMode只能为key或geometry")
+	}
+	if req.GeomTolerance <= 0 {
+		req.GeomTolerance = 0.01
+	}
+
+	// 比较字段：指定字段或两表共有字段，均需在两表中存在
+	if len(req.Fields) == 0 {
+		fields, err := sourceLayerFields(db, req.BaseTable)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if newCols[f.Name] {
+				req.Fields = append(req.Fields, f.Name)
+			}
+		}
+	}
+	ignore := make(map[string]bool)
+	for _, f := range req.IgnoreFields {
+		ignore[strings.ToLower(f)] = true
+	}
+	var fields []string
+	for _, f := range req.Fields {
+		f = strings.ToLower(f)
+		if f == "id" || f == "geom" || ignore[f] {
+			continue
+		}
+		if !baseCols[f] || !newCols[f] {
+			return fmt.Errorf("字段需要在两个图层中都存在: %s", f)
+		}
+		fields = append(fields, f)
+	}
+	req.Fields = fields
+
+	if req.OutTable == "" {
+		var schema models.MySchema
+		if err := db.Where("en = ?", req.BaseTable).First(&schema).Error; err == nil {
+			req.OutTable = schema.CN + "变更"
+		} else {
+			req.OutTable = req.BaseTable + "变更"
+		}
+	}
+	return nil
+}
+
+// changeShiftExpr 两个几何的豪斯多夫距离(米)，按Web墨卡托距离乘以纬度余弦近似
+func changeShiftExpr(a, b string) string {
+	return fmt.Sprintf("ST_HausdorffDistance(ST_Transform(%s, 3857), ST_Transform(%s, 3857)) * cos(radians(ST_Y(ST_Centroid(%s))))", a, b, a)
+}
+
+// changeDiffExpr 逐字段比较，结果为 {"字段": {"old": 旧值, "new": 新值}}，只包含发生变化的字段
+func changeDiffExpr(fields []string) string {
+	if len(fields) == 0 {
+		return "'{}'::jsonb"
+	}
+	// jsonb_build_object参数个数有限，分段构建后合并
+	var parts []string
+	for start := 0; start < len(fields); start += 40 {
+		end := start + 40
+		if end > len(fields) {
+			end = len(fields)
+		}
+		var args []string
+		for _, f := range fields[start:end] {
+			args = append(args, fmt.Sprintf(`'%s', CASE WHEN a."%s"::text IS DISTINCT FROM b."%s"::text THEN jsonb_build_object('old', to_jsonb(a."%s"), 'new', to_jsonb(b."%s")) END`,
+				f, f, f, f, f))
+		}
+		parts = append(parts, fmt.Sprintf("jsonb_build_object(%s)", strings.Join(args, ", ")))
+	}
+	return fmt.Sprintf(`(SELECT COALESCE(jsonb_object_agg(d.key, d.value), '{}'::jsonb) FROM jsonb_each(%s) AS d WHERE d.value <> 'null'::jsonb)`,
+		strings.Join(parts, " || "))
+}
+
+type changePair struct {
+	Bid   int64
+	Nid   int64
+	Score float64
+}
+
+// matchChangePairs 按关键字段或几何相似度建立一对一的匹配关系，写入pairs表
+func matchChangePairs(db *gorm.DB, req changeDetectRequest, baseSrc, newSrc, pairs string) error {
+	if req.MatchMode == "key" {
+		for _, src := range []string{baseSrc, newSrc} {
+			var dup int64
+			db.Raw(fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT t."%s" FROM %s t WHERE t."%s" IS NOT NULL GROUP BY t."%s" HAVING COUNT(*) > 1) d`,
+				req.KeyField, src, req.KeyField, req.KeyField)).Scan(&dup)
+			if dup > 0 {
+				return fmt.Errorf("关键字段 %s 存在 %d 个重复值，无法按关键字段匹配", req.KeyField, dup)
+			}
+		}
+		return db.Exec(fmt.Sprintf(`INSERT INTO "%s" (bid, nid) SELECT a.id, b.id FROM %s a JOIN %s b ON a."%s"::text = b."%s"::text`,
+			pairs, baseSrc, newSrc, req.KeyField, req.KeyField)).Error
+	}
+
+	// 几何匹配：面按重叠度，点、线按豪斯多夫距离，按得分从高到低贪心取一对一匹配
+	var candidates []changePair
+	var sql string
+	if req.layerType == "polygon" {
+		sql = fmt.Sprintf(`SELECT * FROM (
+			SELECT a.id AS bid, b.id AS nid, ST_Area(ST_Intersection(a.geom, b.geom)) / NULLIF(ST_Area(ST_Union(a.geom, b.geom)), 0) AS score
+			FROM %s a JOIN %s b ON ST_Intersects(a.geom, b.geom)) s WHERE s.score >= %g`,
+			baseSrc, newSrc, req.Similarity)
+	} else {
+		// 预筛选按度计，取两倍阈值以覆盖高纬度
+		sql = fmt.Sprintf(`SELECT * FROM (
+			SELECT a.id AS bid, b.id AS nid, -(%s) AS score
+			FROM %s a JOIN %s b ON ST_DWithin(a.geom, b.geom, %g)) s WHERE s.score >= %g`,
+			changeShiftExpr("a.geom", "b.geom"), baseSrc, newSrc, 2*req.MatchDistance/metersPerDegree, -req.MatchDistance)
+	}
+	if err := db.Raw(sql).Scan(&candidates).Error; err != nil {
+		return fmt.Errorf("几何匹配失败: %v", err)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	usedBase, usedNew := make(map[int64]bool), make(map[int64]bool)
+	var values []string
+	for _, p := range candidates {
+		if usedBase[p.Bid] || usedNew[p.Nid] {
+			continue
+		}
+		usedBase[p.Bid], usedNew[p.Nid] = true, true
+		values = append(values, fmt.Sprintf("(%d, %d)", p.Bid, p.Nid))
+	}
+	for start := 0; start < len(values); start += 1000 {
+		end := start + 1000
+		if end > len(values) {
+			end = len(values)
+		}
+		if err := db.Exec(fmt.Sprintf(`INSERT INTO "%s" (bid, nid) VALUES %s`, pairs, strings.Join(values[start:end], ", "))).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runChangeDetect 执行变更检测
+func runChangeDetect(ctx context.Context, req changeDetectRequest, progress analysisProgressFunc) (interface{}, error) {
+	db := models.DB.WithContext(ctx)
+	baseRecordID := maxGeoRecordID(models.DB)
+	baseWhere, err := layerPointsWhere(db, req.BaseTable, req.BaseFilter)
+	if err != nil {
+		return nil, err
+	}
+	newWhere, err := layerPointsWhere(db, req.NewTable, req.NewFilter)
+	if err != nil {
+		return nil, err
+	}
+	baseSrc := fmt.Sprintf(`(SELECT * FROM "%s" AS t WHERE %s)`, req.BaseTable, baseWhere)
+	newSrc := fmt.Sprintf(`(SELECT * FROM "%s" AS t WHERE %s)`, req.NewTable, newWhere)
+
+	pairs := "chg_" + strings.ReplaceAll(uuid.New().String()[:8], "-", "")
+	if err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (bid INTEGER, nid INTEGER)`, pairs)).Error; err != nil {
+		return nil, err
+	}
+	defer models.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, pairs))
+	if !progress(0.05, "正在匹配要素") {
+		return nil, ctx.Err()
+	}
+	if err := matchChangePairs(db, req, baseSrc, newSrc, pairs); err != nil {
+		return nil, err
+	}
+	if !progress(0.4, "正在比较几何和属性") {
+		return nil, ctx.Err()
+	}
+
+	fields := []resultLayerField{
+		{Name: "change_type", Type: "TEXT"},
+		{Name: "base_id", Type: "INTEGER"},
+		{Name: "new_id", Type: "INTEGER"},
+		{Name: "geom_changed", Type: "BOOLEAN"},
+		{Name: "attr_changed", Type: "BOOLEAN"},
+		{Name: "geom_shift", Type: "DOUBLE PRECISION"},
+		{Name: "diff_fields", Type: "TEXT"},
+		{Name: "diff", Type: "JSONB"},
+	}
+	en, err := createResultLayer(models.DB, req.Main, req.OutTable, req.layerType, fields, "")
+	if err != nil {
+		return nil, err
+	}
+	success := false
+	defer func() {
+		if !success {
+			dropResultLayer(models.DB, en)
+		}
+	}()
+
+	// 匹配要素：几何完全一致时偏移为0，否则按豪斯多夫距离与容差比较
+	matchedSQL := fmt.Sprintf(`
+		INSERT INTO "%s" (change_type, base_id, new_id, geom_changed, attr_changed, geom_shift, diff_fields, diff, geom)
+		SELECT CASE WHEN s.shift > %g THEN 'geometry_changed' WHEN s.diff <> '{}'::jsonb THEN 'attribute_changed' ELSE 'unchanged' END,
+			s.bid, s.nid, s.shift > %g, s.diff <> '{}'::jsonb, s.shift,
+			(SELECT string_agg(k, ',') FROM jsonb_object_keys(s.diff) AS k), s.diff, %s
+		FROM (
+			SELECT p.bid, p.nid, b.geom,
+				CASE WHEN ST_OrderingEquals(a.geom, b.geom) THEN 0 ELSE %s END AS shift,
+				%s AS diff
+			FROM "%s" p JOIN "%s" a ON a.id = p.bid JOIN "%s" b ON b.id = p.nid
+		) s`,
+		en, req.GeomTolerance, req.GeomTolerance, resultLayerGeomExpr(req.layerType, "s.geom"),
+		changeShiftExpr("a.geom", "b.geom"), changeDiffExpr(req.Fields), pairs, req.BaseTable, req.NewTable)
+	if err := db.Exec(matchedSQL).Error; err != nil {
+		return nil, fmt.Errorf("比较要素失败: %v", err)
+	}
+	if !progress(0.8, "正在统计新增和删除的要素") {
+		return nil, ctx.Err()
+	}
+	addedSQL := fmt.Sprintf(`INSERT INTO "%s" (change_type, new_id, geom) SELECT 'added', b.id, %s FROM %s b WHERE NOT EXISTS (SELECT 1 FROM "%s" p WHERE p.nid = b.id)`,
+		en, resultLayerGeomExpr(req.layerType, "b.geom"), newSrc, pairs)
+	deletedSQL := fmt.Sprintf(`INSERT INTO "%s" (change_type, base_id, geom) SELECT 'deleted', a.id, %s FROM %s a WHERE NOT EXISTS (SELECT 1 FROM "%s" p WHERE p.bid = a.id)`,
+		en, resultLayerGeomExpr(req.layerType, "a.geom"), baseSrc, pairs)
+	for _, sql := range []string{addedSQL, deletedSQL} {
+		if err := db.Exec(sql).Error; err != nil {
+			return nil, fmt.Errorf("统计新增和删除要素失败: %v", err)
+		}
+	}
+
+	var counts []struct {
+		ChangeType string
+		Count      int64
+	}
+	db.Raw(fmt.Sprintf(`SELECT change_type, COUNT(*) AS count FROM "%s" GROUP BY change_type`, en)).Scan(&counts)
+	record := models.ChangeDetection{
+		BaseTable:    req.BaseTable,
+		NewTable:     req.NewTable,
+		MatchMode:    req.MatchMode,
+		KeyField:     req.KeyField,
+		ChangeLayer:  en,
+		BaseRecordID: baseRecordID,
+		Status:       "已检测",
+		Username:     req.Username,
+		CreatedAt:    timeNowStr(),
+	}
+	for _, item := range counts {
+		switch item.ChangeType {
+		case "added":
+			record.Added = item.Count
+		case "deleted":
+			record.Deleted = item.Count
+		case "geometry_changed":
+			record.GeometryChanged = item.Count
+		case "attribute_changed":
+			record.AttributeChanged = item.Count
+		case "unchanged":
+			record.Unchanged = item.Count
+		}
+	}
+	var fieldCounts []struct {
+		Field string
+		Count int64
+	}
+	db.Raw(fmt.Sprintf(`SELECT k AS field, COUNT(*) AS count FROM "%s", jsonb_object_keys(diff) AS k GROUP BY k ORDER BY count DESC`, en)).Scan(&fieldCounts)
+	fieldStats := make(map[string]int64, len(fieldCounts))
+	for _, item := range fieldCounts {
+		fieldStats[item.Field] = item.Count
+	}
+	record.FieldStats, _ = json.Marshal(fieldStats)
+	record.Fields, _ = json.Marshal(req.Fields)
+	if !req.IncludeUnchanged {
+		db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE change_type = 'unchanged'`, en))
+	}
+	if err := models.DB.Create(&record).Error; err != nil {
+		return nil, err
+	}
+	MakeGeoIndex(en)
+	success = true
+	progress(1, "变更检测完成")
+	return record, nil
+}
+
+// StartChangeDetect 创建变更检测任务
+func (uc *UserController) StartChangeDetect(c *gin.Context) {
+	var req changeDetectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if err := validateChangeDetectRequest(models.DB, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": err.Error()})
+		return
+	}
+	task := newAnalysisTask("change_detect", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		return runChangeDetect(ctx, req, progress)
+	})
+	respondAnalysisTask(c, task, "变更检测")
+}
+
+// ListChangeDetections 图层的变更检测记录
+func (uc *UserController) ListChangeDetections(c *gin.Context) {
+	TableName := c.Query("TableName")
+	DB := models.DB
+	var records []models.ChangeDetection
+	query := DB.Order("id DESC")
+	if TableName != "" {
+		query = query.Where("base_table = ?", TableName)
+	}
+	query.Find(&records)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": records})
+}
+
+type changeApplyRequest struct {
+	ID        int64    `json:"ID"`
+	Types     []string `json:"Types"` // 需要应用的变化类型，默认全部
+	Username  string   `json:"Username"`
+	BZ        string   `json:"BZ"`
+	SessionID int64    `json:"SessionID"` // 版本化会话ID，版本中不支持应用变更
+}
+
+// changeLayerIDs 变更图层中指定类型的要素ID
+func changeLayerIDs(db *gorm.DB, changeLayer, column string, types []string) []int32 {
+	var ids []int32
+	db.Raw(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE change_type IN ? ORDER BY "%s"`, column, changeLayer, column), types).Scan(&ids)
+	return ids
+}
+
+// changeLayerFlaggedIDs 变更图层中几何(geom_changed)或属性(attr_changed)发生变化的现有要素ID
+func changeLayerFlaggedIDs(db *gorm.DB, changeLayer, flag string) []int32 {
+	var ids []int32
+	db.Raw(fmt.Sprintf(`SELECT base_id FROM "%s" WHERE "%s" ORDER BY base_id`, changeLayer, flag)).Scan(&ids)
+	return ids
+}
+
+// editedSinceDetection 检测开始后被编辑过的现有要素ID
+func editedSinceDetection(db *gorm.DB, detection models.ChangeDetection, ids []int32) []int32 {
+	var edited []int32
+	if len(ids) == 0 {
+		return edited
+	}
+	db.Raw(`SELECT DISTINCT e::int AS id FROM geo_record r,
+		jsonb_array_elements_text(COALESCE(r.input_ids, '[]'::jsonb) || COALESCE(r.output_ids, '[]'::jsonb)) AS e
+		WHERE r.table_name = ? AND r.id > ? AND r.status = ? AND e::int IN ? ORDER BY id`,
+		detection.BaseTable, detection.BaseRecordID, "applied", ids).Scan(&edited)
+	return edited
+}
+
+// ApplyChangeDetection 将检测出的变化应用到现有图层，删除、更新和新增分别写入编辑记录，可逐条回退
+func (uc *UserController) ApplyChangeDetection(c *gin.Context) {
+	var req changeApplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	var detection models.ChangeDetection
+	if err := DB.First(&detection, req.ID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到变更检测记录", "data": ""})
+		return
+	}
+	if detection.Status == "已应用" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "该变更已应用", "data": ""})
+		return
+	}
+	if len(req.Types) == 0 {
+		req.Types = changeTypes
+	}
+	apply := make(map[string]bool)
+	for _, t := range req.Types {
+		if !methods.IsStringInSlice(t, changeTypes) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "不支持的变化类型: " + t, "data": ""})
+			return
+		}
+		apply[t] = true
+	}
+	base, changeLayer := detection.BaseTable, detection.ChangeLayer
+	if rejectInVersion(c, DB, base, req.Username, req.SessionID) {
+		return
+	}
+	if !tableColumns(DB, changeLayer)["change_type"] || !tableColumns(DB, detection.NewTable)["geom"] {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "变更图层或新图层已不存在", "data": ""})
+		return
+	}
+	var fields []string
+	json.Unmarshal(detection.Fields, &fields)
+	baseCols := tableColumns(DB, base)
+	for _, f := range fields {
+		if !baseCols[f] {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "现有图层已缺少字段: " + f, "data": ""})
+			return
+		}
+	}
+
+	// 几何和属性分别按geom_changed、attr_changed选取，两者都变化的要素按请求的类型更新
+	var deletedIDs, geomIDs, attrIDs []int32
+	if apply["deleted"] {
+		deletedIDs = changeLayerIDs(DB, changeLayer, "base_id", []string{"deleted"})
+	}
+	if apply["geometry_changed"] {
+		geomIDs = changeLayerFlaggedIDs(DB, changeLayer, "geom_changed")
+	}
+	if apply["attribute_changed"] && len(fields) > 0 {
+		attrIDs = changeLayerFlaggedIDs(DB, changeLayer, "attr_changed")
+	}
+	updated := map[int32]bool{}
+	for _, id := range append(append([]int32{}, geomIDs...), attrIDs...) {
+		updated[id] = true
+	}
+	updatedIDs := make([]int32, 0, len(updated))
+	for id := range updated {
+		updatedIDs = append(updatedIDs, id)
+	}
+	sort.Slice(updatedIDs, func(i, j int) bool { return updatedIDs[i] < updatedIDs[j] })
+	touched := append(append([]int32{}, deletedIDs...), updatedIDs...)
+	if !checkEditLocks(c, DB, base, req.Username, touched) {
+		return
+	}
+	// 检测之后被编辑过的要素不再覆盖，需要重新检测
+	if edited := editedSinceDetection(DB, detection, touched); len(edited) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    409,
+			"message": fmt.Sprintf("%d个要素在检测后已被编辑，请重新检测后再应用", len(edited)),
+			"data":    edited,
+		})
+		return
+	}
+	oldDeleted := GetGeos(getDatas{TableName: base, ID: deletedIDs})
+	oldUpdated := GetGeos(getDatas{TableName: base, ID: updatedIDs})
+
+	// 字段按现有图层的类型写入
+	var sets, cols, sel []string
+	for _, f := range fields {
+		fieldType, err := attributeFieldType(DB, base, f)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+			return
+		}
+		sets = append(sets, fmt.Sprintf(`"%s" = CAST(b."%s" AS %s)`, f, f, fieldType))
+		cols = append(cols, fmt.Sprintf(`"%s"`, f))
+		sel = append(sel, fmt.Sprintf(`CAST(b."%s" AS %s)`, f, fieldType))
+	}
+
+	var addedIDs []int32
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf(`SELECT pg_advisory_xact_lock(hashtext('%s_change_apply'))`, base)).Error; err != nil {
+			return err
+		}
+		if len(deletedIDs) > 0 {
+			if err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE id IN ?`, base), deletedIDs).Error; err != nil {
+				return fmt.Errorf("删除要素失败: %v", err)
+			}
+		}
+		updates := []struct {
+			ids  []int32
+			sets []string
+			flag string
+		}{
+			{geomIDs, []string{"geom = b.geom"}, "geom_changed"},
+			{attrIDs, sets, "attr_changed"},
+		}
+		for _, u := range updates {
+			if len(u.ids) == 0 {
+				continue
+			}
+			sql := fmt.Sprintf(`UPDATE "%s" AS a SET %s FROM "%s" AS c JOIN "%s" AS b ON b.id = c.new_id WHERE a.id = c.base_id AND c."%s"`,
+				base, strings.Join(u.sets, ", "), changeLayer, detection.NewTable, u.flag)
+			if err := tx.Exec(sql).Error; err != nil {
+				return fmt.Errorf("更新要素失败: %v", err)
+			}
+		}
+		if apply["added"] {
+			var maxID int64
+			tx.Raw(fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) FROM "%s"`, base)).Scan(&maxID)
+			insertCols := append([]string{"id"}, cols...)
+			insertSel := append([]string{fmt.Sprintf("%d + ROW_NUMBER() OVER (ORDER BY c.new_id)", maxID)}, sel...)
+			sql := fmt.Sprintf(`INSERT INTO "%s" (%s, geom) SELECT %s, b.geom FROM "%s" AS c JOIN "%s" AS b ON b.id = c.new_id WHERE c.change_type = 'added' RETURNING id`,
+				base, strings.Join(insertCols, ", "), strings.Join(insertSel, ", "), changeLayer, detection.NewTable)
+			if err := tx.Raw(sql).Scan(&addedIDs).Error; err != nil {
+				return fmt.Errorf("新增要素失败: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	pgmvt.DelMVTALL(DB, base)
+
+	// 编辑记录：删除、更新、新增各一条，均归入用户在该图层上的编辑会话
+	session := GetOrCreateSession(DB, base, req.Username)
+	if len(deletedIDs) > 0 {
+		MarkMappingDeleted(DB, base, deletedIDs)
+		OldGeojson, _ := json.Marshal(oldDeleted)
+		DB.Create(&models.GeoRecord{
+			TableName:    base,
+			Username:     req.Username,
+			Type:         "批量要素删除",
+			Date:         timeNowStr(),
+			BZ:           req.BZ,
+			OldGeojson:   OldGeojson,
+			DelObjectIDs: DelIDGen(oldDeleted),
+			SessionID:    session.ID,
+			SeqNo:        GetNextSeqNo(DB, session.ID),
+			InputIDs:     MarshalIDs(deletedIDs),
+			OutputIDs:    MarshalIDs([]int32{}),
+		})
+	}
+	if len(updatedIDs) > 0 {
+		OldGeojson, _ := json.Marshal(oldUpdated)
+		NewGeojson, _ := json.Marshal(GetGeos(getDatas{TableName: base, ID: updatedIDs}))
+		DB.Create(&models.GeoRecord{
+			TableName:    base,
+			Username:     req.Username,
+			Type:         changeApplyUpdateType,
+			Date:         timeNowStr(),
+			BZ:           req.BZ,
+			OldGeojson:   OldGeojson,
+			NewGeojson:   NewGeojson,
+			DelObjectIDs: DelIDGen(oldUpdated),
+			SessionID:    session.ID,
+			SeqNo:        GetNextSeqNo(DB, session.ID),
+			InputIDs:     MarshalIDs(updatedIDs),
+			OutputIDs:    MarshalIDs(updatedIDs),
+		})
+	}
+	if len(addedIDs) > 0 {
+		CreateDerivedMappings(DB, base, addedIDs, 0, session.ID)
+		NewGeojson, _ := json.Marshal(GetGeos(getDatas{TableName: base, ID: addedIDs}))
+		DB.Create(&models.GeoRecord{
+			TableName:  base,
+			GeoID:      addedIDs[0],
+			Username:   req.Username,
+			Type:       "要素添加",
+			Date:       timeNowStr(),
+			BZ:         req.BZ,
+			NewGeojson: NewGeojson,
+			SessionID:  session.ID,
+			SeqNo:      GetNextSeqNo(DB, session.ID),
+			InputIDs:   MarshalIDs([]int32{}),
+			OutputIDs:  MarshalIDs(addedIDs),
+		})
+	}
+
+	DB.Model(&detection).Updates(map[string]interface{}{"status": "已应用", "applied_at": timeNowStr()})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "变更已应用", "data": gin.H{
+		"Deleted":   len(deletedIDs),
+		"Updated":   len(updatedIDs),
+		"Added":     len(addedIDs),
+		"SessionID": session.ID,
+	}})
+}
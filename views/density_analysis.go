@@ -0,0 +1,474 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 密度分析：核密度输出GeoTIFF并注册为动态栅格服务，格网汇总输出六边形或正方形格网图层
+
+// metersPerDegree 赤道处一度经度(及任意位置一度纬度)的近似长度
+const metersPerDegree = 111320.0
+
+type kernelDensityRequest struct {
+	TableName   string    `json:"TableName"`
+	Filter      string    `json:"Filter"`
+	WeightField string    `json:"WeightField"` // 权重字段，为空时每个点权重为1
+	Bandwidth   float64   `json:"Bandwidth"`   // 搜索半径(米)，为0时按Silverman经验公式估算
+	CellSize    float64   `json:"CellSize"`    // 像元大小(米)，为0时取范围短边的1/250
+	Extent      []float64 `json:"Extent"`      // 输出范围 minLon,minLat,maxLon,maxLat，默认为点范围外扩一个搜索半径
+	ServiceName string    `json:"ServiceName"` // 栅格服务名称
+	Description string    `json:"Description"`
+}
+
+// kernelDensityMaxSize 输出栅格的最大行列数
+const kernelDensityMaxSize = 4000
+
+// kernelDensityMaxPoints 参与计算的最大点数
+const kernelDensityMaxPoints = 2000000
+
+type densityPoint struct {
+	X, Y, W float64
+}
+
+func validateKernelDensityRequest(db *gorm.DB, req *kernelDensityRequest) error {
+	req.TableName = strings.ToLower(req.TableName)
+	req.WeightField = strings.ToLower(req.WeightField)
+	cols := tableColumns(db, req.TableName)
+	if !cols["geom"] {
+		return fmt.Errorf("图层不存在或没有几何字段: %s", req.TableName)
+	}
+	if req.WeightField != "" {
+		fieldType, err := attributeFieldType(db, req.TableName, req.WeightField)
+		if err != nil {
+			return err
+		}
+		if !isNumericFieldType(fieldType) {
+			return fmt.Errorf("权重字段必须为数值类型: %s", req.WeightField)
+		}
+	}
+	if req.Bandwidth < 0 || req.CellSize < 0 {
+		return fmt.Errorf("搜索半径和像元大小不能为负数")
+	}
+	if len(req.Extent) != 0 && (len(req.Extent) != 4 || req.Extent[0] >= req.Extent[2] || req.Extent[1] >= req.Extent[3]) {
+		return fmt.Errorf("Extent应为 minLon,minLat,maxLon,maxLat")
+	}
+	if req.ServiceName == "" {
+		req.ServiceName = fmt.Sprintf("kd_%s_%s", req.TableName, uuid.New().String()[:8])
+	}
+	var count int64
+	db.Model(&models.DynamicRaster{}).Where("name = ?", req.ServiceName).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("栅格服务已存在: %s", req.ServiceName)
+	}
+	return nil
+}
+
+// layerPointsWhere 图层筛选条件，字段以t.限定
+func layerPointsWhere(db *gorm.DB, table, filter string) (string, error) {
+	where := "t.geom IS NOT NULL"
+	if strings.TrimSpace(filter) != "" {
+		compiled, err := methods.CompileFilterExpression(db, table, filter)
+		if err != nil {
+			return "", fmt.Errorf("筛选条件错误: %v", err)
+		}
+		where += " AND " + compiled.SQL
+	}
+	return where, nil
+}
+
+// layerPointExpr 参与密度计算的点，点图层拆分多点，线面图层取面内点
+func layerPointExpr(db *gorm.DB, table string) string {
+	if layerType, err := sourceLayerType(db, table); err == nil && layerType == "point" {
+		return "(ST_Dump(t.geom)).geom"
+	}
+	return "ST_PointOnSurface(t.geom)"
+}
+
+// silvermanBandwidth 按标准距离和到平均中心的中位距离估算搜索半径(米)
+func silvermanBandwidth(points []densityPoint, mx, my float64) float64 {
+	var sw, cx, cy float64
+	for _, p := range points {
+		sw += p.W
+		cx += p.W * p.X * mx
+		cy += p.W * p.Y * my
+	}
+	if sw <= 0 {
+		return 0
+	}
+	cx, cy = cx/sw, cy/sw
+	var ss float64
+	dists := make([]float64, len(points))
+	for i, p := range points {
+		dx, dy := p.X*mx-cx, p.Y*my-cy
+		ss += p.W * (dx*dx + dy*dy)
+		dists[i] = math.Hypot(dx, dy)
+	}
+	sort.Float64s(dists)
+	sd := math.Sqrt(ss / sw)
+	dm := math.Sqrt(1/math.Ln2) * dists[len(dists)/2]
+	return 0.9 * math.Min(sd, dm) * math.Pow(sw, -0.2)
+}
+
+// densityColor 热力色带，t为0~1
+func densityColor(t float64) (uint8, uint8, uint8) {
+	stops := [][3]float64{{43, 131, 186}, {171, 221, 164}, {255, 255, 191}, {253, 174, 97}, {215, 25, 28}}
+	t = math.Max(0, math.Min(1, t)) * float64(len(stops)-1)
+	i := int(t)
+	if i >= len(stops)-1 {
+		i = len(stops) - 2
+	}
+	f := t - float64(i)
+	mix := func(k int) uint8 {
+		return uint8(math.Round(stops[i][k] + (stops[i+1][k]-stops[i][k])*f))
+	}
+	return mix(0), mix(1), mix(2)
+}
+
+// runKernelDensity 执行核密度分析，使用四次核函数，结果单位为每平方千米的点数(或权重)
+func runKernelDensity(ctx context.Context, taskID string, req kernelDensityRequest, progress analysisProgressFunc) (interface{}, error) {
+	db := models.DB.WithContext(ctx)
+	where, err := layerPointsWhere(db, req.TableName, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	weight := "1"
+	if req.WeightField != "" {
+		weight = fmt.Sprintf(`COALESCE(t."%s", 0)::float8`, req.WeightField)
+	}
+	var points []densityPoint
+	err = db.Raw(fmt.Sprintf(`SELECT ST_X(p) AS x, ST_Y(p) AS y, w FROM (SELECT %s AS p, %s AS w FROM "%s" AS t WHERE %s) s WHERE NOT ST_IsEmpty(p) AND w > 0 LIMIT %d`,
+		layerPointExpr(db, req.TableName), weight, req.TableName, where, kernelDensityMaxPoints+1)).Scan(&points).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("没有参与计算的点")
+	}
+	if len(points) > kernelDensityMaxPoints {
+		return nil, fmt.Errorf("点数超过 %d，请设置筛选条件", kernelDensityMaxPoints)
+	}
+	if !progress(0.1, fmt.Sprintf("已读取 %d 个点", len(points))) {
+		return nil, ctx.Err()
+	}
+
+	// 点范围，按范围中心纬度换算米与度
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	lat0 := (minY + maxY) / 2
+	if len(req.Extent) == 4 {
+		lat0 = (req.Extent[1] + req.Extent[3]) / 2
+	}
+	my := metersPerDegree
+	mx := metersPerDegree * math.Cos(lat0*math.Pi/180)
+
+	bandwidth := req.Bandwidth
+	if bandwidth == 0 {
+		bandwidth = silvermanBandwidth(points, mx, my)
+		if bandwidth <= 0 {
+			return nil, fmt.Errorf("无法估算搜索半径，请指定Bandwidth")
+		}
+	}
+	if len(req.Extent) == 4 {
+		minX, minY, maxX, maxY = req.Extent[0], req.Extent[1], req.Extent[2], req.Extent[3]
+	} else {
+		minX, maxX = minX-bandwidth/mx, maxX+bandwidth/mx
+		minY, maxY = minY-bandwidth/my, maxY+bandwidth/my
+	}
+	cellSize := req.CellSize
+	if cellSize == 0 {
+		cellSize = math.Min((maxX-minX)*mx, (maxY-minY)*my) / 250
+	}
+	dx, dy := cellSize/mx, cellSize/my
+	width := int(math.Ceil((maxX - minX) / dx))
+	height := int(math.Ceil((maxY - minY) / dy))
+	if width > kernelDensityMaxSize || height > kernelDensityMaxSize {
+		return nil, fmt.Errorf("输出栅格为 %d×%d，超过 %d×%d，请增大像元大小", width, height, kernelDensityMaxSize, kernelDensityMaxSize)
+	}
+
+	// 逐点累加核函数值
+	grid := make([]float64, width*height)
+	r2 := bandwidth * bandwidth
+	scale := 3 / (math.Pi * r2) * 1e6
+	reach := int(math.Ceil(bandwidth / cellSize))
+	for n, p := range points {
+		col := int((p.X - minX) / dx)
+		row := int((maxY - p.Y) / dy)
+		for i := row - reach; i <= row+reach; i++ {
+			if i < 0 || i >= height {
+				continue
+			}
+			cy := (maxY - (float64(i)+0.5)*dy - p.Y) * my
+			for j := col - reach; j <= col+reach; j++ {
+				if j < 0 || j >= width {
+					continue
+				}
+				cx := (minX + (float64(j)+0.5)*dx - p.X) * mx
+				d2 := cx*cx + cy*cy
+				if d2 < r2 {
+					k := 1 - d2/r2
+					grid[i*width+j] += p.W * scale * k * k
+				}
+			}
+		}
+		if (n+1)%5000 == 0 && !progress(0.1+0.7*float64(n+1)/float64(len(points)), fmt.Sprintf("已计算 %d/%d 个点", n+1, len(points))) {
+			return nil, ctx.Err()
+		}
+	}
+
+	// 密度值栅格和用于发布的彩色栅格，密度为0处透明
+	values := make([]float32, len(grid))
+	var maxValue float64
+	for i, v := range grid {
+		values[i] = float32(v)
+		maxValue = math.Max(maxValue, v)
+	}
+	rgba := make([]uint8, 4*len(grid))
+	for i, v := range grid {
+		if v <= 0 || maxValue <= 0 {
+			continue
+		}
+		r, g, b := densityColor(v / maxValue)
+		rgba[4*i], rgba[4*i+1], rgba[4*i+2], rgba[4*i+3] = r, g, b, 220
+	}
+	progress(0.85, "正在写出栅格")
+
+	homeDir, _ := os.UserHomeDir()
+	outDir := filepath.Join(homeDir, "BoundlessMap", "OutFile", taskID)
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	geoTransform := [6]float64{minX, dx, 0, maxY, 0, -dy}
+	dataPath := filepath.Join(outDir, req.ServiceName+".tif")
+	if err := services.WriteGTiff(dataPath, services.RasterGrid{
+		Width: width, Height: height, Bands: 1, Float32: values, GeoTransform: geoTransform, EPSG: 4326,
+	}); err != nil {
+		return nil, fmt.Errorf("写出密度栅格失败: %v", err)
+	}
+	imagePath := filepath.Join(outDir, req.ServiceName+"_color.tif")
+	if err := services.WriteGTiff(imagePath, services.RasterGrid{
+		Width: width, Height: height, Bands: 4, Uint8: rgba, GeoTransform: geoTransform, EPSG: 4326,
+	}); err != nil {
+		return nil, fmt.Errorf("写出彩色栅格失败: %v", err)
+	}
+
+	description := req.Description
+	if description == "" {
+		description = fmt.Sprintf("%s 核密度，搜索半径 %.1f 米，像元 %.1f 米", req.TableName, bandwidth, cellSize)
+	}
+	manager := services.GetTileServerManager()
+	if err := manager.AddService(&models.DynamicRaster{
+		Name:        req.ServiceName,
+		ImagePath:   imagePath,
+		Description: description,
+		MaxZoom:     18,
+		Bounds:      fmt.Sprintf("%.6f,%.6f,%.6f,%.6f", minX, minY, maxX, maxY),
+		Center:      fmt.Sprintf("%.6f,%.6f", (minX+maxX)/2, (minY+maxY)/2),
+	}); err != nil {
+		return nil, fmt.Errorf("注册栅格服务失败: %v", err)
+	}
+	if err := manager.StartServer(req.ServiceName); err != nil {
+		return nil, fmt.Errorf("栅格服务启动失败: %v", err)
+	}
+	progress(1, "核密度分析完成")
+	return gin.H{
+		"ServiceName": req.ServiceName,
+		"DataFile":    "/geo/OutFile/" + taskID + "/" + req.ServiceName + ".tif",
+		"Width":       width,
+		"Height":      height,
+		"Bandwidth":   bandwidth,
+		"CellSize":    cellSize,
+		"Max":         maxValue,
+		"Count":       len(points),
+		"Bounds":      []float64{minX, minY, maxX, maxY},
+	}, nil
+}
+
+// StartKernelDensity 创建核密度分析任务
+func (uc *UserController) StartKernelDensity(c *gin.Context) {
+	var req kernelDensityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if err := validateKernelDensityRequest(models.DB, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": err.Error()})
+		return
+	}
+	var task *analysisTask
+	task = newAnalysisTask("kernel_density", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		return runKernelDensity(ctx, task.ID, req, progress)
+	})
+	respondAnalysisTask(c, task, "核密度分析")
+}
+
+type gridAggregateRequest struct {
+	TableName string    `json:"TableName"`
+	Filter    string    `json:"Filter"`
+	Shape     string    `json:"Shape"`     // hexagon / square
+	Size      float64   `json:"Size"`      // 六边形边长或正方形边长(米)
+	Extent    []float64 `json:"Extent"`    // 格网范围 minLon,minLat,maxLon,maxLat，默认为图层范围
+	SumFields []string  `json:"SumFields"` // 求和字段，结果字段为 sum_<字段名>
+	KeepEmpty bool      `json:"KeepEmpty"` // 保留没有点的格网
+	Main      string    `json:"Main"`
+	OutTable  string    `json:"OutTable"`
+}
+
+// gridAggregateMaxCells 格网数量上限
+const gridAggregateMaxCells = 1000000
+
+func validateGridAggregateRequest(db *gorm.DB, req *gridAggregateRequest) error {
+	req.TableName = strings.ToLower(req.TableName)
+	cols := tableColumns(db, req.TableName)
+	if !cols["geom"] {
+		return fmt.Errorf("图层不存在或没有几何字段: %s", req.TableName)
+	}
+	if req.Shape == "" {
+		req.Shape = "hexagon"
+	}
+	if req.Shape != "hexagon" && req.Shape != "square" {
+		return fmt.Errorf("Shape只能为hexagon或square")
+	}
+	if req.Size <= 0 {
+		return fmt.Errorf("需要指定格网大小")
+	}
+	if len(req.Extent) != 0 && (len(req.Extent) != 4 || req.Extent[0] >= req.Extent[2] || req.Extent[1] >= req.Extent[3]) {
+		return fmt.Errorf("Extent应为 minLon,minLat,maxLon,maxLat")
+	}
+	if req.OutTable == "" {
+		return fmt.Errorf("OutTable不能为空")
+	}
+	for i, f := range req.SumFields {
+		f = strings.ToLower(f)
+		req.SumFields[i] = f
+		fieldType, err := attributeFieldType(db, req.TableName, f)
+		if err != nil {
+			return err
+		}
+		if !isNumericFieldType(fieldType) {
+			return fmt.Errorf("求和字段必须为数值类型: %s", f)
+		}
+	}
+	return nil
+}
+
+// runGridAggregate 在Web墨卡托下生成格网并统计落入各格网的点，线面要素取面内点
+func runGridAggregate(ctx context.Context, req gridAggregateRequest, progress analysisProgressFunc) (interface{}, error) {
+	db := models.DB.WithContext(ctx)
+	where, err := layerPointsWhere(db, req.TableName, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	extent := req.Extent
+	if len(extent) == 0 {
+		var box struct{ MinX, MinY, MaxX, MaxY *float64 }
+		db.Raw(fmt.Sprintf(`SELECT ST_XMin(e) AS min_x, ST_YMin(e) AS min_y, ST_XMax(e) AS max_x, ST_YMax(e) AS max_y FROM (SELECT ST_Extent(t.geom) AS e FROM "%s" AS t WHERE %s) s`,
+			req.TableName, where)).Scan(&box)
+		if box.MinX == nil {
+			return nil, fmt.Errorf("没有参与汇总的要素")
+		}
+		extent = []float64{*box.MinX, *box.MinY, *box.MaxX, *box.MaxY}
+	}
+	extent[1] = math.Max(extent[1], -85)
+	extent[3] = math.Min(extent[3], 85)
+
+	// Web墨卡托在中心纬度处的长度变形，格网在该纬度处为指定大小
+	lat0 := (extent[1] + extent[3]) / 2
+	size := req.Size / math.Cos(lat0*math.Pi/180)
+	widthM := (extent[2] - extent[0]) * metersPerDegree * math.Cos(lat0*math.Pi/180)
+	heightM := (extent[3] - extent[1]) * metersPerDegree
+	cellArea := req.Size * req.Size
+	if req.Shape == "hexagon" {
+		cellArea = 3 * math.Sqrt(3) / 2 * req.Size * req.Size
+	}
+	if cells := widthM * heightM / cellArea; cells > gridAggregateMaxCells {
+		return nil, fmt.Errorf("格网数量约 %.0f 个，超过 %d，请增大格网大小", cells, gridAggregateMaxCells)
+	}
+
+	fields := []resultLayerField{
+		{Name: "grid_i", Type: "INTEGER"},
+		{Name: "grid_j", Type: "INTEGER"},
+		{Name: "pt_count", Type: "INTEGER"},
+		{Name: "density", Type: "DOUBLE PRECISION"},
+	}
+	cols := []string{"grid_i", "grid_j", "pt_count", "density"}
+	pointSel := []string{fmt.Sprintf("ST_Transform(%s, 3857) AS g", layerPointExpr(db, req.TableName))}
+	aggSel := []string{"c.i", "c.j", "COUNT(p.g)", "COUNT(p.g) / (ST_Area(ST_Transform(c.geom, 4326)::geography) / 1e6)"}
+	for i, f := range req.SumFields {
+		name := "sum_" + f
+		fields = append(fields, resultLayerField{Name: name, Type: "DOUBLE PRECISION"})
+		cols = append(cols, fmt.Sprintf(`"%s"`, name))
+		pointSel = append(pointSel, fmt.Sprintf(`t."%s"::float8 AS v%d`, f, i))
+		aggSel = append(aggSel, fmt.Sprintf("COALESCE(SUM(p.v%d), 0)", i))
+	}
+	en, err := createResultLayer(models.DB, req.Main, req.OutTable, "polygon", fields, "")
+	if err != nil {
+		return nil, err
+	}
+	if !progress(0.1, "正在生成格网并汇总") {
+		dropResultLayer(models.DB, en)
+		return nil, ctx.Err()
+	}
+
+	gridFunc := "ST_HexagonGrid"
+	if req.Shape == "square" {
+		gridFunc = "ST_SquareGrid"
+	}
+	join := "JOIN"
+	if req.KeepEmpty {
+		join = "LEFT JOIN"
+	}
+	sql := fmt.Sprintf(`
+		WITH pts AS (SELECT %s FROM "%s" AS t WHERE %s),
+		cells AS (SELECT * FROM %s(%g, ST_Transform(ST_MakeEnvelope(%f, %f, %f, %f, 4326), 3857)))
+		INSERT INTO "%s" (%s, geom)
+		SELECT %s, ST_Multi(ST_Transform(c.geom, 4326))
+		FROM cells AS c %s pts AS p ON ST_Intersects(c.geom, p.g)
+		GROUP BY c.i, c.j, c.geom`,
+		strings.Join(pointSel, ", "), req.TableName, where,
+		gridFunc, size, extent[0], extent[1], extent[2], extent[3],
+		en, strings.Join(cols, ", "), strings.Join(aggSel, ", "), join)
+	if err := db.Exec(sql).Error; err != nil {
+		dropResultLayer(models.DB, en)
+		return nil, fmt.Errorf("格网汇总失败: %v", err)
+	}
+
+	var count int64
+	db.Table(en).Count(&count)
+	MakeGeoIndex(en)
+	progress(1, "格网汇总完成")
+	return gin.H{"TableName": en, "CN": req.OutTable, "Count": count}, nil
+}
+
+// StartGridAggregate 创建格网汇总任务
+func (uc *UserController) StartGridAggregate(c *gin.Context) {
+	var req gridAggregateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if err := validateGridAggregateRequest(models.DB, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": err.Error()})
+		return
+	}
+	task := newAnalysisTask("grid_aggregate", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		return runGridAggregate(ctx, req, progress)
+	})
+	respondAnalysisTask(c, task, "格网汇总")
+}
@@ -0,0 +1,516 @@
+package views
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/Gogeo"
+	"github.com/GrainArc/SouceMap/ImgHandler"
+	Tin2 "github.com/GrainArc/SouceMap/Tin"
+	"github.com/GrainArc/SouceMap/config"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ==================== 高程剖面 ====================
+// 沿线按固定间距采样高程，高程来源为地形MBTiles、GeoTIFF DEM或由高程点构建的TIN
+
+type elevationProfileRequest struct {
+	Line       *geojson.Geometry `json:"Line"`       // 绘制的线(EPSG:4326)
+	TableName  string            `json:"TableName"`  // 未提供Line时取图层中的线要素
+	ID         int32             `json:"ID"`         // 线要素id
+	Source     string            `json:"Source"`     // dem(默认) / geotiff / tin
+	RasterPath string            `json:"RasterPath"` // GeoTIFF DEM路径
+	Band       int               `json:"Band"`       // GeoTIFF波段，默认1
+	TinPoints  [][]float64       `json:"TinPoints"`  // 构建TIN的高程点 [[lon,lat,z],...]
+	TinTable   string            `json:"TinTable"`   // 未提供TinPoints时由点图层构建TIN
+	TinField   string            `json:"TinField"`   // 高程字段，为空时取点的Z值
+	TinFilter  string            `json:"TinFilter"`
+	Spacing    float64           `json:"Spacing"`    // 采样间距(米)，为0时取线长的1/200
+	FlatSlope  float64           `json:"FlatSlope"`  // 坡度(%)绝对值低于该值视为平缓，默认2
+	MinSegment float64           `json:"MinSegment"` // 短于该长度(米)的坡段并入前一坡段，默认3倍采样间距
+	Chart      bool              `json:"Chart"`      // 同时生成PNG剖面图
+	Title      string            `json:"Title"`      // 剖面图标题
+}
+
+// profileMaxSamples 单条剖面的最大采样点数，超过时自动加大间距
+const profileMaxSamples = 5000
+
+// profileTinMaxPoints 构建TIN的最大点数
+const profileTinMaxPoints = 20000
+
+type profilePoint struct {
+	Distance  float64  `json:"distance"`
+	Lon       float64  `json:"lon"`
+	Lat       float64  `json:"lat"`
+	Elevation *float64 `json:"elevation"` // 无数据时为null
+}
+
+type profileStats struct {
+	Length           float64  `json:"length"`         // 水平长度(米)
+	SurfaceLength    float64  `json:"surface_length"` // 沿地表长度(米)
+	Samples          int      `json:"samples"`
+	NoDataSamples    int      `json:"nodata_samples"`
+	StartElevation   *float64 `json:"start_elevation"`
+	EndElevation     *float64 `json:"end_elevation"`
+	MinElevation     float64  `json:"min_elevation"`
+	MinDistance      float64  `json:"min_distance"`
+	MaxElevation     float64  `json:"max_elevation"`
+	MaxDistance      float64  `json:"max_distance"`
+	TotalClimb       float64  `json:"total_climb"`
+	TotalDescent     float64  `json:"total_descent"`
+	AvgSlope         float64  `json:"avg_slope"`          // 平均坡度绝对值(%)，按长度加权
+	MaxUphillSlope   float64  `json:"max_uphill_slope"`   // (%)
+	MaxDownhillSlope float64  `json:"max_downhill_slope"` // (%)，为负值
+}
+
+type profileSegment struct {
+	Type           string  `json:"type"` // 上坡 / 下坡 / 平缓 / 无数据
+	StartDistance  float64 `json:"start_distance"`
+	EndDistance    float64 `json:"end_distance"`
+	Length         float64 `json:"length"`
+	StartElevation float64 `json:"start_elevation"`
+	EndElevation   float64 `json:"end_elevation"`
+	AvgSlope       float64 `json:"avg_slope"` // 起止点高差/长度(%)
+	MaxSlope       float64 `json:"max_slope"` // 段内采样间隔坡度的最大绝对值(%)
+}
+
+// profileLine 请求中的线，或图层中指定要素合并后的单条线
+func profileLine(db *gorm.DB, req elevationProfileRequest) (orb.LineString, error) {
+	var g orb.Geometry
+	if req.Line != nil {
+		g = req.Line.Geometry()
+	} else {
+		if req.TableName == "" {
+			return nil, fmt.Errorf("请提供Line或TableName和ID")
+		}
+		if layerType, err := sourceLayerType(db, req.TableName); err != nil || layerType != "line" {
+			return nil, fmt.Errorf("只能对线图层生成剖面")
+		}
+		var geomJSON string
+		db.Raw(fmt.Sprintf(`SELECT ST_AsGeoJSON(ST_LineMerge(ST_Multi(geom))) FROM "%s" WHERE id = ?`, req.TableName), req.ID).Scan(&geomJSON)
+		if geomJSON == "" {
+			return nil, fmt.Errorf("要素不存在: %d", req.ID)
+		}
+		parsed, err := geojson.UnmarshalGeometry([]byte(geomJSON))
+		if err != nil {
+			return nil, err
+		}
+		g = parsed.Geometry()
+	}
+	switch line := g.(type) {
+	case orb.LineString:
+		return line, nil
+	case orb.MultiLineString:
+		if len(line) == 1 {
+			return line[0], nil
+		}
+		return nil, fmt.Errorf("线要素由%d段不相连的线组成，无法生成连续剖面", len(line))
+	}
+	return nil, fmt.Errorf("剖面只支持线几何")
+}
+
+// sampleProfileLine 沿线按间距取点，末点总是包含在内
+func sampleProfileLine(line orb.LineString, spacing float64) ([]orb.Point, []float64) {
+	cum := make([]float64, len(line))
+	for i := 1; i < len(line); i++ {
+		cum[i] = cum[i-1] + geo.Distance(line[i-1], line[i])
+	}
+	total := cum[len(cum)-1]
+	var points []orb.Point
+	var distances []float64
+	seg := 1
+	for d := 0.0; d < total; d += spacing {
+		for seg < len(line)-1 && cum[seg] < d {
+			seg++
+		}
+		t := 0.0
+		if l := cum[seg] - cum[seg-1]; l > 0 {
+			t = (d - cum[seg-1]) / l
+		}
+		a, b := line[seg-1], line[seg]
+		points = append(points, orb.Point{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t})
+		distances = append(distances, d)
+	}
+	points = append(points, line[len(line)-1])
+	distances = append(distances, total)
+	return points, distances
+}
+
+// demProfileElevations 从地形MBTiles最大层级取高程，缺少瓦片的位置为NaN
+func demProfileElevations(points []orb.Point) ([]float64, error) {
+	DB, err := gorm.Open(sqlite.Open(config.Dem), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("打开地形数据失败: %v", err)
+	}
+	defer func() {
+		if DB, err := DB.DB(); err == nil {
+			DB.Close()
+		}
+	}()
+	var maxZoom int64
+	DB.Model(&models.Tile{}).Select("MAX(zoom_level)").Scan(&maxZoom)
+
+	keys := make([][2]int64, len(points))
+	images := make(map[[2]int64]image.Image)
+	var pending [][2]int64
+	for i, p := range points {
+		x, y := pgmvt.LonLatToTile(p[0], p[1], maxZoom)
+		keys[i] = [2]int64{x, y}
+		if _, ok := images[keys[i]]; !ok {
+			images[keys[i]] = nil
+			pending = append(pending, keys[i])
+		}
+	}
+	// 分批查询涉及的瓦片，每个瓦片只解码一次
+	for start := 0; start < len(pending); start += 200 {
+		end := int(math.Min(float64(start+200), float64(len(pending))))
+		var conditions []string
+		var args []interface{}
+		for _, k := range pending[start:end] {
+			conditions = append(conditions, "(tile_column = ? AND tile_row = ? AND zoom_level = ?)")
+			args = append(args, k[0], k[1], maxZoom)
+		}
+		var tiles []models.Tile
+		DB.Where(strings.Join(conditions, " OR "), args...).Find(&tiles)
+		for _, t := range tiles {
+			if img, _, err := decodeImage(t.TileData); err == nil {
+				images[[2]int64{t.TileColumn, t.TileRow}] = img
+			}
+		}
+	}
+
+	values := make([]float64, len(points))
+	for i, p := range points {
+		img := images[keys[i]]
+		if img == nil {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = demImageElevation(img, maxZoom, keys[i][0], keys[i][1], p[0], p[1])
+	}
+	return values, nil
+}
+
+// geotiffProfileElevations 将DEM重投影到Web墨卡托后双线性取值，范围外和无效值为NaN
+func geotiffProfileElevations(path string, band int, points []orb.Point) ([]float64, error) {
+	rd, err := Gogeo.OpenRasterDataset(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	if rd.GetEPSGCode() == 0 {
+		return nil, fmt.Errorf("栅格缺少坐标信息")
+	}
+	if band <= 0 {
+		band = 1
+	}
+	bandInfo, err := rd.GetBandInfo(band)
+	if err != nil {
+		return nil, err
+	}
+	info := rd.GetInfo()
+	if info.Width < 2 || info.Height < 2 {
+		return nil, fmt.Errorf("栅格尺寸过小")
+	}
+	gt := info.GeoTransform
+
+	const earthRadius = 6378137.0
+	values := make([]float64, len(points))
+	for i, p := range points {
+		// 像元中心坐标系下的浮点行列号
+		mx := p[0] * math.Pi / 180 * earthRadius
+		my := math.Log(math.Tan((90+p[1])*math.Pi/360)) * earthRadius
+		fx := (mx-gt[0])/gt[1] - 0.5
+		fy := (my-gt[3])/gt[5] - 0.5
+		if fx < -0.5 || fy < -0.5 || fx > float64(info.Width)-0.5 || fy > float64(info.Height)-0.5 {
+			values[i] = math.NaN()
+			continue
+		}
+		// 读取包围该点的2x2像元，边缘处窗口向内平移
+		c := int(math.Max(0, math.Min(float64(info.Width-2), math.Floor(fx))))
+		r := int(math.Max(0, math.Min(float64(info.Height-2), math.Floor(fy))))
+		block, err := rd.ReadBandDataRect(band, c, r, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range block {
+			if bandInfo.HasNoData && v == bandInfo.NoDataValue {
+				block[k] = math.NaN()
+			}
+		}
+		tx := math.Max(0, math.Min(1, fx-float64(c)))
+		ty := math.Max(0, math.Min(1, fy-float64(r)))
+		top := block[0]*(1-tx) + block[1]*tx
+		bottom := block[2]*(1-tx) + block[3]*tx
+		values[i] = top*(1-ty) + bottom*ty
+	}
+	return values, nil
+}
+
+// tinProfileElevations 由高程点构建TIN取值，TIN范围外为NaN
+func tinProfileElevations(db *gorm.DB, req elevationProfileRequest, points []orb.Point) ([]float64, error) {
+	coords := req.TinPoints
+	if len(coords) == 0 {
+		if req.TinTable == "" {
+			return nil, fmt.Errorf("请提供TinPoints或TinTable")
+		}
+		where, err := layerPointsWhere(db, req.TinTable, req.TinFilter)
+		if err != nil {
+			return nil, err
+		}
+		zExpr := "ST_Z(p)"
+		if req.TinField != "" {
+			fieldType, err := attributeFieldType(db, req.TinTable, req.TinField)
+			if err != nil || !isNumericFieldType(fieldType) {
+				return nil, fmt.Errorf("高程字段不存在或不是数值类型: %s", req.TinField)
+			}
+			zExpr = fmt.Sprintf(`"%s"::float8`, req.TinField)
+		}
+		var rows []struct {
+			X, Y float64
+			Z    *float64
+		}
+		db.Raw(fmt.Sprintf(`SELECT ST_X(p) AS x, ST_Y(p) AS y, %s AS z FROM (
+				SELECT t.*, %s AS p FROM "%s" AS t WHERE %s
+			) s LIMIT %d`,
+			zExpr, layerPointExpr(db, req.TinTable), req.TinTable, where, profileTinMaxPoints+1)).Scan(&rows)
+		for _, r := range rows {
+			if r.Z != nil {
+				coords = append(coords, []float64{r.X, r.Y, *r.Z})
+			}
+		}
+	}
+	if len(coords) < 3 {
+		return nil, fmt.Errorf("构建TIN至少需要3个高程点")
+	}
+	if len(coords) > profileTinMaxPoints {
+		return nil, fmt.Errorf("构建TIN的高程点不能超过%d个", profileTinMaxPoints)
+	}
+	pts, err := Tin2.CoordsToPoint3D(coords)
+	if err != nil {
+		return nil, err
+	}
+	tin := Tin2.CreateTIN3D(&Tin2.Polygon2D{}, pts)
+	values := make([]float64, len(points))
+	for i, p := range points {
+		z, err := tin.GetElevationAt(p[0], p[1])
+		if err != nil {
+			z = math.NaN()
+		}
+		values[i] = z
+	}
+	return values, nil
+}
+
+// profileSlopeType 按坡度(%)划分坡段类型
+func profileSlopeType(slope, flat float64) string {
+	switch {
+	case math.IsNaN(slope):
+		return "无数据"
+	case slope > flat:
+		return "上坡"
+	case slope < -flat:
+		return "下坡"
+	}
+	return "平缓"
+}
+
+// appendProfileSegment 与末尾同类型的坡段相连时延长末尾坡段
+func appendProfileSegment(segments []profileSegment, seg profileSegment) []profileSegment {
+	if n := len(segments); n > 0 && segments[n-1].Type == seg.Type {
+		last := &segments[n-1]
+		last.EndDistance, last.EndElevation = seg.EndDistance, seg.EndElevation
+		last.MaxSlope = math.Max(last.MaxSlope, seg.MaxSlope)
+		return segments
+	}
+	return append(segments, seg)
+}
+
+// summarizeProfile 统计剖面并划分坡段
+func summarizeProfile(distances, elevations []float64, flat, minSegment float64) (profileStats, []profileSegment) {
+	stats := profileStats{
+		Length:       distances[len(distances)-1],
+		Samples:      len(distances),
+		MinElevation: math.Inf(1),
+		MaxElevation: math.Inf(-1),
+	}
+	var slopeLength float64
+	for i, z := range elevations {
+		if math.IsNaN(z) {
+			stats.NoDataSamples++
+			continue
+		}
+		if z < stats.MinElevation {
+			stats.MinElevation, stats.MinDistance = z, distances[i]
+		}
+		if z > stats.MaxElevation {
+			stats.MaxElevation, stats.MaxDistance = z, distances[i]
+		}
+	}
+	if v := elevations[0]; !math.IsNaN(v) {
+		stats.StartElevation = &v
+	}
+	if v := elevations[len(elevations)-1]; !math.IsNaN(v) {
+		stats.EndElevation = &v
+	}
+	if stats.NoDataSamples == len(elevations) {
+		stats.MinElevation, stats.MaxElevation = 0, 0
+	}
+
+	var segments []profileSegment
+	for i := 1; i < len(distances); i++ {
+		dd := distances[i] - distances[i-1]
+		if dd <= 0 {
+			continue
+		}
+		z0, z1 := elevations[i-1], elevations[i]
+		dz := z1 - z0
+		slope := dz / dd * 100
+		if !math.IsNaN(slope) {
+			stats.SurfaceLength += math.Hypot(dd, dz)
+			if dz > 0 {
+				stats.TotalClimb += dz
+			} else {
+				stats.TotalDescent -= dz
+			}
+			stats.AvgSlope += math.Abs(slope) * dd
+			slopeLength += dd
+			stats.MaxUphillSlope = math.Max(stats.MaxUphillSlope, slope)
+			stats.MaxDownhillSlope = math.Min(stats.MaxDownhillSlope, slope)
+		} else {
+			// 无数据区间按水平距离计入
+			stats.SurfaceLength += dd
+		}
+		seg := profileSegment{Type: profileSlopeType(slope, flat), StartDistance: distances[i-1], EndDistance: distances[i],
+			StartElevation: z0, EndElevation: z1}
+		if !math.IsNaN(slope) {
+			seg.MaxSlope = math.Abs(slope)
+		}
+		segments = appendProfileSegment(segments, seg)
+	}
+	// 短坡段并入前一坡段(位于开头时并入后一坡段)，无数据段保持不变
+	merged := segments[:0:0]
+	for i, seg := range segments {
+		short := seg.Type != "无数据" && seg.EndDistance-seg.StartDistance < minSegment
+		if n := len(merged); short && n > 0 && merged[n-1].Type != "无数据" {
+			seg.Type = merged[n-1].Type
+		} else if short && n == 0 && i+1 < len(segments) && segments[i+1].Type != "无数据" {
+			seg.Type = segments[i+1].Type
+		}
+		merged = appendProfileSegment(merged, seg)
+	}
+	segments = merged
+	for i := range segments {
+		s := &segments[i]
+		s.Length = s.EndDistance - s.StartDistance
+		if s.Type == "无数据" {
+			s.StartElevation, s.EndElevation = 0, 0
+			continue
+		}
+		if s.Length > 0 {
+			s.AvgSlope = (s.EndElevation - s.StartElevation) / s.Length * 100
+		}
+	}
+	if slopeLength > 0 {
+		stats.AvgSlope /= slopeLength
+	}
+	return stats, segments
+}
+
+// ElevationProfile 沿线生成高程剖面
+func (uc *UserController) ElevationProfile(c *gin.Context) {
+	var req elevationProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	req.TableName = strings.ToLower(req.TableName)
+	req.TinTable = strings.ToLower(req.TinTable)
+	if req.Source == "" {
+		req.Source = "dem"
+	}
+	if req.FlatSlope <= 0 {
+		req.FlatSlope = 2
+	}
+
+	line, err := profileLine(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	if len(line) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "线至少需要两个点", "data": ""})
+		return
+	}
+	length := geo.Length(line)
+	if length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "线长度为0", "data": ""})
+		return
+	}
+	if req.Spacing <= 0 {
+		req.Spacing = length / 200
+	}
+	if length/req.Spacing > profileMaxSamples {
+		req.Spacing = length / profileMaxSamples
+	}
+	if req.MinSegment <= 0 {
+		req.MinSegment = req.Spacing * 3
+	}
+	points, distances := sampleProfileLine(line, req.Spacing)
+
+	var elevations []float64
+	switch req.Source {
+	case "dem":
+		elevations, err = demProfileElevations(points)
+	case "geotiff":
+		if req.RasterPath == "" {
+			err = fmt.Errorf("请提供RasterPath")
+			break
+		}
+		elevations, err = geotiffProfileElevations(req.RasterPath, req.Band, points)
+	case "tin":
+		elevations, err = tinProfileElevations(DB, req, points)
+	default:
+		err = fmt.Errorf("Source只能为dem、geotiff或tin")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+
+	stats, segments := summarizeProfile(distances, elevations, req.FlatSlope, req.MinSegment)
+	result := make([]profilePoint, len(points))
+	for i, p := range points {
+		result[i] = profilePoint{Distance: distances[i], Lon: p[0], Lat: p[1]}
+		if z := elevations[i]; !math.IsNaN(z) {
+			result[i].Elevation = &z
+		}
+	}
+	data := gin.H{
+		"Spacing":  req.Spacing,
+		"Points":   result,
+		"Stats":    stats,
+		"Segments": segments,
+	}
+	if req.Chart {
+		chart, err := ImgHandler.ProfileChart(distances, elevations, req.Title)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"code": 500, "message": "剖面图生成失败: " + err.Error(), "data": data})
+			return
+		}
+		data["Chart"] = chart
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": data})
+}
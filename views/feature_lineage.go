@@ -0,0 +1,479 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 要素溯源：沿 GeoRecord 的输入/输出ID与 OriginMapping 的父子关系追溯要素的来源和去向
+
+const lineageMaxSteps = 500
+
+// LineageFeature 某一步操作中的要素及其当时的几何
+type LineageFeature struct {
+	ID      int32            `json:"id"`
+	Feature *geojson.Feature `json:"feature,omitempty"`
+}
+
+// LineageStep 溯源链中的一次编辑操作
+type LineageStep struct {
+	RecordID  int64            `json:"record_id"`
+	Type      string           `json:"type"`
+	Date      string           `json:"date"`
+	Username  string           `json:"username"`
+	BZ        string           `json:"bz"`
+	SessionID int64            `json:"session_id"`
+	Status    string           `json:"status"`
+	Inputs    []LineageFeature `json:"inputs"`
+	Outputs   []LineageFeature `json:"outputs"`
+}
+
+// LineageMapping 映射表中的溯源关系
+type LineageMapping struct {
+	PostGISID       int32  `json:"post_gis_id"`
+	ParentPostGISID int32  `json:"parent_post_gis_id"`
+	SourceObjectID  int64  `json:"source_object_id"`
+	Origin          string `json:"origin"`
+	SessionID       int64  `json:"session_id"`
+	IsDeleted       bool   `json:"is_deleted"`
+}
+
+// featureRecordID 读取要素属性中的id
+func featureRecordID(props geojson.Properties) (int32, bool) {
+	for _, key := range []string{"id", "ID", "Id"} {
+		switch v := props[key].(type) {
+		case float64:
+			return int32(v), true
+		case int:
+			return int32(v), true
+		case int32:
+			return v, true
+		case int64:
+			return int32(v), true
+		case json.Number:
+			n, err := v.Int64()
+			return int32(n), err == nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 32)
+			return int32(n), err == nil
+		}
+	}
+	return 0, false
+}
+
+// recordFeatures 按要素ID索引编辑记录中保存的几何
+func recordFeatures(data []byte) map[int32]*geojson.Feature {
+	result := make(map[int32]*geojson.Feature)
+	if len(data) == 0 {
+		return result
+	}
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal(data, &fc); err == nil && len(fc.Features) > 0 {
+		for _, f := range fc.Features {
+			if f == nil {
+				continue
+			}
+			if id, ok := featureRecordID(f.Properties); ok {
+				result[id] = f
+			}
+		}
+		return result
+	}
+	if f := unmarshalFeature(data); f != nil {
+		if id, ok := featureRecordID(f.Properties); ok {
+			result[id] = f
+		}
+	}
+	return result
+}
+
+func recordIDs(data []byte) []int32 {
+	var ids []int32
+	json.Unmarshal(data, &ids)
+	return ids
+}
+
+func lineageFeatures(ids []int32, features map[int32]*geojson.Feature, withGeometry bool) []LineageFeature {
+	result := make([]LineageFeature, 0, len(ids))
+	for _, id := range ids {
+		item := LineageFeature{ID: id}
+		if withGeometry {
+			item.Feature = features[id]
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func toLineageStep(record models.GeoRecord, withGeometry bool) LineageStep {
+	return LineageStep{
+		RecordID:  record.ID,
+		Type:      record.Type,
+		Date:      record.Date,
+		Username:  record.Username,
+		BZ:        record.BZ,
+		SessionID: record.SessionID,
+		Status:    record.Status,
+		Inputs:    lineageFeatures(recordIDs(record.InputIDs), recordFeatures(record.OldGeojson), withGeometry),
+		Outputs:   lineageFeatures(recordIDs(record.OutputIDs), recordFeatures(record.NewGeojson), withGeometry),
+	}
+}
+
+type lineageWalker struct {
+	db           *gorm.DB
+	tableName    string
+	statuses     []string
+	withGeometry bool
+	seen         map[int64]bool
+	steps        []LineageStep
+}
+
+func (w *lineageWalker) done() bool {
+	return len(w.steps) >= lineageMaxSteps
+}
+
+// ancestors 查找生成该要素的最近一次操作，再沿其输入继续向前追溯
+func (w *lineageWalker) ancestors(id int32, beforeRecordID int64) {
+	if w.done() {
+		return
+	}
+	var record models.GeoRecord
+	err := w.db.Where("table_name = ? AND id < ? AND status IN ? AND output_ids @> ?",
+		w.tableName, beforeRecordID, w.statuses, fmt.Sprintf("[%d]", id)).
+		Order("id DESC").First(&record).Error
+	if err != nil || w.seen[record.ID] {
+		return
+	}
+	w.seen[record.ID] = true
+	w.steps = append(w.steps, toLineageStep(record, w.withGeometry))
+	for _, iid := range recordIDs(record.InputIDs) {
+		w.ancestors(iid, record.ID)
+	}
+}
+
+// descendants 查找之后第一次消费该要素的操作，再沿其输出继续向后追溯
+func (w *lineageWalker) descendants(id int32, afterRecordID int64) {
+	if w.done() {
+		return
+	}
+	var record models.GeoRecord
+	err := w.db.Where("table_name = ? AND id > ? AND status IN ? AND input_ids @> ?",
+		w.tableName, afterRecordID, w.statuses, fmt.Sprintf("[%d]", id)).
+		Order("id ASC").First(&record).Error
+	if err != nil || w.seen[record.ID] {
+		return
+	}
+	w.seen[record.ID] = true
+	w.steps = append(w.steps, toLineageStep(record, w.withGeometry))
+	for _, oid := range recordIDs(record.OutputIDs) {
+		w.descendants(oid, record.ID)
+	}
+}
+
+func (w *lineageWalker) sorted() []LineageStep {
+	steps := w.steps
+	sort.Slice(steps, func(i, j int) bool { return steps[i].RecordID < steps[j].RecordID })
+	return steps
+}
+
+// mappingLineage 沿映射表的父要素链向上、子要素向下收集映射
+func mappingLineage(db *gorm.DB, tableName string, id int32) (ancestors []LineageMapping, descendants []LineageMapping) {
+	toLineage := func(m models.OriginMapping) LineageMapping {
+		return LineageMapping{
+			PostGISID:       m.PostGISID,
+			ParentPostGISID: m.ParentPostGISID,
+			SourceObjectID:  m.SourceObjectID,
+			Origin:          m.Origin,
+			SessionID:       m.SessionID,
+			IsDeleted:       m.IsDeleted,
+		}
+	}
+	seen := map[int64]bool{}
+	current := id
+	for i := 0; i < lineageMaxSteps; i++ {
+		var mapping models.OriginMapping
+		if err := db.Where("table_name = ? AND post_gis_id = ?", tableName, current).
+			Order("id DESC").First(&mapping).Error; err != nil || seen[mapping.ID] {
+			break
+		}
+		seen[mapping.ID] = true
+		ancestors = append(ancestors, toLineage(mapping))
+		if mapping.ParentPostGISID == 0 {
+			break
+		}
+		current = mapping.ParentPostGISID
+	}
+
+	queue := []int32{id}
+	visited := map[int32]bool{id: true}
+	for len(queue) > 0 && len(descendants) < lineageMaxSteps {
+		parent := queue[0]
+		queue = queue[1:]
+		var children []models.OriginMapping
+		db.Where("table_name = ? AND parent_post_gis_id = ?", tableName, parent).Order("id").Find(&children)
+		for _, child := range children {
+			if seen[child.ID] {
+				continue
+			}
+			seen[child.ID] = true
+			descendants = append(descendants, toLineage(child))
+			if !visited[child.PostGISID] {
+				visited[child.PostGISID] = true
+				queue = append(queue, child.PostGISID)
+			}
+		}
+	}
+	return ancestors, descendants
+}
+
+// GetFeatureLineage 要素溯源：返回要素的完整来源和去向及每一步的几何
+func (uc *UserController) GetFeatureLineage(c *gin.Context) {
+	tableName := c.Query("TableName")
+	id, err := strconv.ParseInt(c.Query("ID"), 10, 32)
+	if tableName == "" || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "需要TableName和ID"})
+		return
+	}
+	statuses := []string{"applied"}
+	if c.Query("IncludeUndone") == "true" {
+		statuses = append(statuses, "undone")
+	}
+	withGeometry := c.DefaultQuery("Geometry", "true") != "false"
+	DB := models.DB
+	featureID := int32(id)
+
+	ancestorWalker := &lineageWalker{db: DB, tableName: tableName, statuses: statuses, withGeometry: withGeometry, seen: map[int64]bool{}}
+	ancestorWalker.ancestors(featureID, 1<<62)
+	// 从生成该要素的最近一次操作之后向后追溯，避免与来源中的修改记录重复
+	descendantWalker := &lineageWalker{db: DB, tableName: tableName, statuses: statuses, withGeometry: withGeometry, seen: map[int64]bool{}}
+	afterID := int64(0)
+	if len(ancestorWalker.steps) > 0 {
+		afterID = ancestorWalker.steps[0].RecordID
+	}
+	descendantWalker.descendants(featureID, afterID)
+
+	mappingAncestors, mappingDescendants := mappingLineage(DB, tableName, featureID)
+	var sourceObjectID int64 = -1
+	if len(mappingAncestors) > 0 {
+		sourceObjectID = mappingAncestors[len(mappingAncestors)-1].SourceObjectID
+	}
+
+	var current *geojson.Feature
+	if liveFeatureExists(DB, tableName, featureID) {
+		fc := GetGeo(getData{ID: featureID, TableName: tableName})
+		if len(fc.Features) > 0 {
+			current = fc.Features[0]
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "success",
+		"data": gin.H{
+			"table_name":          tableName,
+			"id":                  featureID,
+			"exists":              current != nil,
+			"current":             current,
+			"source_object_id":    sourceObjectID,
+			"ancestry":            ancestorWalker.sorted(),
+			"descendants":         descendantWalker.sorted(),
+			"mapping_ancestors":   mappingAncestors,
+			"mapping_descendants": mappingDescendants,
+			"truncated":           ancestorWalker.done() || descendantWalker.done(),
+		},
+	})
+}
+
+// loadLayerFeatures 读取图层要素，where为空时读取全部
+func loadLayerFeatures(db *gorm.DB, tableName string, where string, args ...interface{}) ([]*geojson.Feature, error) {
+	sql := fmt.Sprintf(`SELECT ST_AsGeoJSON(geom) AS geojson, to_jsonb(record) - 'geom' AS properties FROM "%s" AS record`, tableName)
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	sql += " ORDER BY id"
+	var rows []outData
+	if err := db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	features := make([]*geojson.Feature, 0, len(rows))
+	for _, row := range rows {
+		var geometry geojson.Geometry
+		if err := json.Unmarshal(row.GeoJson, &geometry); err != nil {
+			continue
+		}
+		feature := geojson.NewFeature(geometry.Geometry())
+		json.Unmarshal(row.Properties, &feature.Properties)
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// attributeOldValue 属性记录中的文本旧值按当前属性值的类型还原，无法转换时保留文本
+func attributeOldValue(current interface{}, old *string) interface{} {
+	if old == nil {
+		return nil
+	}
+	switch current.(type) {
+	case float64:
+		if v, err := strconv.ParseFloat(*old, 64); err == nil {
+			return v
+		}
+	case bool:
+		if v, err := strconv.ParseBool(*old); err == nil {
+			return v
+		}
+	}
+	return *old
+}
+
+// GetFeaturesAsOf 重建图层在指定编辑记录之后的要素集合
+// 从当前要素出发，按ID倒序逆向应用之后所有已生效的记录：几何记录移除其输出要素、恢复其输入要素，
+// 属性修改记录写回各字段的旧值；已撤销的记录视为未发生
+func (uc *UserController) GetFeaturesAsOf(c *gin.Context) {
+	tableName := c.Query("TableName")
+	recordID, err := strconv.ParseInt(c.Query("RecordID"), 10, 64)
+	if tableName == "" || err != nil || recordID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "需要TableName和RecordID"})
+		return
+	}
+	DB := models.DB
+	var target models.GeoRecord
+	if recordID > 0 {
+		if err := DB.Where("id = ?", recordID).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "编辑记录不存在"})
+			return
+		}
+		if target.TableName != tableName {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "编辑记录不属于该图层"})
+			return
+		}
+	}
+	var filter map[int32]bool
+	if ids := c.Query("IDs"); ids != "" {
+		filter = map[int32]bool{}
+		for _, s := range strings.Split(ids, ",") {
+			if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32); err == nil {
+				filter[int32(n)] = true
+			}
+		}
+	}
+
+	var later []models.GeoRecord
+	DB.Where("table_name = ? AND id > ? AND status = ?", tableName, recordID, "applied").
+		Order("id DESC").Find(&later)
+
+	affected := map[int32]bool{}
+	for _, r := range later {
+		for _, id := range recordIDs(r.InputIDs) {
+			affected[id] = true
+		}
+		for _, id := range recordIDs(r.OutputIDs) {
+			affected[id] = true
+		}
+	}
+	affectedIDs := make([]int32, 0, len(affected))
+	for id := range affected {
+		affectedIDs = append(affectedIDs, id)
+	}
+
+	state := map[int32]*geojson.Feature{}
+	if len(affectedIDs) > 0 {
+		current, err := loadLayerFeatures(DB, tableName, "id IN ?", affectedIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+			return
+		}
+		for _, f := range current {
+			if id, ok := featureRecordID(f.Properties); ok {
+				state[id] = f
+			}
+		}
+	}
+	attrChanges := map[int64][]models.AttributeRecord{}
+	var attrRecordIDs []int64
+	for _, r := range later {
+		if r.Type == attributeEditType || r.Type == attributeBulkEditType {
+			attrRecordIDs = append(attrRecordIDs, r.ID)
+		}
+	}
+	if len(attrRecordIDs) > 0 {
+		var changes []models.AttributeRecord
+		DB.Where("geo_record_id IN ?", attrRecordIDs).Find(&changes)
+		for _, ch := range changes {
+			attrChanges[ch.GeoRecordID] = append(attrChanges[ch.GeoRecordID], ch)
+		}
+	}
+	for _, r := range later {
+		// 属性修改记录的输入输出为同一批要素且不保存旧要素，逐字段写回旧值
+		if r.Type == attributeEditType || r.Type == attributeBulkEditType {
+			for _, ch := range attrChanges[r.ID] {
+				if f, ok := state[ch.GeoID]; ok {
+					f.Properties[ch.FieldName] = attributeOldValue(f.Properties[ch.FieldName], ch.OldValue)
+				}
+			}
+			continue
+		}
+		for _, id := range recordIDs(r.OutputIDs) {
+			delete(state, id)
+		}
+		oldFeatures := recordFeatures(r.OldGeojson)
+		for _, id := range recordIDs(r.InputIDs) {
+			if f, ok := oldFeatures[id]; ok {
+				state[id] = f
+			}
+		}
+	}
+
+	features := make([]*geojson.Feature, 0, len(state))
+	// Full=true 时同时返回之后未被改动的当前要素，得到完整图层
+	if c.Query("Full") == "true" {
+		where, args := "", []interface{}{}
+		if len(affectedIDs) > 0 {
+			where, args = "id NOT IN ?", []interface{}{affectedIDs}
+		}
+		unchanged, err := loadLayerFeatures(DB, tableName, where, args...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+			return
+		}
+		features = append(features, unchanged...)
+	}
+	changedIDs := make([]int32, 0, len(state))
+	for id := range state {
+		changedIDs = append(changedIDs, id)
+	}
+	sort.Slice(changedIDs, func(i, j int) bool { return changedIDs[i] < changedIDs[j] })
+	for _, id := range changedIDs {
+		features = append(features, state[id])
+	}
+	if filter != nil {
+		kept := features[:0]
+		for _, f := range features {
+			if id, ok := featureRecordID(f.Properties); ok && filter[id] {
+				kept = append(kept, f)
+			}
+		}
+		features = kept
+	}
+
+	data := gin.H{
+		"table_name":    tableName,
+		"record_id":     recordID,
+		"later_records": len(later),
+		"changed_ids":   changedIDs,
+		"geojson":       geojson.FeatureCollection{Type: "FeatureCollection", Features: features},
+	}
+	if recordID > 0 {
+		data["record"] = toRecordResponses([]models.GeoRecord{target})[0]
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": data})
+}
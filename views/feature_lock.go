@@ -0,0 +1,292 @@
+// views/feature_lock.go
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/config"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 锁默认有效期（分钟）
+const defaultLockMinutes = 30
+
+// ==================== 冲突检查 ====================
+
+// findLockConflicts 查找其他用户持有的、与指定要素或几何相交的未过期锁
+func findLockConflicts(db *gorm.DB, tableName string, username string, ids []int32, geoms []orb.Geometry) []models.FeatureLock {
+	now := timeNowStr()
+	var conflicts []models.FeatureLock
+
+	if len(ids) > 0 {
+		// 要素锁
+		var featureLocks []models.FeatureLock
+		db.Where("table_name = ? AND lock_type = ? AND feature_id IN ? AND username <> ? AND expires_at > ?",
+			tableName, "feature", ids, username, now).Find(&featureLocks)
+		conflicts = append(conflicts, featureLocks...)
+
+		// 范围锁：要素当前几何与锁定范围相交
+		var extentLocks []models.FeatureLock
+		sql := fmt.Sprintf(`
+			SELECT l.* FROM feature_lock l
+			WHERE l.table_name = ? AND l.lock_type = 'extent' AND l.username <> ? AND l.expires_at > ?
+			AND EXISTS (
+				SELECT 1 FROM "%s" t
+				WHERE t.id IN ? AND ST_Intersects(t.geom, ST_SetSRID(ST_GeomFromGeoJSON(l.extent::text), 4326))
+			)`, tableName)
+		db.Raw(sql, tableName, username, now, ids).Scan(&extentLocks)
+		conflicts = append(conflicts, extentLocks...)
+	}
+
+	for _, geom := range geoms {
+		if geom == nil {
+			continue
+		}
+		geomJSON, err := json.Marshal(geojson.NewGeometry(geom))
+		if err != nil {
+			continue
+		}
+		var extentLocks []models.FeatureLock
+		db.Raw(`
+			SELECT * FROM feature_lock
+			WHERE table_name = ? AND lock_type = 'extent' AND username <> ? AND expires_at > ?
+			AND ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), ST_SetSRID(ST_GeomFromGeoJSON(extent::text), 4326))`,
+			tableName, username, now, string(geomJSON)).Scan(&extentLocks)
+		conflicts = append(conflicts, extentLocks...)
+	}
+
+	// 去重
+	seen := make(map[int64]bool)
+	unique := make([]models.FeatureLock, 0, len(conflicts))
+	for _, l := range conflicts {
+		if !seen[l.ID] {
+			seen[l.ID] = true
+			unique = append(unique, l)
+		}
+	}
+	return unique
+}
+
+// checkEditLocks 编辑前检查锁，被他人锁定时直接返回423并中止请求
+func checkEditLocks(c *gin.Context, db *gorm.DB, tableName string, username string, ids []int32, geoms ...orb.Geometry) bool {
+	conflicts := findLockConflicts(db, tableName, username, ids, geoms)
+	if len(conflicts) == 0 {
+		return true
+	}
+	c.JSON(http.StatusLocked, gin.H{
+		"code":    423,
+		"message": fmt.Sprintf("要素已被%s锁定，暂不能编辑", conflicts[0].Username),
+		"data":    conflicts,
+	})
+	return false
+}
+
+// releaseSessionLocks 释放编辑会话持有的全部锁
+func releaseSessionLocks(db *gorm.DB, sessionID int64) {
+	if sessionID == 0 {
+		return
+	}
+	db.Where("session_id = ?", sessionID).Delete(&models.FeatureLock{})
+}
+
+// requestToken 请求携带的登录令牌，优先取Authorization头（可带Bearer前缀），其次取Token头
+func requestToken(c *gin.Context) string {
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" {
+		token = strings.TrimSpace(c.GetHeader("Token"))
+	}
+	return token
+}
+
+// isLockAdmin 按请求令牌识别登录用户，管理员（LoginUser.Grade为admin，由config.xml的LockAdmins授予）
+// 可以强制释放他人的锁，不信任请求体中的用户名
+func isLockAdmin(c *gin.Context, db *gorm.DB) bool {
+	token := requestToken(c)
+	if token == "" {
+		return false
+	}
+	var count int64
+	db.Model(&models.LoginUser{}).Where("token = ? AND grade = ?", token, "admin").Count(&count)
+	return count > 0
+}
+
+// ==================== 接口 ====================
+
+type acquireLockData struct {
+	TableName string                     `json:"TableName"`
+	IDs       []int32                    `json:"IDs"`
+	Extent    *geojson.FeatureCollection `json:"Extent"`
+	Username  string                     `json:"Username"`
+	SessionID int64                      `json:"SessionID"`
+	Minutes   int                        `json:"Minutes"`
+}
+
+// AcquireLock 检出要素锁或范围锁，已持有的锁会续期
+func (uc *UserController) AcquireLock(c *gin.Context) {
+	var jsonData acquireLockData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if len(jsonData.IDs) == 0 && (jsonData.Extent == nil || len(jsonData.Extent.Features) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "IDs和Extent不能同时为空"})
+		return
+	}
+	minutes := jsonData.Minutes
+	if minutes <= 0 {
+		minutes = defaultLockMinutes
+	}
+	DB := models.DB
+	now := timeNowStr()
+	expiresAt := time.Now().Add(time.Duration(minutes) * time.Minute).Format("2006-01-02 15:04:05")
+
+	// 清理过期锁
+	DB.Where("expires_at <= ?", now).Delete(&models.FeatureLock{})
+
+	var extentGeoms []orb.Geometry
+	if jsonData.Extent != nil {
+		for _, f := range jsonData.Extent.Features {
+			if f != nil && f.Geometry != nil {
+				extentGeoms = append(extentGeoms, f.Geometry)
+			}
+		}
+	}
+	conflicts := findLockConflicts(DB, jsonData.TableName, jsonData.Username, jsonData.IDs, extentGeoms)
+
+	// 范围锁还需检查范围内他人持有的要素锁
+	for _, geom := range extentGeoms {
+		geomJSON, _ := json.Marshal(geojson.NewGeometry(geom))
+		var featureLocks []models.FeatureLock
+		sql := fmt.Sprintf(`
+			SELECT l.* FROM feature_lock l
+			JOIN "%s" t ON t.id = l.feature_id
+			WHERE l.table_name = ? AND l.lock_type = 'feature' AND l.username <> ? AND l.expires_at > ?
+			AND ST_Intersects(t.geom, ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))`, jsonData.TableName)
+		DB.Raw(sql, jsonData.TableName, jsonData.Username, now, string(geomJSON)).Scan(&featureLocks)
+		conflicts = append(conflicts, featureLocks...)
+	}
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusLocked, gin.H{
+			"code":    423,
+			"message": fmt.Sprintf("部分要素已被%s锁定", conflicts[0].Username),
+			"data":    conflicts,
+		})
+		return
+	}
+
+	locks := make([]models.FeatureLock, 0)
+	for _, id := range jsonData.IDs {
+		var lock models.FeatureLock
+		err := DB.Where("table_name = ? AND lock_type = ? AND feature_id = ? AND username = ?",
+			jsonData.TableName, "feature", id, jsonData.Username).First(&lock).Error
+		if err != nil {
+			lock = models.FeatureLock{
+				TableName:  jsonData.TableName,
+				LockType:   "feature",
+				FeatureID:  id,
+				Username:   jsonData.Username,
+				DeviceName: config.DeviceName,
+				CreatedAt:  now,
+			}
+		}
+		lock.SessionID = jsonData.SessionID
+		lock.ExpiresAt = expiresAt
+		DB.Save(&lock)
+		locks = append(locks, lock)
+	}
+	for _, geom := range extentGeoms {
+		geomJSON, _ := json.Marshal(geojson.NewGeometry(geom))
+		lock := models.FeatureLock{
+			TableName:  jsonData.TableName,
+			LockType:   "extent",
+			Extent:     geomJSON,
+			Username:   jsonData.Username,
+			SessionID:  jsonData.SessionID,
+			DeviceName: config.DeviceName,
+			CreatedAt:  now,
+			ExpiresAt:  expiresAt,
+		}
+		DB.Create(&lock)
+		locks = append(locks, lock)
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": fmt.Sprintf("成功锁定，有效期至%s", expiresAt), "data": locks})
+}
+
+type releaseLockData struct {
+	LockIDs   []int64 `json:"LockIDs"`
+	TableName string  `json:"TableName"` // LockIDs为空时释放该用户在此图层上的全部锁
+	Username  string  `json:"Username"`
+}
+
+// ReleaseLock 释放自己持有的锁
+func (uc *UserController) ReleaseLock(c *gin.Context) {
+	var jsonData releaseLockData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	query := DB.Where("username = ?", jsonData.Username)
+	if len(jsonData.LockIDs) > 0 {
+		query = query.Where("id IN ?", jsonData.LockIDs)
+	} else if jsonData.TableName != "" {
+		query = query.Where("table_name = ?", jsonData.TableName)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "LockIDs和TableName不能同时为空"})
+		return
+	}
+	result := query.Delete(&models.FeatureLock{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "释放成功", "count": result.RowsAffected})
+}
+
+// ForceReleaseLock 管理员强制释放锁，管理员身份由请求头中的登录令牌确定。
+// 管理员在config.xml中配置，如<LockAdmins>zhangsan,lisi</LockAdmins>，服务启动时授予这些用户admin等级
+func (uc *UserController) ForceReleaseLock(c *gin.Context) {
+	var jsonData releaseLockData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	if !isLockAdmin(c, DB) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "只有管理员可以强制释放锁"})
+		return
+	}
+	var query *gorm.DB
+	if len(jsonData.LockIDs) > 0 {
+		query = DB.Where("id IN ?", jsonData.LockIDs)
+	} else if jsonData.TableName != "" {
+		query = DB.Where("table_name = ?", jsonData.TableName)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "LockIDs和TableName不能同时为空"})
+		return
+	}
+	result := query.Delete(&models.FeatureLock{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "强制释放成功", "count": result.RowsAffected})
+}
+
+// ListLocks 查询未过期的锁
+func (uc *UserController) ListLocks(c *gin.Context) {
+	TableName := c.Query("TableName")
+	Username := c.Query("Username")
+	DB := models.DB
+
+	query := DB.Where("expires_at > ?", timeNowStr())
+	if TableName != "" {
+		query = query.Where("table_name = ?", TableName)
+	}
+	if Username != "" {
+		query = query.Where("username = ?", Username)
+	}
+	var locks []models.FeatureLock
+	query.Order("id DESC").Find(&locks)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": locks})
+}
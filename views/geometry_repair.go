@@ -0,0 +1,632 @@
+// views/geometry_repair.go
+package views
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 几何问题类型
+const (
+	issueInvalid   = "无效几何"
+	issueSliver    = "狭长碎面"
+	issueSpike     = "尖刺"
+	issueDuplicate = "重复节点"
+	issueEmpty     = "空几何"
+)
+
+const geometryCheckBatch = 500
+
+type geometryCheckData struct {
+	TableName          string   `json:"TableName"`
+	Username           string   `json:"Username"`
+	Checks             []string `json:"Checks"`             // invalid / sliver / spike / duplicate / empty，为空时全部检查
+	MinArea            float64  `json:"MinArea"`            // 碎面面积阈值(平方米)，默认1
+	Thinness           float64  `json:"Thinness"`           // 碎面狭长度阈值 4πA/P²，0表示不按狭长度判断
+	SpikeAngle         float64  `json:"SpikeAngle"`         // 尖刺角度阈值(度)，默认1
+	DuplicateTolerance float64  `json:"DuplicateTolerance"` // 重复节点距离容差(米)，默认0.001
+	Fix                bool     `json:"Fix"`                // 是否修复
+	SliverAction       string   `json:"SliverAction"`       // report / delete，碎面默认只报告
+}
+
+// GeometryIssue 报告中的一条问题
+type GeometryIssue struct {
+	FeatureID int32   `json:"FeatureID"`
+	Type      string  `json:"Type"`
+	Detail    string  `json:"Detail"`
+	X         float64 `json:"X"`
+	Y         float64 `json:"Y"`
+	Action    string  `json:"Action"`
+}
+
+func (d *geometryCheckData) enabled(check string) bool {
+	if len(d.Checks) == 0 {
+		return true
+	}
+	for _, c := range d.Checks {
+		if c == check {
+			return true
+		}
+	}
+	return false
+}
+
+// ==================== 几何检查 ====================
+
+// planarVector 以p为原点的局部平面坐标（米）
+func planarVector(p, q orb.Point) (float64, float64) {
+	cosLat := math.Cos(p[1] * math.Pi / 180)
+	return (q[0] - p[0]) * 111320 * cosLat, (q[1] - p[1]) * 111320
+}
+
+func planarDistance(p, q orb.Point) float64 {
+	dx, dy := planarVector(p, q)
+	return math.Hypot(dx, dy)
+}
+
+// vertexAngle 顶点处两条边的夹角(度)
+func vertexAngle(prev, p, next orb.Point) float64 {
+	ax, ay := planarVector(p, prev)
+	bx, by := planarVector(p, next)
+	la, lb := math.Hypot(ax, ay), math.Hypot(bx, by)
+	if la == 0 || lb == 0 {
+		return 180
+	}
+	cos := (ax*bx + ay*by) / (la * lb)
+	return math.Acos(math.Max(-1, math.Min(1, cos))) * 180 / math.Pi
+}
+
+// removeDuplicatePoints 去除相邻的重复节点
+func removeDuplicatePoints(points []orb.Point, tolerance float64) ([]orb.Point, []orb.Point) {
+	if len(points) < 2 {
+		return points, nil
+	}
+	result := []orb.Point{points[0]}
+	var removed []orb.Point
+	for _, p := range points[1:] {
+		if planarDistance(result[len(result)-1], p) <= tolerance {
+			removed = append(removed, p)
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, removed
+}
+
+// removeRingSpikes 去除环上的尖刺顶点，返回新环和被去除的顶点
+func removeRingSpikes(ring orb.Ring, angle float64) (orb.Ring, []orb.Point) {
+	closed := len(ring) > 1 && ring[0] == ring[len(ring)-1]
+	points := []orb.Point(ring)
+	if closed {
+		points = points[:len(points)-1]
+	}
+	var removed []orb.Point
+	for changed := true; changed && len(points) > 3; {
+		changed = false
+		for i := 0; i < len(points); i++ {
+			prev := points[(i-1+len(points))%len(points)]
+			next := points[(i+1)%len(points)]
+			if vertexAngle(prev, points[i], next) < angle {
+				removed = append(removed, points[i])
+				points = append(points[:i:i], points[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	if closed && len(points) > 0 {
+		points = append(points, points[0])
+	}
+	return orb.Ring(points), removed
+}
+
+// removeLineSpikes 去除线内部的尖刺顶点
+func removeLineSpikes(ls orb.LineString, angle float64) (orb.LineString, []orb.Point) {
+	points := []orb.Point(ls)
+	var removed []orb.Point
+	for changed := true; changed && len(points) > 2; {
+		changed = false
+		for i := 1; i < len(points)-1; i++ {
+			if vertexAngle(points[i-1], points[i], points[i+1]) < angle {
+				removed = append(removed, points[i])
+				points = append(points[:i:i], points[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	return orb.LineString(points), removed
+}
+
+// cleanGeometry 按参数去除重复节点和尖刺，返回清理后的几何及发现的问题点
+func cleanGeometry(geom orb.Geometry, opts *geometryCheckData) (orb.Geometry, []orb.Point, []orb.Point) {
+	var duplicates, spikes []orb.Point
+	cleanRing := func(r orb.Ring) orb.Ring {
+		if opts.enabled("duplicate") {
+			// 闭合点不参与去重，最后一个顶点还需与起点比较
+			closed := len(r) > 1 && r[0] == r[len(r)-1]
+			pts := []orb.Point(r)
+			if closed {
+				pts = pts[:len(pts)-1]
+			}
+			pts, removed := removeDuplicatePoints(pts, opts.DuplicateTolerance)
+			if closed && len(pts) > 1 && planarDistance(pts[len(pts)-1], pts[0]) <= opts.DuplicateTolerance {
+				removed = append(removed, pts[len(pts)-1])
+				pts = pts[:len(pts)-1]
+			}
+			if closed && len(pts) > 0 {
+				pts = append(pts, pts[0])
+			}
+			duplicates = append(duplicates, removed...)
+			r = orb.Ring(pts)
+		}
+		if opts.enabled("spike") {
+			var removed []orb.Point
+			r, removed = removeRingSpikes(r, opts.SpikeAngle)
+			spikes = append(spikes, removed...)
+		}
+		return r
+	}
+	cleanLine := func(ls orb.LineString) orb.LineString {
+		if opts.enabled("duplicate") {
+			pts, removed := removeDuplicatePoints(ls, opts.DuplicateTolerance)
+			duplicates = append(duplicates, removed...)
+			ls = orb.LineString(pts)
+		}
+		if opts.enabled("spike") {
+			var removed []orb.Point
+			ls, removed = removeLineSpikes(ls, opts.SpikeAngle)
+			spikes = append(spikes, removed...)
+		}
+		return ls
+	}
+
+	switch g := geom.(type) {
+	case orb.LineString:
+		return cleanLine(g), duplicates, spikes
+	case orb.MultiLineString:
+		result := make(orb.MultiLineString, 0, len(g))
+		for _, ls := range g {
+			result = append(result, cleanLine(ls))
+		}
+		return result, duplicates, spikes
+	case orb.Polygon:
+		result := make(orb.Polygon, 0, len(g))
+		for _, r := range g {
+			result = append(result, cleanRing(r))
+		}
+		return result, duplicates, spikes
+	case orb.MultiPolygon:
+		result := make(orb.MultiPolygon, 0, len(g))
+		for _, poly := range g {
+			newPoly := make(orb.Polygon, 0, len(poly))
+			for _, r := range poly {
+				newPoly = append(newPoly, cleanRing(r))
+			}
+			result = append(result, newPoly)
+		}
+		return result, duplicates, spikes
+	}
+	return geom, nil, nil
+}
+
+// makeValidGeometry 使用ST_MakeValid修复几何，保持原几何维度和单/多部件类型
+func makeValidGeometry(db *gorm.DB, geom orb.Geometry) (orb.Geometry, error) {
+	geomJSON, err := json.Marshal(geojson.NewGeometry(geom))
+	if err != nil {
+		return nil, err
+	}
+	dim := geom.Dimensions() + 1
+	var result struct {
+		GeoJSON string
+		Empty   bool
+	}
+	err = db.Raw(`
+		WITH v AS (
+			SELECT ST_GeomFromGeoJSON(?) AS orig,
+				ST_CollectionExtract(ST_MakeValid(ST_GeomFromGeoJSON(?)), ?) AS fixed
+		)
+		SELECT ST_AsGeoJSON(CASE
+				WHEN GeometryType(orig) LIKE 'MULTI%' THEN ST_Multi(fixed)
+				WHEN ST_NumGeometries(fixed) = 1 THEN ST_GeometryN(fixed, 1)
+				ELSE fixed END, 15) AS geo_json,
+			ST_IsEmpty(fixed) AS empty
+		FROM v`, string(geomJSON), string(geomJSON), dim).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	if result.Empty || result.GeoJSON == "" {
+		return nil, fmt.Errorf("修复后几何为空")
+	}
+	g, err := geojson.UnmarshalGeometry([]byte(result.GeoJSON))
+	if err != nil {
+		return nil, err
+	}
+	return g.Geometry(), nil
+}
+
+// ==================== 任务执行 ====================
+
+type geometryCheckRow struct {
+	ID        int32
+	GeoJSON   *string
+	Empty     bool
+	Valid     bool
+	Reason    *string
+	X         *float64
+	Y         *float64
+	Area      float64
+	Perimeter float64
+}
+
+func runGeometryCheck(task models.GeometryCheckTask, opts geometryCheckData) {
+	DB := models.DB
+	var issues []GeometryIssue
+	summary := make(map[string]int)
+	fixed := 0
+	var session models.EditSession
+
+	fail := func(msg string) {
+		DB.Model(&models.GeometryCheckTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"status": 2, "message": msg, "finished_at": timeNowStr(),
+		})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("几何检查任务 %s 异常: %v", task.TaskID, r)
+			fail(fmt.Sprintf("任务异常: %v", r))
+		}
+	}()
+
+	if opts.Fix {
+		// 修复操作单独成会话，可整体撤销
+		session = models.EditSession{
+			TableName: opts.TableName,
+			Username:  opts.Username,
+			CreatedAt: timeNowStr(),
+			Status:    "committed",
+			Name:      "几何修复: " + task.TaskID,
+		}
+		DB.Create(&session)
+		DB.Model(&models.GeometryCheckTask{}).Where("id = ?", task.ID).Update("session_id", session.ID)
+	}
+
+	var lastID int32
+	var checked int64
+	for {
+		var rows []geometryCheckRow
+		sql := fmt.Sprintf(`
+			SELECT id,
+				ST_AsGeoJSON(geom, 15) AS geo_json,
+				(geom IS NULL OR ST_IsEmpty(geom)) AS empty,
+				COALESCE(ST_IsValid(geom), true) AS valid,
+				CASE WHEN geom IS NOT NULL AND NOT ST_IsValid(geom) THEN ST_IsValidReason(geom) END AS reason,
+				CASE WHEN geom IS NOT NULL AND NOT ST_IsValid(geom) THEN ST_X((ST_IsValidDetail(geom)).location) END AS x,
+				CASE WHEN geom IS NOT NULL AND NOT ST_IsValid(geom) THEN ST_Y((ST_IsValidDetail(geom)).location) END AS y,
+				CASE WHEN ST_Dimension(geom) = 2 AND ST_IsValid(geom) THEN ST_Area(geom::geography) ELSE 0 END AS area,
+				CASE WHEN ST_Dimension(geom) = 2 AND ST_IsValid(geom) THEN ST_Perimeter(geom::geography) ELSE 0 END AS perimeter
+			FROM "%s" WHERE id > ? ORDER BY id LIMIT %d`, opts.TableName, geometryCheckBatch)
+		if err := DB.Raw(sql, lastID).Scan(&rows).Error; err != nil {
+			fail("读取要素失败: " + err.Error())
+			return
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastID = row.ID
+			rowIssues, didFix := checkGeometryRow(DB, row, &opts, session)
+			for _, issue := range rowIssues {
+				summary[issue.Type]++
+			}
+			issues = append(issues, rowIssues...)
+			if didFix {
+				fixed++
+			}
+		}
+		checked += int64(len(rows))
+		progress := 100
+		if task.Total > 0 {
+			progress = int(checked * 100 / task.Total)
+		}
+		DB.Model(&models.GeometryCheckTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"checked": checked, "progress": progress, "issue_count": len(issues), "fixed_count": fixed,
+		})
+	}
+
+	reportPath, err := writeGeometryReport(task, issues)
+	if err != nil {
+		fail("写入报告失败: " + err.Error())
+		return
+	}
+	summaryJSON, _ := json.Marshal(summary)
+	DB.Model(&models.GeometryCheckTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"status":      1,
+		"progress":    100,
+		"checked":     checked,
+		"issue_count": len(issues),
+		"fixed_count": fixed,
+		"summary":     summaryJSON,
+		"report_path": reportPath,
+		"message":     fmt.Sprintf("检查%d个要素，发现问题%d处，处理要素%d个", checked, len(issues), fixed),
+		"finished_at": timeNowStr(),
+	})
+}
+
+// checkGeometryRow 检查单个要素，需要修复时通过编辑记录写回
+func checkGeometryRow(DB *gorm.DB, row geometryCheckRow, opts *geometryCheckData, session models.EditSession) ([]GeometryIssue, bool) {
+	var issues []GeometryIssue
+	action := "仅报告"
+
+	// 空几何
+	if row.Empty {
+		if !opts.enabled("empty") {
+			return nil, false
+		}
+		issue := GeometryIssue{FeatureID: row.ID, Type: issueEmpty, Detail: "几何为空", Action: action}
+		if opts.Fix {
+			issue.Action = applyGeometryFix(DB, row.ID, nil, opts, session, "删除空几何")
+		}
+		return []GeometryIssue{issue}, issue.Action == "已删除"
+	}
+	if row.GeoJSON == nil {
+		return nil, false
+	}
+	g, err := geojson.UnmarshalGeometry([]byte(*row.GeoJSON))
+	if err != nil || g.Geometry() == nil {
+		return nil, false
+	}
+	geom := g.Geometry()
+
+	// 碎面
+	if opts.enabled("sliver") && row.Area > 0 {
+		thinness := 0.0
+		if row.Perimeter > 0 {
+			thinness = 4 * math.Pi * row.Area / (row.Perimeter * row.Perimeter)
+		}
+		if row.Area < opts.MinArea || (opts.Thinness > 0 && thinness < opts.Thinness) {
+			center := geom.Bound().Center()
+			issue := GeometryIssue{FeatureID: row.ID, Type: issueSliver, X: center[0], Y: center[1], Action: action,
+				Detail: fmt.Sprintf("面积%.3f平方米，狭长度%.4f", row.Area, thinness)}
+			if opts.Fix && opts.SliverAction == "delete" {
+				issue.Action = applyGeometryFix(DB, row.ID, nil, opts, session, "删除碎面")
+				return []GeometryIssue{issue}, issue.Action == "已删除"
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	// 无效几何
+	if opts.enabled("invalid") && !row.Valid {
+		issue := GeometryIssue{FeatureID: row.ID, Type: issueInvalid, Action: action}
+		if row.Reason != nil {
+			issue.Detail = *row.Reason
+		}
+		if row.X != nil && row.Y != nil {
+			issue.X, issue.Y = *row.X, *row.Y
+		}
+		issues = append(issues, issue)
+	}
+
+	// 重复节点和尖刺
+	cleaned, duplicates, spikes := cleanGeometry(geom, opts)
+	for _, p := range duplicates {
+		issues = append(issues, GeometryIssue{FeatureID: row.ID, Type: issueDuplicate, Detail: "相邻节点重复", X: p[0], Y: p[1], Action: action})
+	}
+	for _, p := range spikes {
+		issues = append(issues, GeometryIssue{FeatureID: row.ID, Type: issueSpike, Detail: fmt.Sprintf("顶点夹角小于%.2f度", opts.SpikeAngle), X: p[0], Y: p[1], Action: action})
+	}
+
+	needFix := false
+	for _, issue := range issues {
+		if issue.Type != issueSliver {
+			needFix = true
+		}
+	}
+	if !opts.Fix || !needFix {
+		return issues, false
+	}
+	result := applyGeometryFix(DB, row.ID, cleaned, opts, session, "修复几何")
+	for i := range issues {
+		if issues[i].Type != issueSliver {
+			issues[i].Action = result
+		}
+	}
+	return issues, result == "已修复"
+}
+
+// applyGeometryFix 写回修复后的几何，geom为空时删除要素，返回处理结果
+func applyGeometryFix(DB *gorm.DB, id int32, geom orb.Geometry, opts *geometryCheckData, session models.EditSession, bz string) string {
+	if len(findLockConflicts(DB, opts.TableName, opts.Username, []int32{id}, nil)) > 0 {
+		return "跳过(已被锁定)"
+	}
+	if geom == nil {
+		deleteFeatureWithRecord(DB, opts.TableName, id, opts.Username, "几何检查: "+bz, session)
+		return "已删除"
+	}
+	valid, err := makeValidGeometry(DB, geom)
+	if err != nil {
+		return "修复失败: " + err.Error()
+	}
+	feature := geojson.NewFeature(valid)
+	fc := geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}
+	changeFeatureWithRecord(DB, opts.TableName, id, fc, opts.Username, "几何检查: "+bz, session)
+
+	// 写入结果以数据库为准，例如单部件字段写入多部件几何会失败
+	var ok bool
+	DB.Raw(fmt.Sprintf(`SELECT COALESCE(ST_IsValid(geom), false) FROM "%s" WHERE id = ?`, opts.TableName), id).Scan(&ok)
+	if !ok {
+		return "修复失败: 写入后几何仍无效"
+	}
+	return "已修复"
+}
+
+// writeGeometryReport 生成CSV报告（带BOM，Excel可直接打开）
+func writeGeometryReport(task models.GeometryCheckTask, issues []GeometryIssue) (string, error) {
+	homeDir, _ := os.UserHomeDir()
+	outDir := filepath.Join(homeDir, "BoundlessMap", "OutFile", task.TaskID)
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	reportPath := filepath.Join(outDir, task.TableName+"_几何检查报告.csv")
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	file.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"要素ID", "问题类型", "说明", "X", "Y", "处理结果"})
+	for _, issue := range issues {
+		writer.Write([]string{
+			strconv.Itoa(int(issue.FeatureID)),
+			issue.Type,
+			issue.Detail,
+			strconv.FormatFloat(issue.X, 'f', 8, 64),
+			strconv.FormatFloat(issue.Y, 'f', 8, 64),
+			issue.Action,
+		})
+	}
+	writer.Flush()
+	return reportPath, writer.Error()
+}
+
+// ==================== 接口 ====================
+
+// StartGeometryCheck 创建几何检查/修复任务，后台执行
+func (uc *UserController) StartGeometryCheck(c *gin.Context) {
+	var jsonData geometryCheckData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if jsonData.TableName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "TableName不能为空"})
+		return
+	}
+	if jsonData.MinArea <= 0 {
+		jsonData.MinArea = 1
+	}
+	if jsonData.SpikeAngle <= 0 {
+		jsonData.SpikeAngle = 1
+	}
+	if jsonData.DuplicateTolerance <= 0 {
+		jsonData.DuplicateTolerance = 0.001
+	}
+	if jsonData.SliverAction == "" {
+		jsonData.SliverAction = "report"
+	}
+	DB := models.DB
+	var total int64
+	if err := DB.Table(jsonData.TableName).Count(&total).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "图层不存在: " + err.Error()})
+		return
+	}
+	// 修复直接写入线上表，用户在该图层上有活动的版本化会话时拒绝
+	if jsonData.Fix && rejectInVersion(c, DB, jsonData.TableName, jsonData.Username, 0) {
+		return
+	}
+	args, _ := json.Marshal(jsonData)
+	task := models.GeometryCheckTask{
+		TaskID:    uuid.New().String(),
+		TableName: jsonData.TableName,
+		Username:  jsonData.Username,
+		Status:    0,
+		Total:     total,
+		Args:      args,
+		CreatedAt: timeNowStr(),
+	}
+	DB.Create(&task)
+	go runGeometryCheck(task, jsonData)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "几何检查任务已创建", "task_id": task.TaskID})
+}
+
+// GetGeometryCheckTask 查询任务进度和结果
+func (uc *UserController) GetGeometryCheckTask(c *gin.Context) {
+	TaskID := c.Query("TaskID")
+	DB := models.DB
+	var task models.GeometryCheckTask
+	if err := DB.Where("task_id = ?", TaskID).First(&task).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "任务不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": task})
+}
+
+// ListGeometryCheckTasks 查询图层的检查任务
+func (uc *UserController) ListGeometryCheckTasks(c *gin.Context) {
+	TableName := c.Query("TableName")
+	DB := models.DB
+	var tasks []models.GeometryCheckTask
+	query := DB.Order("id DESC")
+	if TableName != "" {
+		query = query.Where("table_name = ?", TableName)
+	}
+	query.Find(&tasks)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": tasks})
+}
+
+// DownloadGeometryCheckReport 下载检查报告
+func (uc *UserController) DownloadGeometryCheckReport(c *gin.Context) {
+	TaskID := c.Query("TaskID")
+	DB := models.DB
+	var task models.GeometryCheckTask
+	if err := DB.Where("task_id = ?", TaskID).First(&task).Error; err != nil || task.ReportPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "报告不存在"})
+		return
+	}
+	c.FileAttachment(task.ReportPath, filepath.Base(task.ReportPath))
+}
+
+// UndoGeometryRepair 撤销任务中的全部修复操作
+func (uc *UserController) UndoGeometryRepair(c *gin.Context) {
+	TaskID := c.Query("TaskID")
+	DB := models.DB
+	var task models.GeometryCheckTask
+	if err := DB.Where("task_id = ?", TaskID).First(&task).Error; err != nil || task.SessionID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "任务不存在或没有修复操作"})
+		return
+	}
+	if task.Status == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "任务仍在运行"})
+		return
+	}
+	var records []models.GeoRecord
+	DB.Where("session_id = ? AND status = ?", task.SessionID, "applied").Order("seq_no DESC").Find(&records)
+	count := 0
+	var errs []string
+	for _, record := range records {
+		// 级联回退时可能已被撤销
+		var current models.GeoRecord
+		if err := DB.Where("id = ?", record.ID).First(&current).Error; err != nil || current.Status != "applied" {
+			continue
+		}
+		all, _ := collectRollbackRecords(DB, current)
+		for _, rec := range all {
+			if err := rollbackSingleRecord(DB, rec); err != nil {
+				errs = append(errs, fmt.Sprintf("回退记录ID=%d失败: %v", rec.ID, err))
+			} else {
+				count++
+			}
+		}
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusOK, gin.H{"code": 207, "message": "部分回退成功", "rolled_back": count, "errors": errs})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "回退成功", "rolled_back": count})
+}
@@ -41,9 +41,11 @@ type Statistic struct {
 	GroupedResult []methods.Result
 }
 type SearchDataChilden struct {
-	TableName   string
-	TableNameCN string
-	Attribute   string
+	TableName    string
+	TableNameCN  string
+	Attribute    string
+	RollUp       string // 按分类字典汇总：1 一级类 / 2 二级类 / category 三大类
+	ClassVersion string // 分类版本，为空时使用内置三调工作分类
 }
 type SearchData struct {
 	IntersectList []SearchDataChilden
@@ -54,9 +56,20 @@ func (uc *UserController) SpaceIntersect(c *gin.Context) {
 	var jsonData SearchData
 	c.BindJSON(&jsonData) //将前端geojson转换为geo对象
 	var result_data []interface{}
+	dicts := map[string]*methods.LandClassDict{}
 	for _, item := range jsonData.IntersectList {
 		TableName := item.TableName
 		groupedResult := methods.GeoIntersect(jsonData.Geojson, TableName, item.Attribute)
+		if item.RollUp != "" {
+			dict, ok := dicts[item.ClassVersion]
+			if !ok {
+				dict, _ = methods.LoadLandClassDict(models.DB, item.ClassVersion)
+				dicts[item.ClassVersion] = dict
+			}
+			if dict != nil {
+				groupedResult = methods.RollUpResults(groupedResult, dict, item.RollUp)
+			}
+		}
 		var data = Statistic{
 			TableName:     item.TableNameCN,
 			Attribute:     item.Attribute,
@@ -549,13 +562,14 @@ func (uc *UserController) AddSchema(c *gin.Context) {
 
 		// 处理 GDB 文件
 		gdbfiles := Transformer.FindFiles(dirpath, "gdb")
-
+		var importedTables []string
 		for _, gdbfile := range gdbfiles {
 
 			ENS := pgmvt.AddGDBDirectlyOptimized(DB, gdbfile, nil, Main, Color, Opacity, Userunits, LineWidth)
 			for _, item := range ENS {
 				MakeGeoIndex(item)
 			}
+			importedTables = append(importedTables, ENS...)
 		}
 
 		// 处理 SHP 文件
@@ -564,6 +578,7 @@ func (uc *UserController) AddSchema(c *gin.Context) {
 		if len(shpfiles) > 0 {
 			EN2 := pgmvt.AddSHPDirectlyOptimized(DB, shpfiles[0], EN, CN, Main, Color, Opacity, Userunits, LineWidth)
 			MakeGeoIndex(EN2)
+			importedTables = append(importedTables, EN2)
 		}
 
 		// 清理临时文件（可选）
@@ -572,7 +587,7 @@ func (uc *UserController) AddSchema(c *gin.Context) {
 				log.Printf("Failed to cleanup temp directory: %v", err)
 			}
 		}()
-		c.String(http.StatusOK, "Schema added successfully")
+		respondImportResult(c, DB, "Schema added successfully", importedTables)
 		return
 	}
 
@@ -589,12 +604,16 @@ func (uc *UserController) AddSchema(c *gin.Context) {
 			for _, item := range ENS {
 				MakeGeoIndex(item)
 			}
+			respondImportResult(c, DB, "Schema added successfully", ENS)
+			return
 		}
 
 		// 处理 SHP 文件
 		if ext == ".shp" {
 			EN2 := pgmvt.AddSHPDirectlyOptimized(DB, VectorPath, EN, CN, Main, Color, Opacity, Userunits, LineWidth)
 			MakeGeoIndex(EN2)
+			respondImportResult(c, DB, "Schema added successfully", []string{EN2})
+			return
 		}
 
 		c.String(http.StatusOK, "Schema added successfully")
@@ -0,0 +1,372 @@
+package views
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"gitee.com/gooffice/gooffice/spreadsheet"
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== 土地利用分类字典 ====================
+
+// ListLandClassVersions 查询分类版本及地类数量
+func (uc *UserController) ListLandClassVersions(c *gin.Context) {
+	type versionCount struct {
+		Version string `json:"version"`
+		Count   int64  `json:"count"`
+	}
+	var data []versionCount
+	models.DB.Model(&models.LandClass{}).Select("version, COUNT(*) AS count").Group("version").Order("version").Scan(&data)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": data, "default": models.DefaultLandClassVersion})
+}
+
+// ListLandClasses 查询某一版本的地类
+func (uc *UserController) ListLandClasses(c *gin.Context) {
+	dict, err := methods.LoadLandClassDict(models.DB, c.Query("Version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "version": dict.Version, "data": dict.Classes})
+}
+
+// readLandClassFile 读取CSV或Excel文件的第一个工作表
+func readLandClassFile(name string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".txt":
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		reader.FieldsPerRecord = -1
+		return reader.ReadAll()
+	case ".xlsx":
+		wb, err := spreadsheet.Read(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("读取Excel失败: %v", err)
+		}
+		defer wb.Close()
+		sheets := wb.Sheets()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("Excel没有工作表")
+		}
+		var rows [][]string
+		for _, row := range sheets[0].Rows() {
+			var values []string
+			for _, cell := range row.Cells() {
+				// 空单元格不在Cells中，按列号补齐
+				col, err := cell.Column()
+				if err != nil {
+					continue
+				}
+				idx := excelColumnIndex(col)
+				for len(values) < idx {
+					values = append(values, "")
+				}
+				values = append(values, cell.GetFormattedValue())
+			}
+			rows = append(rows, values)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("只支持CSV或xlsx文件")
+}
+
+// excelColumnIndex 列字母转为从0开始的序号
+func excelColumnIndex(col string) int {
+	idx := 0
+	for _, ch := range strings.ToUpper(col) {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		idx = idx*26 + int(ch-'A'+1)
+	}
+	return idx - 1
+}
+
+// ImportLandClasses 从CSV或Excel导入分类版本，已存在的版本整体替换
+func (uc *UserController) ImportLandClasses(c *gin.Context) {
+	version := strings.TrimSpace(c.PostForm("Version"))
+	if version == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Version不能为空"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "请上传文件"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	rows, err := readLandClassFile(file.Filename, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	classes, err := methods.ParseLandClassRows(rows)
+	if err == nil {
+		err = methods.ValidateLandClasses(classes)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	var invalidColors []string
+	for i := range classes {
+		classes[i].Version = version
+		if classes[i].Color != "" && !isValidColor(classes[i].Color) {
+			invalidColors = append(invalidColors, classes[i].Code)
+			classes[i].Color = ""
+		}
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("version = ?", version).Delete(&models.LandClass{}).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(&classes, 200).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "导入失败: " + err.Error()})
+		return
+	}
+	response := gin.H{"code": 200, "message": fmt.Sprintf("导入地类%d个", len(classes)), "data": classes}
+	if len(invalidColors) > 0 {
+		response["warning"] = "部分颜色格式不合法已被忽略"
+		response["invalid_colors"] = invalidColors
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DelLandClassVersion 删除分类版本，内置版本不能删除
+func (uc *UserController) DelLandClassVersion(c *gin.Context) {
+	version := c.Query("Version")
+	if version == models.DefaultLandClassVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "内置分类版本不能删除"})
+		return
+	}
+	models.DB.Where("version = ?", version).Delete(&models.LandClass{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "删除成功"})
+}
+
+type landClassFieldData struct {
+	TableName string `json:"TableName"`
+	Field     string `json:"Field"`
+	Version   string `json:"Version"`
+}
+
+// CheckLandClassField 检查图层地类字段中不在分类字典内的值
+func (uc *UserController) CheckLandClassField(c *gin.Context) {
+	var jsonData landClassFieldData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	table := strings.ToLower(jsonData.TableName)
+	field := strings.ToLower(jsonData.Field)
+	if !tableColumns(DB, table)[field] {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "字段不存在: " + jsonData.Field})
+		return
+	}
+	dict, err := methods.LoadLandClassDict(DB, jsonData.Version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	var values []struct {
+		Value string `json:"value"`
+		Count int64  `json:"count"`
+	}
+	DB.Raw(fmt.Sprintf(`SELECT COALESCE("%s"::text, '') AS value, COUNT(*) AS count FROM "%s" GROUP BY 1 ORDER BY 1`, field, table)).Scan(&values)
+	type invalidValue struct {
+		Value string `json:"value"`
+		Count int64  `json:"count"`
+	}
+	invalid := []invalidValue{}
+	var total, invalidCount int64
+	for _, v := range values {
+		total += v.Count
+		if _, ok := dict.Lookup(v.Value); !ok {
+			invalid = append(invalid, invalidValue{v.Value, v.Count})
+			invalidCount += v.Count
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "success",
+		"valid":   len(invalid) == 0,
+		"data": gin.H{
+			"version":       dict.Version,
+			"total":         total,
+			"invalid_count": invalidCount,
+			"invalid":       invalid,
+		},
+	})
+}
+
+// ApplyLandClassColors 按分类字典颜色生成图层分类配色，字典中没有的值自动分配颜色
+func (uc *UserController) ApplyLandClassColors(c *gin.Context) {
+	var jsonData landClassFieldData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	table := strings.ToLower(jsonData.TableName)
+	field := strings.ToLower(jsonData.Field)
+	dict, err := methods.LoadLandClassDict(DB, jsonData.Version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	values, err := getTablePropertyValues(DB, table, field)
+	if err != nil || len(values) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "表中没有可用的属性值"})
+		return
+	}
+	var colorMaps []CMap
+	var unmatched []string
+	for _, v := range values {
+		class, ok := dict.Lookup(v)
+		// 二级类未配色时使用一级类颜色
+		if ok && class.Color == "" {
+			class = dict.Ancestor(class, 1)
+		}
+		if ok && class.Color != "" {
+			colorMaps = append(colorMaps, CMap{Property: v, Color: class.Color})
+		} else {
+			unmatched = append(unmatched, v)
+		}
+	}
+	for i, color := range generateDistinctColors(len(unmatched)) {
+		colorMaps = append(colorMaps, CMap{Property: unmatched[i], Color: color})
+	}
+	if err := fixAttColorSequence(); err != nil {
+		log.Printf("警告：修复序列失败: %v", err)
+	}
+	data := buildAttColorRecords(colorMaps, table, field)
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("layer_name = ?", table).Delete(&models.AttColor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&data).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "保存颜色配置失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":                200,
+		"message":             "颜色配置保存成功",
+		"saved_count":         len(data),
+		"auto_assigned_count": len(unmatched),
+		"unmatched":           unmatched,
+	})
+}
+
+type landClassDomainData struct {
+	Version   string `json:"Version"`
+	TableName string `json:"TableName"`
+	CodeField string `json:"CodeField"` // 地类编码字段，如 dlbm
+	NameField string `json:"NameField"` // 地类名称字段，如 dlmc
+}
+
+// LandClassToDomain 由分类字典生成编码、名称属性域，并为图层地类字段添加属性域校验规则
+func (uc *UserController) LandClassToDomain(c *gin.Context) {
+	var jsonData landClassDomainData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	dict, err := methods.LoadLandClassDict(DB, jsonData.Version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	table := strings.ToLower(jsonData.TableName)
+	cols := tableColumns(DB, table)
+	for _, f := range []string{jsonData.CodeField, jsonData.NameField} {
+		if f != "" && !cols[strings.ToLower(f)] {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "字段不存在: " + f})
+			return
+		}
+	}
+
+	codeValues := make([]models.CodedValue, len(dict.Classes))
+	nameValues := make([]models.CodedValue, len(dict.Classes))
+	for i, class := range dict.Classes {
+		codeValues[i] = models.CodedValue{Code: class.Code, Name: class.Name}
+		nameValues[i] = models.CodedValue{Code: class.Name, Name: class.Name}
+	}
+	domains := []struct {
+		Name   string
+		Field  string
+		Values []models.CodedValue
+	}{
+		{fmt.Sprintf("地类编码(%s)", dict.Version), jsonData.CodeField, codeValues},
+		{fmt.Sprintf("地类名称(%s)", dict.Version), jsonData.NameField, nameValues},
+	}
+	var ruleCount int
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for _, d := range domains {
+			coded, _ := json.Marshal(d.Values)
+			var domain models.AttributeDomain
+			tx.Where("name = ?", d.Name).First(&domain)
+			domain.Name = d.Name
+			domain.Description = "由土地利用分类字典生成"
+			domain.DomainType = "coded"
+			domain.FieldType = "esriFieldTypeString"
+			domain.CodedValues = coded
+			domain.Source = "manual"
+			domain.UpdatedAt = timeNowStr()
+			if err := tx.Save(&domain).Error; err != nil {
+				return err
+			}
+			if d.Field == "" || table == "" {
+				continue
+			}
+			field := strings.ToLower(d.Field)
+			if err := tx.Where("table_name = ? AND field_name = ? AND rule_type = ?", table, field, "domain").
+				Delete(&models.FieldRule{}).Error; err != nil {
+				return err
+			}
+			rule := models.FieldRule{
+				TableName:  table,
+				FieldName:  field,
+				RuleType:   "domain",
+				DomainName: d.Name,
+				Enabled:    true,
+				Source:     "manual",
+			}
+			if err := tx.Create(&rule).Error; err != nil {
+				return err
+			}
+			ruleCount++
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": fmt.Sprintf("生成属性域%d个，字段规则%d条", len(domains), ruleCount)})
+}
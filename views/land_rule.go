@@ -0,0 +1,64 @@
+package views
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 用地分析规则 ====================
+
+// ListLandRules 查询用地分析规则，指定TableName时返回该图层实际生效的规则
+func (uc *UserController) ListLandRules(c *gin.Context) {
+	DB := models.DB
+	TableName := strings.ToLower(c.Query("TableName"))
+	if TableName == "" {
+		var rules []models.LandOccupationRule
+		DB.Order("layer_name").Find(&rules)
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": rules})
+		return
+	}
+	rule := methods.LandRuleFor(DB, TableName)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": rule, "configured": rule.ID != 0})
+}
+
+// SaveLandRule 新增或修改图层的用地分析规则
+func (uc *UserController) SaveLandRule(c *gin.Context) {
+	var jsonData models.LandOccupationRule
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if jsonData.LayerName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "LayerName不能为空"})
+		return
+	}
+	DB := models.DB
+	if err := methods.ValidateLandRule(DB, &jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	// 每个图层一条规则，按图层名覆盖
+	var existing models.LandOccupationRule
+	if err := DB.Where("layer_name = ?", jsonData.LayerName).First(&existing).Error; err == nil {
+		jsonData.ID = existing.ID
+	}
+	jsonData.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+	if err := DB.Save(&jsonData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功", "data": jsonData})
+}
+
+// DelLandRule 删除用地分析规则，删除后图层恢复默认统计方式
+func (uc *UserController) DelLandRule(c *gin.Context) {
+	ID := c.Query("ID")
+	DB := models.DB
+	DB.Where("id = ?", ID).Delete(&models.LandOccupationRule{})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "删除成功"})
+}
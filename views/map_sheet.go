@@ -0,0 +1,325 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// 图幅编号查询、覆盖图幅统计和图幅接合表生成
+
+// mapSheetSearchTable SearchGeoFromSchema 中按图号查询图幅时使用的表名
+const mapSheetSearchTable = "mapsheet"
+
+// mapSheetLimit 单次计算的最大图幅数
+const mapSheetLimit = 20000
+
+type mapSheetRequest struct {
+	Geometry json.RawMessage `json:"Geometry"` // GeoJSON几何，经纬度
+	Bound    []float64       `json:"Bound"`    // 或经纬度范围 [west, south, east, north]
+	Scale    int             `json:"Scale"`
+	Scheme   string          `json:"Scheme"` // gb 经纬度分幅(默认) / rect 正方形分幅
+	EPSG     int             `json:"EPSG"`   // 正方形分幅使用的3度带投影，0时按范围中心自动选择
+	Main     string          `json:"Main"`   // 生成接合表图层的目录
+	CN       string          `json:"CN"`     // 生成接合表图层的名称
+}
+
+// mapSheetFeatures 图幅转为经纬度面要素
+func mapSheetFeatures(db *gorm.DB, sheets []*methods.MapSheet) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	polygons := make(orb.MultiPolygon, len(sheets))
+	for i, s := range sheets {
+		polygons[i] = orb.Bound{Min: orb.Point{s.West, s.South}, Max: orb.Point{s.East, s.North}}.ToPolygon()
+	}
+	// 正方形分幅按所在投影带批量转换
+	byEPSG := map[int][]int{}
+	for i, s := range sheets {
+		if s.EPSG != 4326 {
+			byEPSG[s.EPSG] = append(byEPSG[s.EPSG], i)
+		}
+	}
+	for epsg, idx := range byEPSG {
+		batch := make(orb.MultiPolygon, len(idx))
+		for k, i := range idx {
+			batch[k] = polygons[i]
+		}
+		g, err := transformGeometry(db, batch, epsg, 4326)
+		if err != nil {
+			return nil, err
+		}
+		mp, ok := g.(orb.MultiPolygon)
+		if !ok || len(mp) != len(idx) {
+			return nil, fmt.Errorf("坐标转换失败")
+		}
+		for k, i := range idx {
+			polygons[i] = mp[k]
+		}
+	}
+	for i, s := range sheets {
+		f := geojson.NewFeature(polygons[i])
+		f.Properties["tfh"] = s.Number
+		f.Properties["scale"] = s.Scale
+		f.Properties["scheme"] = s.Scheme
+		fc.Append(f)
+	}
+	return fc, nil
+}
+
+// locateMapSheet 按图号查询图幅，编号含"-"且为数字时按正方形分幅解析
+func locateMapSheet(number string, scale, epsg int) (*methods.MapSheet, error) {
+	if _, err := strconv.ParseFloat(strings.SplitN(strings.TrimSpace(number), "-", 2)[0], 64); err == nil {
+		return methods.ParseRectSheetNumber(number, scale, epsg)
+	}
+	return methods.ParseGBSheetNumber(number)
+}
+
+// mapSheetsCovering 计算几何或范围覆盖的图幅，几何存在时剔除仅外接矩形相交的图幅
+func mapSheetsCovering(db *gorm.DB, req mapSheetRequest) ([]*methods.MapSheet, error) {
+	var geomJSON string
+	var bound [4]float64
+	switch {
+	case len(req.Geometry) > 0:
+		geomJSON = string(req.Geometry)
+		var b struct {
+			West, South, East, North float64
+		}
+		err := db.Raw(`SELECT ST_XMin(g) AS west, ST_YMin(g) AS south, ST_XMax(g) AS east, ST_YMax(g) AS north
+			FROM (SELECT ST_GeomFromGeoJSON(?) AS g) s`, geomJSON).Scan(&b).Error
+		if err != nil {
+			return nil, fmt.Errorf("几何无效: %v", err)
+		}
+		bound = [4]float64{b.West, b.South, b.East, b.North}
+	case len(req.Bound) == 4:
+		copy(bound[:], req.Bound)
+	default:
+		return nil, fmt.Errorf("需要指定Geometry或Bound")
+	}
+
+	var sheets []*methods.MapSheet
+	var err error
+	epsg := 4326
+	if strings.ToLower(req.Scheme) == "rect" {
+		epsg = req.EPSG
+		if epsg == 0 {
+			epsg = methods.CGCS2000ZoneEPSG((bound[0] + bound[2]) / 2)
+		}
+		zone, err := transformGeometry(db, orb.Bound{Min: orb.Point{bound[0], bound[1]}, Max: orb.Point{bound[2], bound[3]}}.ToPolygon(), 4326, epsg)
+		if err != nil {
+			return nil, err
+		}
+		// 经纬度范围投影后边线弯曲，外扩后再按几何筛选
+		zb := zone.Bound()
+		pad := math.Max(zb.Max[0]-zb.Min[0], zb.Max[1]-zb.Min[1]) * 0.01
+		sheets, err = methods.RectSheetsInBound(zb.Min[0]-pad, zb.Min[1]-pad, zb.Max[0]+pad, zb.Max[1]+pad, req.Scale, epsg, mapSheetLimit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sheets, err = methods.GBSheetsInBound(bound[0], bound[1], bound[2], bound[3], req.Scale, mapSheetLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if geomJSON == "" || len(sheets) == 0 {
+		return sheets, nil
+	}
+
+	values := make([]string, len(sheets))
+	for i, s := range sheets {
+		values[i] = fmt.Sprintf("(%d, %f, %f, %f, %f)", i, s.West, s.South, s.East, s.North)
+	}
+	var hits []int
+	sql := fmt.Sprintf(`
+		SELECT v.idx FROM (VALUES %s) AS v(idx, w, s, e, n),
+			(SELECT ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), %d) AS g) geo
+		WHERE ST_Intersects(ST_MakeEnvelope(v.w, v.s, v.e, v.n, %d), geo.g)
+		ORDER BY v.idx`, strings.Join(values, ", "), epsg, epsg)
+	if err := db.Raw(sql, geomJSON).Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*methods.MapSheet, 0, len(hits))
+	for _, i := range hits {
+		result = append(result, sheets[i])
+	}
+	return result, nil
+}
+
+// MapSheetLocate 点所在图幅，经纬度分幅输入经纬度，正方形分幅可输入经纬度或投影坐标
+func (uc *UserController) MapSheetLocate(c *gin.Context) {
+	x, errX := strconv.ParseFloat(c.Query("x"), 64)
+	y, errY := strconv.ParseFloat(c.Query("y"), 64)
+	scale, _ := strconv.Atoi(c.Query("scale"))
+	epsg, _ := strconv.Atoi(c.Query("epsg"))
+	if errX != nil || errY != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "坐标格式错误", "data": ""})
+		return
+	}
+	DB := models.DB
+	var sheet *methods.MapSheet
+	var err error
+	if strings.ToLower(c.Query("scheme")) == "rect" {
+		if x >= -180 && x <= 180 && y >= -90 && y <= 90 {
+			if epsg == 0 {
+				epsg = methods.CGCS2000ZoneEPSG(x)
+			}
+			var pt orb.Geometry
+			if pt, err = transformGeometry(DB, orb.Point{x, y}, 4326, epsg); err == nil {
+				p := pt.(orb.Point)
+				x, y = p[0], p[1]
+			}
+		} else if epsg == 0 {
+			err = fmt.Errorf("投影坐标需要指定epsg")
+		}
+		if err == nil {
+			sheet, err = methods.RectSheetNumber(x, y, scale, epsg)
+		}
+	} else {
+		sheet, err = methods.GBSheetNumber(x, y, scale)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	fc, err := mapSheetFeatures(DB, []*methods.MapSheet{sheet})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{"sheet": sheet, "geojson": fc}})
+}
+
+// MapSheetSearch 按图号查询图幅范围
+func (uc *UserController) MapSheetSearch(c *gin.Context) {
+	scale, _ := strconv.Atoi(c.Query("scale"))
+	epsg, _ := strconv.Atoi(c.Query("epsg"))
+	sheet, err := locateMapSheet(c.Query("number"), scale, epsg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	fc, err := mapSheetFeatures(models.DB, []*methods.MapSheet{sheet})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{"sheet": sheet, "geojson": fc}})
+}
+
+// MapSheetCover 几何或范围涉及的图幅
+func (uc *UserController) MapSheetCover(c *gin.Context) {
+	var req mapSheetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	sheets, err := mapSheetsCovering(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	fc, err := mapSheetFeatures(DB, sheets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	numbers := make([]string, len(sheets))
+	for i, s := range sheets {
+		numbers[i] = s.Number
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{
+		"total":   len(sheets),
+		"numbers": numbers,
+		"geojson": fc,
+	}})
+}
+
+// MapSheetGrid 生成图幅接合表图层，登记后可直接加载和切片
+func (uc *UserController) MapSheetGrid(c *gin.Context) {
+	var req mapSheetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	sheets, err := mapSheetsCovering(DB, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error(), "data": ""})
+		return
+	}
+	if len(sheets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "范围内没有图幅", "data": ""})
+		return
+	}
+	fc, err := mapSheetFeatures(DB, sheets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	cn := req.CN
+	if cn == "" {
+		cn = fmt.Sprintf("1比%d图幅接合表", req.Scale)
+	}
+	en, err := createResultLayer(DB, req.Main, cn, "polygon", []resultLayerField{
+		{Name: "tfh", Type: "VARCHAR(32)"},
+		{Name: "blc", Type: "INTEGER"},
+		{Name: "fffs", Type: "VARCHAR(16)"},
+	}, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		insertSQL := fmt.Sprintf(`INSERT INTO "%s" (tfh, blc, fffs, geom) VALUES (?, ?, ?, %s)`,
+			en, resultLayerGeomExpr("polygon", "ST_SetSRID(ST_GeomFromGeoJSON(?), 4326)"))
+		for i, s := range sheets {
+			geomJSON, _ := json.Marshal(geojson.NewGeometry(fc.Features[i].Geometry))
+			if err := tx.Exec(insertSQL, s.Number, s.Scale, s.Scheme, string(geomJSON)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dropResultLayer(DB, en)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "写入图幅失败: " + err.Error(), "data": ""})
+		return
+	}
+	MakeGeoIndex(en)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "图幅接合表已生成", "data": gin.H{
+		"table_name": en,
+		"cn":         cn,
+		"total":      len(sheets),
+	}})
+}
+
+// searchMapSheetRule 从查询条件中取出图号
+func searchMapSheetRule(rule interface{}) (number string, scale, epsg int) {
+	m, ok := rule.(map[string]interface{})
+	if !ok {
+		return "", 0, 0
+	}
+	for _, key := range []string{"number", "tfh", "all_data_search"} {
+		if v, ok := m[key].(string); ok && v != "" {
+			number = v
+			break
+		}
+	}
+	if v, ok := m["scale"].(float64); ok {
+		scale = int(v)
+	}
+	if v, ok := m["epsg"].(float64); ok {
+		epsg = int(v)
+	}
+	return number, scale, epsg
+}
@@ -83,8 +83,17 @@ func CreateDerivedMappingsMultiParent(db *gorm.DB, tableName string, newIDs []in
 }
 
 // GetOrCreateSession 获取或创建编辑会话
+// 同一用户在同一图层上的连续编辑复用同一个活跃会话，构成该会话的撤销/重做栈
+// 只在写入新操作时调用：会话内已撤销的记录不再可重做，在新操作写入映射前清理
 func GetOrCreateSession(db *gorm.DB, tableName string, username string) models.EditSession {
-	session := models.EditSession{
+	var session models.EditSession
+	err := db.Where("table_name = ? AND username = ? AND status = ? AND versioned = false", tableName, username, "active").
+		Order("id DESC").First(&session).Error
+	if err == nil {
+		ClearRedoStack(db, session.ID)
+		return session
+	}
+	session = models.EditSession{
 		TableName: tableName,
 		Username:  username,
 		CreatedAt: timeNowStr(),
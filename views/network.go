@@ -0,0 +1,486 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/services"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// ==================== 网络分析 ====================
+
+// defaultNetworkTolerance 点吸附到网络的默认搜索距离(米)
+const defaultNetworkTolerance = 500
+
+func validateNetworkDataset(db *gorm.DB, ds *models.NetworkDataset) error {
+	if ds.Name == "" {
+		return fmt.Errorf("Name不能为空")
+	}
+	var layers []string
+	if err := json.Unmarshal(ds.Layers, &layers); err != nil || len(layers) == 0 {
+		return fmt.Errorf("需要指定源线图层")
+	}
+	for i := range layers {
+		layers[i] = strings.ToLower(layers[i])
+	}
+	ds.Layers, _ = json.Marshal(layers)
+	ds.CostField = strings.ToLower(ds.CostField)
+	ds.SpeedField = strings.ToLower(ds.SpeedField)
+	ds.OnewayField = strings.ToLower(ds.OnewayField)
+
+	if ds.Connectivity == "" {
+		ds.Connectivity = "endpoint"
+	}
+	if ds.Connectivity != "endpoint" && ds.Connectivity != "intersection" {
+		return fmt.Errorf("Connectivity只能为endpoint或intersection")
+	}
+	if ds.CostMode == "" {
+		ds.CostMode = "length"
+	}
+	switch ds.CostMode {
+	case "length":
+	case "field":
+		if ds.CostField == "" {
+			return fmt.Errorf("按字段计算成本需要指定CostField")
+		}
+	case "speed":
+		if ds.SpeedField == "" && ds.DefaultSpeed <= 0 {
+			return fmt.Errorf("按速度计算时间需要指定SpeedField或DefaultSpeed")
+		}
+	default:
+		return fmt.Errorf("CostMode只能为length、field或speed")
+	}
+
+	for _, layer := range layers {
+		var schema models.MySchema
+		if err := db.Where("en = ?", layer).First(&schema).Error; err != nil {
+			return fmt.Errorf("图层不存在: %s", layer)
+		}
+		if schema.Type != "line" {
+			return fmt.Errorf("图层 %s 不是线图层", layer)
+		}
+		cols := tableColumns(db, layer)
+		if ds.CostMode == "field" && !cols[ds.CostField] {
+			return fmt.Errorf("图层 %s 没有成本字段 %s", layer, ds.CostField)
+		}
+		if ds.CostMode == "speed" && ds.DefaultSpeed <= 0 && !cols[ds.SpeedField] {
+			return fmt.Errorf("图层 %s 没有速度字段 %s，需要指定DefaultSpeed", layer, ds.SpeedField)
+		}
+	}
+	return nil
+}
+
+// ListNetworks 查询网络数据集
+func (uc *UserController) ListNetworks(c *gin.Context) {
+	var networks []models.NetworkDataset
+	models.DB.Order("id").Find(&networks)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": networks})
+}
+
+// SaveNetwork 新增或修改网络数据集，修改后需要重新构建
+func (uc *UserController) SaveNetwork(c *gin.Context) {
+	var jsonData models.NetworkDataset
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	if err := validateNetworkDataset(DB, &jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	var count int64
+	DB.Model(&models.NetworkDataset{}).Where("name = ? AND id <> ?", jsonData.Name, jsonData.ID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "网络数据集名称已存在"})
+		return
+	}
+	jsonData.Status = "未构建"
+	jsonData.Message = ""
+	jsonData.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+	if err := DB.Save(&jsonData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	services.InvalidateNetworkGraph(jsonData.ID)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功，请重新构建网络", "data": jsonData})
+}
+
+// DelNetwork 删除网络数据集及其边表、节点表和转向规则
+func (uc *UserController) DelNetwork(c *gin.Context) {
+	var ds models.NetworkDataset
+	DB := models.DB
+	if err := DB.Where("id = ?", c.Query("ID")).First(&ds).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "网络数据集不存在"})
+		return
+	}
+	DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, services.NetworkEdgeTable(ds.ID)))
+	DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, services.NetworkNodeTable(ds.ID)))
+	DB.Where("network_id = ?", ds.ID).Delete(&models.NetworkTurn{})
+	DB.Delete(&ds)
+	services.InvalidateNetworkGraph(ds.ID)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "删除成功"})
+}
+
+// StartBuildNetwork 创建网络构建任务
+func (uc *UserController) StartBuildNetwork(c *gin.Context) {
+	var req struct {
+		ID int64 `json:"ID"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	var ds models.NetworkDataset
+	if err := models.DB.Where("id = ?", req.ID).First(&ds).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "网络数据集不存在"})
+		return
+	}
+	if ds.Status == "构建中" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "网络数据集正在构建"})
+		return
+	}
+	task := newAnalysisTask("network_build", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		DB := models.DB
+		DB.Model(&ds).Updates(map[string]interface{}{"status": "构建中", "message": ""})
+		edges, nodes, err := services.BuildNetwork(ctx, DB, ds, progress)
+		if err != nil {
+			DB.Model(&ds).Updates(map[string]interface{}{"status": "构建失败", "message": err.Error()})
+			return nil, err
+		}
+		builtAt := time.Now().Format("2006-01-02 15:04:05")
+		DB.Model(&ds).Updates(map[string]interface{}{
+			"status": "已构建", "message": "", "edge_count": edges, "node_count": nodes, "built_at": builtAt,
+		})
+		services.InvalidateNetworkGraph(ds.ID)
+		return gin.H{"ID": ds.ID, "EdgeCount": edges, "NodeCount": nodes, "BuiltAt": builtAt}, nil
+	})
+	respondAnalysisTask(c, task, "网络构建")
+}
+
+// ListNetworkTurns 查询网络数据集的转向规则
+func (uc *UserController) ListNetworkTurns(c *gin.Context) {
+	var turns []models.NetworkTurn
+	models.DB.Where("network_id = ?", c.Query("NetworkID")).Order("id").Find(&turns)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": turns})
+}
+
+// SaveNetworkTurns 覆盖保存网络数据集的转向规则，立即生效，无需重新构建
+func (uc *UserController) SaveNetworkTurns(c *gin.Context) {
+	var req struct {
+		NetworkID int64                `json:"NetworkID"`
+		Turns     []models.NetworkTurn `json:"Turns"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	var ds models.NetworkDataset
+	if err := DB.Where("id = ?", req.NetworkID).First(&ds).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "网络数据集不存在"})
+		return
+	}
+	layers := services.NetworkLayers(ds)
+	for i := range req.Turns {
+		turn := &req.Turns[i]
+		turn.ID = 0
+		turn.NetworkID = ds.ID
+		turn.FromLayer = strings.ToLower(turn.FromLayer)
+		turn.ToLayer = strings.ToLower(turn.ToLayer)
+		if !methods.IsStringInSlice(turn.FromLayer, layers) || !methods.IsStringInSlice(turn.ToLayer, layers) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": fmt.Sprintf("第%d条转向规则的图层不属于该网络", i+1)})
+			return
+		}
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("network_id = ?", ds.ID).Delete(&models.NetworkTurn{}).Error; err != nil {
+			return err
+		}
+		if len(req.Turns) == 0 {
+			return nil
+		}
+		return tx.Create(&req.Turns).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	services.InvalidateNetworkGraph(ds.ID)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "保存成功", "data": req.Turns})
+}
+
+// networkPoints 一组输入点，label用于错误提示
+type networkPoints struct {
+	label  string
+	points [][]float64
+}
+
+// loadNetworkLocations 读取网络并吸附各组输入点
+func loadNetworkLocations(networkID int64, tolerance float64, groups ...networkPoints) (*services.NetworkGraph, [][]services.NetworkLocation, error) {
+	DB := models.DB
+	g, err := services.LoadNetworkGraph(DB, networkID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tolerance <= 0 {
+		tolerance = defaultNetworkTolerance
+	}
+	result := make([][]services.NetworkLocation, len(groups))
+	for i, group := range groups {
+		if len(group.points) == 0 {
+			return nil, nil, fmt.Errorf("%s为空", group.label)
+		}
+		locations, err := g.Locate(DB, group.points, tolerance)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s%v", group.label, err)
+		}
+		result[i] = locations
+	}
+	return g, result, nil
+}
+
+func networkCutoff(cutoff float64) float64 {
+	if cutoff <= 0 {
+		return math.Inf(1)
+	}
+	return cutoff
+}
+
+// NetworkRoute 依次经过各停靠点的最短路径
+func (uc *UserController) NetworkRoute(c *gin.Context) {
+	var req struct {
+		NetworkID int64       `json:"NetworkID"`
+		Stops     [][]float64 `json:"Stops"` // 经纬度，按经过顺序
+		Tolerance float64     `json:"Tolerance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	g, locations, err := loadNetworkLocations(req.NetworkID, req.Tolerance, networkPoints{"停靠点", req.Stops})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	legs, err := g.Route(locations[0])
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"code": 404, "message": err.Error()})
+		return
+	}
+	fc := geojson.NewFeatureCollection()
+	totalCost, totalLength := 0.0, 0.0
+	for _, leg := range legs {
+		feature := geojson.NewFeature(leg.Line)
+		feature.Properties["from"] = leg.From + 1
+		feature.Properties["to"] = leg.To + 1
+		feature.Properties["cost"] = leg.Cost
+		feature.Properties["length"] = leg.Length
+		fc.Append(feature)
+		totalCost += leg.Cost
+		totalLength += leg.Length
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{
+		"routes": fc, "total_cost": totalCost, "total_length": totalLength, "cost_unit": services.NetworkCostUnit(g.Dataset),
+		"stops": networkLocationFeatures(locations[0]),
+	}})
+}
+
+// networkLocationFeatures 输入点吸附到网络后的位置
+func networkLocationFeatures(locations []services.NetworkLocation) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for i, loc := range locations {
+		feature := geojson.NewFeature(loc.Snapped)
+		feature.Properties["index"] = i + 1
+		feature.Properties["distance"] = loc.Distance
+		fc.Append(feature)
+	}
+	return fc
+}
+
+// NetworkServiceArea 按成本分段计算设施的服务区
+func (uc *UserController) NetworkServiceArea(c *gin.Context) {
+	var req struct {
+		NetworkID  int64       `json:"NetworkID"`
+		Facilities [][]float64 `json:"Facilities"`
+		Breaks     []float64   `json:"Breaks"`    // 成本分段，如 [5,10,15] 分钟
+		Separate   bool        `json:"Separate"`  // 每个设施单独生成服务区，否则合并
+		Concavity  float64     `json:"Concavity"` // 凹包系数，0~1，越小越贴合网络，默认0.3
+		WithLines  bool        `json:"WithLines"` // 同时返回可达的网络线
+		Tolerance  float64     `json:"Tolerance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if len(req.Breaks) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "需要指定成本分段"})
+		return
+	}
+	sort.Float64s(req.Breaks)
+	if req.Breaks[0] <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "成本分段必须为正数"})
+		return
+	}
+	if req.Concavity <= 0 || req.Concavity > 1 {
+		req.Concavity = 0.3
+	}
+	g, locations, err := loadNetworkLocations(req.NetworkID, req.Tolerance, networkPoints{"设施点", req.Facilities})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	groups := [][]services.NetworkLocation{locations[0]}
+	if req.Separate {
+		groups = groups[:0]
+		for i := range locations[0] {
+			groups = append(groups, locations[0][i:i+1])
+		}
+	}
+
+	areas := geojson.NewFeatureCollection()
+	lines := geojson.NewFeatureCollection()
+	maxBreak := req.Breaks[len(req.Breaks)-1]
+	for i, group := range groups {
+		tree := g.Search(group, maxBreak, nil)
+		// 由大到小输出，前端按顺序叠加时小的分段在上层
+		for k := len(req.Breaks) - 1; k >= 0; k-- {
+			reached := tree.ReachedLines(req.Breaks[k])
+			polygon, err := services.ServiceAreaPolygon(models.DB, reached, req.Concavity)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+				return
+			}
+			if polygon == nil {
+				continue
+			}
+			feature := geojson.NewFeature(polygon)
+			feature.Properties["break"] = req.Breaks[k]
+			if k > 0 {
+				feature.Properties["from_break"] = req.Breaks[k-1]
+			} else {
+				feature.Properties["from_break"] = 0
+			}
+			if req.Separate {
+				feature.Properties["facility"] = i + 1
+			}
+			areas.Append(feature)
+			if req.WithLines {
+				lineFeature := geojson.NewFeature(reached)
+				lineFeature.Properties = feature.Properties
+				lines.Append(lineFeature)
+			}
+		}
+	}
+	data := gin.H{"areas": areas, "cost_unit": services.NetworkCostUnit(g.Dataset), "facilities": networkLocationFeatures(locations[0])}
+	if req.WithLines {
+		data["lines"] = lines
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": data})
+}
+
+// NetworkClosestFacility 为每个事件点查找成本最小的设施及路径
+func (uc *UserController) NetworkClosestFacility(c *gin.Context) {
+	var req struct {
+		NetworkID  int64       `json:"NetworkID"`
+		Incidents  [][]float64 `json:"Incidents"`
+		Facilities [][]float64 `json:"Facilities"`
+		Count      int         `json:"Count"`     // 每个事件点查找的设施数，默认1
+		Cutoff     float64     `json:"Cutoff"`    // 超过该成本的设施不再查找
+		Direction  string      `json:"Direction"` // to 从事件点前往设施 / from 从设施前往事件点
+		Tolerance  float64     `json:"Tolerance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	if req.Count <= 0 {
+		req.Count = 1
+	}
+	g, locations, err := loadNetworkLocations(req.NetworkID, req.Tolerance, networkPoints{"事件点", req.Incidents}, networkPoints{"设施点", req.Facilities})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	results, err := g.ClosestFacilities(c.Request.Context(), locations[0], locations[1], req.Count, networkCutoff(req.Cutoff), req.Direction != "from")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	fc := geojson.NewFeatureCollection()
+	unreached := []int{}
+	for i, routes := range results {
+		if len(routes) == 0 {
+			unreached = append(unreached, i+1)
+		}
+		for _, route := range routes {
+			feature := geojson.NewFeature(route.Line)
+			feature.Properties["incident"] = route.Incident + 1
+			feature.Properties["facility"] = route.Facility + 1
+			feature.Properties["rank"] = route.Rank
+			feature.Properties["cost"] = route.Cost
+			feature.Properties["length"] = route.Length
+			fc.Append(feature)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": gin.H{
+		"routes": fc, "unreached": unreached, "cost_unit": services.NetworkCostUnit(g.Dataset),
+	}})
+}
+
+// NetworkODMatrix 计算起点到终点的网络成本矩阵
+func (uc *UserController) NetworkODMatrix(c *gin.Context) {
+	var req struct {
+		NetworkID    int64       `json:"NetworkID"`
+		Origins      [][]float64 `json:"Origins"`
+		Destinations [][]float64 `json:"Destinations"`
+		Cutoff       float64     `json:"Cutoff"`
+		WithLines    bool        `json:"WithLines"` // 返回起终点之间的直线，便于在地图上显示
+		Tolerance    float64     `json:"Tolerance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	g, locations, err := loadNetworkLocations(req.NetworkID, req.Tolerance, networkPoints{"起点", req.Origins}, networkPoints{"终点", req.Destinations})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	matrix, err := g.ODMatrix(c.Request.Context(), locations[0], locations[1], networkCutoff(req.Cutoff))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		return
+	}
+	data := gin.H{"matrix": matrix, "cost_unit": services.NetworkCostUnit(g.Dataset)}
+	if req.WithLines {
+		fc := geojson.NewFeatureCollection()
+		for i, row := range matrix {
+			for j, cell := range row {
+				if cell == nil {
+					continue
+				}
+				feature := geojson.NewFeature(orb.LineString{locations[0][i].Input, locations[1][j].Input})
+				feature.Properties["origin"] = i + 1
+				feature.Properties["destination"] = j + 1
+				feature.Properties["cost"] = cell.Cost
+				feature.Properties["length"] = cell.Length
+				fc.Append(feature)
+			}
+		}
+		data["lines"] = fc
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": data})
+}
@@ -0,0 +1,335 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 宗地按面积分割
+
+type subdivideRequest struct {
+	LayerName        string    `json:"LayerName"`
+	ID               int32     `json:"ID"`
+	Username         string    `json:"Username"`
+	BZ               string    `json:"BZ"`
+	Mode             string    `json:"Mode"`             // parallel 平行线 / rotate 绕点旋转
+	Bearing          float64   `json:"Bearing"`          // 平行模式切割线方位角(度)，沿其右侧推进
+	Pivot            []float64 `json:"Pivot"`            // 旋转模式的旋转中心 [经度, 纬度]
+	StartBearing     float64   `json:"StartBearing"`     // 旋转模式第一条切割线方位角
+	CounterClockwise bool      `json:"CounterClockwise"` // 旋转模式逆时针扫掠
+	Areas            []float64 `json:"Areas"`            // 各块面积(平方米)，不足部分为最后一块
+	Ratios           []float64 `json:"Ratios"`           // 各块面积比例
+	Tolerance        float64   `json:"Tolerance"`        // 面积限差(平方米)，默认0.01
+	AreaField        string    `json:"AreaField"`        // 写入分割后面积的字段
+	Preview          bool      `json:"Preview"`          // 仅预览不保存
+	SessionID        int64     `json:"SessionID"`        // 版本化会话ID，版本中不支持分割
+}
+
+// SubdivideParcel 宗地按面积/比例分割，切割位置迭代至椭球面积满足限差，编辑记录与要素分割一致
+func (uc *UserController) SubdivideParcel(c *gin.Context) {
+	var req subdivideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	DB := models.DB
+	LayerName := req.LayerName
+	var schema models.MySchema
+	if err := DB.Where("en = ?", LayerName).First(&schema).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	if schema.Type != "polygon" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "只有面数据才能分割", "data": ""})
+		return
+	}
+	if !req.Preview && rejectInVersion(c, DB, LayerName, req.Username, req.SessionID) {
+		return
+	}
+	if !req.Preview && !checkEditLocks(c, DB, LayerName, req.Username, []int32{req.ID}) {
+		return
+	}
+	areaField := strings.ToLower(req.AreaField)
+	if areaField != "" {
+		if _, err := attributeFieldType(DB, LayerName, areaField); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+			return
+		}
+	}
+	tolerance := req.Tolerance
+	if tolerance <= 0 {
+		tolerance = 0.01
+	}
+
+	// 宗地转换到所在3度带投影坐标系
+	var center struct {
+		X float64
+		Y float64
+	}
+	centerSQL := fmt.Sprintf(`SELECT ST_X(ST_PointOnSurface(geom)) AS x, ST_Y(ST_PointOnSurface(geom)) AS y FROM "%s" WHERE id = ?`, LayerName)
+	if err := DB.Raw(centerSQL, req.ID).Scan(&center).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	if center.X == 0 && center.Y == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到指定ID的几何数据", "data": ""})
+		return
+	}
+	epsg, err := cgcs2000ZoneEPSG(0, []float64{center.X, center.Y}, 4326)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	var zoneJSON string
+	zoneSQL := fmt.Sprintf(`SELECT ST_AsGeoJSON(ST_Transform(geom, %d), 10) FROM "%s" WHERE id = ?`, epsg, LayerName)
+	if err := DB.Raw(zoneSQL, req.ID).Scan(&zoneJSON).Error; err != nil || zoneJSON == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "坐标转换失败", "data": ""})
+		return
+	}
+	zoneGeom, err := geojson.UnmarshalGeometry([]byte(zoneJSON))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+
+	var sweep *methods.SubdivideSweep
+	switch strings.ToLower(req.Mode) {
+	case "", "parallel":
+		sweep, err = methods.ParallelSweep(zoneGeom.Geometry(), req.Bearing)
+	case "rotate":
+		if len(req.Pivot) != 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": "旋转分割需要旋转中心 Pivot [经度, 纬度]", "data": ""})
+			return
+		}
+		var pivot orb.Geometry
+		pivot, err = transformGeometry(DB, orb.Point{req.Pivot[0], req.Pivot[1]}, 4326, epsg)
+		if err != nil {
+			break
+		}
+		pt, ok := pivot.(orb.Point)
+		if !ok {
+			err = fmt.Errorf("坐标转换失败")
+			break
+		}
+		sweep, err = methods.RotateSweep(zoneGeom.Geometry(), pt, req.StartBearing, req.CounterClockwise)
+	default:
+		err = fmt.Errorf("Mode 只能为 parallel 或 rotate")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+
+	// 相交部分转回经纬度后计算椭球面积
+	areaOf := func(region orb.Polygon) (float64, error) {
+		if len(region) == 0 {
+			return 0, nil
+		}
+		regionJSON, err := json.Marshal(geojson.NewGeometry(region))
+		if err != nil {
+			return 0, err
+		}
+		var area float64
+		err = DB.Raw(`SELECT COALESCE(ST_Area(ST_Transform(ST_SetSRID(ST_Intersection(ST_MakeValid(ST_GeomFromGeoJSON(?)), ST_GeomFromGeoJSON(?)), ?), 4326)::geography), 0)`,
+			zoneJSON, string(regionJSON), epsg).Scan(&area).Error
+		return area, err
+	}
+	total, err := areaOf(sweep.Region(sweep.Lo, sweep.Hi))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "计算面积失败: " + err.Error(), "data": ""})
+		return
+	}
+	targets, err := methods.SubdivideTargets(total, req.Areas, req.Ratios, tolerance)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+	parts, err := methods.SubdivideBySweep(targets, sweep, areaOf, tolerance, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": err.Error(), "data": ""})
+		return
+	}
+
+	// 生成各块几何，图层为单部件面时不允许分割出不连续的块
+	var geomType string
+	DB.Raw(`SELECT type FROM geometry_columns WHERE f_table_schema = 'public' AND f_table_name = ? AND f_geometry_column = 'geom'`,
+		LayerName).Scan(&geomType)
+	geomType = strings.ToUpper(geomType)
+	pieceExpr := `ST_Transform(ST_SetSRID(ST_CollectionExtract(ST_Intersection(ST_MakeValid(ST_GeomFromGeoJSON(?)), ST_GeomFromGeoJSON(?)), 3), ?), 4326)`
+	outputExpr := "g"
+	switch geomType {
+	case "MULTIPOLYGON":
+		outputExpr = "ST_Multi(g)"
+	case "POLYGON":
+		outputExpr = "ST_GeometryN(ST_Multi(g), 1)"
+	}
+	type pieceResult struct {
+		Geojson string
+		Parts   int
+	}
+	preview := geojson.NewFeatureCollection()
+	pieces := make([]string, len(parts))
+	for i, part := range parts {
+		regionJSON, _ := json.Marshal(geojson.NewGeometry(part.Region))
+		var piece pieceResult
+		err := DB.Raw(fmt.Sprintf(`SELECT ST_AsGeoJSON(%s) AS geojson, ST_NumGeometries(g) AS parts FROM (SELECT %s AS g) s`, outputExpr, pieceExpr),
+			zoneJSON, string(regionJSON), epsg).Scan(&piece).Error
+		if err != nil || piece.Geojson == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": fmt.Sprintf("生成第%d块失败", part.Index), "data": ""})
+			return
+		}
+		if geomType == "POLYGON" && piece.Parts > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": fmt.Sprintf("第%d块被分成%d个不相连的部分，请调整分割方向", part.Index, piece.Parts), "data": parts})
+			return
+		}
+		pieces[i] = piece.Geojson
+		g, err := geojson.UnmarshalGeometry([]byte(piece.Geojson))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error(), "data": ""})
+			return
+		}
+		feature := geojson.NewFeature(g.Geometry())
+		feature.Properties["part"] = part.Index
+		feature.Properties["area"] = part.Area
+		feature.Properties["target"] = part.Target
+		preview.Append(feature)
+	}
+	data := gin.H{"epsg": epsg, "total": total, "parts": parts, "geojson": preview}
+	if req.Preview {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": "分割预览", "data": data})
+		return
+	}
+	for _, part := range parts {
+		if !part.Converged {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 500, "message": fmt.Sprintf("第%d块面积未收敛到限差内，请调整分割参数", part.Index), "data": data})
+			return
+		}
+	}
+
+	fileExt := GetFileExt(LayerName)
+	var mappingField string
+	switch fileExt {
+	case ".shp":
+		mappingField = "objectid"
+	case ".gdb":
+		mappingField = "fid"
+	default:
+		mappingField = ""
+	}
+	tx := DB.Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "开启事务失败: " + tx.Error.Error(), "data": ""})
+		return
+	}
+	lockSQL := fmt.Sprintf(`SELECT pg_advisory_xact_lock(hashtext('%s_id_lock'))`, LayerName)
+	if err := tx.Exec(lockSQL).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "获取锁失败: " + err.Error(), "data": ""})
+		return
+	}
+	var originalFeature map[string]interface{}
+	getOriginalSQL := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = %d`, LayerName, req.ID)
+	if err := tx.Raw(getOriginalSQL).Scan(&originalFeature).Error; err != nil || len(originalFeature) == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到指定ID的几何数据", "data": ""})
+		return
+	}
+	var additionalColumns []string
+	for key := range originalFeature {
+		if key != "id" && key != "geom" {
+			additionalColumns = append(additionalColumns, key)
+		}
+	}
+	var maxID int32
+	getMaxIDSQL := fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) as max_id FROM "%s"`, LayerName)
+	if err := tx.Raw(getMaxIDSQL).Scan(&maxID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询最大ID失败: " + err.Error(), "data": ""})
+		return
+	}
+	var maxMappingID int32
+	if mappingField != "" {
+		getMaxMappingIDSQL := fmt.Sprintf(`SELECT COALESCE(MAX("%s"), 0) as max_id FROM "%s"`, mappingField, LayerName)
+		if err := tx.Raw(getMaxMappingIDSQL).Scan(&maxMappingID).Error; err != nil {
+			maxMappingID = 0
+		}
+	}
+	insertCols := "id, geom"
+	for _, col := range additionalColumns {
+		insertCols += fmt.Sprintf(`, "%s"`, col)
+	}
+	var newIDList []int32
+	for i, piece := range pieces {
+		newID := maxID + int32(i) + 1
+		selectCols := fmt.Sprintf(`%d, ST_SetSRID(ST_GeomFromGeoJSON(?), 4326)`, newID)
+		for _, col := range additionalColumns {
+			switch {
+			case mappingField != "" && col == mappingField:
+				selectCols += fmt.Sprintf(`, %d`, maxMappingID+int32(i)+1)
+			case col == areaField:
+				selectCols += fmt.Sprintf(`, %.2f`, parts[i].Area)
+			default:
+				selectCols += fmt.Sprintf(`, "%s"`, col)
+			}
+		}
+		insertSQL := fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s" WHERE id = %d`,
+			LayerName, insertCols, selectCols, LayerName, req.ID)
+		if err := tx.Exec(insertSQL, piece).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "插入新要素失败: " + err.Error(), "data": ""})
+			return
+		}
+		newIDList = append(newIDList, newID)
+	}
+	// 获取原要素GeoJSON（事务提交前，原要素还在）
+	geom := GetGeo(getData{TableName: LayerName, ID: req.ID})
+	deleteSQL := fmt.Sprintf(`DELETE FROM "%s" WHERE id = %d`, LayerName, req.ID)
+	if err := tx.Exec(deleteSQL).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "删除原要素失败: " + err.Error(), "data": ""})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "提交事务失败: " + err.Error(), "data": ""})
+		return
+	}
+	// 提交成功后再清除原要素范围内的瓦片
+	pgmvt.DelMVT(DB, LayerName, geom.Features[0].Geometry)
+	newGeojson := GetGeos(getDatas{TableName: LayerName, ID: newIDList})
+
+	session := GetOrCreateSession(DB, LayerName, req.Username)
+	MarkMappingDeleted(DB, LayerName, []int32{req.ID})
+	CreateDerivedMappings(DB, LayerName, newIDList, req.ID, session.ID)
+
+	OldGeojson, _ := json.Marshal(geom)
+	NewGeojson, _ := json.Marshal(newGeojson)
+	RecordResult := models.GeoRecord{
+		TableName:    LayerName,
+		GeoID:        req.ID,
+		Username:     req.Username,
+		Type:         "要素面积分割",
+		Date:         timeNowStr(),
+		BZ:           req.BZ,
+		OldGeojson:   OldGeojson,
+		NewGeojson:   NewGeojson,
+		DelObjectIDs: DelIDGen(geom),
+		SessionID:    session.ID,
+		SeqNo:        GetNextSeqNo(DB, session.ID),
+		InputIDs:     MarshalIDs([]int32{req.ID}),
+		OutputIDs:    MarshalIDs(newIDList),
+	}
+	DB.Create(&RecordResult)
+
+	data["geojson"] = newGeojson
+	data["record_id"] = RecordResult.ID
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "分割成功，已生成多个新要素", "data": data})
+}
@@ -0,0 +1,149 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"gorm.io/gorm"
+)
+
+// 分析结果图层：新建表、空间索引和MVT缓存表，并登记到MySchema
+
+// resultLayerField 结果图层字段
+type resultLayerField struct {
+	Name string
+	Type string // PostgreSQL字段类型
+}
+
+// resultLayerGeometryType 图层类型对应的几何类型，面和线统一为多部件
+func resultLayerGeometryType(layerType string) (string, error) {
+	switch layerType {
+	case "polygon":
+		return "MULTIPOLYGON", nil
+	case "line":
+		return "MULTILINESTRING", nil
+	case "point":
+		return "POINT", nil
+	}
+	return "", fmt.Errorf("不支持的图层类型: %s", layerType)
+}
+
+// resultLayerGeomExpr 写入结果图层时的几何表达式
+func resultLayerGeomExpr(layerType, expr string) string {
+	if layerType == "point" {
+		return expr
+	}
+	return fmt.Sprintf("ST_Multi(%s)", expr)
+}
+
+// createResultLayer 新建结果图层，表名由目录和图层名首字母生成，重名时追加序号
+func createResultLayer(db *gorm.DB, main, cn, layerType string, fields []resultLayerField, color string) (string, error) {
+	geomType, err := resultLayerGeometryType(layerType)
+	if err != nil {
+		return "", err
+	}
+	if cn == "" {
+		return "", fmt.Errorf("需要指定结果图层名称")
+	}
+	base := methods.ConvertToInitials(cn)
+	if main != "" {
+		base = methods.ConvertToInitials(main) + "_" + base
+	}
+	base = strings.ToLower(base)
+	en := base
+	for i := 1; ; i++ {
+		var count int64
+		db.Model(&models.MySchema{}).Where("en = ?", en).Count(&count)
+		var exists bool
+		db.Raw(`SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = ?)`, en).Scan(&exists)
+		if count == 0 && !exists {
+			break
+		}
+		en = fmt.Sprintf("%s_%d", base, i)
+	}
+
+	defs := []string{"id SERIAL PRIMARY KEY"}
+	for _, f := range fields {
+		defs = append(defs, fmt.Sprintf(`"%s" %s`, strings.ToLower(f.Name), f.Type))
+	}
+	defs = append(defs, fmt.Sprintf("geom GEOMETRY(%s, 4326)", geomType))
+	if err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (%s)`, en, strings.Join(defs, ", "))).Error; err != nil {
+		return "", fmt.Errorf("创建结果图层失败: %v", err)
+	}
+	mvtTableName := en + "mvt"
+	if isEndWithNumber(en) {
+		mvtTableName = en + "_mvt"
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (ID SERIAL PRIMARY KEY, X INT8, Y INT8, Z INT8, Byte BYTEA)", mvtTableName)).Error; err != nil {
+		return "", fmt.Errorf("创建MVT表失败: %v", err)
+	}
+
+	var maxID int64
+	db.Model(&models.MySchema{}).Select("MAX(id)").Scan(&maxID)
+	if main == "" {
+		main = "分析结果"
+	}
+	schema := models.MySchema{
+		ID:          maxID + 1,
+		Main:        main,
+		CN:          cn,
+		EN:          en,
+		Type:        layerType,
+		Opacity:     "1",
+		LineWidth:   "1",
+		UpdatedDate: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err := db.Create(&schema).Error; err != nil {
+		return "", fmt.Errorf("登记结果图层失败: %v", err)
+	}
+	db.Create(&models.AttColor{
+		LayerName: en,
+		AttName:   "默认",
+		Property:  "默认",
+		Color:     color,
+	})
+	return en, nil
+}
+
+// dropResultLayer 分析失败或取消时删除已创建的结果图层
+func dropResultLayer(db *gorm.DB, en string) {
+	mvtTableName := en + "mvt"
+	if isEndWithNumber(en) {
+		mvtTableName = en + "_mvt"
+	}
+	db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, en))
+	db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, mvtTableName))
+	db.Where("en = ?", en).Delete(&models.MySchema{})
+	db.Where("layer_name = ?", en).Delete(&models.AttColor{})
+}
+
+// sourceLayerFields 源图层除id和geom外的字段及类型，按字段顺序
+func sourceLayerFields(db *gorm.DB, table string) ([]resultLayerField, error) {
+	var fields []resultLayerField
+	err := db.Raw(`
+		SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type
+		FROM pg_attribute a
+		WHERE a.attrelid = to_regclass(?) AND a.attnum > 0 AND NOT a.attisdropped
+			AND a.attname NOT IN ('id', 'geom')
+		ORDER BY a.attnum`, fmt.Sprintf(`public."%s"`, table)).Scan(&fields).Error
+	return fields, err
+}
+
+// sourceLayerType 源图层的类型(point/line/polygon)，优先取图层登记信息，未登记时按几何维度判断
+func sourceLayerType(db *gorm.DB, table string) (string, error) {
+	var schema models.MySchema
+	if err := db.Where("en = ?", table).First(&schema).Error; err == nil {
+		if _, err := resultLayerGeometryType(schema.Type); err == nil {
+			return schema.Type, nil
+		}
+	}
+	var dim *int
+	db.Raw(fmt.Sprintf(`SELECT ST_Dimension(geom) FROM "%s" WHERE geom IS NOT NULL LIMIT 1`, table)).Scan(&dim)
+	if dim == nil {
+		return "", fmt.Errorf("无法确定图层类型: %s", table)
+	}
+	return []string{"point", "line", "polygon"}[*dim], nil
+}
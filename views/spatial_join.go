@@ -0,0 +1,526 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/methods"
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 空间连接：按空间关系将连接图层的要素挂接到目标图层，
+// 一对一时按目标要素汇总(计数、求和、平均、按面积占优、拼接等)，一对多时每个匹配输出一条；
+// 结果写入新图层或更新目标图层字段(记录属性变更，可回退)
+
+type spatialJoinAgg struct {
+	Field    string `json:"Field"`    // 连接图层字段，count/area/distance可为空
+	Func     string `json:"Func"`     // count / sum / mean / min / max / majority / concat / area / distance
+	OutField string `json:"OutField"` // 输出字段名，为空时为 字段_函数
+}
+
+type spatialJoinRequest struct {
+	TargetTable  string           `json:"TargetTable"`
+	TargetFilter string           `json:"TargetFilter"`
+	JoinTable    string           `json:"JoinTable"`
+	JoinFilter   string           `json:"JoinFilter"`
+	Predicate    string           `json:"Predicate"` // intersects / within / contains / nearest
+	Distance     float64          `json:"Distance"`  // nearest的最大搜索距离(米)
+	Mode         string           `json:"Mode"`      // one_to_one / one_to_many
+	Aggregations []spatialJoinAgg `json:"Aggregations"`
+	JoinFields   []string         `json:"JoinFields"` // 一对多时输出的连接图层字段，为空时全部输出
+	Separator    string           `json:"Separator"`  // concat的分隔符，默认为、
+	MatchedOnly  bool             `json:"MatchedOnly"`
+	Output       string           `json:"Output"` // layer / update
+	Main         string           `json:"Main"`
+	OutTable     string           `json:"OutTable"`
+	Username     string           `json:"Username"`
+	BZ           string           `json:"BZ"`
+}
+
+// spatialJoinBatchSize 每批匹配的目标要素数
+const spatialJoinBatchSize = 500
+
+var spatialJoinFuncs = []string{"count", "sum", "mean", "min", "max", "majority", "concat", "area", "distance"}
+
+func isNumericFieldType(fieldType string) bool {
+	for _, t := range []string{"integer", "bigint", "smallint", "numeric", "double precision", "real"} {
+		if strings.HasPrefix(fieldType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// spatialJoinAggType 汇总结果的字段类型
+func spatialJoinAggType(db *gorm.DB, joinTable string, agg spatialJoinAgg) (string, error) {
+	switch agg.Func {
+	case "count":
+		return "INTEGER", nil
+	case "sum", "mean", "area", "distance":
+		return "DOUBLE PRECISION", nil
+	case "concat":
+		return "TEXT", nil
+	}
+	return attributeFieldType(db, joinTable, agg.Field)
+}
+
+func validateSpatialJoinRequest(db *gorm.DB, req *spatialJoinRequest) error {
+	req.TargetTable = strings.ToLower(req.TargetTable)
+	req.JoinTable = strings.ToLower(req.JoinTable)
+	if !tableColumns(db, req.TargetTable)["geom"] {
+		return fmt.Errorf("目标图层不存在或没有几何字段: %s", req.TargetTable)
+	}
+	joinCols := tableColumns(db, req.JoinTable)
+	if !joinCols["geom"] {
+		return fmt.Errorf("连接图层不存在或没有几何字段: %s", req.JoinTable)
+	}
+	if req.Predicate == "" {
+		req.Predicate = "intersects"
+	}
+	if !methods.IsStringInSlice(req.Predicate, []string{"intersects", "within", "contains", "nearest"}) {
+		return fmt.Errorf("Predicate只能为intersects、within、contains或nearest")
+	}
+	if req.Predicate == "nearest" && req.Distance <= 0 {
+		return fmt.Errorf("最近要素连接需要指定搜索距离")
+	}
+	if req.Mode == "" {
+		req.Mode = "one_to_one"
+	}
+	if req.Mode != "one_to_one" && req.Mode != "one_to_many" {
+		return fmt.Errorf("Mode只能为one_to_one或one_to_many")
+	}
+	if req.Output == "" {
+		req.Output = "layer"
+	}
+	switch req.Output {
+	case "layer":
+		if req.OutTable == "" {
+			return fmt.Errorf("OutTable不能为空")
+		}
+	case "update":
+		if req.Mode != "one_to_one" {
+			return fmt.Errorf("更新目标图层字段只支持一对一连接")
+		}
+		if req.Username == "" {
+			return fmt.Errorf("更新目标图层字段需要指定Username")
+		}
+	default:
+		return fmt.Errorf("Output只能为layer或update")
+	}
+	if req.Separator == "" {
+		req.Separator = "、"
+	}
+
+	if req.Mode == "one_to_many" {
+		for i, f := range req.JoinFields {
+			req.JoinFields[i] = strings.ToLower(f)
+			if !joinCols[req.JoinFields[i]] || req.JoinFields[i] == "geom" {
+				return fmt.Errorf("连接图层字段不存在: %s", f)
+			}
+		}
+		return nil
+	}
+
+	if len(req.Aggregations) == 0 {
+		req.Aggregations = []spatialJoinAgg{{Func: "count"}}
+	}
+	outFields := map[string]bool{}
+	for i := range req.Aggregations {
+		agg := &req.Aggregations[i]
+		agg.Field = strings.ToLower(agg.Field)
+		if !methods.IsStringInSlice(agg.Func, spatialJoinFuncs) {
+			return fmt.Errorf("不支持的汇总方式: %s", agg.Func)
+		}
+		if agg.Func == "distance" && req.Predicate != "nearest" {
+			return fmt.Errorf("distance只能用于最近要素连接")
+		}
+		if agg.Field == "" && !methods.IsStringInSlice(agg.Func, []string{"count", "area", "distance"}) {
+			return fmt.Errorf("汇总方式 %s 需要指定字段", agg.Func)
+		}
+		if agg.Field != "" {
+			if !joinCols[agg.Field] || agg.Field == "geom" {
+				return fmt.Errorf("连接图层字段不存在: %s", agg.Field)
+			}
+			if agg.Func == "sum" || agg.Func == "mean" {
+				fieldType, err := attributeFieldType(db, req.JoinTable, agg.Field)
+				if err != nil {
+					return err
+				}
+				if !isNumericFieldType(fieldType) {
+					return fmt.Errorf("字段 %s 不是数值字段，不能%s", agg.Field, agg.Func)
+				}
+			}
+		}
+		if agg.OutField == "" {
+			if agg.Field == "" {
+				agg.OutField = "join_" + agg.Func
+			} else {
+				agg.OutField = agg.Field + "_" + agg.Func
+			}
+		}
+		agg.OutField = strings.ToLower(agg.OutField)
+		if agg.OutField == "id" || agg.OutField == "geom" || outFields[agg.OutField] {
+			return fmt.Errorf("输出字段名无效或重复: %s", agg.OutField)
+		}
+		outFields[agg.OutField] = true
+	}
+	return nil
+}
+
+// spatialJoinAggExpr 汇总表达式，t为目标图层，p为匹配表，j为连接图层
+func spatialJoinAggExpr(agg spatialJoinAgg, pairs, joinTable, separator string) string {
+	field := fmt.Sprintf(`j."%s"`, agg.Field)
+	switch agg.Func {
+	case "count":
+		if agg.Field == "" {
+			return "COUNT(p.jid)"
+		}
+		return fmt.Sprintf("COUNT(%s)", field)
+	case "sum":
+		return fmt.Sprintf("SUM(%s)::float8", field)
+	case "mean":
+		return fmt.Sprintf("AVG(%s)::float8", field)
+	case "min":
+		return fmt.Sprintf("MIN(%s)", field)
+	case "max":
+		return fmt.Sprintf("MAX(%s)", field)
+	case "concat":
+		return fmt.Sprintf("string_agg(DISTINCT %s::text, '%s')", field, strings.ReplaceAll(separator, "'", "''"))
+	case "area":
+		return "SUM(p.area)"
+	case "distance":
+		return "MIN(p.dist)"
+	}
+	// majority：面与面按重叠面积，其他按个数
+	return fmt.Sprintf(`(SELECT j2."%s" FROM "%s" p2 JOIN "%s" j2 ON j2.id = p2.jid
+		WHERE p2.tid = t.id AND j2."%s" IS NOT NULL
+		GROUP BY j2."%s" ORDER BY SUM(COALESCE(p2.area, 1)) DESC LIMIT 1)`,
+		agg.Field, pairs, joinTable, agg.Field, agg.Field)
+}
+
+// buildSpatialJoinPairs 按批计算目标要素与连接要素的匹配关系，写入匹配表
+func buildSpatialJoinPairs(ctx context.Context, db *gorm.DB, req spatialJoinRequest, targetWhere, pairs string, progress analysisProgressFunc, share float64) error {
+	var ids []int64
+	if err := db.Raw(fmt.Sprintf(`SELECT t.id FROM "%s" AS t WHERE %s ORDER BY t.id`, req.TargetTable, targetWhere)).Scan(&ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("目标图层没有符合条件的要素")
+	}
+
+	joinSource := fmt.Sprintf(`"%s"`, req.JoinTable)
+	if strings.TrimSpace(req.JoinFilter) != "" {
+		filter, err := methods.CompileFilterExpression(db, req.JoinTable, req.JoinFilter)
+		if err != nil {
+			return fmt.Errorf("连接图层筛选条件错误: %v", err)
+		}
+		joinSource = fmt.Sprintf(`(SELECT * FROM "%s" AS t WHERE %s)`, req.JoinTable, filter.SQL)
+	}
+	self := ""
+	if req.TargetTable == req.JoinTable {
+		self = " AND j.id <> t.id"
+	}
+
+	var pairSQL string
+	if req.Predicate == "nearest" {
+		// 先按经度方向的度数扩展外包框筛选，再按椭球距离取最近的一个
+		pairSQL = fmt.Sprintf(`
+			INSERT INTO "%s" (tid, jid, dist)
+			SELECT t.id, n.id, n.dist FROM "%s" t CROSS JOIN LATERAL (
+				SELECT j.id, ST_Distance(t.geom::geography, j.geom::geography) AS dist FROM %s j
+				WHERE j.geom && ST_Expand(t.geom, %g / 111320.0 / GREATEST(cos(radians(GREATEST(abs(ST_YMin(t.geom)), abs(ST_YMax(t.geom))))), 0.01))
+					AND ST_DWithin(t.geom::geography, j.geom::geography, %g)%s
+				ORDER BY dist LIMIT 1
+			) n WHERE t.id IN ({ids})`, pairs, req.TargetTable, joinSource, req.Distance, req.Distance, self)
+	} else {
+		predicate := map[string]string{
+			"intersects": "ST_Intersects(t.geom, j.geom)",
+			"within":     "ST_Within(t.geom, j.geom)",
+			"contains":   "ST_Contains(t.geom, j.geom)",
+		}[req.Predicate]
+		pairSQL = fmt.Sprintf(`
+			INSERT INTO "%s" (tid, jid, area)
+			SELECT t.id, j.id,
+				CASE WHEN ST_Dimension(t.geom) = 2 AND ST_Dimension(j.geom) = 2
+					THEN ST_Area(ST_Intersection(ST_MakeValid(t.geom), ST_MakeValid(j.geom))::geography) END
+			FROM "%s" t JOIN %s j ON %s%s
+			WHERE t.id IN ({ids})`, pairs, req.TargetTable, joinSource, predicate, self)
+	}
+
+	for start := 0; start < len(ids); start += spatialJoinBatchSize {
+		end := start + spatialJoinBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := make([]string, end-start)
+		for i, id := range ids[start:end] {
+			batch[i] = fmt.Sprintf("%d", id)
+		}
+		if err := db.Exec(strings.Replace(pairSQL, "{ids}", strings.Join(batch, ","), 1)).Error; err != nil {
+			return fmt.Errorf("空间匹配失败: %v", err)
+		}
+		if !progress(share*float64(end)/float64(len(ids)), fmt.Sprintf("已匹配 %d/%d 个目标要素", end, len(ids))) {
+			return ctx.Err()
+		}
+	}
+	return db.Exec(fmt.Sprintf(`CREATE INDEX ON "%s" (tid)`, pairs)).Error
+}
+
+// runSpatialJoin 执行空间连接
+func runSpatialJoin(ctx context.Context, req spatialJoinRequest, progress analysisProgressFunc) (interface{}, error) {
+	db := models.DB.WithContext(ctx)
+	targetWhere := "t.geom IS NOT NULL"
+	if strings.TrimSpace(req.TargetFilter) != "" {
+		filter, err := methods.CompileFilterExpression(db, req.TargetTable, req.TargetFilter)
+		if err != nil {
+			return nil, fmt.Errorf("目标图层筛选条件错误: %v", err)
+		}
+		targetWhere += " AND " + filter.SQL
+	}
+
+	pairs := "sjoin_" + strings.ReplaceAll(uuid.New().String()[:8], "-", "")
+	if err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (tid INTEGER, jid INTEGER, area DOUBLE PRECISION, dist DOUBLE PRECISION)`, pairs)).Error; err != nil {
+		return nil, err
+	}
+	defer models.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, pairs))
+	if err := buildSpatialJoinPairs(ctx, db, req, targetWhere, pairs, progress, 0.8); err != nil {
+		return nil, err
+	}
+	progress(0.85, "正在汇总连接结果")
+
+	matched := ""
+	if req.MatchedOnly {
+		matched = fmt.Sprintf(" AND EXISTS (SELECT 1 FROM \"%s\" m WHERE m.tid = t.id)", pairs)
+	}
+
+	if req.Mode == "one_to_one" {
+		aggCols := make([]string, len(req.Aggregations))
+		aggSelect := []string{"t.id AS tid"}
+		for i, agg := range req.Aggregations {
+			aggCols[i] = fmt.Sprintf(`"%s"`, agg.OutField)
+			aggSelect = append(aggSelect, fmt.Sprintf(`%s AS "%s"`, spatialJoinAggExpr(agg, pairs, req.JoinTable, req.Separator), agg.OutField))
+		}
+		aggSQL := fmt.Sprintf(`SELECT %s FROM "%s" t LEFT JOIN "%s" p ON p.tid = t.id LEFT JOIN "%s" j ON j.id = p.jid WHERE %s%s GROUP BY t.id`,
+			strings.Join(aggSelect, ", "), req.TargetTable, pairs, req.JoinTable, targetWhere, matched)
+
+		if req.Output == "update" {
+			return updateSpatialJoinFields(db, req, targetWhere+matched, aggSQL)
+		}
+
+		fields, err := sourceLayerFields(db, req.TargetTable)
+		if err != nil {
+			return nil, err
+		}
+		// 与汇总字段重名的目标字段不再保留
+		aggNames := map[string]bool{}
+		for _, agg := range req.Aggregations {
+			aggNames[agg.OutField] = true
+		}
+		targetCols := make([]string, 0, len(fields))
+		kept := make([]resultLayerField, 0, len(fields)+len(req.Aggregations))
+		for _, f := range fields {
+			if !aggNames[strings.ToLower(f.Name)] {
+				kept = append(kept, f)
+				targetCols = append(targetCols, fmt.Sprintf(`"%s"`, f.Name))
+			}
+		}
+		for _, agg := range req.Aggregations {
+			fieldType, err := spatialJoinAggType(db, req.JoinTable, agg)
+			if err != nil {
+				return nil, err
+			}
+			kept = append(kept, resultLayerField{Name: agg.OutField, Type: fieldType})
+		}
+		layerType, err := sourceLayerType(db, req.TargetTable)
+		if err != nil {
+			return nil, err
+		}
+		en, err := createResultLayer(models.DB, req.Main, req.OutTable, layerType, kept, "")
+		if err != nil {
+			return nil, err
+		}
+		targetSelect := make([]string, len(targetCols))
+		for i, col := range targetCols {
+			targetSelect[i] = "t." + col
+		}
+		cols := append(append(targetCols, aggCols...), "geom")
+		aggRefs := make([]string, len(aggCols))
+		for i, col := range aggCols {
+			aggRefs[i] = "a." + col
+		}
+		sel := append(append(targetSelect, aggRefs...), resultLayerGeomExpr(layerType, "t.geom"))
+		sql := fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s" t JOIN (%s) a ON a.tid = t.id`,
+			en, strings.Join(cols, ", "), strings.Join(sel, ", "), req.TargetTable, aggSQL)
+		if err := db.Exec(sql).Error; err != nil {
+			dropResultLayer(models.DB, en)
+			return nil, fmt.Errorf("写入结果图层失败: %v", err)
+		}
+		return finishSpatialJoinLayer(db, en, req.OutTable, progress)
+	}
+
+	// 一对多：目标字段 + 连接要素ID + 连接字段，与目标字段重名的连接字段加 j_ 前缀
+	targetFields, err := sourceLayerFields(db, req.TargetTable)
+	if err != nil {
+		return nil, err
+	}
+	joinFields, err := sourceLayerFields(db, req.JoinTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.JoinFields) > 0 {
+		selected := joinFields[:0]
+		for _, f := range joinFields {
+			if methods.IsStringInSlice(f.Name, req.JoinFields) {
+				selected = append(selected, f)
+			}
+		}
+		joinFields = selected
+	}
+	used := map[string]bool{"id": true, "geom": true, "join_id": true, "overlap_area": true, "distance": true}
+	fields := make([]resultLayerField, 0, len(targetFields)+len(joinFields)+2)
+	var cols, sel []string
+	for _, f := range targetFields {
+		name := strings.ToLower(f.Name)
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		fields = append(fields, f)
+		cols = append(cols, fmt.Sprintf(`"%s"`, name))
+		sel = append(sel, fmt.Sprintf(`t."%s"`, f.Name))
+	}
+	fields = append(fields, resultLayerField{Name: "join_id", Type: "INTEGER"})
+	cols = append(cols, "join_id")
+	sel = append(sel, "p.jid")
+	for _, f := range joinFields {
+		name := strings.ToLower(f.Name)
+		for used[name] {
+			name = "j_" + name
+		}
+		used[name] = true
+		fields = append(fields, resultLayerField{Name: name, Type: f.Type})
+		cols = append(cols, fmt.Sprintf(`"%s"`, name))
+		sel = append(sel, fmt.Sprintf(`j."%s"`, f.Name))
+	}
+	if req.Predicate == "nearest" {
+		fields = append(fields, resultLayerField{Name: "distance", Type: "DOUBLE PRECISION"})
+		cols = append(cols, "distance")
+		sel = append(sel, "p.dist")
+	} else {
+		fields = append(fields, resultLayerField{Name: "overlap_area", Type: "DOUBLE PRECISION"})
+		cols = append(cols, "overlap_area")
+		sel = append(sel, "p.area")
+	}
+
+	layerType, err := sourceLayerType(db, req.TargetTable)
+	if err != nil {
+		return nil, err
+	}
+	en, err := createResultLayer(models.DB, req.Main, req.OutTable, layerType, fields, "")
+	if err != nil {
+		return nil, err
+	}
+	cols = append(cols, "geom")
+	sel = append(sel, resultLayerGeomExpr(layerType, "t.geom"))
+	sql := fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s" t LEFT JOIN "%s" p ON p.tid = t.id LEFT JOIN "%s" j ON j.id = p.jid WHERE %s%s ORDER BY t.id, p.jid`,
+		en, strings.Join(cols, ", "), strings.Join(sel, ", "), req.TargetTable, pairs, req.JoinTable, targetWhere, matched)
+	if err := db.Exec(sql).Error; err != nil {
+		dropResultLayer(models.DB, en)
+		return nil, fmt.Errorf("写入结果图层失败: %v", err)
+	}
+	return finishSpatialJoinLayer(db, en, req.OutTable, progress)
+}
+
+func finishSpatialJoinLayer(db *gorm.DB, en, cn string, progress analysisProgressFunc) (interface{}, error) {
+	var count int64
+	db.Table(en).Count(&count)
+	MakeGeoIndex(en)
+	progress(1, "空间连接完成")
+	return gin.H{"TableName": en, "CN": cn, "Count": count}, nil
+}
+
+// updateSpatialJoinFields 将汇总结果写入目标图层，字段不存在时新建，更新记录属性变更
+// 新建字段前完成类型推断和版本检查，更新失败时删除本次新建的字段
+func updateSpatialJoinFields(db *gorm.DB, req spatialJoinRequest, where, aggSQL string) (interface{}, error) {
+	columns := tableColumns(db, req.TargetTable)
+	fields := make([]string, len(req.Aggregations))
+	sets := make([]string, len(req.Aggregations))
+	newFields := map[string]string{}
+	for i, agg := range req.Aggregations {
+		fields[i] = agg.OutField
+		sets[i] = fmt.Sprintf(`"%s" = a."%s"`, agg.OutField, agg.OutField)
+		if columns[agg.OutField] {
+			continue
+		}
+		fieldType, err := spatialJoinAggType(db, req.JoinTable, agg)
+		if err != nil {
+			return nil, err
+		}
+		newFields[agg.OutField] = fieldType
+	}
+	if inActiveVersion(db, req.TargetTable, req.Username, 0) {
+		return nil, errVersionUnsupported
+	}
+
+	var added []string
+	dropAdded := func() {
+		for _, f := range added {
+			db.Exec(fmt.Sprintf(`ALTER TABLE "%s" DROP COLUMN IF EXISTS "%s"`, req.TargetTable, f))
+		}
+	}
+	for _, f := range fields {
+		fieldType, ok := newFields[f]
+		if !ok {
+			continue
+		}
+		delete(newFields, f)
+		if err := db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, req.TargetTable, f, fieldType)).Error; err != nil {
+			dropAdded()
+			return nil, fmt.Errorf("新建字段 %s 失败: %v", f, err)
+		}
+		added = append(added, f)
+	}
+
+	record, changed, err := auditAttributeUpdate(db, attributeAudit{
+		TableName: req.TargetTable,
+		Fields:    fields,
+		Where:     where,
+		Username:  req.Username,
+		BZ:        req.BZ,
+		Type:      attributeBulkEditType,
+	}, func(tx *gorm.DB) error {
+		return tx.Exec(fmt.Sprintf(`UPDATE "%s" AS u SET %s FROM (%s) a WHERE a.tid = u.id`,
+			req.TargetTable, strings.Join(sets, ", "), aggSQL)).Error
+	})
+	if err != nil {
+		dropAdded()
+		return nil, err
+	}
+	result := gin.H{"TableName": req.TargetTable, "Fields": fields, "Changed": changed}
+	if record != nil {
+		result["RecordID"] = record.ID
+	}
+	return result, nil
+}
+
+// StartSpatialJoin 创建空间连接任务
+func (uc *UserController) StartSpatialJoin(c *gin.Context) {
+	var req spatialJoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if err := validateSpatialJoinRequest(models.DB, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "error": err.Error()})
+		return
+	}
+	task := newAnalysisTask("spatial_join", func(ctx context.Context, progress analysisProgressFunc) (interface{}, error) {
+		return runSpatialJoin(ctx, req, progress)
+	})
+	respondAnalysisTask(c, task, "空间连接")
+}
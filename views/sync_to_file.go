@@ -86,8 +86,8 @@ func (uc *UserController) SyncToFile(c *gin.Context) {
 	// ==================== 2. 更新：源文件中有映射、未被删除、但被修改过的要素 ====================
 	// 通过GeoRecord中Type="要素修改"或"要素平移"等原地更新操作来判断
 	var modifiedRecords []models.GeoRecord
-	DB.Where("table_name = ? AND type IN ?", TableName,
-		[]string{"要素修改", "要素平移", "要素环岛构造"}).Find(&modifiedRecords)
+	DB.Where("table_name = ? AND type IN ? AND status = ?", TableName,
+		[]string{"要素修改", "要素平移", "要素环岛构造", changeApplyUpdateType, attributeEditType, attributeBulkEditType}, "applied").Find(&modifiedRecords)
 
 	// 收集所有被原地修改过的PostGIS ID（去重）
 	modifiedIDSet := make(map[int32]bool)
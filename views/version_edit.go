@@ -0,0 +1,627 @@
+// views/version_edit.go
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GrainArc/SouceMap/models"
+	"github.com/GrainArc/SouceMap/pgmvt"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// ==================== 版本化会话辅助 ====================
+//
+// 版本化会话中的变更写入VersionEdit，提交前不影响线上表。支持在版本中编辑的操作：
+// 要素添加(AddGeoToSchema，含COGO导线保存)、要素修改(ChangeGeoToSchema)、要素删除(DelGeoToSchema)、
+// 批量删除(DelGeosToSchema)和单要素属性修改(ChangeAttributes)。
+// 拆分、融合、炸开、环岛构造、聚合、偏移、面面分析、宗地分割、几何修复、变更检测应用，
+// 以及经auditAttributeUpdate写入的批量属性操作(字段计算、表达式计算、面积平差、要素编号、空间连接更新)
+// 无法在版本中隔离，用户在该图层上有活动的版本化会话时拒绝执行
+
+// errVersionUnsupported 操作不支持在版本中编辑
+var errVersionUnsupported = errors.New("该操作不支持在版本中编辑，请先提交或放弃当前版本")
+
+// versionSession 判断编辑请求是否属于指定图层上活跃的版本化会话
+func versionSession(db *gorm.DB, sessionID int64, tableName string) (models.EditSession, bool) {
+	var session models.EditSession
+	if sessionID == 0 {
+		return session, false
+	}
+	err := db.Where("id = ? AND table_name = ? AND versioned = true AND status = ?", sessionID, tableName, "active").
+		First(&session).Error
+	return session, err == nil
+}
+
+// inActiveVersion 请求指定了版本化会话，或用户在该图层上有活动的版本化会话
+func inActiveVersion(db *gorm.DB, tableName, username string, sessionID int64) bool {
+	if _, ok := versionSession(db, sessionID, tableName); ok {
+		return true
+	}
+	if username == "" {
+		return false
+	}
+	var count int64
+	db.Model(&models.EditSession{}).
+		Where("table_name = ? AND username = ? AND versioned = true AND status = ?", tableName, username, "active").
+		Count(&count)
+	return count > 0
+}
+
+// rejectInVersion 无法在版本中隔离的操作在版本化会话活动时拒绝执行，已写入响应时返回true
+func rejectInVersion(c *gin.Context, db *gorm.DB, tableName, username string, sessionID int64) bool {
+	if !inActiveVersion(db, tableName, username, sessionID) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"code":    409,
+		"message": errVersionUnsupported.Error(),
+		"data":    "",
+	})
+	return true
+}
+
+// maxGeoRecordID 当前编辑记录的最大ID，作为冲突检测的基线
+func maxGeoRecordID(db *gorm.DB) int64 {
+	var maxID int64
+	db.Model(&models.GeoRecord{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID)
+	return maxID
+}
+
+// lowerProperties 属性名统一小写，与PostGIS字段名保持一致
+func lowerProperties(props map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(props))
+	for key, value := range props {
+		result[strings.ToLower(key)] = value
+	}
+	return result
+}
+
+func firstFeature(fc geojson.FeatureCollection) (*geojson.Feature, error) {
+	if len(fc.Features) == 0 || fc.Features[0] == nil {
+		return nil, fmt.Errorf("geojson中没有要素")
+	}
+	return fc.Features[0], nil
+}
+
+func unmarshalFeature(data []byte) *geojson.Feature {
+	if len(data) == 0 {
+		return nil
+	}
+	feature, err := geojson.UnmarshalFeature(data)
+	if err != nil {
+		return nil
+	}
+	return feature
+}
+
+// nextTempID 分配会话内新增要素的临时ID（-1, -2, ...）
+func nextTempID(db *gorm.DB, session models.EditSession) int32 {
+	var minID int32
+	db.Model(&models.VersionEdit{}).
+		Where("session_id = ? AND table_name = ?", session.ID, session.TableName).
+		Select("COALESCE(MIN(feature_id), 0)").Scan(&minID)
+	if minID < 0 {
+		return minID - 1
+	}
+	return -1
+}
+
+// versionAddFeature 在版本化会话中新增要素，分配会话内临时ID（负数）
+func versionAddFeature(db *gorm.DB, session models.EditSession, fc geojson.FeatureCollection) (*geojson.Feature, error) {
+	feature, err := firstFeature(fc)
+	if err != nil {
+		return nil, err
+	}
+	tempID := nextTempID(db, session)
+	feature.Properties = lowerProperties(feature.Properties)
+	feature.Properties["id"] = tempID
+	data, _ := json.Marshal(feature)
+	edit := models.VersionEdit{
+		SessionID: session.ID,
+		TableName: session.TableName,
+		FeatureID: tempID,
+		Action:    "insert",
+		Geojson:   data,
+		UpdatedAt: timeNowStr(),
+	}
+	if err := db.Create(&edit).Error; err != nil {
+		return nil, err
+	}
+	return feature, nil
+}
+
+// versionChangeFeature 在版本化会话中修改要素，属性在会话内当前版本的基础上覆盖
+func versionChangeFeature(db *gorm.DB, session models.EditSession, id int32, fc geojson.FeatureCollection) (*geojson.Feature, error) {
+	feature, err := firstFeature(fc)
+	if err != nil {
+		return nil, err
+	}
+	var edit models.VersionEdit
+	found := db.Where("session_id = ? AND table_name = ? AND feature_id = ?", session.ID, session.TableName, id).
+		First(&edit).Error == nil
+	if found && edit.Action == "delete" {
+		return nil, fmt.Errorf("要素ID=%d已在该会话中删除", id)
+	}
+	if !found && id < 0 {
+		return nil, fmt.Errorf("会话中不存在临时要素ID=%d", id)
+	}
+
+	var current *geojson.Feature
+	if found {
+		current = unmarshalFeature(edit.Geojson)
+	} else {
+		live := GetGeo(getData{TableName: session.TableName, ID: id})
+		if len(live.Features) == 0 || live.Features[0] == nil || live.Features[0].Geometry == nil {
+			return nil, fmt.Errorf("未找到要素ID=%d", id)
+		}
+		current = live.Features[0]
+		baseData, _ := json.Marshal(current)
+		edit = models.VersionEdit{
+			SessionID:    session.ID,
+			TableName:    session.TableName,
+			FeatureID:    id,
+			Action:       "update",
+			BaseGeojson:  baseData,
+			BaseRecordID: maxGeoRecordID(db),
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("要素ID=%d的会话版本已损坏", id)
+	}
+
+	merged := geojson.NewFeature(current.Geometry)
+	merged.Properties = lowerProperties(current.Properties)
+	for key, value := range lowerProperties(feature.Properties) {
+		merged.Properties[key] = value
+	}
+	if feature.Geometry != nil {
+		merged.Geometry = feature.Geometry
+	}
+	merged.Properties["id"] = id
+
+	edit.Geojson, _ = json.Marshal(merged)
+	edit.UpdatedAt = timeNowStr()
+	if err := db.Save(&edit).Error; err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// versionDeleteFeature 在版本化会话中删除要素
+func versionDeleteFeature(db *gorm.DB, session models.EditSession, id int32) error {
+	var edit models.VersionEdit
+	found := db.Where("session_id = ? AND table_name = ? AND feature_id = ?", session.ID, session.TableName, id).
+		First(&edit).Error == nil
+	if id < 0 {
+		if !found {
+			return fmt.Errorf("会话中不存在临时要素ID=%d", id)
+		}
+		// 会话内新增的要素直接丢弃
+		return db.Delete(&edit).Error
+	}
+	if !found {
+		live := GetGeo(getData{TableName: session.TableName, ID: id})
+		if len(live.Features) == 0 || live.Features[0] == nil || live.Features[0].Geometry == nil {
+			return fmt.Errorf("未找到要素ID=%d", id)
+		}
+		baseData, _ := json.Marshal(live.Features[0])
+		edit = models.VersionEdit{
+			SessionID:    session.ID,
+			TableName:    session.TableName,
+			FeatureID:    id,
+			BaseGeojson:  baseData,
+			BaseRecordID: maxGeoRecordID(db),
+		}
+	}
+	edit.Action = "delete"
+	edit.Geojson = nil
+	edit.UpdatedAt = timeNowStr()
+	return db.Save(&edit).Error
+}
+
+// ==================== 冲突检测 ====================
+
+// VersionConflict 版本化会话与线上表之间的要素冲突
+type VersionConflict struct {
+	FeatureID      int32               `json:"feature_id"`
+	Action         string              `json:"action"`
+	Reason         string              `json:"reason"` // modified_in_live / deleted_in_live
+	Records        []GeoRecordResponse `json:"records"`
+	BaseVersion    *geojson.Feature    `json:"base_version"`
+	SessionVersion *geojson.Feature    `json:"session_version"`
+	LiveVersion    *geojson.Feature    `json:"live_version"`
+}
+
+func liveFeatureExists(db *gorm.DB, tableName string, id int32) bool {
+	var count int64
+	db.Table(tableName).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// detectVersionConflicts 检测会话修改/删除过的要素在会话基线之后是否被其他会话修改，并更新冲突状态
+func detectVersionConflicts(db *gorm.DB, session models.EditSession) []VersionConflict {
+	var edits []models.VersionEdit
+	db.Where("session_id = ? AND table_name = ? AND feature_id > 0", session.ID, session.TableName).
+		Order("id").Find(&edits)
+
+	conflicts := make([]VersionConflict, 0)
+	for _, edit := range edits {
+		idJSON := fmt.Sprintf("[%d]", edit.FeatureID)
+		var records []models.GeoRecord
+		db.Where("table_name = ? AND id > ? AND session_id <> ? AND status = ? AND (input_ids @> ? OR output_ids @> ?)",
+			session.TableName, edit.BaseRecordID, session.ID, "applied", idJSON, idJSON).
+			Order("id").Find(&records)
+
+		exists := liveFeatureExists(db, session.TableName, edit.FeatureID)
+		reason := ""
+		if !exists && edit.Action != "delete" {
+			reason = "deleted_in_live"
+		} else if len(records) > 0 && !(edit.Action == "delete" && !exists) {
+			reason = "modified_in_live"
+		}
+
+		if reason == "" {
+			if edit.Conflict == "pending" {
+				db.Model(&models.VersionEdit{}).Where("id = ?", edit.ID).Update("conflict", "")
+			}
+			continue
+		}
+		db.Model(&models.VersionEdit{}).Where("id = ?", edit.ID).Update("conflict", "pending")
+
+		conflict := VersionConflict{
+			FeatureID:      edit.FeatureID,
+			Action:         edit.Action,
+			Reason:         reason,
+			Records:        toRecordResponses(records),
+			BaseVersion:    unmarshalFeature(edit.BaseGeojson),
+			SessionVersion: unmarshalFeature(edit.Geojson),
+		}
+		if exists {
+			live := GetGeo(getData{TableName: session.TableName, ID: edit.FeatureID})
+			if len(live.Features) > 0 {
+				conflict.LiveVersion = live.Features[0]
+			}
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// ==================== 接口 ====================
+
+type createVersionData struct {
+	TableName string `json:"TableName"`
+	Username  string `json:"Username"`
+	Name      string `json:"Name"`
+}
+
+// CreateVersion 创建版本化编辑会话
+func (uc *UserController) CreateVersion(c *gin.Context) {
+	var jsonData createVersionData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	var schema models.MySchema
+	if err := DB.Where("en = ?", jsonData.TableName).First(&schema).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到图层: " + jsonData.TableName})
+		return
+	}
+	session := models.EditSession{
+		TableName:    jsonData.TableName,
+		Username:     jsonData.Username,
+		CreatedAt:    timeNowStr(),
+		Status:       "active",
+		Versioned:    true,
+		Name:         jsonData.Name,
+		BaseRecordID: maxGeoRecordID(DB),
+	}
+	if err := DB.Create(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "创建版本失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "创建版本成功", "data": session})
+}
+
+// ListVersions 查询版本化编辑会话
+func (uc *UserController) ListVersions(c *gin.Context) {
+	TableName := c.Query("TableName")
+	Username := c.Query("Username")
+	Status := c.Query("Status")
+	DB := models.DB
+
+	query := DB.Where("versioned = true")
+	if TableName != "" {
+		query = query.Where("table_name = ?", TableName)
+	}
+	if Username != "" {
+		query = query.Where("username = ?", Username)
+	}
+	if Status != "" {
+		query = query.Where("status = ?", Status)
+	}
+	var sessions []models.EditSession
+	query.Order("id DESC").Find(&sessions)
+
+	type versionItem struct {
+		models.EditSession
+		EditCount     int64 `json:"edit_count"`
+		ConflictCount int64 `json:"conflict_count"`
+	}
+	result := make([]versionItem, 0, len(sessions))
+	for _, s := range sessions {
+		item := versionItem{EditSession: s}
+		DB.Model(&models.VersionEdit{}).Where("session_id = ?", s.ID).Count(&item.EditCount)
+		DB.Model(&models.VersionEdit{}).Where("session_id = ? AND conflict = ?", s.ID, "pending").Count(&item.ConflictCount)
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": result})
+}
+
+// GetVersionEdits 获取版本化会话中的全部变更
+func (uc *UserController) GetVersionEdits(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+	var edits []models.VersionEdit
+	DB.Where("session_id = ?", SessionID).Order("id").Find(&edits)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "success", "data": edits})
+}
+
+type versionGeoData struct {
+	SessionID int64 `json:"SessionID"`
+	ID        int32 `json:"ID"`
+}
+
+// GetGeoFromVersion 按会话视图获取单个要素：会话内有变更时返回会话版本，否则返回线上版本
+func (uc *UserController) GetGeoFromVersion(c *gin.Context) {
+	var jsonData versionGeoData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	DB := models.DB
+	var session models.EditSession
+	if err := DB.Where("id = ? AND versioned = true", jsonData.SessionID).First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到版本化会话"})
+		return
+	}
+	NewGeo := geojson.NewFeatureCollection()
+	var edit models.VersionEdit
+	if DB.Where("session_id = ? AND table_name = ? AND feature_id = ?", session.ID, session.TableName, jsonData.ID).
+		First(&edit).Error == nil {
+		if feature := unmarshalFeature(edit.Geojson); feature != nil && edit.Action != "delete" {
+			NewGeo.Append(feature)
+		}
+		c.JSON(http.StatusOK, NewGeo)
+		return
+	}
+	c.JSON(http.StatusOK, GetGeo(getData{TableName: session.TableName, ID: jsonData.ID}))
+}
+
+// VersionMVT 版本化会话的矢量瓦片
+func (uc *UserController) VersionMVT(c *gin.Context) {
+	sessionID, _ := strconv.ParseInt(c.Param("session"), 10, 64)
+	dbname := strings.ToLower(c.Param("tablename"))
+	x, _ := strconv.Atoi(c.Param("x"))
+	y, _ := strconv.Atoi(strings.TrimSuffix(c.Param("y.pbf"), ".pbf"))
+	z, _ := strconv.Atoi(c.Param("z"))
+	DB := models.DB
+	mvtdata := pgmvt.MakeSessionMvt(x, y, z, dbname, sessionID, DB)
+	if mvtdata != nil {
+		c.Data(http.StatusOK, "application/x-protobuf", mvtdata)
+	} else {
+		c.String(http.StatusOK, "err")
+	}
+}
+
+// ReconcileVersion 协调：检测会话与线上表的冲突
+func (uc *UserController) ReconcileVersion(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+	var session models.EditSession
+	if err := DB.Where("id = ? AND versioned = true AND status = ?", SessionID, "active").First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到活跃的版本化会话"})
+		return
+	}
+	conflicts := detectVersionConflicts(DB, session)
+	message := "协调完成，无冲突"
+	if len(conflicts) > 0 {
+		message = fmt.Sprintf("协调完成，发现%d个冲突要素", len(conflicts))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": message, "data": conflicts})
+}
+
+type resolveConflictData struct {
+	SessionID   int64 `json:"SessionID"`
+	Resolutions []struct {
+		FeatureID int32  `json:"FeatureID"`
+		Choose    string `json:"Choose"` // session：保留会话版本 / live：采用线上版本
+	} `json:"Resolutions"`
+}
+
+// ResolveVersionConflicts 逐要素选择冲突的胜出版本
+func (uc *UserController) ResolveVersionConflicts(c *gin.Context) {
+	var jsonData resolveConflictData
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	DB := models.DB
+	var session models.EditSession
+	if err := DB.Where("id = ? AND versioned = true AND status = ?", jsonData.SessionID, "active").First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到活跃的版本化会话"})
+		return
+	}
+	resolveErrors := make([]string, 0)
+	resolved := 0
+	for _, r := range jsonData.Resolutions {
+		var edit models.VersionEdit
+		if err := DB.Where("session_id = ? AND feature_id = ?", session.ID, r.FeatureID).First(&edit).Error; err != nil {
+			resolveErrors = append(resolveErrors, fmt.Sprintf("要素ID=%d不在会话变更中", r.FeatureID))
+			continue
+		}
+		switch r.Choose {
+		case "live":
+			// 放弃会话内的变更
+			DB.Delete(&edit)
+		case "session":
+			if edit.Action == "update" && !liveFeatureExists(DB, session.TableName, r.FeatureID) {
+				// 线上已删除但保留会话版本：转为会话内新增
+				tempID := nextTempID(DB, session)
+				feature := unmarshalFeature(edit.Geojson)
+				if feature == nil {
+					resolveErrors = append(resolveErrors, fmt.Sprintf("要素ID=%d的会话版本已损坏", r.FeatureID))
+					continue
+				}
+				feature.Properties["id"] = tempID
+				data, _ := json.Marshal(feature)
+				DB.Model(&models.VersionEdit{}).Where("id = ?", edit.ID).Updates(map[string]interface{}{
+					"feature_id": tempID,
+					"action":     "insert",
+					"geojson":    data,
+					"conflict":   "session",
+					"updated_at": timeNowStr(),
+				})
+				resolved++
+				continue
+			}
+			// 保留会话版本，基线前移到当前线上状态
+			live := GetGeo(getData{TableName: session.TableName, ID: r.FeatureID})
+			updates := map[string]interface{}{
+				"conflict":       "session",
+				"base_record_id": maxGeoRecordID(DB),
+				"updated_at":     timeNowStr(),
+			}
+			if len(live.Features) > 0 && live.Features[0] != nil && live.Features[0].Geometry != nil {
+				updates["base_geojson"], _ = json.Marshal(live.Features[0])
+			}
+			DB.Model(&models.VersionEdit{}).Where("id = ?", edit.ID).Updates(updates)
+		default:
+			resolveErrors = append(resolveErrors, fmt.Sprintf("要素ID=%d的选择无效: %s", r.FeatureID, r.Choose))
+			continue
+		}
+		resolved++
+	}
+	if len(resolveErrors) > 0 {
+		c.JSON(http.StatusOK, gin.H{"code": 207, "message": "部分冲突已处理", "resolved": resolved, "errors": resolveErrors})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "冲突处理完成", "resolved": resolved})
+}
+
+// PostVersion 提交：协调无冲突后将会话变更经编辑记录写入线上表
+func (uc *UserController) PostVersion(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+	var session models.EditSession
+	if err := DB.Where("id = ? AND versioned = true AND status = ?", SessionID, "active").First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到活跃的版本化会话"})
+		return
+	}
+	conflicts := detectVersionConflicts(DB, session)
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    409,
+			"message": fmt.Sprintf("存在%d个未解决的冲突，请先协调", len(conflicts)),
+			"data":    conflicts,
+		})
+		return
+	}
+
+	var edits []models.VersionEdit
+	DB.Where("session_id = ?", session.ID).Order("id").Find(&edits)
+	// 提交前检查他人持有的锁
+	var lockIDs []int32
+	var lockGeoms []orb.Geometry
+	for _, edit := range edits {
+		if edit.FeatureID > 0 {
+			lockIDs = append(lockIDs, edit.FeatureID)
+		}
+		if feature := unmarshalFeature(edit.Geojson); feature != nil {
+			lockGeoms = append(lockGeoms, feature.Geometry)
+		}
+	}
+	if !checkEditLocks(c, DB, session.TableName, session.Username, lockIDs, lockGeoms...) {
+		return
+	}
+	bz := "版本提交"
+	if session.Name != "" {
+		bz = "版本提交: " + session.Name
+	}
+	idMap := make(map[int32]int32)
+	postErrors := make([]string, 0)
+	applied := make([]int64, 0, len(edits))
+	for _, edit := range edits {
+		feature := unmarshalFeature(edit.Geojson)
+		switch edit.Action {
+		case "insert":
+			if feature == nil {
+				postErrors = append(postErrors, fmt.Sprintf("临时要素ID=%d数据无效", edit.FeatureID))
+				continue
+			}
+			delete(feature.Properties, "id")
+			fc := geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}
+			idMap[edit.FeatureID] = addFeatureWithRecord(DB, session.TableName, fc, session.Username, bz, session)
+			applied = append(applied, edit.ID)
+		case "update":
+			if feature == nil || !liveFeatureExists(DB, session.TableName, edit.FeatureID) {
+				postErrors = append(postErrors, fmt.Sprintf("要素ID=%d无法更新", edit.FeatureID))
+				continue
+			}
+			fc := geojson.FeatureCollection{Type: "FeatureCollection", Features: []*geojson.Feature{feature}}
+			changeFeatureWithRecord(DB, session.TableName, edit.FeatureID, fc, session.Username, bz, session)
+			applied = append(applied, edit.ID)
+		case "delete":
+			if liveFeatureExists(DB, session.TableName, edit.FeatureID) {
+				deleteFeatureWithRecord(DB, session.TableName, edit.FeatureID, session.Username, bz, session)
+			}
+			applied = append(applied, edit.ID)
+		}
+	}
+
+	// 只移除已写入的变更；有失败项时会话保持活动，修正后可再次提交
+	if len(applied) > 0 {
+		DB.Where("id IN ?", applied).Delete(&models.VersionEdit{})
+	}
+	if len(postErrors) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    207,
+			"message": fmt.Sprintf("已写入%d项变更，%d项失败，版本保持活动", len(applied), len(postErrors)),
+			"id_map":  idMap,
+			"errors":  postErrors,
+		})
+		return
+	}
+	DB.Model(&models.EditSession{}).Where("id = ?", session.ID).
+		Updates(map[string]interface{}{"status": "committed", "posted_at": timeNowStr()})
+	releaseSessionLocks(DB, session.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": fmt.Sprintf("提交成功，共写入%d项变更", len(applied)),
+		"id_map":  idMap,
+	})
+}
+
+// AbandonVersion 放弃版本化会话的全部变更
+func (uc *UserController) AbandonVersion(c *gin.Context) {
+	SessionID := c.Query("SessionID")
+	DB := models.DB
+	var session models.EditSession
+	if err := DB.Where("id = ? AND versioned = true AND status = ?", SessionID, "active").First(&session).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 404, "message": "未找到活跃的版本化会话"})
+		return
+	}
+	DB.Where("session_id = ?", session.ID).Delete(&models.VersionEdit{})
+	DB.Model(&models.EditSession{}).Where("id = ?", session.ID).Update("status", "rolledback")
+	releaseSessionLocks(DB, session.ID)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "已放弃该版本的全部变更"})
+}